@@ -0,0 +1,279 @@
+package fync
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Access describes a single request served by a host, as passed to the OnAccess hook of Handler
+// once the request has been served.
+type Access struct {
+	// When the request was received, and how long it took to serve, including any time spent
+	// waiting on a BandwidthLimit.
+	Time     time.Time
+	Duration time.Duration
+
+	// The client that made the request, by default its IP address.
+	Client string
+
+	// The identity the client authenticated as, as by RequireAuth, if any.
+	User string
+
+	// The method and path of the request.
+	Method, Path string
+
+	// What was requested, such as "manifest", "mod", "delta", or "events" for Handler,
+	// or the name of the gRPC method for fyncgrpc. Empty for requests for something unknown.
+	Op string
+
+	// Name of the mod requested, if any.
+	Mod string
+
+	// The HTTP status of the response, and the number of bytes of its body that were sent.
+	Status int
+	Bytes  int64
+
+	// Why the request failed other than by its status, such as by a gRPC error
+	// or the client disconnecting partway through. Empty if it did not.
+	Err string
+}
+
+// OK reports whether the request was served successfully.
+func (a *Access) OK() bool {
+	return a.Status < 400 && a.Err == ""
+}
+
+type accessKey struct{}
+
+// LogAccess returns a handler serving with h that calls onAccess once each request has been served.
+// The client of each request is identified by clientID, or by its IP address when nil.
+// What each request was for is noted by h with NoteAccess.
+func LogAccess(h http.Handler, clientID func(*http.Request) string, onAccess func(Access)) http.Handler {
+	if onAccess == nil {
+		return h
+	}
+	if clientID == nil {
+		clientID = clientIP
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a := &Access{
+			Time:   time.Now(),
+			Client: clientID(r),
+			Method: r.Method,
+			Path:   r.URL.Path,
+		}
+
+		aw := &accessWriter{ResponseWriter: w, access: a}
+		h.ServeHTTP(aw, r.WithContext(context.WithValue(r.Context(), accessKey{}, a)))
+
+		a.Duration = time.Since(a.Time)
+		if a.Status == 0 {
+			a.Status = http.StatusOK
+		}
+		if a.Err == "" && aw.err != nil {
+			a.Err = aw.err.Error()
+		}
+		onAccess(*a)
+	})
+}
+
+// NoteAccess records what a request being served within LogAccess was for, such as the mod requested
+// or why it failed, by calling note with its Access. It does nothing for requests not being logged.
+func NoteAccess(r *http.Request, note func(*Access)) {
+	if a, ok := r.Context().Value(accessKey{}).(*Access); ok {
+		note(a)
+	}
+}
+
+// clientIP returns the IP address of the client of a request.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// accessWriter is an http.ResponseWriter recording the status and size of the response into its Access.
+type accessWriter struct {
+	http.ResponseWriter
+	access *Access
+	err    error
+}
+
+func (w *accessWriter) WriteHeader(status int) {
+	if w.access.Status == 0 {
+		w.access.Status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessWriter) Write(p []byte) (int, error) {
+	if w.access.Status == 0 {
+		w.access.Status = http.StatusOK
+	}
+
+	n, err := w.ResponseWriter.Write(p)
+	w.access.Bytes += int64(n)
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	return n, err
+}
+
+// Flush flushes the underlying writer, if it can be, so that streamed responses are still sent as they are written.
+func (w *accessWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the writer whose response is being recorded.
+func (w *accessWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// accessEntry is how an Access is written by JSONAccessLog.
+type accessEntry struct {
+	Time     time.Time `json:"time"`
+	Duration float64   `json:"duration"`
+	Client   string    `json:"client"`
+	User     string    `json:"user,omitempty"`
+	Method   string    `json:"method"`
+	Path     string    `json:"path"`
+	Op       string    `json:"op,omitempty"`
+	Mod      string    `json:"mod,omitempty"`
+	Status   int       `json:"status"`
+	Bytes    int64     `json:"bytes"`
+	Err      string    `json:"error,omitempty"`
+}
+
+// JSONAccessLog returns an OnAccess hook writing each access to w as a line of JSON, with its duration in seconds,
+// for log processors to ingest:
+//
+//	{"time":"2021-03-14T15:09:26.5Z","duration":1.25,"client":"203.0.113.7","method":"GET","path":"/mods/jei.jar","op":"mod","mod":"jei.jar","status":200,"bytes":682435}
+func JSONAccessLog(w io.Writer) func(Access) {
+	var mu sync.Mutex
+	return func(a Access) {
+		data, err := json.Marshal(accessEntry{
+			Time:     a.Time,
+			Duration: a.Duration.Seconds(),
+			Client:   a.Client,
+			User:     a.User,
+			Method:   a.Method,
+			Path:     a.Path,
+			Op:       a.Op,
+			Mod:      a.Mod,
+			Status:   a.Status,
+			Bytes:    a.Bytes,
+			Err:      a.Err,
+		})
+		if err != nil {
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		w.Write(append(data, '\n'))
+	}
+}
+
+// Metrics counts the requests served by a host, for exporting to monitoring. Its Record method
+// is an OnAccess hook, and it is an expvar.Var, so that it is exported at /debug/vars by:
+//
+//	m := &fync.Metrics{}
+//	expvar.Publish("fync", m)
+//	http.Handle("/", fync.Handler(dir, &fync.HandlerOptions{OnAccess: m.Record}))
+//
+// The zero value is ready to use.
+type Metrics struct {
+	mu sync.Mutex
+	s  MetricsSnapshot
+}
+
+// MetricsSnapshot holds the counts of Metrics at one point in time.
+type MetricsSnapshot struct {
+	// The number of requests served, those of which failed, and the bytes sent in response to them.
+	Requests int64 `json:"requests"`
+	Failures int64 `json:"failures"`
+	Bytes    int64 `json:"bytes"`
+
+	// The number of requests for each Op.
+	Ops map[string]int64 `json:"ops"`
+
+	// The counts of each mod that was requested.
+	Mods map[string]ModMetrics `json:"mods"`
+}
+
+// ModMetrics counts the requests for a single mod.
+type ModMetrics struct {
+	// The number of requests for the mod, those of which failed, and the bytes of it that were sent.
+	Requests int64 `json:"requests"`
+	Failures int64 `json:"failures"`
+	Bytes    int64 `json:"bytes"`
+}
+
+// Record counts an access.
+func (m *Metrics) Record(a Access) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	failed := int64(0)
+	if !a.OK() {
+		failed = 1
+	}
+
+	m.s.Requests++
+	m.s.Failures += failed
+	m.s.Bytes += a.Bytes
+
+	if a.Op != "" {
+		if m.s.Ops == nil {
+			m.s.Ops = make(map[string]int64)
+		}
+		m.s.Ops[a.Op]++
+	}
+
+	if a.Mod != "" {
+		if m.s.Mods == nil {
+			m.s.Mods = make(map[string]ModMetrics)
+		}
+		mod := m.s.Mods[a.Mod]
+		mod.Requests++
+		mod.Failures += failed
+		mod.Bytes += a.Bytes
+		m.s.Mods[a.Mod] = mod
+	}
+}
+
+// Snapshot returns a copy of the current counts.
+func (m *Metrics) Snapshot() MetricsSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := m.s
+	s.Ops = make(map[string]int64, len(m.s.Ops))
+	for op, n := range m.s.Ops {
+		s.Ops[op] = n
+	}
+	s.Mods = make(map[string]ModMetrics, len(m.s.Mods))
+	for name, mod := range m.s.Mods {
+		s.Mods[name] = mod
+	}
+	return s
+}
+
+// String returns the current counts as JSON, implementing expvar.Var.
+func (m *Metrics) String() string {
+	data, err := json.Marshal(m.Snapshot())
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
@@ -0,0 +1,187 @@
+package fync
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HMACScheme is the authorization scheme of requests signed with a shared secret by SignRequest.
+const HMACScheme = "Fync-HMAC"
+
+// DefaultMaxSkew is how far the time a request was signed at may be from the host's clock
+// when HMACAuth does not choose how far.
+const DefaultMaxSkew = 5 * time.Minute
+
+// Authenticator authenticates the clients of a host, so that only those given credentials can sync its pack,
+// as by RequireAuth.
+type Authenticator interface {
+	// Authenticate reports whether the request carries valid credentials,
+	// returning the identity of the client it authenticated as, such as its user name.
+	Authenticate(r *http.Request) (string, bool)
+
+	// Challenge returns the WWW-Authenticate header sent to clients that did not authenticate,
+	// or an empty string for none.
+	Challenge() string
+}
+
+// RequireAuth returns a handler serving with h only the requests a authenticates, responding to others
+// with 401 Unauthorized. The identity of each client is noted as the User of its Access.
+func RequireAuth(h http.Handler, a Authenticator) http.Handler {
+	if a == nil {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := a.Authenticate(r)
+		if !ok {
+			if challenge := a.Challenge(); challenge != "" {
+				w.Header().Set("WWW-Authenticate", challenge)
+			}
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		NoteAccess(r, func(a *Access) { a.User = user })
+		h.ServeHTTP(w, r)
+	})
+}
+
+// BearerAuth authenticates clients by the bearer token they send, such as one given to each player.
+type BearerAuth struct {
+	// The identity of the client given each token, by the token.
+	Tokens map[string]string
+}
+
+// Authenticate checks the request's bearer token, in time independent of which token it is.
+func (a *BearerAuth) Authenticate(r *http.Request) (string, bool) {
+	token, ok := credentials(r, "Bearer")
+	if !ok {
+		return "", false
+	}
+
+	var user string
+	found := false
+	for t, u := range a.Tokens {
+		if secretsEqual(t, token) {
+			user, found = u, true
+		}
+	}
+	return user, found && token != ""
+}
+
+func (a *BearerAuth) Challenge() string {
+	return `Bearer realm="fync"`
+}
+
+// BasicAuth authenticates clients by the user name and password they send with HTTP basic authentication,
+// which should only be served over HTTPS since it sends the password as it is.
+type BasicAuth struct {
+	// The password of each user, by their name.
+	Users map[string]string
+}
+
+// Authenticate checks the request's user name and password, in time independent of the password.
+func (a *BasicAuth) Authenticate(r *http.Request) (string, bool) {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return "", false
+	}
+
+	want, ok := a.Users[user]
+	if !secretsEqual(want, password) || !ok {
+		return "", false
+	}
+	return user, true
+}
+
+func (a *BasicAuth) Challenge() string {
+	return `Basic realm="fync", charset="UTF-8"`
+}
+
+// HMACAuth authenticates clients by requests signed with a secret shared with them, as by SignRequest,
+// so that the secret itself is never sent. Signatures are only valid for the request they were made for,
+// and only near the time they were made at, limiting how long a captured request can be replayed.
+type HMACAuth struct {
+	// The shared secret.
+	Secret []byte
+
+	// How far the time a request was signed at may be from the host's clock. Defaults to DefaultMaxSkew.
+	MaxSkew time.Duration
+}
+
+// Authenticate checks the request's signature. Clients authenticated by it have no identity.
+func (a *HMACAuth) Authenticate(r *http.Request) (string, bool) {
+	value, ok := credentials(r, HMACScheme)
+	if !ok || len(a.Secret) == 0 {
+		return "", false
+	}
+
+	fields := strings.Fields(value)
+	if len(fields) != 2 {
+		return "", false
+	}
+	signed, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return "", false
+	}
+
+	skew := a.MaxSkew
+	if skew <= 0 {
+		skew = DefaultMaxSkew
+	}
+	if d := time.Since(time.Unix(signed, 0)); d > skew || d < -skew {
+		return "", false
+	}
+
+	// the URI as requested, before any prefix was stripped from its path
+	uri := r.RequestURI
+	if uri == "" {
+		uri = r.URL.RequestURI()
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil || !hmac.Equal(sig, requestMAC(a.Secret, r.Method, uri, signed)) {
+		return "", false
+	}
+	return "", true
+}
+
+func (a *HMACAuth) Challenge() string {
+	return HMACScheme
+}
+
+// SignRequest signs the request with the shared secret as HMACAuth expects, by the HMAC-SHA256 of its method,
+// its URI, and the current time, which must be resent with a new signature once the time has passed.
+func SignRequest(r *http.Request, secret []byte) {
+	now := time.Now().Unix()
+	mac := requestMAC(secret, r.Method, r.URL.RequestURI(), now)
+	r.Header.Set("Authorization", HMACScheme+" "+strconv.FormatInt(now, 10)+" "+base64.StdEncoding.EncodeToString(mac))
+}
+
+// requestMAC returns the HMAC of a request signed at the given time.
+func requestMAC(secret []byte, method, uri string, signed int64) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(method + "\n" + uri + "\n" + strconv.FormatInt(signed, 10)))
+	return mac.Sum(nil)
+}
+
+// credentials returns the credentials of the request's Authorization header when it uses the scheme.
+func credentials(r *http.Request, scheme string) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	if len(auth) <= len(scheme) || !strings.EqualFold(auth[:len(scheme)], scheme) || auth[len(scheme)] != ' ' {
+		return "", false
+	}
+	return strings.TrimSpace(auth[len(scheme)+1:]), true
+}
+
+// secretsEqual reports whether two secrets are equal, in time independent of their contents and lengths.
+func secretsEqual(a, b string) bool {
+	x, y := sha256.Sum256([]byte(a)), sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(x[:], y[:]) == 1
+}
@@ -0,0 +1,360 @@
+// Package azureserver implements a fync.Server for mods stored in an Azure Blob Storage container.
+//
+// Blobs are listed directly beneath a configurable prefix. Each blob's SHA-256 checksum is read from
+// a "sha256" metadata entry containing the hex encoded checksum, and blobs without one are compared by size.
+package azureserver
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/han-tyumi/fync"
+)
+
+// apiVersion is the version of the Blob service REST API used.
+const apiVersion = "2020-04-08"
+
+// Options contains options for the New function.
+type Options struct {
+	// Only blobs directly beneath this prefix, such as "pack/mods/", are listed.
+	Prefix string
+
+	// A shared access signature granting read and list access to the container, with or without
+	// its leading question mark. A signature may instead be included within the container URL.
+	SASToken string
+
+	// Name of the storage account and its base64 encoded access key, used to authorize requests
+	// with Shared Key authorization. The account name defaults to the first label of the URL's host.
+	AccountName, AccountKey string
+
+	// The HTTP client used for all requests. Defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// Server is a fync.Server that lists mods from an Azure Blob Storage container.
+type Server struct {
+	container *url.URL
+	prefix    string
+	sas       url.Values
+	account   string
+	key       []byte
+	client    *http.Client
+}
+
+// New returns a Server for the container at the given URL, such as
+// https://account.blob.core.windows.net/container. Public containers need no authorization.
+func New(containerURL string, o *Options) (*Server, error) {
+	u, err := url.Parse(containerURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported URL scheme %q", u.Scheme)
+	}
+
+	if o == nil {
+		o = &Options{}
+	}
+
+	s := &Server{prefix: o.Prefix, client: o.Client}
+	if s.client == nil {
+		s.client = http.DefaultClient
+	}
+
+	// a signature may be given within the URL or separately
+	token := strings.TrimPrefix(o.SASToken, "?")
+	if token == "" {
+		token = u.RawQuery
+	}
+	if s.sas, err = url.ParseQuery(token); err != nil {
+		return nil, fmt.Errorf("azureserver: invalid SAS token: %w", err)
+	}
+	u.RawQuery = ""
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	s.container = u
+
+	if o.AccountKey != "" {
+		if s.key, err = base64.StdEncoding.DecodeString(o.AccountKey); err != nil {
+			return nil, fmt.Errorf("azureserver: invalid account key: %w", err)
+		}
+
+		s.account = o.AccountName
+		if s.account == "" {
+			s.account = strings.SplitN(u.Hostname(), ".", 2)[0]
+		}
+	}
+	return s, nil
+}
+
+// String returns the URL of the container and prefix without any signature.
+func (s *Server) String() string {
+	return s.container.String() + "/" + s.prefix
+}
+
+// Mods returns a slice of mod ServerFiles for each jar directly beneath the prefix.
+// Mods are not downloaded until they are written.
+func (s *Server) Mods() ([]fync.ServerFile, error) {
+	var mods []fync.ServerFile
+
+	marker := ""
+	for {
+		query := url.Values{
+			"restype":   {"container"},
+			"comp":      {"list"},
+			"prefix":    {s.prefix},
+			"delimiter": {"/"},
+			"include":   {"metadata"},
+		}
+		if marker != "" {
+			query.Set("marker", marker)
+		}
+
+		res, err := s.request(http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			Blobs struct {
+				Blob []struct {
+					Name       string
+					Properties struct {
+						ContentLength int64  `xml:"Content-Length"`
+						LastModified  string `xml:"Last-Modified"`
+						Etag          string
+					}
+					Metadata struct {
+						SHA256 string `xml:"sha256"`
+					}
+				}
+			}
+			NextMarker string
+		}
+		err = xml.NewDecoder(res.Body).Decode(&result)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, b := range result.Blobs.Blob {
+			if !strings.HasSuffix(b.Name, ".jar") {
+				continue
+			}
+
+			modTime, _ := http.ParseTime(b.Properties.LastModified)
+			mods = append(mods, &file{
+				server:  s,
+				name:    b.Name,
+				size:    b.Properties.ContentLength,
+				modTime: modTime,
+				etag:    b.Properties.Etag,
+				sum:     strings.ToLower(b.Metadata.SHA256),
+			})
+		}
+
+		if result.NextMarker == "" {
+			return mods, nil
+		}
+		marker = result.NextMarker
+	}
+}
+
+// request sends an authorized request for the named blob, or the container when it is empty.
+func (s *Server) request(method, name string, query url.Values, header http.Header) (*http.Response, error) {
+	u := *s.container
+	if name != "" {
+		u.Path += "/" + name
+	}
+
+	q := url.Values{}
+	for k, v := range query {
+		q[k] = v
+	}
+	for k, v := range s.sas {
+		q[k] = v
+	}
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("x-ms-version", apiVersion)
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+
+	if s.key != nil {
+		s.sign(req, query)
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		defer res.Body.Close()
+
+		target := s.container.String()
+		if name != "" {
+			target += "/" + name
+		}
+		return nil, responseError(target, res)
+	}
+	return res, nil
+}
+
+// sign authorizes the request with Shared Key authorization. Only the request's
+// own query parameters are signed, since a SAS token is not used alongside a key.
+func (s *Server) sign(req *http.Request, query url.Values) {
+	var msHeaders []string
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-ms-") {
+			msHeaders = append(msHeaders, name+":"+strings.TrimSpace(strings.Join(values, ",")))
+		}
+	}
+	sort.Strings(msHeaders)
+
+	resource := "/" + s.account + req.URL.EscapedPath()
+	var params []string
+	for name, values := range query {
+		sorted := append([]string(nil), values...)
+		sort.Strings(sorted)
+		params = append(params, strings.ToLower(name)+":"+strings.Join(sorted, ","))
+	}
+	sort.Strings(params)
+	for _, p := range params {
+		resource += "\n" + p
+	}
+
+	stringToSign := strings.Join([]string{
+		req.Method,
+		req.Header.Get("Content-Encoding"),
+		req.Header.Get("Content-Language"),
+		"", // Content-Length, which is always empty for requests without a body
+		req.Header.Get("Content-MD5"),
+		req.Header.Get("Content-Type"),
+		"", // Date, replaced by x-ms-date
+		req.Header.Get("If-Modified-Since"),
+		req.Header.Get("If-Match"),
+		req.Header.Get("If-None-Match"),
+		req.Header.Get("If-Unmodified-Since"),
+		req.Header.Get("Range"),
+		strings.Join(msHeaders, "\n"),
+		resource,
+	}, "\n")
+
+	h := hmac.New(sha256.New, s.key)
+	h.Write([]byte(stringToSign))
+	req.Header.Set("Authorization", "SharedKey "+s.account+":"+base64.StdEncoding.EncodeToString(h.Sum(nil)))
+}
+
+// responseError returns an error describing an unsuccessful response, including the service's error message if any.
+func responseError(name string, res *http.Response) error {
+	if res.StatusCode == http.StatusPreconditionFailed {
+		return fmt.Errorf("%s: changed since it was listed", name)
+	}
+
+	var e struct {
+		Code    string
+		Message string
+	}
+	data, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1<<16))
+	if xml.Unmarshal(data, &e) == nil && e.Code != "" {
+		return fmt.Errorf("%s: %s: %s", name, e.Code, strings.SplitN(e.Message, "\n", 2)[0])
+	}
+	return fmt.Errorf("%s: unexpected status %q", name, res.Status)
+}
+
+// file is a fync.ServerFile that downloads a mod from the container.
+type file struct {
+	server  *Server
+	name    string
+	size    int64
+	modTime time.Time
+	etag    string
+	sum     string
+	res     *http.Response
+}
+
+// String returns the URL of the mod without any signature.
+func (f *file) String() string {
+	return f.server.container.String() + "/" + f.name
+}
+
+func (f *file) Stat() (os.FileInfo, error) {
+	return fileInfo{f}, nil
+}
+
+// SHA256 returns the checksum from the blob's sha256 metadata entry, if any.
+func (f *file) SHA256() (string, error) {
+	return f.sum, nil
+}
+
+func (f *file) WriteTo(w io.Writer) (int64, error) {
+	if f.res == nil {
+		// fail rather than mix up the blob's listed size with a newer version
+		var header http.Header
+		if f.etag != "" {
+			header = http.Header{"If-Match": {f.etag}}
+		}
+
+		res, err := f.server.request(http.MethodGet, f.name, nil, header)
+		if err != nil {
+			return 0, err
+		}
+		f.res = res
+	}
+
+	defer f.Close()
+	return io.Copy(w, f.res.Body)
+}
+
+// Seek only supports rewinding to the start of the file so that a failed transfer can be retried.
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekStart {
+		return 0, errors.New("azureserver: can only seek to the start of a file")
+	}
+	return 0, f.Close()
+}
+
+func (f *file) Close() error {
+	if f.res == nil {
+		return nil
+	}
+
+	err := f.res.Body.Close()
+	f.res = nil
+	return err
+}
+
+type fileInfo struct {
+	f *file
+}
+
+func (i fileInfo) Name() string       { return path.Base(i.f.name) }
+func (i fileInfo) Size() int64        { return i.f.size }
+func (i fileInfo) Mode() os.FileMode  { return 0644 }
+func (i fileInfo) ModTime() time.Time { return i.f.modTime }
+func (i fileInfo) IsDir() bool        { return false }
+func (i fileInfo) Sys() interface{}   { return nil }
+
+var _ fync.HashedFile = (*file)(nil)
@@ -0,0 +1,481 @@
+package fync
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat is the layout used to name each sync's backup set.
+const backupTimeFormat = "2006-01-02T15-04-05"
+
+// backupArchiveExt is the extension of backup sets compressed into an archive.
+const backupArchiveExt = ".zip"
+
+// backupIndexName is the name of the file within a backup set recording the sync's changes.
+const backupIndexName = "index.json"
+
+// backupSet is the backup directory for the mods replaced or removed by a single sync.
+// It is only created once the first mod is backed up or the sync's changes are saved.
+type backupSet struct {
+	dir     string
+	once    sync.Once
+	created bool
+	err     error
+
+	mu    sync.Mutex
+	index backupIndex
+
+	// records each mod before it is backed up
+	journal *journal
+}
+
+// backupIndex records the changes a sync made to the mods directory.
+type backupIndex struct {
+	// Names of the mods written by the sync.
+	Installed []string `json:"installed,omitempty"`
+
+	// The mods backed up by the sync.
+	Files []BackupFile `json:"files,omitempty"`
+}
+
+// BackupReason is the reason a mod was backed up.
+type BackupReason string
+
+const (
+	// BackupReplaced is the reason for a mod replaced by a different server mod of the same name.
+	BackupReplaced BackupReason = "replaced"
+
+	// BackupNotOnServer is the reason for a mod removed because it was not on the server.
+	BackupNotOnServer BackupReason = "not-on-server"
+)
+
+// BackupFile describes a mod within a backup set.
+type BackupFile struct {
+	// Name of the mod.
+	Name string `json:"name"`
+
+	// Where the mod was located before it was backed up.
+	Path string `json:"path"`
+
+	// Size of the mod in bytes.
+	Size int64 `json:"size"`
+
+	// Hex encoded SHA-256 checksum of the mod.
+	SHA256 string `json:"sha256"`
+
+	// Why the mod was backed up.
+	Reason BackupReason `json:"reason"`
+
+	// Whether the mod is stored once by its checksum in the shared object store
+	// rather than within the backup set itself.
+	Deduplicated bool `json:"deduplicated,omitempty"`
+}
+
+// backupObjectsName is the name of the directory within the backup directory
+// that stores deduplicated mods by their checksum.
+const backupObjectsName = "objects"
+
+func objectPath(sum string) string {
+	return filepath.Join(backupDir, backupObjectsName, sum)
+}
+
+func newBackupSet(t time.Time) *backupSet {
+	return &backupSet{dir: filepath.Join(backupDir, t.Format(backupTimeFormat))}
+}
+
+func (b *backupSet) backup(name string, reason BackupReason, o *SyncOptions) error {
+	from := filepath.Join(modsDir, name)
+
+	if o.Delete {
+		if o.OnDelete != nil {
+			o.OnDelete(name, from)
+		}
+		if err := b.journal.backup(name, "", false); err != nil {
+			return err
+		}
+		return os.Remove(from)
+	}
+
+	if o.UseTrash {
+		if o.OnBackup != nil {
+			o.OnBackup(name, from, "")
+		}
+		if err := b.journal.backup(name, "", false); err != nil {
+			return err
+		}
+		return trash(from)
+	}
+
+	if err := b.ensure(); err != nil {
+		return err
+	}
+
+	to := filepath.Join(b.dir, name)
+
+	info, err := os.Stat(from)
+	if err != nil {
+		return err
+	}
+
+	sum, err := hashFile(from)
+	if err != nil {
+		return err
+	}
+
+	if o.DeduplicateBackups {
+		to = objectPath(sum)
+	}
+
+	if o.OnBackup != nil {
+		o.OnBackup(name, from, to)
+	}
+
+	if err := b.journal.backup(name, to, o.DeduplicateBackups); err != nil {
+		return err
+	}
+
+	if o.DeduplicateBackups {
+		if err := storeObject(from, to); err != nil {
+			return err
+		}
+	} else if err := move(from, to); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	b.index.Files = append(b.index.Files, BackupFile{
+		Name:         name,
+		Path:         from,
+		Size:         info.Size(),
+		SHA256:       sum,
+		Reason:       reason,
+		Deduplicated: o.DeduplicateBackups,
+	})
+	b.mu.Unlock()
+
+	return nil
+}
+
+var objectsMu sync.Mutex
+
+// storeObject moves the mod at from into the object store at to,
+// discarding it instead if an identical copy is already stored.
+func storeObject(from, to string) error {
+	objectsMu.Lock()
+	defer objectsMu.Unlock()
+
+	if _, err := os.Stat(to); err == nil {
+		return os.Remove(from)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(to), os.ModeDir|0755); err != nil {
+		return err
+	}
+	return move(from, to)
+}
+
+// collectObjects removes stored objects that are no longer referenced by any backup set.
+func collectObjects() error {
+	objectsMu.Lock()
+	defer objectsMu.Unlock()
+
+	objects, err := ioutil.ReadDir(filepath.Join(backupDir, backupObjectsName))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	sets, err := backupSets()
+	if err != nil {
+		return err
+	}
+
+	referenced := make(map[string]bool)
+	for _, set := range sets {
+		index, _, err := set.contents()
+		if err != nil {
+			return err
+		}
+
+		for _, f := range index.Files {
+			if f.Deduplicated {
+				referenced[f.SHA256] = true
+			}
+		}
+	}
+
+	for i := range objects {
+		if !referenced[objects[i].Name()] {
+			if err := os.Remove(objectPath(objects[i].Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// install records that the named mod was written by the sync.
+func (b *backupSet) install(name string) {
+	b.mu.Lock()
+	b.index.Installed = append(b.index.Installed, name)
+	b.mu.Unlock()
+}
+
+// save writes the backup set's index if the sync made any changes,
+// compressing the backup set into an archive when requested.
+// Nothing is saved when displaced mods were deleted or moved to the trash instead.
+func (b *backupSet) save(o *SyncOptions) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if o.Delete || o.UseTrash || len(b.index.Installed) == 0 && !b.created {
+		return nil
+	}
+
+	if err := b.ensure(); err != nil {
+		return err
+	}
+
+	sort.Strings(b.index.Installed)
+	sort.Slice(b.index.Files, func(i, j int) bool {
+		return b.index.Files[i].Name < b.index.Files[j].Name
+	})
+	data, err := json.MarshalIndent(b.index, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(b.dir, backupIndexName), data, 0644); err != nil {
+		return err
+	}
+
+	if o.CompressBackups {
+		c, err := codec(o.BackupCodec)
+		if err != nil {
+			return err
+		}
+		return b.compress(c)
+	}
+	return nil
+}
+
+// compress replaces the backup set's directory with an archive of its files compressed by c.
+func (b *backupSet) compress(c Codec) (err error) {
+	files, err := ioutil.ReadDir(b.dir)
+	if err != nil {
+		return err
+	}
+
+	path := b.dir + backupArchiveExt
+	archive, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			archive.Close()
+			os.Remove(path)
+		}
+	}()
+
+	w := zip.NewWriter(archive)
+	if c.Compressor != nil {
+		w.RegisterCompressor(c.Method, c.Compressor)
+	}
+
+	for i := range files {
+		if files[i].IsDir() {
+			continue
+		}
+
+		if err := addToArchive(w, filepath.Join(b.dir, files[i].Name()), files[i], c.Method); err != nil {
+			return err
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+
+	return os.RemoveAll(b.dir)
+}
+
+func addToArchive(w *zip.Writer, path string, info os.FileInfo, method uint16) error {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Method = method
+
+	dest, err := w.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(dest, file)
+	return err
+}
+
+func (b *backupSet) ensure() error {
+	b.once.Do(func() {
+		b.err = b.create()
+	})
+	return b.err
+}
+
+// create makes the backup set's directory, adding a suffix
+// if a set already exists from another sync within the same second.
+func (b *backupSet) create() error {
+	if err := os.MkdirAll(backupDir, os.ModeDir|0755); err != nil {
+		return err
+	}
+
+	dir := b.dir
+	for i := 1; ; i++ {
+		if i > 1 {
+			dir = b.dir + "-" + strconv.Itoa(i-1)
+		}
+
+		// a compressed set may already exist in its place
+		if _, err := os.Stat(dir + backupArchiveExt); err == nil {
+			continue
+		}
+
+		err := os.Mkdir(dir, os.ModeDir|0755)
+		if err == nil {
+			b.dir = dir
+			b.created = true
+			return nil
+		}
+		if !os.IsExist(err) {
+			return err
+		}
+	}
+}
+
+// PruneBackups removes backup sets so that only the keepLast most recent remain,
+// sparing any older sets that were created within the olderThan duration.
+// A keepLast of zero considers all sets, and an olderThan of zero ignores their age.
+// The number of backup sets removed is returned as well as any errors encountered.
+func PruneBackups(keepLast int, olderThan time.Duration) (int, error) {
+	var n int
+
+	if dirErr != nil {
+		return n, dirErr
+	}
+
+	sets, err := backupSets()
+	if err != nil {
+		return n, err
+	}
+
+	if keepLast >= len(sets) {
+		return n, nil
+	}
+
+	cutoff := time.Now().Add(-olderThan)
+	for _, set := range sets[keepLast:] {
+		if olderThan > 0 && set.created.After(cutoff) {
+			continue
+		}
+
+		if err := os.RemoveAll(set.path()); err != nil {
+			return n, err
+		}
+		n++
+	}
+
+	if n == 0 {
+		return n, nil
+	}
+	return n, collectObjects()
+}
+
+type backupSetEntry struct {
+	name       string
+	created    time.Time
+	seq        int
+	compressed bool
+}
+
+// path returns the location of the backup set's directory or archive.
+func (e backupSetEntry) path() string {
+	if e.compressed {
+		return filepath.Join(backupDir, e.name+backupArchiveExt)
+	}
+	return filepath.Join(backupDir, e.name)
+}
+
+// backupSets returns the backup sets within the backup directory, newest first.
+// Entries that are not named like a backup set are ignored.
+func backupSets() ([]backupSetEntry, error) {
+	files, err := ioutil.ReadDir(backupDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var sets []backupSetEntry
+	for i := range files {
+		name := files[i].Name()
+
+		compressed := !files[i].IsDir()
+		if compressed {
+			if !strings.HasSuffix(name, backupArchiveExt) {
+				continue
+			}
+			name = strings.TrimSuffix(name, backupArchiveExt)
+		}
+
+		// sets may have a suffix when created within the same second
+		stamp := name
+		if len(stamp) > len(backupTimeFormat) {
+			stamp = stamp[:len(backupTimeFormat)]
+		}
+
+		created, err := time.ParseInLocation(backupTimeFormat, stamp, time.Local)
+		if err != nil {
+			continue
+		}
+
+		var seq int
+		if suffix := name[len(stamp):]; suffix != "" {
+			if !strings.HasPrefix(suffix, "-") {
+				continue
+			}
+			if seq, err = strconv.Atoi(suffix[1:]); err != nil {
+				continue
+			}
+		}
+
+		sets = append(sets, backupSetEntry{name, created, seq, compressed})
+	}
+
+	sort.Slice(sets, func(i, j int) bool {
+		if sets[i].created.Equal(sets[j].created) {
+			return sets[i].seq > sets[j].seq
+		}
+		return sets[i].created.After(sets[j].created)
+	})
+	return sets, nil
+}
@@ -0,0 +1,170 @@
+package fync
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// limitChunk is the most bytes of a response written at once by LimitBandwidth,
+// which is also as many as can be sent in a burst after a pause.
+const limitChunk = 32 << 10
+
+// BandwidthLimit limits the bandwidth a host uses to serve mods, so that many players syncing
+// a large update at once do not saturate the host's connection. Limits are in bytes per second,
+// and a limit of zero is unlimited.
+type BandwidthLimit struct {
+	// The bandwidth of all clients together.
+	Total int64
+
+	// The bandwidth of each client, identified by its IP address. Clients behind the same proxy
+	// or network address translation share it.
+	PerClient int64
+}
+
+// LimitBandwidth returns a handler serving with h, whose responses are sent no faster than the limit allows.
+// Clients share the total bandwidth roughly evenly, since each write waits its turn.
+func LimitBandwidth(h http.Handler, limit BandwidthLimit) http.Handler {
+	if limit.Total <= 0 && limit.PerClient <= 0 {
+		return h
+	}
+
+	l := &limiter{handler: h, perClient: limit.PerClient, clients: make(map[string]*clientBucket)}
+	if limit.Total > 0 {
+		l.total = newBucket(limit.Total)
+	}
+	return l
+}
+
+type limiter struct {
+	handler   http.Handler
+	total     *bucket
+	perClient int64
+
+	// buckets of clients with requests in progress
+	mu      sync.Mutex
+	clients map[string]*clientBucket
+}
+
+type clientBucket struct {
+	*bucket
+	requests int
+}
+
+func (l *limiter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	lw := &limitedWriter{ResponseWriter: w, ctx: r.Context()}
+	if l.total != nil {
+		lw.buckets = append(lw.buckets, l.total)
+	}
+
+	if l.perClient > 0 {
+		client := clientIP(r)
+
+		l.mu.Lock()
+		b, ok := l.clients[client]
+		if !ok {
+			b = &clientBucket{bucket: newBucket(l.perClient)}
+			l.clients[client] = b
+		}
+		b.requests++
+		l.mu.Unlock()
+
+		defer func() {
+			l.mu.Lock()
+			if b.requests--; b.requests == 0 {
+				delete(l.clients, client)
+			}
+			l.mu.Unlock()
+		}()
+		lw.buckets = append(lw.buckets, b.bucket)
+	}
+
+	l.handler.ServeHTTP(lw, r)
+}
+
+// limitedWriter is an http.ResponseWriter writing no faster than each of its buckets allows.
+type limitedWriter struct {
+	http.ResponseWriter
+	ctx     context.Context
+	buckets []*bucket
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	var n int
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > limitChunk {
+			chunk = chunk[:limitChunk]
+		}
+
+		var wait time.Duration
+		for _, b := range w.buckets {
+			if d := b.reserve(len(chunk)); d > wait {
+				wait = d
+			}
+		}
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-w.ctx.Done():
+				timer.Stop()
+				return n, w.ctx.Err()
+			case <-timer.C:
+			}
+		}
+
+		written, err := w.ResponseWriter.Write(chunk)
+		n += written
+		if err != nil {
+			return n, err
+		}
+		p = p[len(chunk):]
+	}
+	return n, nil
+}
+
+// Flush flushes the underlying writer, if it can be, so that streamed responses are still sent as they are written.
+func (w *limitedWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying writer, so that callers can reach methods the limit does not wrap.
+func (w *limitedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// bucket is a token bucket of bytes, refilled at its rate up to a burst of limitChunk bytes.
+// Bytes are reserved ahead of time, so that writers waiting on it are served in turn.
+type bucket struct {
+	rate float64
+
+	mu    sync.Mutex
+	avail float64
+	last  time.Time
+}
+
+func newBucket(rate int64) *bucket {
+	return &bucket{rate: float64(rate), avail: limitChunk, last: time.Now()}
+}
+
+// reserve takes n bytes from the bucket, returning how long to wait before sending them.
+func (b *bucket) reserve(n int) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.avail += now.Sub(b.last).Seconds() * b.rate
+	if b.avail > limitChunk {
+		b.avail = limitChunk
+	}
+	b.last = now
+
+	b.avail -= float64(n)
+	if b.avail >= 0 {
+		return 0
+	}
+	return time.Duration(-b.avail / b.rate * float64(time.Second))
+}
@@ -0,0 +1,127 @@
+package fync
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// basisExt is the extension of the copy of a mod being replaced that its update is transferred as a delta from.
+// It ends with tempExt so that a copy left by an interrupted sync is cleaned up.
+const basisExt = ".basis" + tempExt
+
+// DeltaFile represents a ServerFile that is able to transfer only the parts of a mod
+// that differ from an older version of it, such as in the manner of rsync.
+// Sync writes it as a delta from the local mod it replaces, or otherwise from a local mod being removed
+// with the same name apart from its version. Mods that cannot be written as a delta are written whole.
+type DeltaFile interface {
+	ServerFile
+
+	// WriteDelta writes the mod to w like WriteTo, reading the parts it has in common
+	// with the basis, an older version of the mod of the given size, from the basis.
+	WriteDelta(w io.Writer, basis io.ReaderAt, size int64) (int64, error)
+}
+
+// ConditionalFile represents a ServerFile that can tell whether it is the same as a local copy of it
+// without being transferred, such as by a conditional request. Sync checks mods replacing or overwriting
+// a local mod of the same name and size, or of unknown size, which are written from the local mod when unchanged.
+// The check comes before any delta transfer, since it costs less even when the mod has changed.
+type ConditionalFile interface {
+	ServerFile
+
+	// Unchanged reports whether the mod is the same as the local copy of it with the given hex encoded
+	// SHA-256 checksum. Reporting false is always safe, and only costs writing the mod.
+	Unchanged(sum string) (bool, error)
+}
+
+// deltaBases returns the paths of the local mods each DeltaFile being written can be transferred as a delta from,
+// and those each ConditionalFile can be written from when unchanged, by the name of the mod being written.
+func deltaBases(writes, removals []*Action) map[string]string {
+	// mods being removed that are the only one with their name apart from the version
+	stems := make(map[string]string)
+	for _, a := range removals {
+		stem, _ := parseModFileName(a.Name)
+		if _, ok := stems[stem]; ok {
+			stems[stem] = ""
+		} else {
+			stems[stem] = a.Name
+		}
+	}
+
+	bases := make(map[string]string)
+	for _, a := range writes {
+		_, isDelta := a.file.(DeltaFile)
+		_, isConditional := a.file.(ConditionalFile)
+		if !isDelta && !isConditional {
+			continue
+		}
+
+		path := filepath.Join(modsDir, a.Name)
+		if _, err := os.Stat(path); err == nil {
+			bases[a.Name] = path
+		} else if stem, _ := parseModFileName(a.Name); isDelta && stems[stem] != "" {
+			bases[a.Name] = filepath.Join(modsDir, stems[stem])
+		}
+	}
+	return bases
+}
+
+// keepBasis keeps a copy of the local mod at path, which is about to be backed up, for its update to be
+// transferred as a delta from. The path of the copy is returned, which should be removed once written.
+func keepBasis(path string) (string, error) {
+	basis := path + basisExt
+	os.Remove(basis)
+
+	// a hard link costs nothing, though not every volume supports them
+	if err := os.Link(path, basis); err == nil {
+		return basis, nil
+	}
+	if err := copyFile(path, basis); err != nil {
+		os.Remove(basis)
+		return "", err
+	}
+	return basis, nil
+}
+
+// writeMod writes from, of the given size, to w. When basis is not empty, from is written from the local mod
+// at basis when it is a ConditionalFile that is unchanged from it, and otherwise transferred as a delta from it
+// when it is a DeltaFile.
+func writeMod(from ServerFile, w io.Writer, basis string, size int64) (int64, error) {
+	c, isConditional := from.(ConditionalFile)
+	d, isDelta := from.(DeltaFile)
+	if basis == "" || !isConditional && !isDelta {
+		return from.WriteTo(w)
+	}
+
+	f, err := os.Open(basis)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	// mods of a different size have certainly changed
+	if isConditional && (size < 0 || size == info.Size()) {
+		sum, err := hashFile(basis)
+		if err != nil {
+			return 0, err
+		}
+
+		unchanged, err := c.Unchanged(sum)
+		if err != nil {
+			return 0, err
+		}
+		if unchanged {
+			return io.Copy(w, f)
+		}
+	}
+
+	if !isDelta {
+		return from.WriteTo(w)
+	}
+	return d.WriteDelta(w, f, info.Size())
+}
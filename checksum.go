@@ -0,0 +1,86 @@
+package fync
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ChecksumServer represents a Server that is able to provide SHA-256 checksums for its mods.
+type ChecksumServer interface {
+	Server
+
+	// Checksums returns a map of mod file names to their hex encoded SHA-256 checksums.
+	// Mods missing from the map are compared by size only.
+	Checksums() (map[string]string, error)
+}
+
+// HashedFile represents a ServerFile that is able to provide its own SHA-256 checksum,
+// such as from object storage metadata or an API response.
+// Checksums provided by a ChecksumServer take precedence.
+type HashedFile interface {
+	ServerFile
+
+	// SHA256 returns the hex encoded SHA-256 checksum of the file,
+	// or an empty string if it is unknown.
+	SHA256() (string, error)
+}
+
+// ParseChecksums parses checksums in the format produced by sha256sum,
+// such as a sha256sums.txt file, into a map of file names to hex encoded checksums.
+func ParseChecksums(r io.Reader) (map[string]string, error) {
+	sums := make(map[string]string)
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(text, " ", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("checksums line %d: missing file name", line)
+		}
+
+		sum := strings.ToLower(fields[0])
+		if !validChecksum(sum) {
+			return nil, fmt.Errorf("checksums line %d: invalid SHA-256 checksum %q", line, fields[0])
+		}
+
+		// binary mode entries are prefixed with an asterisk
+		name := strings.TrimPrefix(strings.TrimSpace(fields[1]), "*")
+		sums[name] = sum
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return sums, nil
+}
+
+func validChecksum(sum string) bool {
+	if len(sum) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(sum)
+	return err == nil
+}
+
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
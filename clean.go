@@ -0,0 +1,48 @@
+package fync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// tempExt is the extension of mods that are still being written.
+// Mods are only renamed to their proper name once they are completely written and verified.
+const tempExt = ".fync-tmp"
+
+// Clean removes partially written mods left within the mods directory by syncs that were
+// interrupted, such as by a crash. It is run at the start of each sync.
+// The number of files removed is returned as well as any errors encountered.
+func Clean() (int, error) {
+	if dirErr != nil {
+		return 0, dirErr
+	}
+	return CleanTemp(modsDir)
+}
+
+// CleanTemp removes partially written mods left within dir by syncs that were interrupted.
+// It is run at the start of each sync for the chosen SyncOptions.TempDir.
+// The number of files removed is returned as well as any errors encountered.
+func CleanTemp(dir string) (int, error) {
+	var n int
+
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return n, nil
+	} else if err != nil {
+		return n, err
+	}
+
+	for i := range files {
+		if files[i].IsDir() || !strings.HasSuffix(files[i].Name(), tempExt) {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(dir, files[i].Name())); err != nil && !os.IsNotExist(err) {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,72 @@
+package fync
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+)
+
+// ClientCertAuth authenticates clients by the TLS certificate they present, for packs only served
+// to whitelisted players' machines. Certificates are allowed when issued by one of Roots, identified by their
+// subject's common name, or when their key is one of Keys, which allows self-signed certificates.
+// The http.Server must request client certificates, as with the config returned by TLSConfig.
+type ClientCertAuth struct {
+	// Certificate authorities whose client certificates are allowed. When Keys is also set,
+	// certificates must both be issued by one of them and have one of the keys.
+	Roots *x509.CertPool
+
+	// The identity of each allowed client, by the fingerprint of its certificate's key as by KeyFingerprint.
+	Keys map[string]string
+}
+
+// Authenticate checks the certificate the client presented.
+func (a *ClientCertAuth) Authenticate(r *http.Request) (string, bool) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 || a.Roots == nil && a.Keys == nil {
+		return "", false
+	}
+	cert := r.TLS.PeerCertificates[0]
+
+	user := cert.Subject.CommonName
+	if a.Roots != nil {
+		intermediates := x509.NewCertPool()
+		for _, c := range r.TLS.PeerCertificates[1:] {
+			intermediates.AddCert(c)
+		}
+
+		_, err := cert.Verify(x509.VerifyOptions{
+			Roots:         a.Roots,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		})
+		if err != nil {
+			return "", false
+		}
+	}
+
+	if a.Keys != nil {
+		var ok bool
+		if user, ok = a.Keys[KeyFingerprint(cert.RawSubjectPublicKeyInfo)]; !ok {
+			return "", false
+		}
+	}
+	return user, true
+}
+
+// Challenge returns nothing, since client certificates are presented while connecting rather than along with requests.
+func (a *ClientCertAuth) Challenge() string {
+	return ""
+}
+
+// TLSConfig returns a copy of the config, or a new config when nil, that requires clients to present
+// a certificate, leaving it to be verified by Authenticate so that self-signed certificates can be allowed
+// and rejected clients are told why by their response.
+func (a *ClientCertAuth) TLSConfig(config *tls.Config) *tls.Config {
+	if config == nil {
+		config = &tls.Config{}
+	} else {
+		config = config.Clone()
+	}
+
+	config.ClientAuth = tls.RequireAnyClientCert
+	return config
+}
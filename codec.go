@@ -0,0 +1,88 @@
+package fync
+
+import (
+	"archive/zip"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Codec compresses the mods within backup sets that are compressed into an archive.
+// Zstandard or xz, for example, may be registered using an implementation of the
+// compression format by its zip method ID, 93 or 95 respectively.
+type Codec struct {
+	// The zip compression method identifying the codec within an archive.
+	Method uint16
+
+	// Compresses and decompresses mods. These may be nil for methods that are
+	// built into the archive/zip package, such as zip.Store and zip.Deflate.
+	Compressor   zip.Compressor
+	Decompressor zip.Decompressor
+}
+
+// DefaultCodec is the name of the codec used when none is chosen.
+const DefaultCodec = "deflate"
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{
+		"none":       {Method: zip.Store},
+		DefaultCodec: {Method: zip.Deflate},
+	}
+)
+
+// RegisterCodec makes a codec available by the given name for SyncOptions.BackupCodec,
+// replacing any codec already registered by that name.
+// Codecs needed to restore existing backup sets must remain registered.
+func RegisterCodec(name string, c Codec) {
+	codecsMu.Lock()
+	codecs[name] = c
+	codecsMu.Unlock()
+}
+
+// Codecs returns the names of the registered codecs in sorted order.
+func Codecs() []string {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	names := make([]string, 0, len(codecs))
+	for name := range codecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// codec returns the registered codec with the given name, or the default codec when it is empty.
+func codec(name string) (Codec, error) {
+	if name == "" {
+		name = DefaultCodec
+	}
+
+	codecsMu.RLock()
+	c, ok := codecs[name]
+	codecsMu.RUnlock()
+
+	if !ok {
+		return c, fmt.Errorf("unknown codec %q", name)
+	}
+	return c, nil
+}
+
+// openArchive opens the archive at path, able to decompress the methods of any registered codec.
+func openArchive(path string) (*zip.ReadCloser, error) {
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+
+	codecsMu.RLock()
+	for _, c := range codecs {
+		if c.Decompressor != nil {
+			archive.RegisterDecompressor(c.Method, c.Decompressor)
+		}
+	}
+	codecsMu.RUnlock()
+
+	return archive, nil
+}
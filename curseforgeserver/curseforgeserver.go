@@ -0,0 +1,668 @@
+// Package curseforgeserver implements a fync.Server for the mods of a CurseForge modpack,
+// given as its manifest.json or as the exported pack containing it, or for a list of CurseForge files.
+// Each file is resolved by its project and file ID through the CurseForge API, which requires an API key,
+// and verified against the SHA-1 hash CurseForge records for it. Requests to the API are spaced out
+// and retried when rate limited, and resolved files can be cached across runs.
+// Mods within a pack's overrides are served from the pack itself.
+//
+// Some authors do not allow their mods to be downloaded by third-party tools.
+// Such mods are reported together as DistributionErrors so they can be installed by hand.
+package curseforgeserver
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/han-tyumi/fync"
+)
+
+// DefaultAPI is the base URL of the CurseForge API used when none is chosen.
+const DefaultAPI = "https://api.curseforge.com"
+
+// manifestName is the name of the manifest within an exported pack.
+const manifestName = "manifest.json"
+
+// sha1Algo identifies SHA-1 hashes within the CurseForge API.
+const sha1Algo = 1
+
+// DefaultRequestInterval is the time between requests to the API used when none is chosen.
+const DefaultRequestInterval = 200 * time.Millisecond
+
+// maxAttempts is the number of times a request to the API is made while it is rate limited.
+const maxAttempts = 5
+
+// fileBatch is the most files resolved by a single request.
+const fileBatch = 500
+
+// Options contains options for the New function.
+type Options struct {
+	// Key used to authenticate with the CurseForge API. Defaults to $CURSEFORGE_API_KEY.
+	APIKey string
+
+	// Base URL of the CurseForge API. Defaults to DefaultAPI.
+	API string
+
+	// Whether to include the files the manifest marks as not required.
+	Optional bool
+
+	// Path of a file caching resolved files across runs, since a file ID always refers to the same file.
+	// Files that could not be downloaded are resolved again, since their authors may begin to allow it.
+	// Resolved files are not cached if empty.
+	CacheFile string
+
+	// The least time between requests to the API. Defaults to DefaultRequestInterval.
+	// Requests that are rate limited are retried after the time the API asks for.
+	RequestInterval time.Duration
+
+	// Whether to leave out mods that cannot be downloaded by third parties rather than
+	// failing with DistributionErrors. Syncs should then use SyncOptions.ManagedOnly
+	// or SyncOptions.KeepExisting so that those mods are kept once installed by hand.
+	SkipDisallowed bool
+
+	// The HTTP client used for all requests. Defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// DistributionError describes a mod whose author does not allow it to be downloaded by third parties.
+type DistributionError struct {
+	ProjectID, FileID int
+
+	// Name of the mod's file.
+	FileName string
+
+	// The mod's page on CurseForge, if known.
+	WebsiteURL string
+}
+
+func (e *DistributionError) Error() string {
+	msg := fmt.Sprintf("%s: not allowed to be downloaded by third parties", e.FileName)
+	if e.WebsiteURL != "" {
+		msg += "; download it from " + e.WebsiteURL + "/files/" + strconv.Itoa(e.FileID)
+	}
+	return msg
+}
+
+// DistributionErrors is returned by Mods for all of the mods that cannot be downloaded by third parties.
+type DistributionErrors []*DistributionError
+
+func (e DistributionErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i := range e {
+		msgs[i] = e[i].Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// File identifies a file on CurseForge.
+type File struct {
+	ProjectID, FileID int
+}
+
+// Server is a fync.Server that lists the mods of a CurseForge modpack or list of files.
+// It should be closed once it is no longer needed.
+type Server struct {
+	location string
+	manifest manifest
+	pack     *zip.ReadCloser
+	o        Options
+
+	mu       sync.Mutex
+	resolved []apiFile
+
+	// when the next request to the API may be made
+	limitMu     sync.Mutex
+	nextRequest time.Time
+}
+
+// manifest is the part of a pack's manifest.json that is used.
+type manifest struct {
+	ManifestType string         `json:"manifestType"`
+	Name         string         `json:"name"`
+	Version      string         `json:"version"`
+	Overrides    string         `json:"overrides"`
+	Files        []manifestFile `json:"files"`
+}
+
+type manifestFile struct {
+	ProjectID int  `json:"projectID"`
+	FileID    int  `json:"fileID"`
+	Required  bool `json:"required"`
+}
+
+// New returns a Server for the manifest.json, or exported pack containing it, at the given path.
+func New(path string, o *Options) (*Server, error) {
+	s, err := newServer(path, o)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// exported packs are zip archives containing the manifest
+	if bytes.HasPrefix(data, []byte("PK")) {
+		if s.pack, err = zip.OpenReader(path); err != nil {
+			return nil, err
+		}
+		if data, err = s.readPacked(manifestName); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+
+	if err := json.Unmarshal(data, &s.manifest); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if s.manifest.ManifestType != "minecraftModpack" {
+		s.Close()
+		return nil, fmt.Errorf("%s: unsupported manifest type %q", path, s.manifest.ManifestType)
+	}
+	return s, nil
+}
+
+// NewFiles returns a Server for the given files, all of which are required.
+func NewFiles(files []File, o *Options) (*Server, error) {
+	ids := make([]string, len(files))
+	for i, f := range files {
+		ids[i] = strconv.Itoa(f.ProjectID) + "/" + strconv.Itoa(f.FileID)
+	}
+
+	s, err := newServer("curseforge:"+strings.Join(ids, ","), o)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range files {
+		s.manifest.Files = append(s.manifest.Files, manifestFile{ProjectID: f.ProjectID, FileID: f.FileID, Required: true})
+	}
+	return s, nil
+}
+
+func newServer(location string, o *Options) (*Server, error) {
+	s := &Server{location: location}
+	if o != nil {
+		s.o = *o
+	}
+	if s.o.APIKey == "" {
+		s.o.APIKey = os.Getenv("CURSEFORGE_API_KEY")
+	}
+	if s.o.API == "" {
+		s.o.API = DefaultAPI
+	}
+	s.o.API = strings.TrimSuffix(s.o.API, "/")
+	if s.o.RequestInterval == 0 {
+		s.o.RequestInterval = DefaultRequestInterval
+	}
+	if s.o.Client == nil {
+		s.o.Client = http.DefaultClient
+	}
+
+	if s.o.APIKey == "" {
+		return nil, errors.New("a CurseForge API key is required")
+	}
+	return s, nil
+}
+
+func (s *Server) readPacked(name string) ([]byte, error) {
+	for _, f := range s.pack.File {
+		if f.Name != name {
+			continue
+		}
+
+		r, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+
+		return ioutil.ReadAll(r)
+	}
+	return nil, fmt.Errorf("%s: no %s", s.location, name)
+}
+
+// String returns the pack's name and version, or the files the Server was created for.
+func (s *Server) String() string {
+	if s.manifest.ManifestType == "" {
+		return s.location
+	}
+	return s.manifest.Name + " " + s.manifest.Version
+}
+
+// Close closes an exported pack.
+func (s *Server) Close() error {
+	if s.pack == nil {
+		return nil
+	}
+	return s.pack.Close()
+}
+
+// Mods resolves the files the manifest lists and returns a slice of mod ServerFiles for each,
+// along with each mod within the pack's overrides. Mods are not downloaded until they are written.
+// When mods cannot be downloaded by third parties, DistributionErrors is returned for all of them.
+func (s *Server) Mods() ([]fync.ServerFile, error) {
+	files, err := s.resolve()
+	if err != nil {
+		return nil, err
+	}
+
+	var mods []fync.ServerFile
+	var disallowed DistributionErrors
+	for _, f := range files {
+		if f.DownloadURL == "" {
+			disallowed = append(disallowed, &DistributionError{ProjectID: f.ModID, FileID: f.ID, FileName: f.FileName})
+			continue
+		}
+
+		mods = append(mods, &download{apiFile: f, client: s.o.Client})
+	}
+
+	if len(disallowed) > 0 && !s.o.SkipDisallowed {
+		s.describe(disallowed)
+		return nil, disallowed
+	}
+
+	if s.pack != nil {
+		dir := strings.Trim(s.manifest.Overrides, "/")
+		if dir == "" {
+			dir = "overrides"
+		}
+
+		for _, f := range s.pack.File {
+			if path.Dir(f.Name) == dir+"/mods" && strings.HasSuffix(f.Name, ".jar") && !f.FileInfo().IsDir() {
+				mods = append(mods, &override{server: s, f: f})
+			}
+		}
+	}
+	return mods, nil
+}
+
+// resolve resolves the mods the manifest lists once, caching the result.
+func (s *Server) resolve() ([]apiFile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.resolved != nil {
+		return s.resolved, nil
+	}
+
+	cached := s.loadCache()
+
+	var wanted []manifestFile
+	var missing []int
+	for _, f := range s.manifest.Files {
+		if !f.Required && !s.o.Optional {
+			continue
+		}
+		wanted = append(wanted, f)
+		if c, ok := cached[f.FileID]; !ok || c.DownloadURL == "" {
+			missing = append(missing, f.FileID)
+		}
+	}
+
+	if len(missing) > 0 {
+		files, err := s.files(missing)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			cached[f.ID] = f
+		}
+
+		// a failed save only costs resolving the files again
+		s.saveCache(cached)
+	}
+
+	// the manifest may also list resource packs and shaders
+	mods := make([]apiFile, 0, len(wanted))
+	for _, w := range wanted {
+		f := cached[w.FileID]
+		if w.ProjectID != 0 && f.ModID != w.ProjectID {
+			return nil, fmt.Errorf("%s: file %d belongs to project %d, not %d", s, f.ID, f.ModID, w.ProjectID)
+		}
+
+		if path.Base(f.FileName) == f.FileName && strings.HasSuffix(f.FileName, ".jar") {
+			mods = append(mods, f)
+		}
+	}
+
+	s.resolved = mods
+	return mods, nil
+}
+
+// apiFile is the part of a file returned by the CurseForge API that is used.
+type apiFile struct {
+	ID          int       `json:"id"`
+	ModID       int       `json:"modId"`
+	FileName    string    `json:"fileName"`
+	FileLength  int64     `json:"fileLength"`
+	FileDate    time.Time `json:"fileDate"`
+	DownloadURL string    `json:"downloadUrl"`
+	Hashes      []struct {
+		Value string `json:"value"`
+		Algo  int    `json:"algo"`
+	} `json:"hashes"`
+}
+
+// files resolves the files with the given IDs.
+func (s *Server) files(ids []int) ([]apiFile, error) {
+	var files []apiFile
+	for start := 0; start < len(ids); start += fileBatch {
+		end := start + fileBatch
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		var res struct {
+			Data []apiFile `json:"data"`
+		}
+		if err := s.post("/v1/mods/files", map[string][]int{"fileIds": ids[start:end]}, &res); err != nil {
+			return nil, err
+		}
+		files = append(files, res.Data...)
+	}
+
+	if len(files) != len(ids) {
+		found := make(map[int]bool)
+		for _, f := range files {
+			found[f.ID] = true
+		}
+		for _, id := range ids {
+			if !found[id] {
+				return nil, fmt.Errorf("%s: file %d not found", s, id)
+			}
+		}
+	}
+	return files, nil
+}
+
+// cache is the contents of the file caching resolved files.
+type cache struct {
+	Files map[int]apiFile `json:"files"`
+}
+
+// loadCache returns the files resolved by earlier runs, which is empty if they are not cached
+// or the cache cannot be read.
+func (s *Server) loadCache() map[int]apiFile {
+	c := cache{Files: make(map[int]apiFile)}
+	if s.o.CacheFile == "" {
+		return c.Files
+	}
+
+	data, err := ioutil.ReadFile(s.o.CacheFile)
+	if err != nil || json.Unmarshal(data, &c) != nil || c.Files == nil {
+		return make(map[int]apiFile)
+	}
+	return c.Files
+}
+
+// saveCache replaces the cache file with the given files.
+func (s *Server) saveCache(files map[int]apiFile) error {
+	if s.o.CacheFile == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(cache{files})
+	if err != nil {
+		return err
+	}
+
+	tmp := s.o.CacheFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.o.CacheFile)
+}
+
+// describe fills in the page of each mod that cannot be downloaded, ignoring any errors
+// since the mods are already being reported.
+func (s *Server) describe(disallowed DistributionErrors) {
+	ids := make([]int, len(disallowed))
+	for i, e := range disallowed {
+		ids[i] = e.ProjectID
+	}
+
+	var res struct {
+		Data []struct {
+			ID    int `json:"id"`
+			Links struct {
+				WebsiteURL string `json:"websiteUrl"`
+			} `json:"links"`
+		} `json:"data"`
+	}
+	if s.post("/v1/mods", map[string][]int{"modIds": ids}, &res) != nil {
+		return
+	}
+
+	for _, m := range res.Data {
+		for _, e := range disallowed {
+			if e.ProjectID == m.ID {
+				e.WebsiteURL = strings.TrimSuffix(m.Links.WebsiteURL, "/")
+			}
+		}
+	}
+}
+
+func (s *Server) post(endpoint string, body, v interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	var req *http.Request
+	var res *http.Response
+	var delay time.Duration
+	for attempt := 1; ; attempt++ {
+		req, err = http.NewRequest(http.MethodPost, s.o.API+endpoint, bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("x-api-key", s.o.APIKey)
+
+		s.wait(delay)
+		if res, err = s.o.Client.Do(req); err != nil {
+			return err
+		}
+
+		limited := res.StatusCode == http.StatusTooManyRequests || res.StatusCode == http.StatusServiceUnavailable
+		if !limited || attempt == maxAttempts {
+			break
+		}
+
+		res.Body.Close()
+		delay = retryAfter(res, attempt)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: unexpected status %q", req.URL, res.Status)
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return fmt.Errorf("%s: %w", req.URL, err)
+	}
+	return nil
+}
+
+// wait waits until the next request to the API may be made, after first delaying it by d
+// such as when the API asks that requests stop for a while.
+func (s *Server) wait(d time.Duration) {
+	s.limitMu.Lock()
+	now := time.Now()
+	if s.nextRequest.Before(now) {
+		s.nextRequest = now
+	}
+	s.nextRequest = s.nextRequest.Add(d)
+	start := s.nextRequest
+	s.nextRequest = s.nextRequest.Add(s.o.RequestInterval)
+	s.limitMu.Unlock()
+
+	time.Sleep(time.Until(start))
+}
+
+// retryAfter returns how long to wait before retrying a rate limited request,
+// which is given by its Retry-After header or else doubles with each attempt.
+func retryAfter(res *http.Response, attempt int) time.Duration {
+	header := res.Header.Get("Retry-After")
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(header); err == nil {
+		return time.Until(t)
+	}
+	return time.Second << uint(attempt-1)
+}
+
+// download is a fync.ServerFile that downloads a mod resolved through the API.
+type download struct {
+	apiFile
+	client *http.Client
+	res    *http.Response
+}
+
+// String returns the URL the mod is downloaded from.
+func (d *download) String() string {
+	return d.DownloadURL
+}
+
+func (d *download) Stat() (os.FileInfo, error) {
+	return fileInfo{d.FileName, d.FileLength, d.FileDate}, nil
+}
+
+// sha1 returns the mod's hex encoded SHA-1 hash, or an empty string if it is unknown.
+func (d *download) sha1() string {
+	for _, h := range d.Hashes {
+		if h.Algo == sha1Algo {
+			return strings.ToLower(h.Value)
+		}
+	}
+	return ""
+}
+
+// WriteTo downloads the mod, failing if it does not match its SHA-1 hash once written.
+func (d *download) WriteTo(w io.Writer) (int64, error) {
+	if d.res == nil {
+		res, err := d.client.Get(d.DownloadURL)
+		if err != nil {
+			return 0, err
+		}
+		if res.StatusCode != http.StatusOK {
+			res.Body.Close()
+			return 0, fmt.Errorf("%s: unexpected status %q", d.DownloadURL, res.Status)
+		}
+		d.res = res
+	}
+
+	defer d.Close()
+
+	h := sha1.New()
+	n, err := io.Copy(io.MultiWriter(w, h), d.res.Body)
+	if err != nil {
+		return n, err
+	}
+
+	if want := d.sha1(); want != "" {
+		if sum := hex.EncodeToString(h.Sum(nil)); sum != want {
+			return n, &fync.VerificationError{Path: d.DownloadURL, Field: "checksum", Expected: want, Actual: sum}
+		}
+	}
+	return n, nil
+}
+
+// Seek only supports rewinding to the start of the file so that a failed transfer can be retried.
+func (d *download) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekStart {
+		return 0, errors.New("curseforgeserver: can only seek to the start of a file")
+	}
+	return 0, d.Close()
+}
+
+func (d *download) Close() error {
+	if d.res == nil {
+		return nil
+	}
+
+	err := d.res.Body.Close()
+	d.res = nil
+	return err
+}
+
+// override is a fync.ServerFile that reads a mod from the pack's overrides.
+type override struct {
+	server *Server
+	f      *zip.File
+	r      io.ReadCloser
+}
+
+// String returns the location of the mod within the pack.
+func (o *override) String() string {
+	return o.server.location + "!/" + o.f.Name
+}
+
+func (o *override) Stat() (os.FileInfo, error) {
+	return fileInfo{path.Base(o.f.Name), int64(o.f.UncompressedSize64), o.f.Modified}, nil
+}
+
+func (o *override) WriteTo(w io.Writer) (int64, error) {
+	if o.r == nil {
+		r, err := o.f.Open()
+		if err != nil {
+			return 0, err
+		}
+		o.r = r
+	}
+
+	defer o.Close()
+	return io.Copy(w, o.r)
+}
+
+// Seek only supports rewinding to the start of the file so that a failed transfer can be retried.
+func (o *override) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekStart {
+		return 0, errors.New("curseforgeserver: can only seek to the start of a file")
+	}
+	return 0, o.Close()
+}
+
+func (o *override) Close() error {
+	if o.r == nil {
+		return nil
+	}
+
+	err := o.r.Close()
+	o.r = nil
+	return err
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (i fileInfo) Name() string       { return i.name }
+func (i fileInfo) Size() int64        { return i.size }
+func (i fileInfo) Mode() os.FileMode  { return 0644 }
+func (i fileInfo) ModTime() time.Time { return i.modTime }
+func (i fileInfo) IsDir() bool        { return false }
+func (i fileInfo) Sys() interface{}   { return nil }
+
+var _ fync.Server = (*Server)(nil)
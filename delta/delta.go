@@ -0,0 +1,438 @@
+// Package delta implements rsync-style delta transfers of files, so that updating a file
+// only transfers the parts of it that changed.
+//
+// The receiver, which has an older version of the file known as the basis, sends a Signature
+// of it made up of a weak rolling checksum and a strong hash of each of its blocks.
+// The sender, which has the new version, finds the blocks of the basis within it wherever they
+// have moved to and sends a delta copying those blocks and including everything else literally.
+// The receiver then applies the delta to the basis with Patch to produce the new version,
+// which is verified against the SHA-256 checksum of the new version that ends the delta.
+package delta
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// MinBlockSize and MaxBlockSize bound the size of the blocks chosen by BlockSize.
+const (
+	MinBlockSize = 1 << 10
+	MaxBlockSize = 1 << 16
+)
+
+// strongSize is the number of bytes of each block's SHA-256 hash kept within a signature.
+const strongSize = 16
+
+// maxLiteral is the most bytes included by a single literal operation of a delta.
+const maxLiteral = 1 << 16
+
+// Magic numbers beginning signatures and deltas.
+var (
+	signatureMagic = []byte("fyS1")
+	deltaMagic     = []byte("fyD1")
+)
+
+// Operations of a delta.
+const (
+	opCopy    = 'C'
+	opLiteral = 'L'
+	opEnd     = 'E'
+)
+
+// ErrChecksum is returned by Patch when the file it produces does not match the checksum ending the delta,
+// such as when the basis changed after its signature was made.
+var ErrChecksum = errors.New("delta: patched file does not match its checksum")
+
+// BlockSize returns the size of the blocks used for the signature of a basis of the given size,
+// which is about its square root so that larger files have fewer, larger blocks.
+func BlockSize(size int64) int {
+	n := MinBlockSize
+	for int64(n)*int64(n) < size && n < MaxBlockSize {
+		n *= 2
+	}
+	return n
+}
+
+// Signature describes the blocks of a basis so that they can be found within a new version of it.
+type Signature struct {
+	// BlockSize is the size of each block. Any bytes after the last whole block are not described.
+	BlockSize int
+
+	blocks []block
+
+	// the index of the first block with each weak checksum and strong hash, so that each lookup
+	// takes the same time however many blocks share a weak checksum
+	index map[uint32]map[[strongSize]byte]int
+}
+
+type block struct {
+	weak   uint32
+	strong [strongSize]byte
+}
+
+// NewSignature reads the basis and returns its signature using blocks of the given size,
+// which must be between MinBlockSize and MaxBlockSize, or of the size chosen by BlockSize
+// for the number of bytes read if it is not positive.
+func NewSignature(basis io.Reader, blockSize int) (*Signature, error) {
+	if blockSize > 0 && (blockSize < MinBlockSize || blockSize > MaxBlockSize) {
+		return nil, fmt.Errorf("delta: unsupported block size %d", blockSize)
+	}
+	if blockSize <= 0 {
+		// the size is chosen once the basis is read, which must then be kept in memory
+		data, err := readAll(basis)
+		if err != nil {
+			return nil, err
+		}
+		basis = bytes.NewReader(data)
+		blockSize = BlockSize(int64(len(data)))
+	}
+
+	sig := newSignature(blockSize)
+	buf := make([]byte, blockSize)
+	for {
+		if _, err := io.ReadFull(basis, buf); err == io.EOF || err == io.ErrUnexpectedEOF {
+			return sig, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		var b block
+		b.weak = weakSum(buf)
+		strong := sha256.Sum256(buf)
+		copy(b.strong[:], strong[:])
+		sig.add(b)
+	}
+}
+
+func newSignature(blockSize int) *Signature {
+	return &Signature{BlockSize: blockSize, index: make(map[uint32]map[[strongSize]byte]int)}
+}
+
+// add appends the next block of the basis, indexing it unless an earlier block has the same contents,
+// which a copy of the earlier block serves just as well.
+func (s *Signature) add(b block) {
+	s.blocks = append(s.blocks, b)
+
+	strong, ok := s.index[b.weak]
+	if !ok {
+		strong = make(map[[strongSize]byte]int)
+		s.index[b.weak] = strong
+	}
+	if _, ok := strong[b.strong]; !ok {
+		strong[b.strong] = len(s.blocks) - 1
+	}
+}
+
+func readAll(r io.Reader) ([]byte, error) {
+	var buf bytes.Buffer
+	_, err := buf.ReadFrom(r)
+	return buf.Bytes(), err
+}
+
+// WriteTo writes the signature in the form read by ReadSignature.
+func (s *Signature) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+	bw.Write(signatureMagic)
+	binary.Write(bw, binary.BigEndian, uint32(s.BlockSize))
+	binary.Write(bw, binary.BigEndian, uint32(len(s.blocks)))
+	for _, b := range s.blocks {
+		binary.Write(bw, binary.BigEndian, b.weak)
+		bw.Write(b.strong[:])
+	}
+
+	n := int64(len(signatureMagic) + 8 + len(s.blocks)*(4+strongSize))
+	if err := bw.Flush(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// ReadSignature reads a signature written by Signature.WriteTo. Blocks with the same contents
+// as an earlier block are only matched as the earlier one, so that a signature repeating
+// a block many times costs no more to write a delta from.
+func ReadSignature(r io.Reader) (*Signature, error) {
+	br := bufio.NewReader(r)
+
+	magic := make([]byte, len(signatureMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || !bytes.Equal(magic, signatureMagic) {
+		return nil, errors.New("delta: not a signature")
+	}
+
+	var header struct {
+		BlockSize, Blocks uint32
+	}
+	if err := binary.Read(br, binary.BigEndian, &header); err != nil {
+		return nil, fmt.Errorf("delta: reading signature: %w", err)
+	}
+	if header.BlockSize < MinBlockSize || header.BlockSize > MaxBlockSize {
+		return nil, fmt.Errorf("delta: unsupported block size %d", header.BlockSize)
+	}
+
+	sig := newSignature(int(header.BlockSize))
+	for i := uint32(0); i < header.Blocks; i++ {
+		var b block
+		if err := binary.Read(br, binary.BigEndian, &b.weak); err != nil {
+			return nil, fmt.Errorf("delta: reading signature: %w", err)
+		}
+		if _, err := io.ReadFull(br, b.strong[:]); err != nil {
+			return nil, fmt.Errorf("delta: reading signature: %w", err)
+		}
+		sig.add(b)
+	}
+	return sig, nil
+}
+
+// weakSum returns the rolling checksum of a block, made up of the sum of its bytes and the sum of those sums.
+func weakSum(p []byte) uint32 {
+	var a, b uint32
+	for i, c := range p {
+		a += uint32(c)
+		b += uint32(len(p)-i) * uint32(c)
+	}
+	return a&0xffff | b<<16
+}
+
+// Write writes the delta from the basis described by sig to the new version read from r.
+// The number of bytes of the new version copied from the basis rather than included literally is returned.
+func Write(w io.Writer, sig *Signature, r io.Reader) (int64, error) {
+	d := &deltaWriter{w: bufio.NewWriter(w)}
+	d.w.Write(deltaMagic)
+
+	size := sig.BlockSize
+	h := sha256.New()
+	src := bufio.NewReaderSize(io.TeeReader(r, h), maxLiteral)
+
+	// buf holds the pending literal followed by the window being matched at pos
+	var buf []byte
+	pos := 0
+	eof := false
+	fill := func() error {
+		for !eof && len(buf)-pos <= size {
+			chunk := make([]byte, maxLiteral)
+			n, err := src.Read(chunk)
+			buf = append(buf, chunk[:n]...)
+			if err == io.EOF {
+				eof = true
+			} else if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var a, b uint32
+	rolled := false
+	for {
+		if err := fill(); err != nil {
+			return d.copied, err
+		}
+		if len(buf)-pos < size || len(sig.blocks) == 0 {
+			break
+		}
+
+		window := buf[pos : pos+size]
+		if !rolled {
+			sum := weakSum(window)
+			a, b = sum&0xffff, sum>>16
+			rolled = true
+		}
+
+		if i, ok := d.match(sig, a&0xffff|b<<16, window); ok {
+			if err := d.literal(buf[:pos]); err != nil {
+				return d.copied, err
+			}
+			d.copy(int64(i)*int64(size), int64(size))
+
+			buf = append(buf[:0], buf[pos+size:]...)
+			pos = 0
+			rolled = false
+			continue
+		}
+
+		// roll the checksum forward a byte
+		out := uint32(buf[pos])
+		pos++
+		if pos+size <= len(buf) {
+			in := uint32(buf[pos+size-1])
+			a = (a - out + in) & 0xffff
+			b = (b - uint32(size)*out + a) & 0xffff
+		} else {
+			rolled = false
+		}
+
+		if pos >= maxLiteral {
+			if err := d.literal(buf[:pos]); err != nil {
+				return d.copied, err
+			}
+			buf = append(buf[:0], buf[pos:]...)
+			pos = 0
+		}
+	}
+
+	// the rest could not be matched with any block
+	for len(buf) > 0 || !eof {
+		n := len(buf)
+		if n > maxLiteral {
+			n = maxLiteral
+		}
+		if err := d.literal(buf[:n]); err != nil {
+			return d.copied, err
+		}
+
+		buf = buf[n:]
+		if len(buf) == 0 {
+			pos = 0
+			if err := fill(); err != nil {
+				return d.copied, err
+			}
+		}
+	}
+	if err := d.flushCopy(); err != nil {
+		return d.copied, err
+	}
+
+	d.w.WriteByte(opEnd)
+	d.w.Write(h.Sum(nil))
+	return d.copied, d.w.Flush()
+}
+
+// deltaWriter writes the operations of a delta, combining copies of consecutive blocks.
+type deltaWriter struct {
+	w      *bufio.Writer
+	copied int64
+
+	// the pending copy
+	offset, length int64
+}
+
+// match returns the index of the block of the basis with the given weak checksum and contents.
+func (d *deltaWriter) match(sig *Signature, weak uint32, window []byte) (int, bool) {
+	candidates, ok := sig.index[weak]
+	if !ok {
+		return 0, false
+	}
+
+	sum := sha256.Sum256(window)
+	var strong [strongSize]byte
+	copy(strong[:], sum[:])
+	i, ok := candidates[strong]
+	return i, ok
+}
+
+func (d *deltaWriter) copy(offset, length int64) {
+	d.copied += length
+	if d.length > 0 && d.offset+d.length == offset {
+		d.length += length
+		return
+	}
+
+	d.flushCopy()
+	d.offset, d.length = offset, length
+}
+
+func (d *deltaWriter) flushCopy() error {
+	if d.length == 0 {
+		return nil
+	}
+
+	d.w.WriteByte(opCopy)
+	binary.Write(d.w, binary.BigEndian, uint64(d.offset))
+	err := binary.Write(d.w, binary.BigEndian, uint64(d.length))
+	d.length = 0
+	return err
+}
+
+func (d *deltaWriter) literal(p []byte) error {
+	if len(p) == 0 {
+		return nil
+	}
+	if err := d.flushCopy(); err != nil {
+		return err
+	}
+
+	d.w.WriteByte(opLiteral)
+	binary.Write(d.w, binary.BigEndian, uint32(len(p)))
+	_, err := d.w.Write(p)
+	return err
+}
+
+// Patch applies the delta read from r to the basis, writing the new version to w.
+// The number of bytes written is returned, and ErrChecksum if they do not match the new version.
+func Patch(w io.Writer, basis io.ReaderAt, r io.Reader) (int64, error) {
+	br := bufio.NewReader(r)
+
+	magic := make([]byte, len(deltaMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || !bytes.Equal(magic, deltaMagic) {
+		return 0, errors.New("delta: not a delta")
+	}
+
+	h := sha256.New()
+	out := io.MultiWriter(w, h)
+	var n int64
+	for {
+		op, err := br.ReadByte()
+		if err != nil {
+			return n, fmt.Errorf("delta: reading delta: %w", unexpected(err))
+		}
+
+		switch op {
+		case opCopy:
+			var c struct {
+				Offset, Length uint64
+			}
+			if err := binary.Read(br, binary.BigEndian, &c); err != nil {
+				return n, fmt.Errorf("delta: reading delta: %w", unexpected(err))
+			}
+			if c.Offset > 1<<62 || c.Length > 1<<62 {
+				return n, errors.New("delta: copy past the end of the basis")
+			}
+
+			copied, err := io.Copy(out, io.NewSectionReader(basis, int64(c.Offset), int64(c.Length)))
+			n += copied
+			if err != nil {
+				return n, err
+			}
+			if copied != int64(c.Length) {
+				return n, errors.New("delta: copy past the end of the basis")
+			}
+
+		case opLiteral:
+			var length uint32
+			if err := binary.Read(br, binary.BigEndian, &length); err != nil {
+				return n, fmt.Errorf("delta: reading delta: %w", unexpected(err))
+			}
+
+			written, err := io.CopyN(out, br, int64(length))
+			n += written
+			if err != nil {
+				return n, fmt.Errorf("delta: reading delta: %w", unexpected(err))
+			}
+
+		case opEnd:
+			sum := make([]byte, sha256.Size)
+			if _, err := io.ReadFull(br, sum); err != nil {
+				return n, fmt.Errorf("delta: reading delta: %w", unexpected(err))
+			}
+			if !bytes.Equal(sum, h.Sum(nil)) {
+				return n, ErrChecksum
+			}
+			return n, nil
+
+		default:
+			return n, fmt.Errorf("delta: unknown operation %q", op)
+		}
+	}
+}
+
+// unexpected reports the end of a delta before its end operation as unexpected.
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,130 @@
+package delta_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/han-tyumi/fync/delta"
+)
+
+func TestRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	basis := make([]byte, 300<<10)
+	rng.Read(basis)
+
+	// the new version moves, changes, and adds to parts of the basis
+	var version []byte
+	version = append(version, basis[100<<10:200<<10]...)
+	version = append(version, []byte("changed")...)
+	version = append(version, basis[:100<<10]...)
+	extra := make([]byte, 5000)
+	rng.Read(extra)
+	version = append(version, extra...)
+	version = append(version, basis[200<<10:]...)
+
+	for _, size := range []int{0, delta.MinBlockSize, 4096, delta.MaxBlockSize} {
+		sig, err := delta.NewSignature(bytes.NewReader(basis), size)
+		if err != nil {
+			t.Fatalf("NewSignature(%d): %v", size, err)
+		}
+
+		var encoded bytes.Buffer
+		if _, err := sig.WriteTo(&encoded); err != nil {
+			t.Fatalf("WriteTo(%d): %v", size, err)
+		}
+		read, err := delta.ReadSignature(&encoded)
+		if err != nil {
+			t.Fatalf("ReadSignature(%d): %v", size, err)
+		}
+		if read.BlockSize != sig.BlockSize {
+			t.Fatalf("ReadSignature(%d) block size = %d, want %d", size, read.BlockSize, sig.BlockSize)
+		}
+
+		var d bytes.Buffer
+		if _, err := delta.Write(&d, read, bytes.NewReader(version)); err != nil {
+			t.Fatalf("Write(%d): %v", size, err)
+		}
+		// the largest blocks mostly straddle the edits of a basis this small
+		if size < delta.MaxBlockSize && d.Len() >= len(version)/2 {
+			t.Errorf("delta with block size %d is %d bytes, for a version of %d", size, d.Len(), len(version))
+		}
+
+		var patched bytes.Buffer
+		if _, err := delta.Patch(&patched, bytes.NewReader(basis), &d); err != nil {
+			t.Fatalf("Patch(%d): %v", size, err)
+		}
+		if !bytes.Equal(patched.Bytes(), version) {
+			t.Fatalf("Patch(%d) did not produce the new version", size)
+		}
+	}
+}
+
+func TestBlockSizeBounds(t *testing.T) {
+	for _, size := range []int{1, delta.MinBlockSize - 1, delta.MaxBlockSize + 1} {
+		if _, err := delta.NewSignature(bytes.NewReader(make([]byte, 1<<20)), size); err == nil {
+			t.Errorf("NewSignature(%d) succeeded", size)
+		}
+	}
+}
+
+func TestCollidingSignature(t *testing.T) {
+	// a 4 MB signature of blocks that all share the weak sum of every window of zeroes,
+	// but none of which match
+	const blocks = 200000
+	var sig bytes.Buffer
+	sig.WriteString("fyS1")
+	binary.Write(&sig, binary.BigEndian, uint32(delta.MinBlockSize))
+	binary.Write(&sig, binary.BigEndian, uint32(blocks))
+	strong := make([]byte, 16)
+	for i := 0; i < blocks; i++ {
+		binary.Write(&sig, binary.BigEndian, uint32(0))
+		binary.BigEndian.PutUint32(strong, uint32(i)+1)
+		sig.Write(strong)
+	}
+
+	s, err := delta.ReadSignature(&sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		var d bytes.Buffer
+		_, err := delta.Write(&d, s, bytes.NewReader(make([]byte, 256<<10)))
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Write took too long with colliding blocks")
+	}
+}
+
+func TestDuplicateBlocks(t *testing.T) {
+	// a basis repeating the same block matches as its first copy
+	basis := bytes.Repeat([]byte("fync"), 64<<10)
+	sig, err := delta.NewSignature(bytes.NewReader(basis), delta.MinBlockSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var d bytes.Buffer
+	if _, err := delta.Write(&d, sig, bytes.NewReader(basis)); err != nil {
+		t.Fatal(err)
+	}
+
+	var patched bytes.Buffer
+	if _, err := delta.Patch(&patched, bytes.NewReader(basis), &d); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(patched.Bytes(), basis) {
+		t.Fatal("Patch did not reproduce the basis")
+	}
+}
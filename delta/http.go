@@ -0,0 +1,102 @@
+package delta
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// Media types of signatures and deltas sent over HTTP.
+const (
+	SignatureType = "application/x-fync-signature"
+	DeltaType     = "application/x-fync-delta"
+)
+
+// maxSignature is the largest signature accepted by FileServer, which suffices for files of 64 GiB.
+const maxSignature = 32 << 20
+
+// FileServer returns a handler that serves the files within root like http.FileServer,
+// which also responds to POST requests for a file containing a signature of an older version of it
+// with the delta from that version.
+func FileServer(root http.FileSystem) http.Handler {
+	files := http.FileServer(root)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			files.ServeHTTP(w, r)
+			return
+		}
+
+		name := path.Clean("/" + r.URL.Path)
+		f, err := root.Open(name)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer f.Close()
+
+		if info, err := f.Stat(); err != nil || info.IsDir() {
+			http.NotFound(w, r)
+			return
+		}
+
+		ServeDelta(w, r, f)
+	})
+}
+
+// ServeDelta responds to the request, which contains the signature of an older version of the content,
+// with the delta from that version to the content.
+func ServeDelta(w http.ResponseWriter, r *http.Request, content io.Reader) {
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), SignatureType) {
+		http.Error(w, "expected a "+SignatureType, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	sig, err := ReadSignature(http.MaxBytesReader(w, r.Body, maxSignature))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", DeltaType)
+	Write(w, sig, content)
+}
+
+// Get requests the delta from the basis, of the given size, to the content at the URL
+// using the client, and writes the content patched from it to w.
+// Hosts that do not support delta transfers, such as by FileServer, fail with an error.
+func Get(client *http.Client, url string, w io.Writer, basis io.ReaderAt, size int64) (int64, error) {
+	sig, err := NewSignature(io.NewSectionReader(basis, 0, size), BlockSize(size))
+	if err != nil {
+		return 0, err
+	}
+
+	var body bytes.Buffer
+	if _, err := sig.WriteTo(&body); err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, &body)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", SignatureType)
+	req.Header.Set("Accept", DeltaType)
+
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("%s: unexpected status %q", url, res.Status)
+	}
+	if !strings.HasPrefix(res.Header.Get("Content-Type"), DeltaType) {
+		return 0, fmt.Errorf("%s: delta transfers are not supported", url)
+	}
+
+	return Patch(w, basis, res.Body)
+}
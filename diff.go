@@ -0,0 +1,106 @@
+package fync
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ModDiff is the set of changes syncing would make to the mods directory.
+type ModDiff struct {
+	// Server mods that are not within the mods directory.
+	Added []ModChange
+
+	// Local mods that are not on the server.
+	Removed []ModChange
+
+	// Local mods that would be replaced by a different server mod, either of the same name
+	// or of the same mod under a new file name, such as when its version changes.
+	Updated []ModChange
+}
+
+// ModChange is a single change to a mod. The old fields are empty for added mods
+// and the new fields are empty for removed mods.
+type ModChange struct {
+	OldName, NewName string
+
+	// Versions of the mod, when they could be determined. Local mods are read from the
+	// metadata within their jar, falling back to their file name like server mods.
+	OldVersion, NewVersion string
+}
+
+// Diff compares the local mods with the server's, returning the changes syncing would make
+// with the default options, without making any changes or calling any callbacks.
+func Diff(s Server) (*ModDiff, error) {
+	if dirErr != nil {
+		return nil, dirErr
+	}
+
+	r, err := Verify(s, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &ModDiff{}
+	for _, name := range r.Modified {
+		d.Updated = append(d.Updated, ModChange{
+			OldName:    name,
+			NewName:    name,
+			OldVersion: localVersion(name),
+		})
+	}
+
+	// a removed mod sharing its name with exactly one added mod is an update of it
+	removed := make(map[string][]string)
+	for _, name := range r.Extra {
+		stem, _ := parseModFileName(name)
+		stem = strings.ToLower(stem)
+		removed[stem] = append(removed[stem], name)
+	}
+
+	added := make(map[string]int)
+	for _, name := range r.Missing {
+		stem, _ := parseModFileName(name)
+		added[strings.ToLower(stem)]++
+	}
+
+	for _, name := range r.Missing {
+		stem, version := parseModFileName(name)
+		stem = strings.ToLower(stem)
+
+		if old := removed[stem]; len(old) == 1 && added[stem] == 1 {
+			d.Updated = append(d.Updated, ModChange{
+				OldName:    old[0],
+				NewName:    name,
+				OldVersion: localVersion(old[0]),
+				NewVersion: version,
+			})
+			delete(removed, stem)
+			continue
+		}
+
+		d.Added = append(d.Added, ModChange{NewName: name, NewVersion: version})
+	}
+
+	for _, name := range r.Extra {
+		stem, _ := parseModFileName(name)
+		if _, ok := removed[strings.ToLower(stem)]; ok {
+			d.Removed = append(d.Removed, ModChange{OldName: name, OldVersion: localVersion(name)})
+		}
+	}
+
+	sort.Slice(d.Updated, func(i, j int) bool {
+		return d.Updated[i].NewName < d.Updated[j].NewName
+	})
+	return d, nil
+}
+
+// localVersion returns the version of the named local mod, if it can be determined.
+func localVersion(name string) string {
+	if info, err := readModInfo(filepath.Join(modsDir, name)); err == nil && info.Version != "" {
+		return info.Version
+	}
+
+	_, version := parseModFileName(name)
+	return version
+}
@@ -0,0 +1,125 @@
+// Package dirserver implements a fync.Server for mods within a local directory,
+// including directories mounted from network shares such as NFS or SMB.
+package dirserver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/han-tyumi/fync"
+)
+
+// checksumsName is the name of an optional file within the directory listing the mods' checksums.
+const checksumsName = "sha256sums.txt"
+
+// Server is a fync.Server that lists mods from a local directory.
+type Server struct {
+	dir string
+}
+
+// New returns a Server for the directory at the given path.
+func New(dir string) (*Server, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(abs)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s: not a directory", abs)
+	}
+	return &Server{dir: abs}, nil
+}
+
+// String returns the path of the directory.
+func (s *Server) String() string {
+	return s.dir
+}
+
+// Mods returns a slice of mod ServerFiles for each jar within the directory.
+// Mods are not opened until they are written.
+func (s *Server) Mods() ([]fync.ServerFile, error) {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var mods []fync.ServerFile
+	for i := range files {
+		if files[i].Mode().IsRegular() && strings.HasSuffix(files[i].Name(), ".jar") {
+			mods = append(mods, &file{path: filepath.Join(s.dir, files[i].Name()), info: files[i]})
+		}
+	}
+	return mods, nil
+}
+
+// Checksums returns the checksums from a sha256sums.txt file within the directory,
+// or an empty map when there is none.
+func (s *Server) Checksums() (map[string]string, error) {
+	f, err := os.Open(filepath.Join(s.dir, checksumsName))
+	if os.IsNotExist(err) {
+		return map[string]string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return fync.ParseChecksums(f)
+}
+
+// file is a fync.ServerFile that copies a mod from the directory.
+type file struct {
+	path string
+	info os.FileInfo
+	f    *os.File
+}
+
+// String returns the path of the mod.
+func (f *file) String() string {
+	return f.path
+}
+
+func (f *file) Stat() (os.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *file) WriteTo(w io.Writer) (int64, error) {
+	if f.f == nil {
+		opened, err := os.Open(f.path)
+		if err != nil {
+			return 0, err
+		}
+		f.f = opened
+	}
+
+	defer f.Close()
+	return io.Copy(w, f.f)
+}
+
+// Seek only supports rewinding to the start of the file so that a failed transfer can be retried.
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekStart {
+		return 0, errors.New("dirserver: can only seek to the start of a file")
+	}
+	return 0, f.Close()
+}
+
+func (f *file) Close() error {
+	if f.f == nil {
+		return nil
+	}
+
+	err := f.f.Close()
+	f.f = nil
+	return err
+}
+
+var _ fync.ChecksumServer = (*Server)(nil)
@@ -0,0 +1,31 @@
+package dirserver_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/han-tyumi/fync"
+	"github.com/han-tyumi/fync/dirserver"
+	"github.com/han-tyumi/fync/sourcetest"
+)
+
+func TestConformance(t *testing.T) {
+	sourcetest.Conformance(t, newServer)
+}
+
+// newServer returns a Server for a directory holding the mods.
+func newServer(t testing.TB, mods map[string]string) fync.Server {
+	dir := t.TempDir()
+	for name, data := range mods {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, err := dirserver.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
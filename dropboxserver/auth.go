@@ -0,0 +1,72 @@
+package dropboxserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// refresher obtains short-lived access tokens using a long-lived refresh token.
+type refresher struct {
+	tokenURL     string
+	refreshToken string
+	appKey       string
+	appSecret    string
+	client       *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// accessToken returns a cached access token, refreshing it when it is about to expire.
+func (r *refresher) accessToken() (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.token != "" && time.Now().Add(time.Minute).Before(r.expires) {
+		return r.token, nil
+	}
+
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {r.refreshToken},
+		"client_id":     {r.appKey},
+	}
+	if r.appSecret != "" {
+		form.Set("client_secret", r.appSecret)
+	}
+
+	res, err := r.client.PostForm(r.tokenURL, form)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	var result struct {
+		AccessToken      string `json:"access_token"`
+		ExpiresIn        int    `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<16)).Decode(&result); err != nil && res.StatusCode == http.StatusOK {
+		return "", fmt.Errorf("dropboxserver: refreshing access token: %w", err)
+	}
+
+	if res.StatusCode != http.StatusOK || result.AccessToken == "" {
+		msg := strings.TrimSpace(result.Error + " " + result.ErrorDescription)
+		if msg == "" {
+			msg = fmt.Sprintf("unexpected status %q", res.Status)
+		}
+		return "", fmt.Errorf("dropboxserver: refreshing access token: %s", msg)
+	}
+
+	r.token = result.AccessToken
+	r.expires = time.Now().Add(time.Duration(result.ExpiresIn) * time.Second)
+	return r.token, nil
+}
@@ -0,0 +1,53 @@
+package dropboxserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+)
+
+// contentBlockSize is the size of the blocks hashed by a Dropbox content hash.
+const contentBlockSize = 4 << 20
+
+// contentHash computes the content hash Dropbox reports for each file, which is the SHA-256 hash
+// of the concatenated SHA-256 hashes of each 4 MiB block of the file.
+type contentHash struct {
+	overall hash.Hash
+	block   hash.Hash
+	n       int
+}
+
+func newContentHash() *contentHash {
+	return &contentHash{overall: sha256.New(), block: sha256.New()}
+}
+
+func (h *contentHash) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 {
+		chunk := contentBlockSize - h.n
+		if chunk > len(p) {
+			chunk = len(p)
+		}
+
+		h.block.Write(p[:chunk])
+		h.n += chunk
+		p = p[chunk:]
+
+		if h.n == contentBlockSize {
+			h.overall.Write(h.block.Sum(nil))
+			h.block.Reset()
+			h.n = 0
+		}
+	}
+	return written, nil
+}
+
+// sum returns the hex encoded content hash of everything written.
+func (h *contentHash) sum() string {
+	if h.n > 0 {
+		h.overall.Write(h.block.Sum(nil))
+		h.block.Reset()
+		h.n = 0
+	}
+	return hex.EncodeToString(h.overall.Sum(nil))
+}
@@ -0,0 +1,339 @@
+// Package dropboxserver implements a fync.Server for mods within a Dropbox folder,
+// either a folder of the account or app folder authorized by the access token,
+// or a folder shared by a link, which any Dropbox account can read.
+//
+// Each mod is downloaded at the revision that was listed and verified against its Dropbox content hash.
+// Access tokens are either given directly or obtained by refreshing a long-lived refresh token,
+// since the access tokens Dropbox issues expire after a few hours.
+package dropboxserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/han-tyumi/fync"
+)
+
+// Default base URLs of the Dropbox API used when none are chosen.
+const (
+	DefaultAPI     = "https://api.dropboxapi.com"
+	DefaultContent = "https://content.dropboxapi.com"
+)
+
+// Options contains options for the New function.
+type Options struct {
+	// An access token used to authorize requests. Defaults to $DROPBOX_TOKEN.
+	Token string
+
+	// A refresh token used to obtain access tokens instead, along with the app key it was issued to
+	// and the app secret unless it was issued using PKCE.
+	RefreshToken, AppKey, AppSecret string
+
+	// Folder within a shared link's folder containing the mods. Defaults to the shared folder itself.
+	Path string
+
+	// Base URLs of the Dropbox API and its content endpoints. Default to DefaultAPI and DefaultContent.
+	API, Content string
+
+	// The HTTP client used for all requests. Defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// Server is a fync.Server that lists mods from a Dropbox folder.
+type Server struct {
+	folder  string
+	link    string
+	api     string
+	content string
+	client  *http.Client
+	token   string
+	refresh *refresher
+}
+
+// New returns a Server for the folder at the given path, such as "/mods",
+// or for the folder shared by the given shared link.
+func New(location string, o *Options) (*Server, error) {
+	if o == nil {
+		o = &Options{}
+	}
+
+	s := &Server{
+		api:     strings.TrimSuffix(o.API, "/"),
+		content: strings.TrimSuffix(o.Content, "/"),
+		client:  o.Client,
+		token:   o.Token,
+	}
+	if s.api == "" {
+		s.api = DefaultAPI
+	}
+	if s.content == "" {
+		s.content = DefaultContent
+	}
+	if s.client == nil {
+		s.client = http.DefaultClient
+	}
+
+	if strings.HasPrefix(location, "https://") {
+		s.link = location
+		s.folder = o.Path
+	} else {
+		s.folder = location
+	}
+
+	// the root is named with an empty path
+	s.folder = strings.TrimSuffix(path.Clean("/"+s.folder), "/")
+
+	if o.RefreshToken != "" {
+		if o.AppKey == "" {
+			return nil, errors.New("dropboxserver: an app key is required to use a refresh token")
+		}
+		s.refresh = &refresher{
+			tokenURL:     s.api + "/oauth2/token",
+			refreshToken: o.RefreshToken,
+			appKey:       o.AppKey,
+			appSecret:    o.AppSecret,
+			client:       s.client,
+		}
+	} else if s.token == "" {
+		s.token = os.Getenv("DROPBOX_TOKEN")
+	}
+
+	if s.token == "" && s.refresh == nil {
+		return nil, errors.New("dropboxserver: an access token or refresh token is required")
+	}
+	return s, nil
+}
+
+// String returns the path of the folder, or its shared link.
+func (s *Server) String() string {
+	if s.link != "" {
+		if s.folder != "" {
+			return s.link + "#" + s.folder
+		}
+		return s.link
+	}
+	if s.folder == "" {
+		return "dropbox:/"
+	}
+	return "dropbox:" + s.folder
+}
+
+// entry is the part of a file or folder listed by the Dropbox API that is used.
+type entry struct {
+	Tag            string    `json:".tag"`
+	Name           string    `json:"name"`
+	PathLower      string    `json:"path_lower"`
+	Rev            string    `json:"rev"`
+	Size           int64     `json:"size"`
+	ServerModified time.Time `json:"server_modified"`
+	ContentHash    string    `json:"content_hash"`
+}
+
+// Mods returns a slice of mod ServerFiles for each jar within the folder.
+// Mods are not downloaded until they are written.
+func (s *Server) Mods() ([]fync.ServerFile, error) {
+	arg := map[string]interface{}{"path": s.folder}
+	if s.link != "" {
+		arg["shared_link"] = map[string]string{"url": s.link}
+	}
+
+	var mods []fync.ServerFile
+	endpoint := "/2/files/list_folder"
+	for {
+		var result struct {
+			Entries []entry `json:"entries"`
+			Cursor  string  `json:"cursor"`
+			HasMore bool    `json:"has_more"`
+		}
+		if err := s.call(endpoint, arg, &result); err != nil {
+			return nil, err
+		}
+
+		for _, e := range result.Entries {
+			if e.Tag == "file" && strings.HasSuffix(e.Name, ".jar") {
+				mods = append(mods, &file{server: s, entry: e})
+			}
+		}
+
+		if !result.HasMore {
+			return mods, nil
+		}
+		endpoint = "/2/files/list_folder/continue"
+		arg = map[string]interface{}{"cursor": result.Cursor}
+	}
+}
+
+// call calls an RPC endpoint of the API with the JSON encoded argument, decoding its result into v.
+func (s *Server) call(endpoint string, arg, v interface{}) error {
+	body, err := json.Marshal(arg)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.request(s.api+endpoint, bytes.NewReader(body), "application/json", "")
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return fmt.Errorf("%s: %w", s, err)
+	}
+	return nil
+}
+
+// request sends an authorized POST request, passing the argument of content endpoints in a header.
+func (s *Server) request(u string, body io.Reader, contentType, arg string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPost, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if arg != "" {
+		req.Header.Set("Dropbox-API-Arg", arg)
+	}
+
+	token := s.token
+	if s.refresh != nil {
+		if token, err = s.refresh.accessToken(); err != nil {
+			return nil, err
+		}
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		defer res.Body.Close()
+
+		var e struct {
+			ErrorSummary string `json:"error_summary"`
+		}
+		if json.NewDecoder(io.LimitReader(res.Body, 1<<16)).Decode(&e) == nil && e.ErrorSummary != "" {
+			return nil, fmt.Errorf("%s: %s", s, e.ErrorSummary)
+		}
+		return nil, fmt.Errorf("%s: unexpected status %q", s, res.Status)
+	}
+	return res, nil
+}
+
+// headerArg encodes the argument of a content endpoint as JSON for the Dropbox-API-Arg header,
+// which must escape every character that is not ASCII.
+func headerArg(arg interface{}) (string, error) {
+	data, err := json.Marshal(arg)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	for _, r := range string(data) {
+		if r < 0x80 {
+			b.WriteRune(r)
+			continue
+		}
+		for _, u := range utf16.Encode([]rune{r}) {
+			b.WriteString(`\u`)
+			b.WriteString(fmt.Sprintf("%04x", u))
+		}
+	}
+	return b.String(), nil
+}
+
+// file is a fync.ServerFile that downloads a mod from the folder.
+type file struct {
+	server *Server
+	entry
+	res *http.Response
+}
+
+// String returns the path of the mod, or its path within the shared link.
+func (f *file) String() string {
+	return f.server.String() + "/" + f.Name
+}
+
+func (f *file) Stat() (os.FileInfo, error) {
+	return fileInfo{f.entry}, nil
+}
+
+// WriteTo downloads the revision of the mod that was listed, failing if it does not match its content hash
+// once written.
+func (f *file) WriteTo(w io.Writer) (int64, error) {
+	if f.res == nil {
+		endpoint := "/2/files/download"
+		var arg interface{} = map[string]string{"path": "rev:" + f.Rev}
+		if f.server.link != "" {
+			endpoint = "/2/sharing/get_shared_link_file"
+			arg = map[string]string{"url": f.server.link, "path": f.server.folder + "/" + f.Name}
+		}
+
+		header, err := headerArg(arg)
+		if err != nil {
+			return 0, err
+		}
+		res, err := f.server.request(f.server.content+endpoint, nil, "", header)
+		if err != nil {
+			return 0, err
+		}
+		f.res = res
+	}
+
+	defer f.Close()
+
+	h := newContentHash()
+	n, err := io.Copy(io.MultiWriter(w, h), f.res.Body)
+	if err != nil {
+		return n, err
+	}
+
+	if f.ContentHash != "" {
+		if sum := h.sum(); sum != f.ContentHash {
+			return n, &fync.VerificationError{Path: f.String(), Field: "checksum", Expected: f.ContentHash, Actual: sum}
+		}
+	}
+	return n, nil
+}
+
+// Seek only supports rewinding to the start of the file so that a failed transfer can be retried.
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekStart {
+		return 0, errors.New("dropboxserver: can only seek to the start of a file")
+	}
+	return 0, f.Close()
+}
+
+func (f *file) Close() error {
+	if f.res == nil {
+		return nil
+	}
+
+	err := f.res.Body.Close()
+	f.res = nil
+	return err
+}
+
+type fileInfo struct {
+	entry
+}
+
+func (i fileInfo) Name() string       { return i.entry.Name }
+func (i fileInfo) Size() int64        { return i.entry.Size }
+func (i fileInfo) Mode() os.FileMode  { return 0644 }
+func (i fileInfo) ModTime() time.Time { return i.ServerModified }
+func (i fileInfo) IsDir() bool        { return false }
+func (i fileInfo) Sys() interface{}   { return nil }
+
+var _ fync.Server = (*Server)(nil)
@@ -0,0 +1,320 @@
+package ftpserver
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// conn is an authenticated FTP control connection.
+type conn struct {
+	server *Server
+	net    net.Conn
+	text   *textproto.Conn
+	secure bool
+
+	// whether the server supports the MLSD command
+	mlsd bool
+}
+
+func (s *Server) dial() (*conn, error) {
+	nc, err := net.DialTimeout("tcp", s.addr, s.timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &conn{server: s}
+	if s.implicit {
+		nc = tls.Client(nc, s.tlsConfig)
+		c.secure = true
+	}
+	c.setConn(nc)
+
+	if err := c.login(); err != nil {
+		c.close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *conn) setConn(nc net.Conn) {
+	c.net = nc
+	c.text = textproto.NewConn(nc)
+}
+
+func (c *conn) login() error {
+	if _, _, err := c.text.ReadResponse(220); err != nil {
+		return err
+	}
+
+	// upgrade the connection before sending any credentials
+	if c.server.tlsConfig != nil && !c.secure {
+		if _, err := c.cmd(234, "AUTH TLS"); err != nil {
+			return err
+		}
+		c.setConn(tls.Client(c.net, c.server.tlsConfig))
+		c.secure = true
+	}
+
+	code, err := c.cmd(0, "USER %s", c.server.user)
+	if err != nil {
+		return err
+	}
+	if code == 331 {
+		if _, err := c.cmd(230, "PASS %s", c.server.pass); err != nil {
+			return err
+		}
+	} else if code != 230 {
+		return fmt.Errorf("ftpserver: unexpected response %d to USER", code)
+	}
+
+	// protect data connections as well
+	if c.secure {
+		if _, err := c.cmd(200, "PBSZ 0"); err != nil {
+			return err
+		}
+		if _, err := c.cmd(200, "PROT P"); err != nil {
+			return err
+		}
+	}
+
+	if _, err := c.cmd(200, "TYPE I"); err != nil {
+		return err
+	}
+
+	// MLSD gives exact sizes and times without a SIZE command per mod
+	if _, msg, err := c.run(211, "FEAT"); err == nil {
+		for _, line := range strings.Split(msg, "\n") {
+			if strings.HasPrefix(strings.ToUpper(strings.TrimSpace(line)), "MLST") {
+				c.mlsd = true
+			}
+		}
+	}
+	return nil
+}
+
+// cmd sends a command and reads its response, expecting the given code unless it is zero.
+func (c *conn) cmd(expect int, format string, args ...interface{}) (int, error) {
+	code, _, err := c.run(expect, format, args...)
+	return code, err
+}
+
+func (c *conn) run(expect int, format string, args ...interface{}) (int, string, error) {
+	if err := c.net.SetDeadline(time.Now().Add(c.server.timeout)); err != nil {
+		return 0, "", err
+	}
+	defer c.net.SetDeadline(time.Time{})
+
+	if _, err := c.text.Cmd(format, args...); err != nil {
+		return 0, "", err
+	}
+
+	if expect == 0 {
+		// accept any positive completion or intermediate code
+		code, msg, err := c.text.ReadResponse(2)
+		if err != nil && code/100 == 3 {
+			return code, msg, nil
+		}
+		return code, msg, err
+	}
+	return c.text.ReadResponse(expect)
+}
+
+// passive opens a data connection, preferring extended passive mode.
+func (c *conn) passive() (net.Conn, error) {
+	host, _, err := net.SplitHostPort(c.server.addr)
+	if err != nil {
+		return nil, err
+	}
+
+	var port int
+	if _, msg, err := c.run(229, "EPSV"); err == nil {
+		// the port is given as (|||port|)
+		start, end := strings.Index(msg, "(|||"), strings.LastIndex(msg, "|)")
+		if start < 0 || end < start {
+			return nil, fmt.Errorf("ftpserver: invalid EPSV response %q", msg)
+		}
+		if port, err = strconv.Atoi(msg[start+4 : end]); err != nil {
+			return nil, fmt.Errorf("ftpserver: invalid EPSV response %q", msg)
+		}
+	} else {
+		_, msg, err := c.run(227, "PASV")
+		if err != nil {
+			return nil, err
+		}
+
+		// the address is given as (h1,h2,h3,h4,p1,p2), of which only the port is used
+		// since servers behind NAT often advertise an unreachable address
+		start, end := strings.Index(msg, "("), strings.LastIndex(msg, ")")
+		if start < 0 || end < start {
+			return nil, fmt.Errorf("ftpserver: invalid PASV response %q", msg)
+		}
+		fields := strings.Split(msg[start+1:end], ",")
+		if len(fields) != 6 {
+			return nil, fmt.Errorf("ftpserver: invalid PASV response %q", msg)
+		}
+		p1, err1 := strconv.Atoi(fields[4])
+		p2, err2 := strconv.Atoi(fields[5])
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("ftpserver: invalid PASV response %q", msg)
+		}
+		port = p1<<8 | p2
+	}
+
+	nc, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(port)), c.server.timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.secure {
+		nc = tls.Client(nc, c.server.tlsConfig)
+	}
+	return nc, nil
+}
+
+// transfer runs a command that sends data over a passive connection,
+// copying the data to w and waiting for the transfer to complete.
+func (c *conn) transfer(w io.Writer, format string, args ...interface{}) (int64, error) {
+	data, err := c.passive()
+	if err != nil {
+		return 0, err
+	}
+	defer data.Close()
+
+	if _, err := c.text.Cmd(format, args...); err != nil {
+		return 0, err
+	}
+
+	code, msg, err := c.text.ReadResponse(1)
+	if err != nil {
+		return 0, err
+	}
+	if code != 125 && code != 150 {
+		return 0, &textproto.Error{Code: code, Msg: msg}
+	}
+
+	n, err := io.Copy(w, data)
+	if err != nil {
+		return n, err
+	}
+
+	if err := data.Close(); err != nil {
+		return n, err
+	}
+
+	if _, _, err := c.text.ReadResponse(226); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+// retrieve downloads the file at the given path to w.
+func (c *conn) retrieve(p string, w io.Writer) (int64, error) {
+	return c.transfer(w, "RETR %s", p)
+}
+
+// list returns the files within the directory.
+func (c *conn) list(dir string) ([]entry, error) {
+	if c.mlsd {
+		return c.listMLSD(dir)
+	}
+
+	var names strings.Builder
+	if _, err := c.transfer(&names, "NLST %s", dir); err != nil {
+		return nil, err
+	}
+
+	var entries []entry
+	scanner := bufio.NewScanner(strings.NewReader(names.String()))
+	for scanner.Scan() {
+		// some servers list names with the directory prefixed
+		name := path.Base(strings.TrimSpace(scanner.Text()))
+		if name == "" || name == "." || name == ".." {
+			continue
+		}
+
+		_, msg, err := c.run(213, "SIZE %s", path.Join(dir, name))
+		if err != nil {
+			// directories have no size
+			if _, ok := err.(*textproto.Error); ok {
+				continue
+			}
+			return nil, err
+		}
+
+		size, err := strconv.ParseInt(strings.TrimSpace(msg), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("ftpserver: invalid SIZE response %q", msg)
+		}
+
+		e := entry{name: name, size: size}
+		if _, msg, err := c.run(213, "MDTM %s", path.Join(dir, name)); err == nil {
+			e.modTime, _ = time.Parse("20060102150405", strings.TrimSpace(msg))
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// listMLSD returns the files within the directory using machine readable listings.
+func (c *conn) listMLSD(dir string) ([]entry, error) {
+	var listing strings.Builder
+	if _, err := c.transfer(&listing, "MLSD %s", dir); err != nil {
+		return nil, err
+	}
+
+	var entries []entry
+	scanner := bufio.NewScanner(strings.NewReader(listing.String()))
+	for scanner.Scan() {
+		// each line is a list of facts followed by a space and the name: type=file;size=1; name
+		line := scanner.Text()
+		i := strings.Index(line, " ")
+		if i < 0 {
+			continue
+		}
+
+		e := entry{name: line[i+1:], size: -1}
+		isFile := false
+		for _, fact := range strings.Split(line[:i], ";") {
+			kv := strings.SplitN(fact, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+
+			switch strings.ToLower(kv[0]) {
+			case "type":
+				isFile = strings.ToLower(kv[1]) == "file"
+			case "size":
+				e.size, _ = strconv.ParseInt(kv[1], 10, 64)
+			case "modify":
+				// fractional seconds may follow
+				stamp := kv[1]
+				if len(stamp) > 14 {
+					stamp = stamp[:14]
+				}
+				e.modTime, _ = time.Parse("20060102150405", stamp)
+			}
+		}
+
+		if isFile && e.size >= 0 {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+func (c *conn) quit() {
+	c.cmd(221, "QUIT")
+	c.close()
+}
+
+func (c *conn) close() {
+	c.text.Close()
+}
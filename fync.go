@@ -1,15 +1,18 @@
 package fync
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 	"runtime"
-	"strings"
+	"strconv"
 	"sync"
+	"time"
 )
 
 var installDir, modsDir, backupDir string
@@ -38,7 +41,33 @@ func init() {
 	}
 
 	modsDir = filepath.Join(installDir, "mods")
-	backupDir = filepath.Join(modsDir, "backup")
+
+	// keep backups out of the mods directory where loaders and other tools may find them
+	dataDir, err := userDataDir()
+	if err != nil {
+		dirErr = err
+		return
+	}
+	backupDir = filepath.Join(dataDir, "fync", "backup")
+}
+
+// userDataDir returns the directory for user-specific application data,
+// $XDG_DATA_HOME or ~/.local/share on Linux, %AppData% on Windows,
+// and ~/Library/Application Support on macOS.
+func userDataDir() (string, error) {
+	if runtime.GOOS != "linux" {
+		return os.UserConfigDir()
+	}
+
+	if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+		return dir, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "share"), nil
 }
 
 // InstallDir returns the Minecraft installation directory.
@@ -52,12 +81,40 @@ func ModsDir() (string, error) {
 }
 
 // BackupDir returns the backup directory for Minecraft mods that were not on the server.
+// Each sync that backs up mods does so into its own timestamped backup set within it.
+// It defaults to a fync directory within the user's application data directory.
 func BackupDir() (string, error) {
 	return backupDir, dirErr
 }
 
+// SetInstallDir changes the Minecraft installation directory, and with it the mods directory.
+// It should be called before syncing.
+func SetInstallDir(dir string) {
+	installDir = dir
+	modsDir = filepath.Join(dir, "mods")
+	hashes.reset()
+
+	if backupDir != "" {
+		dirErr = nil
+	}
+}
+
+// SetBackupDir changes the backup directory used by Sync, Restore, ListBackups, and PruneBackups.
+// It should be called before syncing.
+func SetBackupDir(dir string) {
+	backupDir = dir
+
+	if installDir != "" {
+		dirErr = nil
+	}
+}
+
 // ServerFile represents a server mod file that can be written to another file
 // and is able to provide its FileInfo and be closed.
+// Stat is only called once while planning a sync. Files that cannot know their size
+// before being written, such as generated or chunked streams, report a negative size;
+// they are compared with local mods by checksum alone, and are always hashed as they are
+// written so that the checksum can still be verified.
 type ServerFile interface {
 	io.WriterTo
 	io.Closer
@@ -67,6 +124,7 @@ type ServerFile interface {
 // Server represents a MinecraftForge server.
 type Server interface {
 	// Mods returns a slice of mod ServerFiles the server is using.
+	// Servers that would have to open each mod to do so should also implement ListServer.
 	Mods() ([]ServerFile, error)
 }
 
@@ -76,36 +134,155 @@ type SyncOptions struct {
 	OnWrite func(from os.FileInfo, to string)
 
 	// Called when an existing mod is being backed up.
+	// The destination is empty when the mod is being moved to the trash.
 	OnBackup func(name, from, to string)
 
-	// Called when a task's progress has updated.
+	// Called when an existing mod is being deleted.
+	OnDelete func(name, path string)
+
+	// Called when a task's progress has updated. The tasks, in the order they occur, are "list" for
+	// obtaining the server's mods, "scan" for reading the mods directory, "compare" for comparing
+	// server mods with local ones, "write", and "backup". When RecordHistory is set, "history"
+	// reports hashing the mods and config directories before and after syncing.
 	OnProgress func(task string, curr, total int)
 
+	// Called with the planned changes before any are made. Individual actions
+	// may be skipped by setting their Skip field, and returning an error aborts the sync.
+	OnPlan func(p *Plan) error
+
 	// Whether or not to keep existing mods by not backing up them up if they're not on the server.
 	KeepExisting bool
 
 	// Whether to overwite existing local mods with same name as a server mod.
 	Force bool
+
+	// Whether to only replace or back up mods that were installed by fync,
+	// leaving mods that were installed by hand untouched.
+	ManagedOnly bool
+
+	// Whether to snapshot the entire mods directory before making any changes.
+	// Snapshots are kept within SnapshotDir and are not affected by Restore or PruneBackups.
+	Snapshot bool
+
+	// Whether to record the checksums of the files within the mods and config directories
+	// before and after syncing, saving the changes between them for History.
+	RecordHistory bool
+
+	// Whether to delete mods that would be backed up instead, so that the mods directory
+	// exactly mirrors the server. Syncs that delete mods cannot be undone by Restore.
+	// It cannot be combined with KeepExisting or UseTrash.
+	Delete bool
+
+	// Whether to move mods that would be backed up to the platform's Recycle Bin or Trash instead.
+	// Syncs that use the trash do not create a backup set and cannot be undone by Restore.
+	UseTrash bool
+
+	// Whether to compress each sync's backup set into a zip archive.
+	CompressBackups bool
+
+	// Name of the registered codec used to compress backup sets, defaulting to DefaultCodec.
+	BackupCodec string
+
+	// Whether to store backed up mods once by their checksum, shared between backup sets,
+	// instead of keeping a copy within each backup set.
+	DeduplicateBackups bool
+
+	// When either is set, backup sets are pruned after syncing as if by PruneBackups.
+	PruneKeepLast  int
+	PruneOlderThan time.Duration
+
+	// Whether to trust the checksums supplied by the server rather than
+	// verifying them by hashing each mod as it is written.
+	TrustChecksums bool
+
+	// A lockfile, such as one distributed by the server's admin, that the server mods must
+	// match exactly by name, size, and checksum. Mods are always hashed when verifying against
+	// a lock, and a *LockError is returned before any changes are made if the server differs.
+	Lock *Lock
+
+	// A policy restricting the mods installed to trusted jars, protecting against a compromised server.
+	// Mods it rejects fail the sync with a *PolicyError, unless it quarantines them.
+	Policy *JarPolicy
+
+	// Called when a mod rejected by the Policy has been moved to its quarantine directory.
+	OnQuarantine func(name, path string)
+
+	// Directory in which mods are written before being moved into the mods directory once verified.
+	// Defaults to the mods directory itself, where a mod's temporary file is named after it.
+	TempDir string
+
+	// Closing the channel cancels the sync, or its verification by Verify, with ErrCanceled.
+	// Mods being hashed, written, or backed up are finished first, and local checksums
+	// computed so far are kept so that the next sync or verification resumes from them.
+	Cancel <-chan struct{}
+
+	// Maximum number of mods to compare, write, or back up at once. Defaults to 8.
+	// Server files are closed as soon as they are written or found to be up to date.
+	Concurrency int
+
+	// Number of times to retry a mod transfer that failed or was incomplete.
+	// Only ServerFiles implementing io.Seeker are retried.
+	Retries int
 }
 
-// Sync will sync the server's mods with the user's local Minecraft mods.
+// VerificationError is returned when a written mod does not match what the server advertised.
+type VerificationError struct {
+	// Path of the written mod.
+	Path string
+
+	// The property that failed verification, either "size" or "checksum".
+	Field string
+
+	// The value advertised by the server and the value that was written.
+	Expected, Actual string
+}
+
+func (e *VerificationError) Error() string {
+	return fmt.Sprintf("%s: %s mismatch: expected %s, wrote %s", e.Path, e.Field, e.Expected, e.Actual)
+}
+
+// ErrInsufficientSpace is returned by Sync when the volume containing the mods directory
+// does not have enough space available for the mods that are to be written.
+var ErrInsufficientSpace = errors.New("insufficient disk space")
+
+// Sync will sync the server's mods with the user's local Minecraft mods,
+// recording the resulting server mods to the lockfile at LockPath.
 // The number of mods written is returned as well as any errors encountered.
-func Sync(s Server, o *SyncOptions) (int, error) {
-	var n int
+// Server mods that are not named like a mod are rejected with a *NameError before anything is written.
+// Each change is journaled before it is made, so that a sync interrupted by a crash is reported
+// by Interrupted, and can be completed by syncing again or undone by RollBack.
+// Errors caused by insufficient privileges are returned as a *PermissionError.
+func Sync(s Server, o *SyncOptions) (n int, err error) {
+	defer func() {
+		err = permissionError(err)
+	}()
 
 	if dirErr != nil {
 		return n, dirErr
 	}
 
-	// obtain list of mods
-	serverMods, err := s.Mods()
+	if o.Delete && (o.KeepExisting || o.UseTrash) {
+		return n, errors.New("the Delete option cannot be combined with KeepExisting or UseTrash")
+	}
+
+	if o.CompressBackups {
+		if _, err := codec(o.BackupCodec); err != nil {
+			return n, err
+		}
+	}
+
+	listed := progress("list", o)
+	if listed != nil {
+		listed(0, 1)
+	}
+
+	mods, sums, err := fetchMods(s)
 	if err != nil {
 		return n, err
 	}
 
-	total := len(serverMods)
-	if total == 0 {
-		return n, errors.New("no server mods to sync")
+	if listed != nil {
+		listed(1, 1)
 	}
 
 	// make sure mods directory exists
@@ -113,169 +290,447 @@ func Sync(s Server, o *SyncOptions) (int, error) {
 		return n, err
 	}
 
-	// determine local mods
-	var localMods map[string]int64
-	if !(o.KeepExisting && o.Force) {
-		files, err := ioutil.ReadDir(modsDir)
-		if err != nil {
-			return n, err
-		}
+	// keep the local checksums computed so far, even when the sync fails or is canceled,
+	// though failing to do so only costs hashing them again
+	defer flushHashes()
 
-		localMods = make(map[string]int64)
-		for i := range files {
-			if !files[i].IsDir() && strings.HasSuffix(files[i].Name(), ".jar") {
-				localMods[files[i].Name()] = files[i].Size()
-			}
-		}
+	// remove anything left behind by an interrupted sync
+	if _, err := Clean(); err != nil {
+		return n, err
 	}
 
-	curr := 0
-	if o.OnProgress != nil {
-		o.OnProgress("write", curr, total)
+	if o.TempDir != "" {
+		if err := os.MkdirAll(o.TempDir, os.ModeDir|0755); err != nil {
+			return n, err
+		}
+		if _, err := CleanTemp(o.TempDir); err != nil {
+			return n, err
+		}
 	}
 
-	// download each mod to mods directory
-	ch := make(chan error, total)
-	var mu sync.Mutex
-	for i := range serverMods {
-		go func(mod ServerFile) {
-			defer mod.Close()
-
-			info, err := mod.Stat()
-			if err != nil {
-				ch <- err
-				return
-			}
+	// journal each change so that a sync interrupted by a crash can be rolled back or completed,
+	// discarding the journal only once the backups, state, and history recording the changes have been saved,
+	// which are deferred after this so that they are saved first
+	var j *journal
+	saveFailed := false
+	defer func() {
+		if saveFailed {
+			j.abandon()
+			return
+		}
+		if commitErr := j.commit(); commitErr != nil && err == nil {
+			err = commitErr
+		}
+	}()
 
-			name := info.Name()
-			dest := filepath.Join(modsDir, name)
+	start := time.Now()
+	if o.RecordHistory {
+		before, err := readInstanceState(o)
+		if err != nil {
+			return n, err
+		}
 
-			// write server mod to local mods dir
-			if o.Force {
-				err := write(mod, dest, o)
-				if err != nil {
-					ch <- err
-					return
-				}
-				n++
-			} else {
-				mu.Lock()
-				size, exists := localMods[name]
-				mu.Unlock()
-
-				if !exists {
-					err := write(mod, dest, o)
-					if err != nil {
-						ch <- err
-						return
-					}
-					n++
-				} else if size != info.Size() {
-					err := backup(name, o)
-					if err != nil {
-						ch <- err
-						return
-					}
-
-					err = write(mod, dest, o)
-					if err != nil {
-						ch <- err
-						return
-					}
-					n++
+		// record the changes even when the sync fails partway through
+		defer func() {
+			after, stateErr := readInstanceState(o)
+			if stateErr == nil {
+				stateErr = recordHistory(start, before, after, err)
+			}
+			if stateErr != nil {
+				saveFailed = true
+				if err == nil {
+					err = stateErr
 				}
 			}
+		}()
+	}
+
+	// guarantee a recovery point before any changes are made
+	if o.Snapshot {
+		if _, err := snapshot(start); err != nil {
+			return n, err
+		}
+	}
 
-			if !o.KeepExisting {
-				mu.Lock()
-				delete(localMods, name)
-				mu.Unlock()
+	// keep track of the mods that have been installed by fync
+	st, err := readState()
+	if err != nil {
+		return n, err
+	}
+	defer func() {
+		if saveErr := st.save(); saveErr != nil {
+			saveFailed = true
+			if err == nil {
+				err = saveErr
 			}
+		}
+	}()
 
-			ch <- nil
-		}(serverMods[i])
+	// determine the changes to make
+	p, err := plan(mods, sums, st, o)
+	if err != nil {
+		return n, err
 	}
+	defer p.close()
 
-	// TODO: refactor this error channel pattern into type
-	for range serverMods {
-		err := <-ch
-		if err != nil {
-			close(ch)
+	if o.OnPlan != nil {
+		if err := o.OnPlan(p); err != nil {
 			return n, err
 		}
 
-		if o.OnProgress != nil {
-			curr++
-			o.OnProgress("write", curr, total)
+		if err := p.validate(); err != nil {
+			return n, err
 		}
 	}
 
-	total = len(localMods)
-	if !o.KeepExisting && total != 0 {
-		os.MkdirAll(backupDir, os.ModeDir|0755)
+	var writes, removals []*Action
+	for _, a := range p.Actions {
+		if a.Skip {
+			a.close()
+		} else if a.Type == ActionRemove {
+			removals = append(removals, a)
+		} else {
+			writes = append(writes, a)
+		}
+	}
 
-		if o.OnProgress != nil {
-			curr = 0
-			o.OnProgress("backup", curr, total)
+	// fail before making any changes rather than partway through with a full disk
+	if err := checkSpace(writes); err != nil {
+		return n, err
+	}
+
+	if j, err = beginJournal(start); err != nil {
+		return n, err
+	}
+
+	// mods replaced or removed by this sync are kept together
+	// along with a record of the mods it installed so it can be restored
+	set := newBackupSet(start)
+	set.journal = j
+	defer func() {
+		if saveErr := set.save(o); saveErr != nil {
+			saveFailed = true
+			if err == nil {
+				err = saveErr
+			}
 		}
+	}()
 
-		ch = make(chan error, total)
-		for mod := range localMods {
-			mod := mod
-			go func() {
-				ch <- backup(mod, o)
-			}()
+	// download each mod to mods directory
+	bases := deltaBases(writes, removals)
+	var mu sync.Mutex
+	err = run(len(writes), concurrency(o), o.Cancel, progress("write", o), func(i int) error {
+		a := writes[i]
+		defer a.close()
+
+		// keep the mod being replaced to check its update against or transfer it as a delta from,
+		// though without it the update is only transferred whole
+		basis := bases[a.Name]
+		if basis == filepath.Join(modsDir, a.Name) {
+			var err error
+			if basis, err = keepBasis(basis); err == nil {
+				defer os.Remove(basis)
+			}
 		}
 
-		for range localMods {
-			err := <-ch
-			if err != nil {
-				close(ch)
-				return n, err
+		if a.Type == ActionReplace {
+			if err := set.backup(a.Name, BackupReplaced, o); err != nil {
+				return err
 			}
+		}
+
+		path := filepath.Join(modsDir, a.Name)
 
-			if o.OnProgress != nil {
-				curr++
-				o.OnProgress("backup", curr, total)
+		// a forced install overwrites the local mod without backing it up, so it cannot be rolled back
+		_, statErr := os.Stat(path)
+		if err := j.write(a.Name, a.Type == ActionInstall && statErr == nil); err != nil {
+			return err
+		}
+
+		sum, size, err := write(a.file, a.Server, path, basis, a.sum, o)
+		if e, ok := err.(*PolicyError); ok && e.Quarantined != "" {
+			if o.OnQuarantine != nil {
+				o.OnQuarantine(a.Name, e.Quarantined)
 			}
+			return j.done(a.Name)
+		} else if err != nil {
+			return err
+		}
+		rememberHash(path, sum)
+		set.install(a.Name)
+		st.manage(a.Name, size, sum)
+
+		if err := j.done(a.Name); err != nil {
+			return err
+		}
+
+		mu.Lock()
+		n++
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return n, err
+	}
+
+	// back up local mods that are not on the server
+	err = run(len(removals), concurrency(o), o.Cancel, progress("backup", o), func(i int) error {
+		if err := set.backup(removals[i].Name, BackupNotOnServer, o); err != nil {
+			return err
+		}
+		st.release(removals[i].Name)
+		return j.done(removals[i].Name)
+	})
+	if err != nil {
+		return n, err
+	}
+
+	// record the resulting mods so later syncs and other clients can verify against them
+	source := sourceOf(s)
+	for i := range p.mods {
+		if p.mods[i].Source == "" {
+			p.mods[i].Source = source
+		}
+	}
+	if err := writeLock(p.mods, st); err != nil {
+		return n, err
+	}
+
+	if o.PruneKeepLast > 0 || o.PruneOlderThan > 0 {
+		if _, err := PruneBackups(o.PruneKeepLast, o.PruneOlderThan); err != nil {
+			return n, err
 		}
 	}
 
 	return n, nil
 }
 
-func backup(name string, o *SyncOptions) error {
-	from := filepath.Join(modsDir, name)
-	to := filepath.Join(backupDir, name)
+// fetchMods obtains the server's mods along with their checksums when the server provides them.
+func fetchMods(s Server) ([]ServerFile, map[string]string, error) {
+	mods, err := serverMods(s)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	if o.OnBackup != nil {
-		o.OnBackup(name, from, to)
+	if len(mods) == 0 {
+		return nil, nil, errors.New("no server mods to sync")
 	}
 
-	if err := os.Rename(from, to); err != nil {
+	var sums map[string]string
+	if cs, ok := s.(ChecksumServer); ok {
+		if sums, err = cs.Checksums(); err != nil {
+			for _, mod := range mods {
+				mod.Close()
+			}
+			return nil, nil, err
+		}
+	}
+	return mods, sums, nil
+}
+
+// checkSpace ensures there is enough space available to write the mods.
+// Mods of unknown size are not accounted for.
+func checkSpace(writes []*Action) error {
+	var needed uint64
+	for _, a := range writes {
+		if size := a.Server.Size(); size > 0 {
+			needed += uint64(size)
+		}
+	}
+
+	if needed == 0 {
+		return nil
+	}
+
+	available, err := availableSpace(modsDir)
+	if err != nil {
 		return err
 	}
+
+	if needed > available {
+		return fmt.Errorf("%w: %d bytes needed, %d available", ErrInsufficientSpace, needed, available)
+	}
 	return nil
 }
 
-func write(from ServerFile, to string, o *SyncOptions) error {
+// progress returns a function reporting the named task's progress,
+// or nil if progress is not being reported.
+func progress(task string, o *SyncOptions) func(curr, total int) {
+	if o.OnProgress == nil {
+		return nil
+	}
+
+	return func(curr, total int) {
+		o.OnProgress(task, curr, total)
+	}
+}
+
+// write writes from, described by info, to the path to, returning the hex encoded SHA-256 checksum
+// and size of the written mod. When basis is not empty, the mod is written from the local mod at basis
+// as by writeMod, and written whole if that fails.
+// A checksum supplied by the server is returned instead of hashing the mod when it is trusted,
+// though mods of unknown size are always hashed since their size cannot be verified.
+func write(from ServerFile, info os.FileInfo, to, basis, sum string, o *SyncOptions) (string, int64, error) {
 	if o.OnWrite != nil {
-		info, err := from.Stat()
-		if err != nil {
-			return err
-		}
 		o.OnWrite(info, to)
 	}
 
-	file, err := os.Create(to)
+	trusted := o.TrustChecksums && sum != "" && o.Lock == nil && o.Policy == nil && info.Size() >= 0
+	for attempt := 0; ; attempt++ {
+		written, size, retry, err := transfer(from, to, o.TempDir, basis, info.Size(), sum, !trusted, o.Policy)
+		if err == nil {
+			if trusted {
+				return sum, size, nil
+			}
+			return written, size, nil
+		}
+
+		// a rejected mod is rejected however it is transferred
+		if _, ok := err.(*PolicyError); ok {
+			return "", 0, err
+		}
+
+		// writing the mod whole does not count as a retry
+		if basis != "" {
+			basis = ""
+			attempt--
+			continue
+		}
+
+		if !retry || attempt >= o.Retries {
+			return "", 0, err
+		}
+
+		// only server files that can be rewound are able to be transferred again
+		seeker, ok := from.(io.Seeker)
+		if !ok {
+			return "", 0, err
+		}
+		if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+			return "", 0, err
+		}
+	}
+}
+
+// transfer writes from to a temporary file within tempDir, or alongside the path to when it is empty,
+// verifies the result, and then moves it to the path to.
+// It is written from the local mod at basis when that is not empty, as by writeMod.
+// The size is only verified when it is not negative.
+// When hashWritten is set the written mod is hashed, verifying it against sum when not empty,
+// and its checksum returned along with the number of bytes written.
+// The written mod is only moved to the path to once the policy, when not nil, allows it.
+// Whether the error is the result of a failed transfer that can be retried is also returned.
+func transfer(from ServerFile, to, tempDir, basis string, size int64, sum string, hashWritten bool, policy *JarPolicy) (string, int64, bool, error) {
+	// an interrupted transfer must never leave a partial mod where the game would load it
+	tmp := to + tempExt
+	if tempDir != "" {
+		tmp = filepath.Join(tempDir, filepath.Base(to)+tempExt)
+	}
+
+	// remove the temporary file however the transfer ends, including by a panic
+	placed := false
+	defer func() {
+		if !placed {
+			os.Remove(tmp)
+		}
+	}()
+
+	written, n, retry, err := transferTemp(from, tmp, basis, size, sum, hashWritten)
 	if err != nil {
-		return err
+		if e, ok := err.(*VerificationError); ok {
+			e.Path = to
+		}
+		return "", 0, retry, err
+	}
+
+	if err := policy.check(tmp, filepath.Base(to), written); err != nil {
+		return "", 0, false, err
+	}
+
+	// replace rather than truncate any existing file, which may be hard linked by a snapshot
+	if err := place(tmp, to); err != nil {
+		return "", 0, false, err
+	}
+	placed = true
+	return written, n, false, nil
+}
+
+func transferTemp(from ServerFile, tmp, basis string, size int64, sum string, hashWritten bool) (string, int64, bool, error) {
+	file, err := os.Create(tmp)
+	if err != nil {
+		return "", 0, false, err
 	}
 	defer file.Close()
 
-	if _, err := from.WriteTo(file); err != nil {
+	var w io.Writer = file
+	var h hash.Hash
+	if hashWritten {
+		h = sha256.New()
+		w = io.MultiWriter(file, h)
+	} else {
+		sum = ""
+	}
+
+	n, err := writeMod(from, w, basis, size)
+	if err != nil {
+		return "", 0, true, err
+	}
+
+	// errors from a full disk may only surface once the file is closed
+	if err := file.Close(); err != nil {
+		return "", 0, false, err
+	}
+
+	// mods of unknown size are verified by what was written and their checksum alone
+	if size < 0 {
+		size = n
+	}
+
+	if n != size {
+		return "", 0, true, &VerificationError{
+			Path:     tmp,
+			Field:    "size",
+			Expected: strconv.FormatInt(size, 10),
+			Actual:   strconv.FormatInt(n, 10),
+		}
+	}
+
+	var written string
+	if h != nil {
+		written = hex.EncodeToString(h.Sum(nil))
+	}
+
+	if err := verify(tmp, size, sum, written); err != nil {
+		return "", 0, true, err
+	}
+	return written, n, false, nil
+}
+
+// verify checks that the mod written to path has the expected size
+// and, when expectedSum is not empty, that the written checksum matches.
+func verify(path string, expectedSize int64, expectedSum, writtenSum string) error {
+	stat, err := os.Stat(path)
+	if err != nil {
 		return err
 	}
 
+	if stat.Size() != expectedSize {
+		return &VerificationError{
+			Path:     path,
+			Field:    "size",
+			Expected: strconv.FormatInt(expectedSize, 10),
+			Actual:   strconv.FormatInt(stat.Size(), 10),
+		}
+	}
+
+	if expectedSum != "" && writtenSum != expectedSum {
+		return &VerificationError{
+			Path:     path,
+			Field:    "checksum",
+			Expected: expectedSum,
+			Actual:   writtenSum,
+		}
+	}
+
 	return nil
 }
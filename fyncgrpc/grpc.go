@@ -0,0 +1,118 @@
+package fyncgrpc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Paths of the methods of the Mods service.
+const (
+	listModsPath  = "/fync.v1.Mods/ListMods"
+	getModPath    = "/fync.v1.Mods/GetMod"
+	watchModsPath = "/fync.v1.Mods/WatchMods"
+)
+
+// contentType is the media type of gRPC requests and responses.
+const contentType = "application/grpc"
+
+// maxMessageSize is the size of the largest message that is accepted, the default of gRPC implementations.
+const maxMessageSize = 4 << 20
+
+// Status codes of gRPC that are used.
+const (
+	codeOK              = 0
+	codeInvalidArgument = 3
+	codeNotFound        = 5
+	codeInternal        = 13
+	codeUnimplemented   = 12
+)
+
+// StatusError is returned when a call fails with a gRPC status other than OK.
+type StatusError struct {
+	// The gRPC status code, such as 5 for NOT_FOUND.
+	Code int
+
+	// The message describing the error.
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("fyncgrpc: %s (code %d)", e.Message, e.Code)
+}
+
+// writeMessage writes a length prefixed message, which is never compressed.
+func writeMessage(w io.Writer, msg []byte) error {
+	var prefix [5]byte
+	binary.BigEndian.PutUint32(prefix[1:], uint32(len(msg)))
+	if _, err := w.Write(prefix[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(msg)
+	return err
+}
+
+// readMessage reads a length prefixed message, returning io.EOF when there are no more messages.
+func readMessage(r io.Reader) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("fyncgrpc: truncated message: %w", err)
+		}
+		return nil, err
+	}
+
+	if prefix[0] != 0 {
+		return nil, &StatusError{Code: codeUnimplemented, Message: "compressed messages are unsupported"}
+	}
+	n := binary.BigEndian.Uint32(prefix[1:])
+	if n > maxMessageSize {
+		return nil, &StatusError{Code: codeInvalidArgument, Message: fmt.Sprintf("message of %d bytes is too large", n)}
+	}
+
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("fyncgrpc: truncated message: %w", err)
+	}
+	return msg, nil
+}
+
+// encodeStatusMessage percent-encodes a status message for the grpc-message trailer.
+func encodeStatusMessage(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		if c := msg[i]; c >= ' ' && c <= '~' && c != '%' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// statusError returns the error described by the grpc-status and grpc-message trailers,
+// or nil if the status is OK.
+func statusError(status, msg string) error {
+	if status == "" {
+		return &StatusError{Code: codeInternal, Message: "missing grpc-status"}
+	}
+
+	code, err := strconv.Atoi(status)
+	if err != nil {
+		return &StatusError{Code: codeInternal, Message: fmt.Sprintf("invalid grpc-status %q", status)}
+	}
+	if code == codeOK {
+		return nil
+	}
+
+	if decoded, err := url.PathUnescape(msg); err == nil {
+		msg = decoded
+	}
+	return &StatusError{Code: code, Message: msg}
+}
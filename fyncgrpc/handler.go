@@ -0,0 +1,309 @@
+package fyncgrpc
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/han-tyumi/fync"
+)
+
+// DefaultAddr is the address served on when none is chosen.
+const DefaultAddr = ":7460"
+
+// DefaultPollInterval is how often the mods directory is checked for changes to notify watchers of
+// when no interval is chosen.
+const DefaultPollInterval = 2 * time.Second
+
+// chunkSize is the size of the chunks mods are streamed in.
+const chunkSize = 64 << 10
+
+// ServeOptions contains options for the Serve and Handler functions.
+type ServeOptions struct {
+	// The address to listen on. Defaults to DefaultAddr. Unused by Handler.
+	Addr string
+
+	// Paths of the certificate and its private key to serve with. gRPC requires HTTP/2,
+	// which is only served over TLS, so both are required by Serve. Unused by Handler.
+	CertFile, KeyFile string
+
+	// How often the mods directory is checked for changes to notify watchers of. Defaults to DefaultPollInterval.
+	PollInterval time.Duration
+
+	// The bandwidth used to serve clients, as by fync.LimitBandwidth. Unlimited by default.
+	Bandwidth fync.BandwidthLimit
+
+	// Called once each call has been served, as by fync.LogAccess, with the name of the method as its Op
+	// and any gRPC error as its Err.
+	OnAccess func(fync.Access)
+
+	// Identifies the client of a call for OnAccess. Defaults to the client's IP address.
+	ClientID func(*http.Request) string
+}
+
+// Serve serves the mods within the mods directory over gRPC until it fails.
+func Serve(modsDir string, o *ServeOptions) error {
+	if o == nil || o.CertFile == "" || o.KeyFile == "" {
+		return errors.New("fyncgrpc: a certificate and key are required, since gRPC is served over HTTP/2, which requires TLS")
+	}
+
+	addr := o.Addr
+	if addr == "" {
+		addr = DefaultAddr
+	}
+	return http.ListenAndServeTLS(addr, o.CertFile, o.KeyFile, Handler(modsDir, o))
+}
+
+// Handler returns an http.Handler serving the mods within the mods directory over gRPC,
+// to be served by an http.Server that serves HTTP/2.
+func Handler(modsDir string, o *ServeOptions) http.Handler {
+	h := &handler{dir: modsDir, poll: DefaultPollInterval, hashes: make(map[string]hashEntry)}
+	if o == nil {
+		return h
+	}
+
+	if o.PollInterval > 0 {
+		h.poll = o.PollInterval
+	}
+	return fync.LogAccess(fync.LimitBandwidth(h, o.Bandwidth), o.ClientID, o.OnAccess)
+}
+
+type handler struct {
+	dir  string
+	poll time.Duration
+
+	mu     sync.Mutex
+	hashes map[string]hashEntry
+}
+
+// hashEntry is the checksum of a mod, which is trusted while its size and modification time are unchanged.
+type hashEntry struct {
+	size    int64
+	modTime time.Time
+	sum     string
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), contentType) {
+		http.Error(w, "fyncgrpc: only gRPC requests are served", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+
+	req, err := readMessage(r.Body)
+	if err == nil {
+		switch r.URL.Path {
+		case listModsPath:
+			err = h.listMods(w)
+		case getModPath:
+			err = h.getMod(w, r, req)
+		case watchModsPath:
+			err = h.watchMods(w, r)
+		default:
+			err = &StatusError{Code: codeUnimplemented, Message: "unknown method " + r.URL.Path}
+		}
+	} else if err == io.EOF {
+		err = &StatusError{Code: codeInvalidArgument, Message: "missing request message"}
+	}
+
+	status := &StatusError{Code: codeOK}
+	if e, ok := err.(*StatusError); ok {
+		status = e
+	} else if err != nil {
+		status = &StatusError{Code: codeInternal, Message: err.Error()}
+	}
+
+	fync.NoteAccess(r, func(a *fync.Access) {
+		switch r.URL.Path {
+		case listModsPath, getModPath, watchModsPath:
+			a.Op = path.Base(r.URL.Path)
+		}
+		if status.Code != codeOK {
+			a.Err = status.Error()
+		}
+	})
+
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(status.Code))
+	if status.Message != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", encodeStatusMessage(status.Message))
+	}
+}
+
+// mods returns the mods within the mods directory.
+func (h *handler) mods() ([]mod, error) {
+	files, err := ioutil.ReadDir(h.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var mods []mod
+	for _, info := range files {
+		if info.Mode().IsRegular() && strings.HasSuffix(info.Name(), ".jar") {
+			mods = append(mods, mod{name: info.Name(), size: info.Size(), modTime: info.ModTime().UnixNano()})
+		}
+	}
+	return mods, nil
+}
+
+func (h *handler) listMods(w io.Writer) error {
+	mods, err := h.mods()
+	if err != nil {
+		return err
+	}
+
+	for i := range mods {
+		if mods[i].sha256, err = h.checksum(&mods[i]); err != nil {
+			return err
+		}
+	}
+
+	res := listModsResponse{mods: mods}
+	return writeMessage(w, res.marshal())
+}
+
+// checksum returns the checksum of a mod, hashing it unless it is unchanged since it was last hashed.
+func (h *handler) checksum(m *mod) (string, error) {
+	modTime := time.Unix(0, m.modTime)
+
+	h.mu.Lock()
+	e, ok := h.hashes[m.name]
+	h.mu.Unlock()
+	if ok && e.size == m.size && e.modTime.Equal(modTime) {
+		return e.sum, nil
+	}
+
+	f, err := os.Open(filepath.Join(h.dir, m.name))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+
+	h.mu.Lock()
+	h.hashes[m.name] = hashEntry{size: m.size, modTime: modTime, sum: sum}
+	h.mu.Unlock()
+	return sum, nil
+}
+
+func (h *handler) getMod(w io.Writer, r *http.Request, data []byte) error {
+	var req getModRequest
+	if err := req.unmarshal(data); err != nil {
+		return &StatusError{Code: codeInvalidArgument, Message: err.Error()}
+	}
+
+	if req.name != filepath.Base(req.name) || strings.HasPrefix(req.name, ".") || !strings.HasSuffix(req.name, ".jar") {
+		return &StatusError{Code: codeInvalidArgument, Message: fmt.Sprintf("invalid mod name %q", req.name)}
+	}
+	// only valid names are noted, so that clients cannot fill the log and metrics with arbitrary ones
+	fync.NoteAccess(r, func(a *fync.Access) { a.Mod = req.name })
+	if req.offset < 0 {
+		return &StatusError{Code: codeInvalidArgument, Message: "negative offset"}
+	}
+
+	f, err := os.Open(filepath.Join(h.dir, req.name))
+	if os.IsNotExist(err) {
+		return &StatusError{Code: codeNotFound, Message: "no mod named " + req.name}
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if info, err := f.Stat(); err != nil {
+		return err
+	} else if !info.Mode().IsRegular() {
+		return &StatusError{Code: codeNotFound, Message: "no mod named " + req.name}
+	}
+	if _, err := f.Seek(req.offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			c := chunk{data: buf[:n]}
+			if err := writeMessage(w, c.marshal()); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// watchMods sends a message each time the mods within the directory change until the request is canceled.
+func (h *handler) watchMods(w http.ResponseWriter, r *http.Request) error {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return &StatusError{Code: codeUnimplemented, Message: "streaming is unsupported"}
+	}
+
+	// send the headers so that the client knows the watch has begun
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	last, err := h.fingerprint()
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(h.poll)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return nil
+		case t := <-ticker.C:
+			curr, err := h.fingerprint()
+			if err != nil {
+				return err
+			}
+			if bytes.Equal(curr, last) {
+				continue
+			}
+			last = curr
+
+			msg := modsChanged{time: t.UnixNano()}
+			if err := writeMessage(w, msg.marshal()); err != nil {
+				return err
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// fingerprint returns the names, sizes, and modification times of the mods, which change whenever the mods do.
+func (h *handler) fingerprint() ([]byte, error) {
+	mods, err := h.mods()
+	if err != nil {
+		return nil, err
+	}
+
+	var b []byte
+	for i := range mods {
+		b = append(b, mods[i].marshal()...)
+	}
+	return b, nil
+}
@@ -0,0 +1,195 @@
+package fyncgrpc
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// errProtobuf is returned when a message is not validly encoded.
+var errProtobuf = errors.New("fyncgrpc: invalid protobuf message")
+
+// Messages of the protocol as described by fync.proto, encoded and decoded by hand
+// so that no generated code or protobuf runtime is needed.
+
+type mod struct {
+	name    string
+	size    int64
+	modTime int64
+	sha256  string
+}
+
+func (m *mod) marshal() []byte {
+	var b []byte
+	b = appendString(b, 1, m.name)
+	b = appendVarint(b, 2, uint64(m.size))
+	b = appendVarint(b, 3, uint64(m.modTime))
+	return appendString(b, 4, m.sha256)
+}
+
+func (m *mod) unmarshal(data []byte) error {
+	return fields(data, func(num int, v uint64, b []byte) {
+		switch num {
+		case 1:
+			m.name = string(b)
+		case 2:
+			m.size = int64(v)
+		case 3:
+			m.modTime = int64(v)
+		case 4:
+			m.sha256 = string(b)
+		}
+	})
+}
+
+type listModsResponse struct {
+	mods []mod
+}
+
+func (m *listModsResponse) marshal() []byte {
+	var b []byte
+	for i := range m.mods {
+		b = appendBytes(b, 1, m.mods[i].marshal())
+	}
+	return b
+}
+
+func (m *listModsResponse) unmarshal(data []byte) error {
+	var err error
+	ferr := fields(data, func(num int, v uint64, b []byte) {
+		if num == 1 && err == nil {
+			var mod mod
+			err = mod.unmarshal(b)
+			m.mods = append(m.mods, mod)
+		}
+	})
+	if ferr != nil {
+		return ferr
+	}
+	return err
+}
+
+type getModRequest struct {
+	name   string
+	offset int64
+}
+
+func (m *getModRequest) marshal() []byte {
+	return appendVarint(appendString(nil, 1, m.name), 2, uint64(m.offset))
+}
+
+func (m *getModRequest) unmarshal(data []byte) error {
+	return fields(data, func(num int, v uint64, b []byte) {
+		switch num {
+		case 1:
+			m.name = string(b)
+		case 2:
+			m.offset = int64(v)
+		}
+	})
+}
+
+// chunk is a chunk of a mod. Its data refers to the message it was decoded from rather than a copy.
+type chunk struct {
+	data []byte
+}
+
+func (m *chunk) marshal() []byte {
+	return appendBytes(nil, 1, m.data)
+}
+
+func (m *chunk) unmarshal(data []byte) error {
+	return fields(data, func(num int, v uint64, b []byte) {
+		if num == 1 {
+			m.data = b
+		}
+	})
+}
+
+type modsChanged struct {
+	time int64
+}
+
+func (m *modsChanged) marshal() []byte {
+	return appendVarint(nil, 1, uint64(m.time))
+}
+
+func (m *modsChanged) unmarshal(data []byte) error {
+	return fields(data, func(num int, v uint64, b []byte) {
+		if num == 1 {
+			m.time = int64(v)
+		}
+	})
+}
+
+// appendVarint appends a varint field, omitting it when it has the default value of zero.
+func appendVarint(b []byte, num int, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = appendUvarint(b, uint64(num)<<3)
+	return appendUvarint(b, v)
+}
+
+// appendBytes appends a length delimited field.
+func appendBytes(b []byte, num int, v []byte) []byte {
+	b = appendUvarint(b, uint64(num)<<3|2)
+	b = appendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// appendString appends a string field, omitting it when it is empty.
+func appendString(b []byte, num int, s string) []byte {
+	if s == "" {
+		return b
+	}
+	return appendBytes(b, num, []byte(s))
+}
+
+// appendUvarint appends v encoded as a varint.
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+// fields calls f with the number and value of each field of a message,
+// with the value as v for varints and as b for length delimited fields. Unknown fields are skipped.
+func fields(data []byte, f func(num int, v uint64, b []byte)) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errProtobuf
+		}
+		data = data[n:]
+
+		var v uint64
+		var b []byte
+		switch key & 7 {
+		case 0:
+			if v, n = binary.Uvarint(data); n <= 0 {
+				return errProtobuf
+			}
+			data = data[n:]
+		case 1:
+			if len(data) < 8 {
+				return errProtobuf
+			}
+			v, data = binary.LittleEndian.Uint64(data), data[8:]
+		case 2:
+			l, n := binary.Uvarint(data)
+			if n <= 0 || l > uint64(len(data)-n) {
+				return errProtobuf
+			}
+			b, data = data[n:n+int(l)], data[n+int(l):]
+		case 5:
+			if len(data) < 4 {
+				return errProtobuf
+			}
+			v, data = uint64(binary.LittleEndian.Uint32(data)), data[4:]
+		default:
+			return errProtobuf
+		}
+
+		f(int(key>>3), v, b)
+	}
+	return nil
+}
@@ -0,0 +1,306 @@
+// Package fyncgrpc implements the fync gRPC protocol described by fync.proto: both a fync.Server
+// for mods served by it and a host serving a mods directory with it, for integrators who want
+// a typed, versioned protocol rather than a layout of files over HTTP.
+//
+// The protocol lists mods along with their checksums, streams mods in chunks, and notifies watchers
+// when the mods change. It is implemented without a gRPC runtime, speaking the gRPC protocol over
+// HTTP/2 directly, so any gRPC client generated from fync.proto can sync from Serve,
+// and New can sync from any host implementing the service.
+package fyncgrpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/han-tyumi/fync"
+)
+
+// Options contains options for the New function.
+type Options struct {
+	// The HTTP client used for all calls, which must support HTTP/2 for hosts other than Handler.
+	// Defaults to http.DefaultClient, which uses HTTP/2 over TLS.
+	Client *http.Client
+}
+
+// Server is a fync.Server that lists mods from a host serving the fync gRPC protocol.
+type Server struct {
+	target string
+	client *http.Client
+}
+
+// New returns a Server for the host at the given base URL, such as "https://mods.example.com:7460".
+func New(target string, o *Options) (*Server, error) {
+	if !strings.HasPrefix(target, "https://") && !strings.HasPrefix(target, "http://") {
+		return nil, fmt.Errorf("fyncgrpc: %s: target must be an http(s) URL", target)
+	}
+
+	s := &Server{target: strings.TrimSuffix(target, "/"), client: http.DefaultClient}
+	if o != nil && o.Client != nil {
+		s.client = o.Client
+	}
+	return s, nil
+}
+
+// String returns the base URL of the host.
+func (s *Server) String() string {
+	return s.target
+}
+
+// Mods returns a slice of mod ServerFiles for each mod the host lists.
+// Mods are not downloaded until they are written.
+func (s *Server) Mods() ([]fync.ServerFile, error) {
+	c, err := s.call(context.Background(), listModsPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	msg, err := c.recv()
+	if err == io.EOF {
+		return nil, fmt.Errorf("%s: missing response message", s)
+	} else if err != nil {
+		return nil, err
+	}
+
+	// the status of the call follows its only message
+	if _, err := c.recv(); err != io.EOF {
+		if err == nil {
+			err = fmt.Errorf("%s: unexpected response message", s)
+		}
+		return nil, err
+	}
+
+	var res listModsResponse
+	if err := res.unmarshal(msg); err != nil {
+		return nil, err
+	}
+
+	var mods []fync.ServerFile
+	for i := range res.mods {
+		mods = append(mods, &file{server: s, mod: res.mods[i]})
+	}
+	return mods, nil
+}
+
+// Watch returns a channel receiving a ChangeEvent each time the mods the host serves change,
+// which is closed once the context is canceled or the watch fails.
+func (s *Server) Watch(ctx context.Context) <-chan fync.ChangeEvent {
+	changes := make(chan fync.ChangeEvent)
+	go func() {
+		defer close(changes)
+
+		c, err := s.call(ctx, watchModsPath, nil)
+		if err != nil {
+			if ctx.Err() == nil {
+				select {
+				case changes <- fync.ChangeEvent{Time: time.Now(), Err: err}:
+				case <-ctx.Done():
+				}
+			}
+			return
+		}
+		defer c.Close()
+
+		for {
+			msg, err := c.recv()
+			var m modsChanged
+			if err == nil {
+				err = m.unmarshal(msg)
+			}
+
+			var change fync.ChangeEvent
+			if err == nil {
+				change.Time = time.Unix(0, m.time)
+			} else if ctx.Err() != nil {
+				return
+			} else {
+				if err == io.EOF {
+					err = fmt.Errorf("%s: watch ended", s)
+				}
+				change.Time, change.Err = time.Now(), err
+			}
+
+			select {
+			case changes <- change:
+			case <-ctx.Done():
+				return
+			}
+			if change.Err != nil {
+				return
+			}
+		}
+	}()
+	return changes
+}
+
+// call is a call of a method whose response messages are being received.
+type call struct {
+	server *Server
+	res    *http.Response
+}
+
+// call calls a method with a request message, returning once the host has responded with its headers.
+func (s *Server) call(ctx context.Context, method string, msg []byte) (*call, error) {
+	var body bytes.Buffer
+	if err := writeMessage(&body, msg); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.target+method, &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("TE", "trailers")
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("%s%s: unexpected status %q", s, method, res.Status)
+	}
+	if !strings.HasPrefix(res.Header.Get("Content-Type"), contentType) {
+		res.Body.Close()
+		return nil, fmt.Errorf("%s%s: not a gRPC response", s, method)
+	}
+
+	// a call failing before any messages may respond with its status in the headers alone
+	if status := res.Header.Get("Grpc-Status"); status != "" {
+		if err := statusError(status, res.Header.Get("Grpc-Message")); err != nil {
+			res.Body.Close()
+			return nil, err
+		}
+	}
+	return &call{server: s, res: res}, nil
+}
+
+// recv returns the next response message, or io.EOF once the call has succeeded with no more messages.
+func (c *call) recv() ([]byte, error) {
+	msg, err := readMessage(c.res.Body)
+	if err != io.EOF {
+		return msg, err
+	}
+
+	status := c.res.Trailer.Get("Grpc-Status")
+	if status == "" {
+		status = c.res.Header.Get("Grpc-Status")
+	}
+	if err := statusError(status, c.res.Trailer.Get("Grpc-Message")); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+func (c *call) Close() error {
+	return c.res.Body.Close()
+}
+
+// file is a fync.ServerFile that downloads a mod from the host.
+type file struct {
+	server *Server
+	mod
+	call *call
+}
+
+// String returns the name of the mod on the host.
+func (f *file) String() string {
+	return f.server.String() + "/" + f.name
+}
+
+func (f *file) Stat() (os.FileInfo, error) {
+	return fileInfo{f.mod}, nil
+}
+
+func (f *file) SHA256() (string, error) {
+	return f.sha256, nil
+}
+
+// WriteTo downloads the mod, failing if it does not match its checksum once written.
+func (f *file) WriteTo(w io.Writer) (int64, error) {
+	if f.call == nil {
+		req := getModRequest{name: f.name}
+		c, err := f.server.call(context.Background(), getModPath, req.marshal())
+		if err != nil {
+			return 0, err
+		}
+		f.call = c
+	}
+
+	defer f.Close()
+
+	h := sha256.New()
+	mw := io.MultiWriter(w, h)
+
+	var n int64
+	for {
+		msg, err := f.call.recv()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return n, err
+		}
+
+		var c chunk
+		if err := c.unmarshal(msg); err != nil {
+			return n, err
+		}
+		m, err := mw.Write(c.data)
+		n += int64(m)
+		if err != nil {
+			return n, err
+		}
+	}
+
+	if f.sha256 != "" {
+		if sum := hex.EncodeToString(h.Sum(nil)); sum != f.sha256 {
+			return n, &fync.VerificationError{Path: f.String(), Field: "checksum", Expected: f.sha256, Actual: sum}
+		}
+	}
+	return n, nil
+}
+
+// Seek only supports rewinding to the start of the file so that a failed transfer can be retried.
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekStart {
+		return 0, errors.New("fyncgrpc: can only seek to the start of a file")
+	}
+	return 0, f.Close()
+}
+
+func (f *file) Close() error {
+	if f.call == nil {
+		return nil
+	}
+
+	err := f.call.Close()
+	f.call = nil
+	return err
+}
+
+type fileInfo struct {
+	mod
+}
+
+func (i fileInfo) Name() string       { return i.name }
+func (i fileInfo) Size() int64        { return i.size }
+func (i fileInfo) Mode() os.FileMode  { return 0644 }
+func (i fileInfo) ModTime() time.Time { return time.Unix(0, i.modTime) }
+func (i fileInfo) IsDir() bool        { return false }
+func (i fileInfo) Sys() interface{}   { return nil }
+
+var (
+	_ fync.WatchServer = (*Server)(nil)
+	_ fync.HashedFile  = (*file)(nil)
+)
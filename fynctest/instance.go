@@ -0,0 +1,153 @@
+// Package fynctest provides utilities for testing syncs end to end against a synthetic
+// Minecraft instance, along with scripted scenarios that any fync.Server can be run through.
+//
+// fync's directories are global, so tests using an Instance must not run in parallel.
+package fynctest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/han-tyumi/fync"
+)
+
+// Instance is a synthetic Minecraft instance within a temporary directory
+// that fync syncs to for the duration of a test.
+type Instance struct {
+	// The instance's installation directory.
+	Dir string
+
+	// The directory backups, snapshots, and history are recorded to.
+	BackupDir string
+
+	t testing.TB
+}
+
+// NewInstance creates an empty instance and points fync at it until the test ends.
+func NewInstance(t testing.TB) *Instance {
+	t.Helper()
+
+	root, err := ioutil.TempDir("", "fynctest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prevInstall, _ := fync.InstallDir()
+	prevBackup, _ := fync.BackupDir()
+
+	i := &Instance{
+		Dir:       filepath.Join(root, "minecraft"),
+		BackupDir: filepath.Join(root, "backup"),
+		t:         t,
+	}
+	fync.SetInstallDir(i.Dir)
+	fync.SetBackupDir(i.BackupDir)
+
+	t.Cleanup(func() {
+		fync.SetInstallDir(prevInstall)
+		fync.SetBackupDir(prevBackup)
+		os.RemoveAll(root)
+	})
+	return i
+}
+
+// ModsDir returns the instance's mods directory.
+func (i *Instance) ModsDir() string {
+	return filepath.Join(i.Dir, "mods")
+}
+
+// WriteMods writes the given mods, by name, to the mods directory.
+func (i *Instance) WriteMods(mods map[string]string) {
+	i.t.Helper()
+
+	if err := os.MkdirAll(i.ModsDir(), os.ModeDir|0755); err != nil {
+		i.t.Fatal(err)
+	}
+
+	for name, data := range mods {
+		if err := ioutil.WriteFile(filepath.Join(i.ModsDir(), name), []byte(data), 0644); err != nil {
+			i.t.Fatal(err)
+		}
+	}
+}
+
+// RemoveMods removes the named mods from the mods directory.
+func (i *Instance) RemoveMods(names ...string) {
+	i.t.Helper()
+
+	for _, name := range names {
+		if err := os.Remove(filepath.Join(i.ModsDir(), name)); err != nil && !os.IsNotExist(err) {
+			i.t.Fatal(err)
+		}
+	}
+}
+
+// Mods returns the contents of each mod within the mods directory by name.
+// Files fync keeps alongside the mods are not included.
+func (i *Instance) Mods() map[string]string {
+	i.t.Helper()
+
+	mods := make(map[string]string)
+
+	files, err := ioutil.ReadDir(i.ModsDir())
+	if os.IsNotExist(err) {
+		return mods
+	} else if err != nil {
+		i.t.Fatal(err)
+	}
+
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".jar") {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(i.ModsDir(), f.Name()))
+		if err != nil {
+			i.t.Fatal(err)
+		}
+		mods[f.Name()] = string(data)
+	}
+	return mods
+}
+
+// AssertMods reports an error for each way the mods directory differs from want.
+// It returns whether they matched.
+func (i *Instance) AssertMods(want map[string]string) bool {
+	i.t.Helper()
+
+	got := i.Mods()
+	ok := true
+
+	for _, name := range sortedNames(want) {
+		data, exists := got[name]
+		switch {
+		case !exists:
+			i.t.Errorf("%s: missing", name)
+			ok = false
+		case data != want[name]:
+			i.t.Errorf("%s: got %q, want %q", name, data, want[name])
+			ok = false
+		}
+	}
+
+	for _, name := range sortedNames(got) {
+		if _, exists := want[name]; !exists {
+			i.t.Errorf("%s: unexpected", name)
+			ok = false
+		}
+	}
+	return ok
+}
+
+func sortedNames(mods map[string]string) []string {
+	names := make([]string, 0, len(mods))
+	for name := range mods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
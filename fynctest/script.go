@@ -0,0 +1,174 @@
+package fynctest
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/han-tyumi/fync"
+)
+
+// NewServerFunc returns a fync.Server serving exactly the given mods, by name,
+// so that the same scenarios can be run against any backend.
+type NewServerFunc func(t testing.TB, mods map[string]string) fync.Server
+
+// Step is a single step of a scripted scenario.
+type Step struct {
+	// Describes the step when it fails.
+	Name string
+
+	// The mods the server serves from this step on. Nil keeps the previous step's server.
+	Server map[string]string
+
+	// Changes made to the mods directory by hand before the step runs.
+	Write  map[string]string
+	Remove []string
+
+	// What the step does to the instance. Defaults to Sync with no options.
+	Do func(s fync.Server) error
+
+	// Whether Do is expected to fail.
+	WantErr bool
+
+	// The mods expected within the mods directory once the step has run.
+	Want map[string]string
+}
+
+// Scenario is a named sequence of steps run against a single fresh instance.
+type Scenario struct {
+	Name  string
+	Steps []Step
+}
+
+// Sync returns a Step.Do syncing with the given options, which may be nil.
+func Sync(o *fync.SyncOptions) func(s fync.Server) error {
+	if o == nil {
+		o = &fync.SyncOptions{}
+	}
+
+	return func(s fync.Server) error {
+		_, err := fync.Sync(s, o)
+		return err
+	}
+}
+
+// Restore returns a Step.Do restoring the backup set with the given ID, or the most recent when empty.
+func Restore(id string) func(s fync.Server) error {
+	return func(fync.Server) error {
+		_, err := fync.Restore(id, &fync.RestoreOptions{})
+		return err
+	}
+}
+
+// Verify returns a Step.Do failing if the mods directory has drifted from the server.
+func Verify() func(s fync.Server) error {
+	return func(s fync.Server) error {
+		report, err := fync.Verify(s, nil)
+		if err != nil {
+			return err
+		}
+		if !report.InSync() {
+			return fmt.Errorf("drifted: missing %v, modified %v, extra %v", report.Missing, report.Modified, report.Extra)
+		}
+		return nil
+	}
+}
+
+// Run runs each scenario as a subtest against a fresh instance and servers made by newServer.
+func Run(t *testing.T, newServer NewServerFunc, scenarios []Scenario) {
+	for _, sc := range scenarios {
+		sc := sc
+		t.Run(sc.Name, func(t *testing.T) {
+			RunSteps(t, newServer, sc.Steps)
+		})
+	}
+}
+
+// RunSteps runs the steps in order against a fresh instance, stopping at the first that fails.
+func RunSteps(t *testing.T, newServer NewServerFunc, steps []Step) {
+	t.Helper()
+
+	instance := NewInstance(t)
+	var s fync.Server
+
+	for i, step := range steps {
+		name := step.Name
+		if name == "" {
+			name = fmt.Sprintf("step %d", i+1)
+		}
+
+		if step.Server != nil {
+			s = newServer(t, step.Server)
+		}
+		if s == nil {
+			t.Fatalf("%s: no server mods given", name)
+		}
+
+		instance.WriteMods(step.Write)
+		instance.RemoveMods(step.Remove...)
+
+		do := step.Do
+		if do == nil {
+			do = Sync(nil)
+		}
+
+		err := do(s)
+		if step.WantErr && err == nil {
+			t.Fatalf("%s: expected an error", name)
+		} else if !step.WantErr && err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if step.Want != nil && !instance.AssertMods(step.Want) {
+			t.Fatalf("%s: unexpected mods", name)
+		}
+	}
+}
+
+// Scenarios covers installing, updating, removing, and restoring mods
+// as well as leaving mods installed by hand alone when asked to.
+// Mods change size whenever they change, so servers without checksums are covered too.
+var Scenarios = []Scenario{
+	{
+		Name: "install",
+		Steps: []Step{
+			{Server: map[string]string{"a.jar": "a1", "b.jar": "b1"}, Want: map[string]string{"a.jar": "a1", "b.jar": "b1"}},
+			{Name: "verify", Do: Verify()},
+		},
+	},
+	{
+		Name: "update and remove",
+		Steps: []Step{
+			{Server: map[string]string{"a.jar": "a1", "b.jar": "b1"}, Want: map[string]string{"a.jar": "a1", "b.jar": "b1"}},
+			{Name: "update", Server: map[string]string{"a.jar": "a22", "b.jar": "b1"}, Want: map[string]string{"a.jar": "a22", "b.jar": "b1"}},
+			{Name: "remove", Server: map[string]string{"a.jar": "a22"}, Want: map[string]string{"a.jar": "a22"}},
+		},
+	},
+	{
+		Name: "replace modified",
+		Steps: []Step{
+			{Server: map[string]string{"a.jar": "a1"}, Want: map[string]string{"a.jar": "a1"}},
+			{Name: "drift", Write: map[string]string{"a.jar": "a999"}, Do: Verify(), WantErr: true},
+			{Name: "sync", Want: map[string]string{"a.jar": "a1"}},
+		},
+	},
+	{
+		Name: "restore",
+		Steps: []Step{
+			{Write: map[string]string{"a.jar": "a", "c.jar": "c0"}, Server: map[string]string{"a.jar": "a1", "b.jar": "b1"}, Want: map[string]string{"a.jar": "a1", "b.jar": "b1"}},
+			{Name: "restore", Do: Restore(""), Want: map[string]string{"a.jar": "a", "c.jar": "c0"}},
+		},
+	},
+	{
+		Name: "managed only",
+		Steps: []Step{
+			{Server: map[string]string{"a.jar": "a1"}, Want: map[string]string{"a.jar": "a1"}},
+			{
+				Name:   "keep by hand",
+				Write:  map[string]string{"c.jar": "c0"},
+				Server: map[string]string{"b.jar": "b1"},
+				Do:     Sync(&fync.SyncOptions{ManagedOnly: true}),
+				Want:   map[string]string{"b.jar": "b1", "c.jar": "c0"},
+			},
+		},
+	},
+}
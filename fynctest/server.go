@@ -0,0 +1,89 @@
+package fynctest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/han-tyumi/fync"
+	"github.com/han-tyumi/fync/httpserver"
+)
+
+// Server serves mods over HTTP with a manifest read by the httpserver package.
+type Server struct {
+	*httptest.Server
+
+	mu   sync.Mutex
+	mods map[string]string
+}
+
+// NewServer starts a Server for the given mods that is closed when the test ends.
+func NewServer(t testing.TB, mods map[string]string) *Server {
+	s := &Server{}
+	s.SetMods(mods)
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// SetMods changes the mods being served.
+func (s *Server) SetMods(mods map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.mods = make(map[string]string, len(mods))
+	for name, data := range mods {
+		s.mods[name] = data
+	}
+}
+
+// Client returns a fync.Server for the mods being served.
+func (s *Server) Client() fync.Server {
+	client, err := httpserver.New(s.URL, &httpserver.Options{Client: s.Server.Client()})
+	if err != nil {
+		panic(err)
+	}
+	return client
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name, err := url.PathUnescape(strings.TrimPrefix(r.URL.Path, "/"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if name == httpserver.DefaultManifest {
+		manifest := fync.Manifest{SchemaVersion: fync.ManifestSchemaVersion, Mods: make([]fync.ManifestMod, 0, len(s.mods))}
+		for _, name := range sortedNames(s.mods) {
+			size := int64(len(s.mods[name]))
+			sum := sha256.Sum256([]byte(s.mods[name]))
+			manifest.Mods = append(manifest.Mods, fync.ManifestMod{Name: name, Size: &size, SHA256: hex.EncodeToString(sum[:])})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		manifest.WriteTo(w)
+		return
+	}
+
+	data, ok := s.mods[name]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeContent(w, r, name, time.Time{}, strings.NewReader(data))
+}
+
+// HTTP is a NewServerFunc serving mods with a Server.
+func HTTP(t testing.TB, mods map[string]string) fync.Server {
+	return NewServer(t, mods).Client()
+}
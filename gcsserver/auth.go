@@ -0,0 +1,333 @@
+package gcsserver
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// readOnlyScope is the OAuth scope requested for service accounts.
+const readOnlyScope = "https://www.googleapis.com/auth/devstorage.read_only"
+
+// defaultTokenURI is where access tokens are obtained when credentials do not say otherwise.
+const defaultTokenURI = "https://oauth2.googleapis.com/token"
+
+// metadataProbeTimeout is how long the metadata server is given to answer before assuming
+// the process is not running on Google Cloud.
+const metadataProbeTimeout = 2 * time.Second
+
+// credentials obtain the access tokens requests are authorized with.
+type credentials interface {
+	// accessToken returns a token that is valid for at least a little while,
+	// or an empty token when requests must be sent anonymously.
+	accessToken() (string, error)
+}
+
+// defaultCredentials returns the application default credentials: the file named by
+// $GOOGLE_APPLICATION_CREDENTIALS, then the file written by "gcloud auth application-default login",
+// then the service account of the Compute Engine instance, if any, from its metadata server.
+func defaultCredentials(client *http.Client) (credentials, error) {
+	if name := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); name != "" {
+		return readCredentials(name, client)
+	}
+
+	if name := wellKnownCredentials(); name != "" {
+		if _, err := os.Stat(name); err == nil {
+			return readCredentials(name, client)
+		}
+	}
+
+	host := os.Getenv("GCE_METADATA_HOST")
+	if host == "" {
+		host = "169.254.169.254"
+	}
+	return &metadataServer{host: host, client: client}, nil
+}
+
+// wellKnownCredentials returns the path of the credentials file written by gcloud, whether or not it exists.
+func wellKnownCredentials() string {
+	if dir := os.Getenv("CLOUDSDK_CONFIG"); dir != "" {
+		return filepath.Join(dir, "application_default_credentials.json")
+	}
+	if runtime.GOOS == "windows" {
+		if dir := os.Getenv("APPDATA"); dir != "" {
+			return filepath.Join(dir, "gcloud", "application_default_credentials.json")
+		}
+		return ""
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "gcloud", "application_default_credentials.json")
+}
+
+// readCredentials reads a credentials file, which is either a service account key
+// or the credentials of a user as written by gcloud.
+func readCredentials(name string, client *http.Client) (credentials, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var f struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("gcsserver: invalid credentials: %w", err)
+	}
+
+	switch f.Type {
+	case "service_account":
+		return parseServiceAccount(data, client)
+	case "authorized_user":
+		return parseAuthorizedUser(data, client)
+	default:
+		return nil, fmt.Errorf("gcsserver: unsupported credentials type %q", f.Type)
+	}
+}
+
+// tokenCache holds an access token until it is about to expire.
+type tokenCache struct {
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// tokenResponse is the response of an OAuth token endpoint or the metadata server.
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	ExpiresIn        int    `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// get returns the cached token, calling fetch for a new one when it is about to expire.
+func (c *tokenCache) get(fetch func() (*http.Response, error)) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token != "" && time.Now().Before(c.expires) {
+		return c.token, nil
+	}
+
+	res, err := fetch()
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	var result tokenResponse
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("gcsserver: token exchange: %w", err)
+	}
+
+	if res.StatusCode != http.StatusOK || result.AccessToken == "" {
+		return "", fmt.Errorf("gcsserver: token exchange: %s: %s", result.Error, result.ErrorDescription)
+	}
+
+	// renew the token a little early so requests in flight do not use an expired one
+	c.token = result.AccessToken
+	c.expires = time.Now().Add(time.Duration(result.ExpiresIn)*time.Second - time.Minute)
+	return c.token, nil
+}
+
+// serviceAccount obtains access tokens for a service account using a signed JWT assertion.
+type serviceAccount struct {
+	email    string
+	key      *rsa.PrivateKey
+	tokenURI string
+	client   *http.Client
+	cache    tokenCache
+}
+
+// parseServiceAccount parses a service account key file as downloaded from the Google Cloud console.
+func parseServiceAccount(data []byte, client *http.Client) (*serviceAccount, error) {
+	var f struct {
+		Type        string `json:"type"`
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+		TokenURI    string `json:"token_uri"`
+	}
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("gcsserver: invalid credentials: %w", err)
+	}
+
+	if f.Type != "service_account" {
+		return nil, fmt.Errorf("gcsserver: unsupported credentials type %q", f.Type)
+	}
+
+	block, _ := pem.Decode([]byte(f.PrivateKey))
+	if block == nil {
+		return nil, errors.New("gcsserver: invalid credentials: missing private key")
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		if parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			return nil, fmt.Errorf("gcsserver: invalid credentials: %w", err)
+		}
+	}
+
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("gcsserver: invalid credentials: private key is not an RSA key")
+	}
+
+	if f.TokenURI == "" {
+		f.TokenURI = defaultTokenURI
+	}
+	return &serviceAccount{email: f.ClientEmail, key: key, tokenURI: f.TokenURI, client: client}, nil
+}
+
+// accessToken returns a cached access token, exchanging a new assertion for one when it is about to expire.
+func (a *serviceAccount) accessToken() (string, error) {
+	return a.cache.get(func() (*http.Response, error) {
+		assertion, err := a.assertion(time.Now())
+		if err != nil {
+			return nil, err
+		}
+
+		return a.client.PostForm(a.tokenURI, url.Values{
+			"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+			"assertion":  {assertion},
+		})
+	})
+}
+
+// assertion returns a JWT signed by the service account's key.
+func (a *serviceAccount) assertion(now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":   a.email,
+		"scope": readOnlyScope,
+		"aud":   a.tokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, a.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return strings.Join([]string{unsigned, enc.EncodeToString(signature)}, "."), nil
+}
+
+// authorizedUser obtains access tokens for a user who signed in with gcloud, using their refresh token.
+type authorizedUser struct {
+	clientID, clientSecret, refreshToken string
+	tokenURI                             string
+	client                               *http.Client
+	cache                                tokenCache
+}
+
+// parseAuthorizedUser parses the credentials of a user as written by "gcloud auth application-default login".
+func parseAuthorizedUser(data []byte, client *http.Client) (*authorizedUser, error) {
+	var f struct {
+		ClientID     string `json:"client_id"`
+		ClientSecret string `json:"client_secret"`
+		RefreshToken string `json:"refresh_token"`
+		TokenURI     string `json:"token_uri"`
+	}
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("gcsserver: invalid credentials: %w", err)
+	}
+
+	if f.RefreshToken == "" {
+		return nil, errors.New("gcsserver: invalid credentials: missing refresh token")
+	}
+	if f.TokenURI == "" {
+		f.TokenURI = defaultTokenURI
+	}
+	return &authorizedUser{
+		clientID:     f.ClientID,
+		clientSecret: f.ClientSecret,
+		refreshToken: f.RefreshToken,
+		tokenURI:     f.TokenURI,
+		client:       client,
+	}, nil
+}
+
+// accessToken returns a cached access token, refreshing it when it is about to expire.
+func (u *authorizedUser) accessToken() (string, error) {
+	return u.cache.get(func() (*http.Response, error) {
+		return u.client.PostForm(u.tokenURI, url.Values{
+			"grant_type":    {"refresh_token"},
+			"client_id":     {u.clientID},
+			"client_secret": {u.clientSecret},
+			"refresh_token": {u.refreshToken},
+		})
+	})
+}
+
+// metadataServer obtains access tokens for the service account of the Compute Engine instance,
+// or other Google Cloud environment, the process is running on. Requests are sent anonymously
+// when the metadata server cannot be reached, as when running elsewhere.
+type metadataServer struct {
+	host   string
+	client *http.Client
+	cache  tokenCache
+
+	once        sync.Once
+	unavailable bool
+}
+
+// accessToken returns a cached access token from the metadata server, requesting another when it is about to expire.
+func (m *metadataServer) accessToken() (string, error) {
+	m.once.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), metadataProbeTimeout)
+		defer cancel()
+
+		res, err := m.get(ctx, "/computeMetadata/v1/")
+		if err != nil {
+			m.unavailable = true
+			return
+		}
+		res.Body.Close()
+		m.unavailable = res.Header.Get("Metadata-Flavor") != "Google"
+	})
+	if m.unavailable {
+		return "", nil
+	}
+
+	return m.cache.get(func() (*http.Response, error) {
+		return m.get(context.Background(), "/computeMetadata/v1/instance/service-accounts/default/token")
+	})
+}
+
+func (m *metadataServer) get(ctx context.Context, p string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, "http://"+m.host+p, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	return m.client.Do(req.WithContext(ctx))
+}
@@ -0,0 +1,307 @@
+// Package gcsserver implements a fync.Server for mods stored in a Google Cloud Storage bucket.
+//
+// Objects are listed directly beneath a configurable prefix. Each object's SHA-256 checksum is read from
+// a "sha256" metadata entry containing the hex encoded checksum, and objects without one are compared by size.
+// Downloads are checked against the CRC32C checksum Cloud Storage records for every object,
+// and against its MD5 hash when it has one, which composite objects do not.
+package gcsserver
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/han-tyumi/fync"
+)
+
+// Options contains options for the New function.
+type Options struct {
+	// Only objects directly beneath this prefix, such as "pack/mods/", are listed.
+	Prefix string
+
+	// Path of a service account key file, or of a user's credentials as written by gcloud,
+	// used to authorize requests. Defaults to the application default credentials:
+	// $GOOGLE_APPLICATION_CREDENTIALS, then gcloud's application_default_credentials.json,
+	// then the service account of the Compute Engine instance. Requests are sent anonymously
+	// to public buckets when none of them, nor an AccessToken, are available.
+	CredentialsFile string
+
+	// An OAuth access token used to authorize requests instead of a service account.
+	AccessToken string
+
+	// Base URL of the Cloud Storage JSON API, which may be changed to use an emulator.
+	// Defaults to https://storage.googleapis.com.
+	Endpoint string
+
+	// The HTTP client used for all requests. Defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// Server is a fync.Server that lists mods from a Cloud Storage bucket.
+type Server struct {
+	bucket   string
+	prefix   string
+	endpoint string
+	client   *http.Client
+	token    string
+	creds    credentials
+}
+
+// New returns a Server for the named bucket.
+func New(bucket string, o *Options) (*Server, error) {
+	if bucket == "" {
+		return nil, errors.New("gcsserver: missing bucket name")
+	}
+
+	if o == nil {
+		o = &Options{}
+	}
+
+	s := &Server{
+		bucket:   bucket,
+		prefix:   o.Prefix,
+		endpoint: strings.TrimSuffix(o.Endpoint, "/"),
+		client:   o.Client,
+		token:    o.AccessToken,
+	}
+
+	if s.endpoint == "" {
+		s.endpoint = "https://storage.googleapis.com"
+	}
+
+	if s.client == nil {
+		s.client = http.DefaultClient
+	}
+
+	var err error
+	switch {
+	case o.CredentialsFile != "":
+		s.creds, err = readCredentials(o.CredentialsFile, s.client)
+	case s.token == "":
+		s.creds, err = defaultCredentials(s.client)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// String returns the gs:// URL of the bucket and prefix.
+func (s *Server) String() string {
+	return "gs://" + s.bucket + "/" + s.prefix
+}
+
+// Mods returns a slice of mod ServerFiles for each jar directly beneath the prefix.
+// Mods are not downloaded until they are written.
+func (s *Server) Mods() ([]fync.ServerFile, error) {
+	var mods []fync.ServerFile
+
+	token := ""
+	for {
+		query := url.Values{
+			"prefix":    {s.prefix},
+			"delimiter": {"/"},
+			"fields":    {"items(name,size,updated,generation,metadata,crc32c,md5Hash),nextPageToken"},
+		}
+		if token != "" {
+			query.Set("pageToken", token)
+		}
+
+		res, err := s.request(s.endpoint+"/storage/v1/b/"+url.PathEscape(s.bucket)+"/o?"+query.Encode(), s.String())
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			Items []struct {
+				Name       string            `json:"name"`
+				Size       string            `json:"size"`
+				Updated    time.Time         `json:"updated"`
+				Generation string            `json:"generation"`
+				Metadata   map[string]string `json:"metadata"`
+				CRC32C     string            `json:"crc32c"`
+				MD5Hash    string            `json:"md5Hash"`
+			} `json:"items"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		err = json.NewDecoder(res.Body).Decode(&result)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range result.Items {
+			if !strings.HasSuffix(item.Name, ".jar") {
+				continue
+			}
+
+			size, err := strconv.ParseInt(item.Size, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("gs://%s/%s: invalid size %q", s.bucket, item.Name, item.Size)
+			}
+
+			mods = append(mods, &file{
+				server:     s,
+				name:       item.Name,
+				size:       size,
+				modTime:    item.Updated,
+				generation: item.Generation,
+				sum:        strings.ToLower(item.Metadata["sha256"]),
+				crc32c:     item.CRC32C,
+				md5Hash:    item.MD5Hash,
+			})
+		}
+
+		if result.NextPageToken == "" {
+			return mods, nil
+		}
+		token = result.NextPageToken
+	}
+}
+
+// request sends an authorized GET request, using name to describe the resource in errors.
+func (s *Server) request(u, name string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	token := s.token
+	if s.creds != nil {
+		if token, err = s.creds.accessToken(); err != nil {
+			return nil, err
+		}
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		defer res.Body.Close()
+
+		var e struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if json.NewDecoder(io.LimitReader(res.Body, 1<<16)).Decode(&e) == nil && e.Error.Message != "" {
+			return nil, fmt.Errorf("%s: %s", name, e.Error.Message)
+		}
+		return nil, fmt.Errorf("%s: unexpected status %q", name, res.Status)
+	}
+	return res, nil
+}
+
+// file is a fync.ServerFile that downloads a mod from the bucket.
+type file struct {
+	server     *Server
+	name       string
+	size       int64
+	modTime    time.Time
+	generation string
+	sum        string
+	res        *http.Response
+
+	// base64 encoded checksums recorded by Cloud Storage, the MD5 hash being empty for composite objects
+	crc32c, md5Hash string
+}
+
+// String returns the gs:// URL of the mod.
+func (f *file) String() string {
+	return "gs://" + f.server.bucket + "/" + f.name
+}
+
+func (f *file) Stat() (os.FileInfo, error) {
+	return fileInfo{f}, nil
+}
+
+// SHA256 returns the checksum from the object's sha256 metadata entry, if any.
+func (f *file) SHA256() (string, error) {
+	return f.sum, nil
+}
+
+// WriteTo downloads the mod, failing if it does not match the checksums Cloud Storage records once written.
+func (f *file) WriteTo(w io.Writer) (int64, error) {
+	if f.res == nil {
+		// download the listed generation so a newer version cannot be mixed up with its size
+		query := url.Values{"alt": {"media"}}
+		if f.generation != "" {
+			query.Set("generation", f.generation)
+		}
+
+		u := f.server.endpoint + "/storage/v1/b/" + url.PathEscape(f.server.bucket) + "/o/" + url.PathEscape(f.name) + "?" + query.Encode()
+		res, err := f.server.request(u, f.String())
+		if err != nil {
+			return 0, err
+		}
+		f.res = res
+	}
+
+	defer f.Close()
+
+	crc := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	md := md5.New()
+	n, err := io.Copy(io.MultiWriter(w, crc, md), f.res.Body)
+	if err != nil {
+		return n, err
+	}
+
+	if f.crc32c != "" {
+		if sum := base64.StdEncoding.EncodeToString(crc.Sum(nil)); sum != f.crc32c {
+			return n, &fync.VerificationError{Path: f.String(), Field: "checksum", Expected: f.crc32c, Actual: sum}
+		}
+	}
+	if f.md5Hash != "" {
+		if sum := base64.StdEncoding.EncodeToString(md.Sum(nil)); sum != f.md5Hash {
+			return n, &fync.VerificationError{Path: f.String(), Field: "checksum", Expected: f.md5Hash, Actual: sum}
+		}
+	}
+	return n, nil
+}
+
+// Seek only supports rewinding to the start of the file so that a failed transfer can be retried.
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekStart {
+		return 0, errors.New("gcsserver: can only seek to the start of a file")
+	}
+	return 0, f.Close()
+}
+
+func (f *file) Close() error {
+	if f.res == nil {
+		return nil
+	}
+
+	err := f.res.Body.Close()
+	f.res = nil
+	return err
+}
+
+type fileInfo struct {
+	f *file
+}
+
+func (i fileInfo) Name() string       { return path.Base(i.f.name) }
+func (i fileInfo) Size() int64        { return i.f.size }
+func (i fileInfo) Mode() os.FileMode  { return 0644 }
+func (i fileInfo) ModTime() time.Time { return i.f.modTime }
+func (i fileInfo) IsDir() bool        { return false }
+func (i fileInfo) Sys() interface{}   { return nil }
+
+var _ fync.HashedFile = (*file)(nil)
@@ -0,0 +1,218 @@
+package gdriveserver
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// readOnlyScope is the OAuth scope requested for service accounts.
+const readOnlyScope = "https://www.googleapis.com/auth/drive.readonly"
+
+// defaultTokenURI is the endpoint access tokens are obtained from when the credentials do not name one.
+const defaultTokenURI = "https://oauth2.googleapis.com/token"
+
+// tokenSource obtains access tokens used to authorize requests.
+type tokenSource interface {
+	accessToken() (string, error)
+}
+
+// parseCredentials parses a credentials file, either a service account key as downloaded from the
+// Google Cloud console or the credentials of a user saved by "gcloud auth application-default login".
+func parseCredentials(data []byte, client *http.Client) (tokenSource, error) {
+	var f struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("gdriveserver: invalid credentials: %w", err)
+	}
+
+	switch f.Type {
+	case "service_account":
+		return parseServiceAccount(data, client)
+	case "authorized_user":
+		return parseAuthorizedUser(data, client)
+	default:
+		return nil, fmt.Errorf("gdriveserver: unsupported credentials type %q", f.Type)
+	}
+}
+
+// serviceAccount obtains access tokens for a service account using a signed JWT assertion.
+type serviceAccount struct {
+	email    string
+	key      *rsa.PrivateKey
+	tokenURI string
+	client   *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// parseServiceAccount parses a service account key file as downloaded from the Google Cloud console.
+func parseServiceAccount(data []byte, client *http.Client) (*serviceAccount, error) {
+	var f struct {
+		Type        string `json:"type"`
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+		TokenURI    string `json:"token_uri"`
+	}
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("gdriveserver: invalid credentials: %w", err)
+	}
+
+	block, _ := pem.Decode([]byte(f.PrivateKey))
+	if block == nil {
+		return nil, errors.New("gdriveserver: invalid credentials: missing private key")
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		if parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			return nil, fmt.Errorf("gdriveserver: invalid credentials: %w", err)
+		}
+	}
+
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("gdriveserver: invalid credentials: private key is not an RSA key")
+	}
+
+	if f.TokenURI == "" {
+		f.TokenURI = defaultTokenURI
+	}
+	return &serviceAccount{email: f.ClientEmail, key: key, tokenURI: f.TokenURI, client: client}, nil
+}
+
+// accessToken returns a cached access token, exchanging a new assertion for one when it is about to expire.
+func (a *serviceAccount) accessToken() (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.token != "" && time.Now().Before(a.expires) {
+		return a.token, nil
+	}
+
+	assertion, err := a.assertion(time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	a.token, a.expires, err = exchange(a.client, a.tokenURI, url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	})
+	return a.token, err
+}
+
+// assertion returns a JWT signed by the service account's key.
+func (a *serviceAccount) assertion(now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":   a.email,
+		"scope": readOnlyScope,
+		"aud":   a.tokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, a.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return strings.Join([]string{unsigned, enc.EncodeToString(signature)}, "."), nil
+}
+
+// authorizedUser obtains access tokens for a user by refreshing their refresh token.
+type authorizedUser struct {
+	clientID, clientSecret, refreshToken string
+	client                               *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func parseAuthorizedUser(data []byte, client *http.Client) (*authorizedUser, error) {
+	var f struct {
+		ClientID     string `json:"client_id"`
+		ClientSecret string `json:"client_secret"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("gdriveserver: invalid credentials: %w", err)
+	}
+
+	if f.RefreshToken == "" {
+		return nil, errors.New("gdriveserver: invalid credentials: missing refresh token")
+	}
+	return &authorizedUser{clientID: f.ClientID, clientSecret: f.ClientSecret, refreshToken: f.RefreshToken, client: client}, nil
+}
+
+// accessToken returns a cached access token, refreshing it when it is about to expire.
+func (u *authorizedUser) accessToken() (string, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.token != "" && time.Now().Before(u.expires) {
+		return u.token, nil
+	}
+
+	var err error
+	u.token, u.expires, err = exchange(u.client, defaultTokenURI, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {u.refreshToken},
+		"client_id":     {u.clientID},
+		"client_secret": {u.clientSecret},
+	})
+	return u.token, err
+}
+
+// exchange obtains an access token from the token endpoint, returning when it should be renewed.
+func exchange(client *http.Client, tokenURI string, form url.Values) (string, time.Time, error) {
+	res, err := client.PostForm(tokenURI, form)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer res.Body.Close()
+
+	var result struct {
+		AccessToken      string `json:"access_token"`
+		ExpiresIn        int    `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return "", time.Time{}, fmt.Errorf("gdriveserver: token exchange: %w", err)
+	}
+
+	if res.StatusCode != http.StatusOK || result.AccessToken == "" {
+		return "", time.Time{}, fmt.Errorf("gdriveserver: token exchange: %s: %s", result.Error, result.ErrorDescription)
+	}
+
+	// renew the token a little early so requests in flight do not use an expired one
+	return result.AccessToken, time.Now().Add(time.Duration(result.ExpiresIn)*time.Second - time.Minute), nil
+}
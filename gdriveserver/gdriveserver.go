@@ -0,0 +1,432 @@
+// Package gdriveserver implements a fync.Server for mods within a Google Drive folder.
+//
+// Folders are read through the Drive API, authorized by a service account or user credentials,
+// an OAuth access token, or for folders shared publicly, an API key. Shortcuts to mods are followed,
+// and Google Docs and other files that can only be exported rather than downloaded are skipped.
+// Mods are compared by the SHA-256 checksum Drive records for them and verified against
+// their MD5 checksum when Drive has not recorded one.
+//
+// Public folders read with an API key are downloaded the way a browser does rather than through
+// the API, which limits how often a file may be downloaded. Drive asks for confirmation before
+// downloading files too large to scan for viruses, which is given automatically.
+package gdriveserver
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/han-tyumi/fync"
+)
+
+// Default base URLs of the Drive API and of public downloads used when none are chosen.
+const (
+	DefaultAPI      = "https://www.googleapis.com"
+	DefaultDownload = "https://drive.usercontent.google.com/download"
+)
+
+// Mime types of Drive folders and shortcuts. Every other Google Apps type can only be exported.
+const (
+	shortcutType = "application/vnd.google-apps.shortcut"
+	appsPrefix   = "application/vnd.google-apps."
+)
+
+// fileFields are the fields of each file requested from the API.
+const fileFields = "id,name,mimeType,size,modifiedTime,md5Checksum,sha256Checksum,shortcutDetails(targetId)"
+
+// folderURL matches the URLs of Drive folders, such as https://drive.google.com/drive/folders/ID.
+var folderURL = regexp.MustCompile(`/folders/([\w-]+)`)
+
+// Patterns of the form of the page asking to confirm the download of a large file.
+var (
+	confirmForm  = regexp.MustCompile(`(?s)<form[^>]*id="download-form"[^>]*action="([^"]*)"[^>]*>(.*?)</form>`)
+	confirmInput = regexp.MustCompile(`<input[^>]*type="hidden"[^>]*name="([^"]*)"[^>]*value="([^"]*)"`)
+)
+
+// Options contains options for the New function.
+type Options struct {
+	// Path of a service account key file or user credentials file used to authorize requests.
+	// Defaults to $GOOGLE_APPLICATION_CREDENTIALS when neither an AccessToken nor an APIKey is given.
+	CredentialsFile string
+
+	// An OAuth access token used to authorize requests instead of credentials.
+	AccessToken string
+
+	// An API key used to read a publicly shared folder without authorization.
+	APIKey string
+
+	// Base URLs of the Drive API and of public downloads. Default to DefaultAPI and DefaultDownload.
+	API, Download string
+
+	// The HTTP client used for all requests. Defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// Server is a fync.Server that lists mods from a Google Drive folder.
+type Server struct {
+	folder   string
+	api      string
+	download string
+	key      string
+	token    string
+	source   tokenSource
+	client   *http.Client
+}
+
+// New returns a Server for the folder with the given ID, or the folder at the given URL.
+func New(folder string, o *Options) (*Server, error) {
+	if o == nil {
+		o = &Options{}
+	}
+
+	if m := folderURL.FindStringSubmatch(folder); m != nil {
+		folder = m[1]
+	}
+	if folder == "" {
+		return nil, errors.New("gdriveserver: missing folder ID")
+	}
+
+	s := &Server{
+		folder:   folder,
+		api:      strings.TrimSuffix(o.API, "/"),
+		download: o.Download,
+		key:      o.APIKey,
+		token:    o.AccessToken,
+		client:   o.Client,
+	}
+	if s.api == "" {
+		s.api = DefaultAPI
+	}
+	if s.download == "" {
+		s.download = DefaultDownload
+	}
+	if s.client == nil {
+		s.client = http.DefaultClient
+	}
+
+	credentials := o.CredentialsFile
+	if credentials == "" && s.token == "" && s.key == "" {
+		credentials = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	}
+
+	if credentials != "" {
+		data, err := ioutil.ReadFile(credentials)
+		if err != nil {
+			return nil, err
+		}
+		if s.source, err = parseCredentials(data, s.client); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.source == nil && s.token == "" && s.key == "" {
+		return nil, errors.New("gdriveserver: credentials, an access token, or an API key is required")
+	}
+	return s, nil
+}
+
+// String returns the URL of the folder.
+func (s *Server) String() string {
+	return "https://drive.google.com/drive/folders/" + s.folder
+}
+
+// driveFile is the part of a file listed by the Drive API that is used.
+type driveFile struct {
+	ID              string    `json:"id"`
+	Name            string    `json:"name"`
+	MimeType        string    `json:"mimeType"`
+	Size            string    `json:"size"`
+	ModifiedTime    time.Time `json:"modifiedTime"`
+	MD5Checksum     string    `json:"md5Checksum"`
+	SHA256Checksum  string    `json:"sha256Checksum"`
+	ShortcutDetails struct {
+		TargetID string `json:"targetId"`
+	} `json:"shortcutDetails"`
+}
+
+// Mods returns a slice of mod ServerFiles for each jar within the folder, following shortcuts.
+// Mods are not downloaded until they are written.
+func (s *Server) Mods() ([]fync.ServerFile, error) {
+	var mods []fync.ServerFile
+
+	page := ""
+	for {
+		query := url.Values{
+			"q":                         {"'" + s.folder + "' in parents and trashed = false"},
+			"fields":                    {"nextPageToken,files(" + fileFields + ")"},
+			"pageSize":                  {"1000"},
+			"supportsAllDrives":         {"true"},
+			"includeItemsFromAllDrives": {"true"},
+		}
+		if page != "" {
+			query.Set("pageToken", page)
+		}
+
+		var result struct {
+			Files         []driveFile `json:"files"`
+			NextPageToken string      `json:"nextPageToken"`
+		}
+		if err := s.get("/drive/v3/files", query, &result); err != nil {
+			return nil, err
+		}
+
+		for _, f := range result.Files {
+			if !strings.HasSuffix(f.Name, ".jar") {
+				continue
+			}
+
+			// a shortcut is named like the mod, but the mod's contents are those of its target
+			if f.MimeType == shortcutType {
+				var target driveFile
+				query := url.Values{"fields": {fileFields}, "supportsAllDrives": {"true"}}
+				if err := s.get("/drive/v3/files/"+url.PathEscape(f.ShortcutDetails.TargetID), query, &target); err != nil {
+					return nil, err
+				}
+				target.Name = f.Name
+				f = target
+			}
+
+			if strings.HasPrefix(f.MimeType, appsPrefix) {
+				continue
+			}
+
+			size, err := strconv.ParseInt(f.Size, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s: invalid size %q", s, f.Name, f.Size)
+			}
+			mods = append(mods, &file{server: s, driveFile: f, size: size})
+		}
+
+		if result.NextPageToken == "" {
+			return mods, nil
+		}
+		page = result.NextPageToken
+	}
+}
+
+// get requests an endpoint of the API, decoding its JSON response into v.
+func (s *Server) get(endpoint string, query url.Values, v interface{}) error {
+	res, err := s.request(endpoint, query)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return fmt.Errorf("%s: %w", s, err)
+	}
+	return nil
+}
+
+// apiError is an error returned by the Drive API.
+type apiError struct {
+	status  int
+	reason  string
+	message string
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+// request sends an authorized GET request to an endpoint of the API.
+func (s *Server) request(endpoint string, query url.Values) (*http.Response, error) {
+	token := s.token
+	if s.source != nil {
+		var err error
+		if token, err = s.source.accessToken(); err != nil {
+			return nil, err
+		}
+	}
+	if token == "" {
+		query.Set("key", s.key)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, s.api+endpoint+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		defer res.Body.Close()
+
+		var e struct {
+			Error struct {
+				Message string `json:"message"`
+				Errors  []struct {
+					Reason string `json:"reason"`
+				} `json:"errors"`
+			} `json:"error"`
+		}
+		err := &apiError{status: res.StatusCode, message: fmt.Sprintf("%s: unexpected status %q", s, res.Status)}
+		if json.NewDecoder(io.LimitReader(res.Body, 1<<16)).Decode(&e) == nil && e.Error.Message != "" {
+			err.message = fmt.Sprintf("%s: %s", s, e.Error.Message)
+			if len(e.Error.Errors) > 0 {
+				err.reason = e.Error.Errors[0].Reason
+			}
+		}
+		return nil, err
+	}
+	return res, nil
+}
+
+// publicDownload downloads a publicly shared file the way a browser does,
+// confirming the download when Drive asks to first.
+func (s *Server) publicDownload(id string) (*http.Response, error) {
+	u := s.download + "?" + url.Values{"id": {id}, "export": {"download"}}.Encode()
+	for confirmed := false; ; confirmed = true {
+		res, err := s.client.Get(u)
+		if err != nil {
+			return nil, err
+		}
+
+		if res.StatusCode != http.StatusOK {
+			res.Body.Close()
+			return nil, fmt.Errorf("%s: unexpected status %q", u, res.Status)
+		}
+		if !strings.HasPrefix(res.Header.Get("Content-Type"), "text/html") {
+			return res, nil
+		}
+
+		// large files are preceded by a page with a form to download them anyway
+		page, err := ioutil.ReadAll(io.LimitReader(res.Body, 1<<20))
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		form := confirmForm.FindSubmatch(page)
+		if form == nil || confirmed {
+			return nil, fmt.Errorf("%s: Drive did not allow the download, such as when it has been downloaded too often", u)
+		}
+
+		action, err := url.Parse(html.UnescapeString(string(form[1])))
+		if err != nil {
+			return nil, err
+		}
+		query := url.Values{}
+		for _, input := range confirmInput.FindAllSubmatch(form[2], -1) {
+			query.Set(html.UnescapeString(string(input[1])), html.UnescapeString(string(input[2])))
+		}
+		action.RawQuery = query.Encode()
+		u = res.Request.URL.ResolveReference(action).String()
+	}
+}
+
+// file is a fync.ServerFile that downloads a mod from the folder.
+type file struct {
+	server *Server
+	driveFile
+	size int64
+	res  *http.Response
+}
+
+// String returns the URL of the mod.
+func (f *file) String() string {
+	return "https://drive.google.com/file/d/" + f.ID
+}
+
+func (f *file) Stat() (os.FileInfo, error) {
+	return fileInfo{f.Name, f.size, f.ModifiedTime}, nil
+}
+
+// SHA256 returns the checksum Drive records for the mod, if any.
+func (f *file) SHA256() (string, error) {
+	return strings.ToLower(f.SHA256Checksum), nil
+}
+
+// open starts downloading the mod, acknowledging the risk of files Drive has flagged as abusive
+// when the account is allowed to.
+func (f *file) open() (*http.Response, error) {
+	if f.server.source == nil && f.server.token == "" {
+		return f.server.publicDownload(f.ID)
+	}
+
+	query := url.Values{"alt": {"media"}, "supportsAllDrives": {"true"}}
+	res, err := f.server.request("/drive/v3/files/"+url.PathEscape(f.ID), query)
+	if e, ok := err.(*apiError); ok && e.reason == "cannotDownloadAbusiveFile" {
+		query.Set("acknowledgeAbuse", "true")
+		res, err = f.server.request("/drive/v3/files/"+url.PathEscape(f.ID), query)
+	}
+	return res, err
+}
+
+// WriteTo downloads the mod, failing if it does not match its MD5 checksum once written
+// when Drive has not recorded its SHA-256 checksum.
+func (f *file) WriteTo(w io.Writer) (int64, error) {
+	if f.res == nil {
+		res, err := f.open()
+		if err != nil {
+			return 0, err
+		}
+		f.res = res
+	}
+
+	defer f.Close()
+
+	h := md5.New()
+	n, err := io.Copy(io.MultiWriter(w, h), f.res.Body)
+	if err != nil {
+		return n, err
+	}
+
+	if f.SHA256Checksum == "" && f.MD5Checksum != "" {
+		if sum := hex.EncodeToString(h.Sum(nil)); sum != strings.ToLower(f.MD5Checksum) {
+			return n, &fync.VerificationError{Path: f.String(), Field: "checksum", Expected: f.MD5Checksum, Actual: sum}
+		}
+	}
+	return n, nil
+}
+
+// Seek only supports rewinding to the start of the file so that a failed transfer can be retried.
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekStart {
+		return 0, errors.New("gdriveserver: can only seek to the start of a file")
+	}
+	return 0, f.Close()
+}
+
+func (f *file) Close() error {
+	if f.res == nil {
+		return nil
+	}
+
+	err := f.res.Body.Close()
+	f.res = nil
+	return err
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (i fileInfo) Name() string       { return i.name }
+func (i fileInfo) Size() int64        { return i.size }
+func (i fileInfo) Mode() os.FileMode  { return 0644 }
+func (i fileInfo) ModTime() time.Time { return i.modTime }
+func (i fileInfo) IsDir() bool        { return false }
+func (i fileInfo) Sys() interface{}   { return nil }
+
+var _ fync.HashedFile = (*file)(nil)
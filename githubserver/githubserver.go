@@ -0,0 +1,297 @@
+// Package githubserver implements a fync.Server for the jar assets of a GitHub release.
+// Assets are downloaded through the GitHub API when a token is given so that the releases
+// of private repositories can be synced, and are verified against the SHA-256 digests
+// GitHub records for them when available.
+package githubserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/han-tyumi/fync"
+)
+
+// DefaultAPI is the base URL of the GitHub API used when none is chosen.
+const DefaultAPI = "https://api.github.com"
+
+// Options contains options for the New function.
+type Options struct {
+	// Tag of the release to sync. Defaults to the latest release.
+	Tag string
+
+	// Token used to authenticate with GitHub, which is required for private repositories.
+	// Defaults to $GITHUB_TOKEN.
+	Token string
+
+	// Base URL of the GitHub API, such as that of a GitHub Enterprise Server. Defaults to DefaultAPI.
+	API string
+
+	// The HTTP client used for all requests. Defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// Server is a fync.Server that lists the jar assets of a release.
+type Server struct {
+	repo   string
+	tag    string
+	token  string
+	api    string
+	client *http.Client
+
+	mu     sync.Mutex
+	assets []asset
+}
+
+// release is the part of a release returned by the GitHub API that is used.
+type release struct {
+	TagName string  `json:"tag_name"`
+	Assets  []asset `json:"assets"`
+}
+
+// asset is a single file attached to a release.
+type asset struct {
+	Name        string    `json:"name"`
+	Size        int64     `json:"size"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Digest      string    `json:"digest"`
+	URL         string    `json:"url"`
+	DownloadURL string    `json:"browser_download_url"`
+}
+
+// sha256 returns the asset's hex encoded SHA-256 checksum, or an empty string if it is unknown.
+func (a asset) sha256() string {
+	if !strings.HasPrefix(a.Digest, "sha256:") {
+		return ""
+	}
+	return strings.ToLower(strings.TrimPrefix(a.Digest, "sha256:"))
+}
+
+// New returns a Server for the releases of the repository given as "owner/name".
+func New(repo string, o *Options) (*Server, error) {
+	if o == nil {
+		o = &Options{}
+	}
+
+	parts := strings.Split(repo, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("invalid repository %q: must be owner/name", repo)
+	}
+
+	s := &Server{
+		repo:   repo,
+		tag:    o.Tag,
+		token:  o.Token,
+		api:    strings.TrimSuffix(o.API, "/"),
+		client: o.Client,
+	}
+
+	if s.token == "" {
+		s.token = os.Getenv("GITHUB_TOKEN")
+	}
+	if s.api == "" {
+		s.api = DefaultAPI
+	}
+	if s.client == nil {
+		s.client = http.DefaultClient
+	}
+	return s, nil
+}
+
+// String returns the repository and the tag of the release being synced.
+func (s *Server) String() string {
+	if s.tag == "" {
+		return s.repo + "@latest"
+	}
+	return s.repo + "@" + s.tag
+}
+
+// Mods returns a slice of mod ServerFiles for each jar asset of the release.
+// Assets are not downloaded until they are written.
+func (s *Server) Mods() ([]fync.ServerFile, error) {
+	assets, err := s.list()
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]fync.ServerFile, len(assets))
+	for i := range assets {
+		files[i] = &file{asset: assets[i], server: s}
+	}
+	return files, nil
+}
+
+// List returns a reference to each jar asset of the release, keyed by its API URL.
+func (s *Server) List() ([]fync.ModRef, error) {
+	assets, err := s.list()
+	if err != nil {
+		return nil, err
+	}
+
+	refs := make([]fync.ModRef, len(assets))
+	for i, a := range assets {
+		refs[i] = fync.ModRef{Info: fileInfo{a}, SHA256: a.sha256(), Key: a.URL}
+	}
+	return refs, nil
+}
+
+// Open returns a ServerFile for the listed asset, which is downloaded once written.
+func (s *Server) Open(ref fync.ModRef) (fync.ServerFile, error) {
+	assets, err := s.list()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, a := range assets {
+		if a.URL == ref.Key {
+			return &file{asset: a, server: s}, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: not an asset of %s", ref.Key, s)
+}
+
+// list fetches the release's jar assets once, caching the result.
+func (s *Server) list() ([]asset, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.assets != nil {
+		return s.assets, nil
+	}
+
+	u := s.api + "/repos/" + s.repo + "/releases/latest"
+	if s.tag != "" {
+		u = s.api + "/repos/" + s.repo + "/releases/tags/" + url.PathEscape(s.tag)
+	}
+
+	res, err := s.request(u, "application/vnd.github+json")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var r release
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return nil, fmt.Errorf("%s: %w", s, err)
+	}
+
+	assets := make([]asset, 0, len(r.Assets))
+	for _, a := range r.Assets {
+		if !strings.HasSuffix(a.Name, ".jar") || path.Base(a.Name) != a.Name {
+			continue
+		}
+		assets = append(assets, a)
+	}
+
+	s.assets = assets
+	return assets, nil
+}
+
+func (s *Server) request(u, accept string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+
+	// assets redirect to storage on another host, which never receives the token
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		if res.StatusCode == http.StatusNotFound && s.token == "" {
+			return nil, fmt.Errorf("%s: not found, or private and requiring a token", u)
+		}
+		return nil, fmt.Errorf("%s: unexpected status %q", u, res.Status)
+	}
+	return res, nil
+}
+
+// file is a fync.ServerFile that streams a release asset.
+type file struct {
+	asset
+	server *Server
+	res    *http.Response
+}
+
+// String returns the URL the asset is downloaded from.
+func (f *file) String() string {
+	return f.DownloadURL
+}
+
+func (f *file) Stat() (os.FileInfo, error) {
+	return fileInfo{f.asset}, nil
+}
+
+// SHA256 returns the digest GitHub recorded for the asset, if any.
+func (f *file) SHA256() (string, error) {
+	return f.sha256(), nil
+}
+
+func (f *file) WriteTo(w io.Writer) (int64, error) {
+	if f.res == nil {
+		// assets of private repositories can only be downloaded through the API
+		u, accept := f.DownloadURL, "*/*"
+		if f.server.token != "" {
+			u, accept = f.URL, "application/octet-stream"
+		}
+
+		res, err := f.server.request(u, accept)
+		if err != nil {
+			return 0, err
+		}
+		f.res = res
+	}
+
+	defer f.Close()
+	return io.Copy(w, f.res.Body)
+}
+
+// Seek only supports rewinding to the start of the file so that a failed transfer can be retried.
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekStart {
+		return 0, errors.New("githubserver: can only seek to the start of a file")
+	}
+	return 0, f.Close()
+}
+
+func (f *file) Close() error {
+	if f.res == nil {
+		return nil
+	}
+
+	err := f.res.Body.Close()
+	f.res = nil
+	return err
+}
+
+type fileInfo struct {
+	asset
+}
+
+func (i fileInfo) Name() string       { return i.asset.Name }
+func (i fileInfo) Size() int64        { return i.asset.Size }
+func (i fileInfo) Mode() os.FileMode  { return 0644 }
+func (i fileInfo) ModTime() time.Time { return i.UpdatedAt }
+func (i fileInfo) IsDir() bool        { return false }
+func (i fileInfo) Sys() interface{}   { return nil }
+
+var (
+	_ fync.ListServer = (*Server)(nil)
+	_ fync.HashedFile = (*file)(nil)
+)
@@ -0,0 +1,311 @@
+// Package gitserver implements a fync.Server for mods committed to a Git repository,
+// tracking a branch or tag. It requires the git command.
+//
+// Only the latest commit of the ref is fetched, and only the mods directory of it
+// is checked out, fetching just the blobs within it when the remote supports partial clones.
+// Mods stored with Git LFS are reported as an error rather than synced as pointer files.
+package gitserver
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/han-tyumi/fync"
+)
+
+// DefaultPath is the directory within the repository containing the mods when none is chosen.
+const DefaultPath = "mods"
+
+// checksumsName is the name of an optional file alongside the mods listing their checksums.
+const checksumsName = "sha256sums.txt"
+
+// lfsPointer begins the pointer files Git LFS commits in place of the files it stores.
+var lfsPointer = []byte("version https://git-lfs.github.com/spec/")
+
+// Options contains options for the New function.
+type Options struct {
+	// Branch or tag to sync. Defaults to the remote's default branch.
+	Ref string
+
+	// Directory within the repository containing the mods, or "." for its root. Defaults to DefaultPath.
+	Path string
+
+	// Directory the repository is checked out to, reused by later syncs when it is kept.
+	// Defaults to a temporary directory that is removed once the Server is closed.
+	Dir string
+
+	// Path of the git command. Defaults to git as found on $PATH.
+	Git string
+}
+
+// Server is a fync.Server that lists mods from a Git repository.
+// It should be closed once it is no longer needed.
+type Server struct {
+	url  string
+	ref  string
+	path string
+	dir  string
+	temp bool
+	git  string
+
+	mu     sync.Mutex
+	commit string
+}
+
+// New returns a Server for the Git repository at the given URL.
+func New(url string, o *Options) (*Server, error) {
+	if o == nil {
+		o = &Options{}
+	}
+
+	s := &Server{
+		url:  url,
+		ref:  o.Ref,
+		path: strings.Trim(path.Clean("/"+o.Path), "/"),
+		dir:  o.Dir,
+		git:  o.Git,
+	}
+
+	if o.Path == "" {
+		s.path = DefaultPath
+	}
+	if s.git == "" {
+		s.git = "git"
+	}
+
+	if _, err := exec.LookPath(s.git); err != nil {
+		return nil, err
+	}
+
+	if s.dir == "" {
+		dir, err := ioutil.TempDir("", "fync-git")
+		if err != nil {
+			return nil, err
+		}
+		s.dir = dir
+		s.temp = true
+	} else if err := os.MkdirAll(s.dir, os.ModeDir|0755); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// String returns the URL of the repository along with the commit being synced once it is known,
+// or the ref being tracked.
+func (s *Server) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.commit != "" {
+		return s.url + "@" + s.commit
+	}
+	if s.ref != "" {
+		return s.url + "@" + s.ref
+	}
+	return s.url
+}
+
+// Close removes the checkout when it is within a temporary directory.
+func (s *Server) Close() error {
+	if !s.temp {
+		return nil
+	}
+	return os.RemoveAll(s.dir)
+}
+
+// Mods fetches the latest commit of the ref and returns a slice of mod ServerFiles
+// for each jar within the mods directory.
+func (s *Server) Mods() ([]fync.ServerFile, error) {
+	if err := s.fetch(); err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Join(s.dir, filepath.FromSlash(s.path))
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%s: no %s directory", s, s.path)
+	} else if err != nil {
+		return nil, err
+	}
+
+	var mods []fync.ServerFile
+	for i := range files {
+		if !files[i].Mode().IsRegular() || !strings.HasSuffix(files[i].Name(), ".jar") {
+			continue
+		}
+
+		f := &file{server: s, path: filepath.Join(dir, files[i].Name()), info: files[i]}
+		if err := f.checkLFS(); err != nil {
+			return nil, err
+		}
+		mods = append(mods, f)
+	}
+	return mods, nil
+}
+
+// Checksums returns the checksums from a sha256sums.txt file alongside the mods,
+// or an empty map when there is none. It must be called after Mods.
+func (s *Server) Checksums() (map[string]string, error) {
+	f, err := os.Open(filepath.Join(s.dir, filepath.FromSlash(s.path), checksumsName))
+	if os.IsNotExist(err) {
+		return map[string]string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return fync.ParseChecksums(f)
+}
+
+// fetch makes the checkout match the latest commit of the ref.
+func (s *Server) fetch() error {
+	if _, err := os.Stat(filepath.Join(s.dir, ".git")); os.IsNotExist(err) {
+		if err := s.run("init", "-q"); err != nil {
+			return err
+		}
+		if err := s.run("remote", "add", "origin", s.url); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	} else if err := s.run("remote", "set-url", "origin", s.url); err != nil {
+		return err
+	}
+
+	// only check out the mods directory
+	if err := s.run("config", "core.sparseCheckout", "true"); err != nil {
+		return err
+	}
+	sparse := filepath.Join(s.dir, ".git", "info", "sparse-checkout")
+	if err := os.MkdirAll(filepath.Dir(sparse), os.ModeDir|0755); err != nil {
+		return err
+	}
+	pattern := "/" + s.path + "/\n"
+	if s.path == "" {
+		pattern = "/*\n"
+	}
+	if err := ioutil.WriteFile(sparse, []byte(pattern), 0644); err != nil {
+		return err
+	}
+
+	ref := s.ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+	if err := s.run("fetch", "-q", "--depth", "1", "--filter=blob:none", "origin", ref); err != nil {
+		return err
+	}
+	if err := s.run("checkout", "-q", "-f", "--detach", "FETCH_HEAD"); err != nil {
+		return err
+	}
+
+	commit, err := s.output("rev-parse", "HEAD")
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.commit = commit
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *Server) run(args ...string) error {
+	_, err := s.output(args...)
+	return err
+}
+
+// output runs git within the checkout, returning its trimmed output.
+func (s *Server) output(args ...string) (string, error) {
+	cmd := exec.Command(s.git, args...)
+	cmd.Dir = s.dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return "", fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// file is a fync.ServerFile that copies a mod from the checkout.
+type file struct {
+	server *Server
+	path   string
+	info   os.FileInfo
+	r      *os.File
+}
+
+// String returns the location of the mod within the repository.
+func (f *file) String() string {
+	return f.server.String() + ":" + path.Join(f.server.path, f.info.Name())
+}
+
+// checkLFS returns an error if the mod is a Git LFS pointer rather than the mod itself.
+func (f *file) checkLFS() error {
+	if f.info.Size() > 1024 {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+
+	if bytes.HasPrefix(data, lfsPointer) {
+		return fmt.Errorf("%s: stored with Git LFS, which is unsupported", f)
+	}
+	return nil
+}
+
+func (f *file) Stat() (os.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *file) WriteTo(w io.Writer) (int64, error) {
+	if f.r == nil {
+		r, err := os.Open(f.path)
+		if err != nil {
+			return 0, err
+		}
+		f.r = r
+	}
+
+	defer f.Close()
+	return io.Copy(w, f.r)
+}
+
+// Seek only supports rewinding to the start of the file so that a failed transfer can be retried.
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekStart {
+		return 0, errors.New("gitserver: can only seek to the start of a file")
+	}
+	return 0, f.Close()
+}
+
+func (f *file) Close() error {
+	if f.r == nil {
+		return nil
+	}
+
+	err := f.r.Close()
+	f.r = nil
+	return err
+}
+
+var _ fync.ChecksumServer = (*Server)(nil)
@@ -0,0 +1,380 @@
+package fync
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/han-tyumi/fync/delta"
+)
+
+// Paths served by Handler, which are where clients of httpserver look for them by default.
+const (
+	manifestPath = "/manifest.json"
+	eventsPath   = "/events"
+	modsPath     = "/mods/"
+)
+
+// manifestHistory is the number of past manifests Handler remembers, so that clients that last fetched one of them
+// are sent only the changes since.
+const manifestHistory = 16
+
+// hostProtocol is the protocol spoken by Handler.
+var hostProtocol = Protocol{
+	Version: ProtocolVersion,
+	Capabilities: []string{
+		CapabilityDeltas,
+		CapabilityHashes,
+		CapabilityIncremental,
+		CapabilityNotifications,
+		CapabilityRanges,
+	},
+}
+
+// HandlerOptions contains options for the Handler function.
+type HandlerOptions struct {
+	// How often the mods directory is checked for changes while clients are watching it.
+	// Defaults to 2 seconds.
+	PollInterval time.Duration
+
+	// The key the manifest is signed with, so that clients configured with its public key
+	// can tell the manifest has not been tampered with. The manifest is unsigned when nil.
+	PrivateKey ed25519.PrivateKey
+
+	// The lowest version of the fync protocol clients must speak, declared in the manifest
+	// as Manifest.MinProtocolVersion. Clients requesting the manifest with an older version,
+	// including those that predate the protocol, are refused with the status 426 Upgrade Required.
+	MinProtocolVersion int
+
+	// The bandwidth used to serve clients, as by LimitBandwidth. Unlimited by default.
+	Bandwidth BandwidthLimit
+
+	// Authenticates clients, as by RequireAuth, such as with a BearerAuth, BasicAuth, HMACAuth, or ClientCertAuth,
+	// so that the pack cannot be synced by anyone who finds its URL. Every client is served when nil.
+	Auth Authenticator
+
+	// Called once each request has been served, as by LogAccess, such as with JSONAccessLog
+	// or the Record method of Metrics.
+	OnAccess func(Access)
+
+	// Identifies the client of a request for OnAccess. Defaults to the client's IP address.
+	ClientID func(*http.Request) string
+}
+
+// Handler returns an http.Handler that serves the mods within a directory to clients of httpserver.
+// It serves the manifest generated by GenerateManifest at /manifest.json,
+// each mod at /mods/ with support for ranges and delta transfers, and notifications of changes
+// to the mods at /events, speaking every capability of the fync protocol.
+// Mods are hashed when first listed, and again only once they change. Clients that last fetched
+// one of the recent manifests are sent only the changes to it.
+// Mods are sent with their checksum as their ETag, and whole manifests with their sync token,
+// so that conditional requests for unchanged ones cost a 304 Not Modified.
+//
+// Exposing a pack takes little more than:
+//
+//	http.Handle("/", fync.Handler("/srv/minecraft/mods", nil))
+//	log.Fatal(http.ListenAndServe(":8080", nil))
+//
+// after which players sync it with httpserver.New("http://example.com:8080/", nil).
+// The handler can be served beneath a prefix with http.StripPrefix.
+func Handler(dir string, o *HandlerOptions) http.Handler {
+	h := &handler{dir: dir, poll: defaultWatchInterval, cache: make(map[string]handlerMod), manifests: make(map[string]*Manifest)}
+	if o != nil {
+		if o.PollInterval > 0 {
+			h.poll = o.PollInterval
+		}
+		h.key = o.PrivateKey
+		h.minVersion = o.MinProtocolVersion
+		return LogAccess(RequireAuth(LimitBandwidth(h, o.Bandwidth), o.Auth), o.ClientID, o.OnAccess)
+	}
+	return h
+}
+
+type handler struct {
+	dir        string
+	poll       time.Duration
+	key        ed25519.PrivateKey
+	minVersion int
+	notifier   Notifier
+
+	mu    sync.Mutex
+	cache map[string]handlerMod
+
+	// recent manifests by their sync token, oldest first
+	manifests map[string]*Manifest
+	tokens    []string
+
+	// the mods directory is only polled while clients are watching it,
+	// comparing against the mods as they last were so that changes made meanwhile are noticed
+	watchers    int
+	stopWatch   context.CancelFunc
+	checked     bool
+	fingerprint string
+}
+
+// handlerMod is the description of a mod, valid while its size and modification time are unchanged.
+type handlerMod struct {
+	size    int64
+	modTime time.Time
+	mod     ManifestMod
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch p := r.URL.Path; {
+	case p == manifestPath:
+		h.serveManifest(w, r)
+	case p == eventsPath:
+		h.serveEvents(w, r)
+	case strings.HasPrefix(p, modsPath):
+		h.serveMod(w, r, strings.TrimPrefix(p, modsPath))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *handler) serveManifest(w http.ResponseWriter, r *http.Request) {
+	NoteAccess(r, func(a *Access) { a.Op = "manifest" })
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	client, err := ParseProtocol(r.Header)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if client.Version < h.minVersion {
+		w.Header().Set(MinVersionHeader, strconv.Itoa(h.minVersion))
+		http.Error(w, fmt.Sprintf("fync protocol version %d or newer is required; please update fync", h.minVersion), http.StatusUpgradeRequired)
+		return
+	}
+
+	mods, err := modFiles(h.dir)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.forget(mods)
+
+	manifest, err := generateManifest(mods, strings.TrimPrefix(modsPath, "/"), h.describe)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	manifest.MinProtocolVersion = h.minVersion
+
+	token := h.remember(manifest)
+	if since := r.Header.Get(SyncTokenHeader); since != "" {
+		if old := h.recall(since); old != nil {
+			manifest = DiffManifests(old, manifest)
+		}
+	}
+
+	header := w.Header()
+	if p := Negotiate(hostProtocol, client); p.Version > 0 {
+		p.SetHeader(header)
+	}
+	header.Set(SyncTokenHeader, token)
+	header.Set("Vary", VersionHeader+", "+CapabilitiesHeader+", "+SyncTokenHeader)
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Content-Type", "application/json")
+
+	var buf bytes.Buffer
+	manifest.WriteTo(&buf)
+
+	// the signature is sent along with the manifest, so that it always matches the manifest as served
+	if h.key != nil {
+		header.Set(SignatureHeader, SignManifest(buf.Bytes(), h.key))
+	}
+
+	// whole manifests are tagged by their sync token, while changes depend on the token requested
+	if manifest.Since == "" {
+		header.Set("ETag", `"`+token+`"`)
+	}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(buf.Bytes()))
+}
+
+func (h *handler) serveMod(w http.ResponseWriter, r *http.Request, name string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if checkName(name) != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	NoteAccess(r, func(a *Access) {
+		a.Op, a.Mod = "mod", name
+		if r.Method == http.MethodPost {
+			a.Op = "delta"
+		}
+	})
+
+	f, err := os.Open(filepath.Join(h.dir, name))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		http.NotFound(w, r)
+		return
+	}
+
+	// POST requests hold the signature of an older version of the mod to transfer a delta from
+	if r.Method == http.MethodPost {
+		delta.ServeDelta(w, r, f)
+		return
+	}
+
+	// the checksum is a strong validator, so that interrupted downloads can resume from a range
+	if mod, err := h.describe(info); err == nil {
+		w.Header().Set("ETag", `"`+mod.SHA256+`"`)
+	}
+	w.Header().Set("Content-Type", "application/java-archive")
+	http.ServeContent(w, r, name, info.ModTime(), f)
+}
+
+// serveEvents streams notifications of changes to the mods, polling the mods directory while anyone is subscribed.
+func (h *handler) serveEvents(w http.ResponseWriter, r *http.Request) {
+	NoteAccess(r, func(a *Access) { a.Op = "events" })
+
+	h.mu.Lock()
+	if h.watchers == 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		h.stopWatch = cancel
+		go h.watch(ctx)
+	}
+	h.watchers++
+	h.mu.Unlock()
+
+	defer func() {
+		h.mu.Lock()
+		if h.watchers--; h.watchers == 0 {
+			h.stopWatch()
+		}
+		h.mu.Unlock()
+	}()
+
+	h.notifier.ServeHTTP(w, r)
+}
+
+// watch notifies subscribers each time the mods change, until the context is done.
+func (h *handler) watch(ctx context.Context) {
+	ticker := time.NewTicker(h.poll)
+	defer ticker.Stop()
+
+	for {
+		h.checkChanges()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkChanges notifies subscribers if the mods have changed since they were last checked.
+func (h *handler) checkChanges() {
+	mods, err := modFiles(h.dir)
+	if err != nil {
+		return
+	}
+
+	var b strings.Builder
+	for _, info := range mods {
+		fmt.Fprintf(&b, "%q %d %d\n", info.Name(), info.Size(), info.ModTime().UnixNano())
+	}
+	curr := b.String()
+
+	h.mu.Lock()
+	checked, last := h.checked, h.fingerprint
+	h.checked, h.fingerprint = true, curr
+	h.mu.Unlock()
+
+	// the mods are first checked when the first client subscribes
+	if checked && curr != last {
+		h.notifier.Notify()
+	}
+}
+
+// remember records the manifest among the recent ones, returning its sync token.
+func (h *handler) remember(m *Manifest) string {
+	token := ManifestToken(m)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.manifests[token]; ok {
+		return token
+	}
+	if len(h.tokens) == manifestHistory {
+		delete(h.manifests, h.tokens[0])
+		h.tokens = h.tokens[1:]
+	}
+	h.manifests[token] = m
+	h.tokens = append(h.tokens, token)
+	return token
+}
+
+// recall returns the recent manifest with the sync token, or nil if it is not known.
+func (h *handler) recall(token string) *Manifest {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.manifests[token]
+}
+
+// forget discards the descriptions of mods that have been removed.
+func (h *handler) forget(mods []os.FileInfo) {
+	names := make(map[string]bool, len(mods))
+	for _, info := range mods {
+		names[info.Name()] = true
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for name := range h.cache {
+		if !names[name] {
+			delete(h.cache, name)
+		}
+	}
+}
+
+// describe returns the description of a mod, hashing it unless it is unchanged since it was last described.
+func (h *handler) describe(info os.FileInfo) (ManifestMod, error) {
+	h.mu.Lock()
+	e, ok := h.cache[info.Name()]
+	h.mu.Unlock()
+	if ok && e.size == info.Size() && e.modTime.Equal(info.ModTime()) {
+		return e.mod, nil
+	}
+
+	mod, err := describeMod(filepath.Join(h.dir, info.Name()), info)
+	if err != nil {
+		return mod, err
+	}
+
+	h.mu.Lock()
+	h.cache[info.Name()] = handlerMod{size: info.Size(), modTime: info.ModTime(), mod: mod}
+	h.mu.Unlock()
+	return mod, nil
+}
@@ -0,0 +1,150 @@
+package fync
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// hashCacheName is the name of the file within the mods directory caching the checksums of local files.
+const hashCacheName = ".fync-hashes.json"
+
+// hashCheckpoint is the number of files hashed between saves of the hash cache,
+// so that hashing which is interrupted or canceled resumes close to where it left off.
+const hashCheckpoint = 16
+
+// hashCache records the checksums of local files by their path, size, and modification time.
+type hashCache struct {
+	mu     sync.Mutex
+	loaded bool
+	Files  map[string]cachedHash `json:"files"`
+
+	// number of files hashed since the cache was last saved
+	pending int
+}
+
+type cachedHash struct {
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"modTime"`
+	SHA256  string `json:"sha256"`
+}
+
+var hashes hashCache
+
+// hashLocal returns the checksum of the local file at path, reusing the cached checksum
+// when the file's size and modification time have not changed since it was hashed.
+func hashLocal(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	hashes.mu.Lock()
+	hashes.load()
+	cached, ok := hashes.Files[path]
+	hashes.mu.Unlock()
+
+	if ok && cached.Size == info.Size() && cached.ModTime == info.ModTime().UnixNano() {
+		return cached.SHA256, nil
+	}
+
+	sum, err := hashFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	hashes.mu.Lock()
+	defer hashes.mu.Unlock()
+
+	hashes.Files[path] = cachedHash{Size: info.Size(), ModTime: info.ModTime().UnixNano(), SHA256: sum}
+	hashes.pending++
+	if hashes.pending >= hashCheckpoint {
+		// a failed checkpoint only costs rehashing later
+		hashes.save()
+	}
+	return sum, nil
+}
+
+// rememberHash caches the checksum of the local file at path, such as a mod that was just written.
+func rememberHash(path, sum string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+
+	hashes.mu.Lock()
+	hashes.load()
+	hashes.Files[path] = cachedHash{Size: info.Size(), ModTime: info.ModTime().UnixNano(), SHA256: sum}
+	hashes.pending++
+	hashes.mu.Unlock()
+}
+
+// flushHashes saves any checksums hashed since the hash cache was last saved.
+func flushHashes() error {
+	hashes.mu.Lock()
+	defer hashes.mu.Unlock()
+
+	if hashes.pending == 0 {
+		return nil
+	}
+	return hashes.save()
+}
+
+// reset forgets the hash cache so that it is read again from the current mods directory.
+func (c *hashCache) reset() {
+	c.mu.Lock()
+	c.loaded = false
+	c.Files = nil
+	c.pending = 0
+	c.mu.Unlock()
+}
+
+// load reads the hash cache if it has not been read yet, starting afresh if it cannot be.
+func (c *hashCache) load() {
+	if c.loaded {
+		return
+	}
+	c.loaded = true
+	c.Files = make(map[string]cachedHash)
+
+	data, err := ioutil.ReadFile(filepath.Join(modsDir, hashCacheName))
+	if err != nil {
+		return
+	}
+
+	if json.Unmarshal(data, c) != nil || c.Files == nil {
+		c.Files = make(map[string]cachedHash)
+	}
+}
+
+// save writes the hash cache, forgetting files that no longer exist.
+func (c *hashCache) save() error {
+	for path := range c.Files {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			delete(c.Files, path)
+		}
+	}
+
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(modsDir, os.ModeDir|0755); err != nil {
+		return err
+	}
+
+	// replace the cache in one step so an interruption cannot leave it truncated
+	path := filepath.Join(modsDir, hashCacheName)
+	if err := ioutil.WriteFile(path+tempExt, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(path+tempExt, path); err != nil {
+		return err
+	}
+
+	c.pending = 0
+	return nil
+}
@@ -0,0 +1,172 @@
+package fync
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// historyName is the name of the directory within the backup directory containing each sync's history entry.
+const historyName = "history"
+
+// historyTimeFormat is the layout used to name history entries, precise enough to avoid collisions.
+const historyTimeFormat = "2006-01-02T15-04-05.000000000"
+
+// HistoryEntry records how a sync changed the mods and config directories.
+type HistoryEntry struct {
+	// When the sync began.
+	Time time.Time `json:"time"`
+
+	// The error that ended the sync, if any.
+	Err string `json:"error,omitempty"`
+
+	// The files that were added, removed, or modified by the sync.
+	Changes []FileChange `json:"changes"`
+}
+
+// FileChange is a single file changed by a sync.
+type FileChange struct {
+	// Slash separated path of the file relative to the Minecraft installation directory,
+	// such as "mods/jei-1.16.5-7.7.1.jar".
+	Path string `json:"path"`
+
+	// Hex encoded SHA-256 checksums of the file before and after the sync,
+	// empty when the file did not exist.
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+}
+
+// History returns the history entries recorded by syncs using the RecordHistory option, newest first.
+func History() ([]HistoryEntry, error) {
+	if dirErr != nil {
+		return nil, dirErr
+	}
+
+	files, err := ioutil.ReadDir(filepath.Join(backupDir, historyName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var entries []HistoryEntry
+	for i := range files {
+		if files[i].IsDir() || !strings.HasSuffix(files[i].Name(), ".json") {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(backupDir, historyName, files[i].Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		var e HistoryEntry
+		if err := json.Unmarshal(data, &e); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Time.After(entries[j].Time)
+	})
+	return entries, nil
+}
+
+// instanceState maps the slash separated paths of the files within the mods
+// and config directories, relative to the installation directory, to their checksums.
+type instanceState map[string]string
+
+// readInstanceState hashes the files within the mods and config directories.
+// Files written by fync itself are excluded, as is the backup directory.
+func readInstanceState(o *SyncOptions) (instanceState, error) {
+	var paths []string
+	for _, dir := range []string{modsDir, filepath.Join(installDir, "config")} {
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if os.IsNotExist(err) && path == dir {
+				return nil
+			} else if err != nil {
+				return err
+			}
+
+			if info.IsDir() {
+				if path == backupDir || strings.HasPrefix(path, backupDir+string(filepath.Separator)) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			name := info.Name()
+			if !info.Mode().IsRegular() || dir == modsDir && (name == stateName || name == lockName || name == hashCacheName || name == journalName || name == pinsName || name == manifestCacheName || strings.HasSuffix(name, tempExt)) {
+				return nil
+			}
+
+			paths = append(paths, path)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	st := make(instanceState, len(paths))
+	var mu sync.Mutex
+	err := run(len(paths), concurrency(o), o.Cancel, progress("history", o), func(i int) error {
+		sum, err := hashLocal(paths[i])
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(installDir, paths[i])
+		if err != nil {
+			return err
+		}
+
+		mu.Lock()
+		st[filepath.ToSlash(rel)] = sum
+		mu.Unlock()
+		return nil
+	})
+	return st, err
+}
+
+// recordHistory saves a history entry of the changes between the states before and after a sync.
+func recordHistory(t time.Time, before, after instanceState, syncErr error) error {
+	e := HistoryEntry{Time: t, Changes: []FileChange{}}
+	if syncErr != nil {
+		e.Err = syncErr.Error()
+	}
+
+	for path, sum := range before {
+		if after[path] != sum {
+			e.Changes = append(e.Changes, FileChange{Path: path, Before: sum, After: after[path]})
+		}
+	}
+	for path, sum := range after {
+		if _, ok := before[path]; !ok {
+			e.Changes = append(e.Changes, FileChange{Path: path, After: sum})
+		}
+	}
+
+	sort.Slice(e.Changes, func(i, j int) bool {
+		return e.Changes[i].Path < e.Changes[j].Path
+	})
+
+	dir := filepath.Join(backupDir, historyName)
+	if err := os.MkdirAll(dir, os.ModeDir|0755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(e, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, t.Format(historyTimeFormat)+".json"), data, 0644)
+}
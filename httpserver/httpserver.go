@@ -0,0 +1,1050 @@
+// Package httpserver implements a fync.Server for mods described by a manifest
+// in the format of fync.Manifest served over HTTP(S), such as:
+//
+//	{
+//		"schemaVersion": 1,
+//		"mods": [
+//			{
+//				"name": "jei-1.16.5-7.7.1.jar",
+//				"url": "mods/jei-1.16.5-7.7.1.jar",
+//				"size": 742314,
+//				"sha256": "2be429ec087642b06b2a2c1c0f541ea57db33aa9c1537cce6655d3d706b8b8f3"
+//			}
+//		]
+//	}
+//
+// Each mod's URL is resolved relative to the manifest and defaults to its name.
+// Sizes and checksums are optional; missing sizes are requested with HEAD requests,
+// and mods the server does not report a size for are verified by checksum alone.
+// Mods marked optional are synced unless Options.SkipOptional is set.
+// A directory of mods is served along with such a manifest by fync.Handler, which supports all of the below.
+// Manifests for static hosts, such as S3 or GitHub Pages, are generated by fync.GenerateManifest.
+//
+// The fync protocol is negotiated with the request for the manifest, so that fync-aware hosts
+// can offer the capabilities they support: updated mods are transferred as deltas from the mods
+// they replace, downloads cut off part way resume where they stopped, and mods are required to
+// have checksums when the host hashes every mod. Hosts that are not fync-aware only serve files,
+// though those that support delta transfers, such as by delta.FileServer, can be used with Options.Delta.
+// Mods that would overwrite a local copy that may be the same are first requested on the condition
+// that they do not match its checksum, so that hosts using checksums as ETags, such as fync.Handler,
+// answer 304 Not Modified rather than sending them again.
+//
+// Requests are made by the client of Options.Client, so any transport can be used, such as HTTP/3
+// from quic-go, which holds up better than many parallel TCP connections over lossy or long links:
+//
+//	s, err := httpserver.New("https://mods.example.com/", &httpserver.Options{
+//		Client: &http.Client{Transport: &http3.Transport{}},
+//	})
+//
+// A transport that only speaks HTTP/3 fails for hosts that do not serve it, and for mods listed
+// at URLs of hosts other than the manifest's that do not.
+//
+// With Options.Incremental, hosts supporting it, such as fync.Handler, send only the changes
+// to the manifest since it was last fetched, which is cached between syncs, rather than the whole
+// manifest of a large pack that has barely changed.
+//
+// Hosts that serve a fync.Notifier, by default at "events" alongside the manifest, can be watched
+// with Server.Watch so that a daemon resyncs as soon as the pack is updated.
+//
+// Packs requiring a newer version of fync, by the schema or minimum protocol version of their manifest,
+// fail to sync with a *fync.UpdateError asking to update fync.
+//
+// Packs served only to clients with credentials, such as by a fync.Handler with an Authenticator,
+// are synced by giving the credentials in Options, including client certificates for hosts requiring them.
+//
+// Manifests signed by the pack's admin, whether by a fync.Handler given the private key or with
+// fync.SignManifest for static hosting, are verified against the public key of Options.PublicKey.
+package httpserver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/han-tyumi/fync"
+	"github.com/han-tyumi/fync/delta"
+)
+
+// DefaultManifest is the path of the manifest relative to the base URL when none is chosen.
+const DefaultManifest = "manifest.json"
+
+// DefaultEvents is the path of the host's change notifications relative to the base URL when none is chosen.
+const DefaultEvents = "events"
+
+// defaultRetry is how long Watch waits before reconnecting when the host has not chosen how long.
+const defaultRetry = 3 * time.Second
+
+// maxResumes is the most times a single download is resumed after being cut off.
+const maxResumes = 3
+
+// clientProtocol is the protocol requested of hosts.
+var clientProtocol = fync.Protocol{
+	Version: fync.ProtocolVersion,
+	Capabilities: []string{
+		fync.CapabilityDeltas,
+		fync.CapabilityHashes,
+		fync.CapabilityIncremental,
+		fync.CapabilityNotifications,
+		fync.CapabilityRanges,
+	},
+}
+
+// Options contains options for the New function.
+type Options struct {
+	// The HTTP client used for all requests, including those for deltas. Defaults to http.DefaultClient.
+	// Its transport chooses the protocol mods are downloaded with, such as HTTP/3.
+	Client *http.Client
+
+	// Path or URL of the manifest, resolved relative to the base URL. Defaults to DefaultManifest.
+	Manifest string
+
+	// Whether to transfer updated mods as deltas from the mods they replace when the host
+	// is not fync-aware, which requires it to support delta transfers anyway.
+	// Deltas are used with fync-aware hosts whenever they support them.
+	Delta bool
+
+	// Path or URL of the host's change notifications, served by a fync.Notifier,
+	// resolved relative to the base URL. Defaults to DefaultEvents.
+	Events string
+
+	// Whether to leave out mods the manifest marks as optional.
+	SkipOptional bool
+
+	// The public key the manifest must be signed by, as with fync.SignManifest, so that a manifest
+	// tampered with on the host or along the way is rejected before any mod is downloaded.
+	// Every mod of a signed manifest must have a checksum, which downloads are verified against.
+	// Manifests are not verified when nil.
+	PublicKey ed25519.PublicKey
+
+	// Path or URL of the manifest's signature, resolved relative to the base URL, for hosts that
+	// do not send it in the fync.SignatureHeader along with the manifest.
+	// Defaults to the manifest's URL followed by fync.SignatureSuffix.
+	Signature string
+
+	// Pins trusting HTTPS hosts by the key they first presented rather than by certificate authorities,
+	// for self-signed hosts. The client's transport, which must be an *http.Transport when set,
+	// is replaced by one from Pins.Transport.
+	Pins *fync.Pins
+
+	// Credentials sent to the host of the manifest, as checked by the fync.Authenticator of a fync.Handler:
+	// a bearer token as with fync.BearerAuth, a user name and password as with fync.BasicAuth,
+	// or a shared secret requests are signed with as with fync.HMACAuth. At most one may be set.
+	// They are not sent to other hosts, such as those of mods listed at other URLs.
+	BearerToken        string
+	Username, Password string
+	HMACSecret         []byte
+
+	// The TLS config of the client's transport, which must be an *http.Transport when set, in place
+	// of the transport's own, such as with client certificates for hosts that require them.
+	TLSConfig *tls.Config
+
+	// Paths of a client certificate and its private key, as PEM, presented to hosts that request one,
+	// such as a fync.Handler authenticating clients with a fync.ClientCertAuth.
+	CertFile, KeyFile string
+
+	// Whether to request only the changes to the manifest since it was last fetched from hosts
+	// that support fync.CapabilityIncremental, such as fync.Handler, rather than the whole manifest.
+	// The manifest last fetched is cached at ManifestCache, so that later syncs request only the changes too.
+	Incremental bool
+
+	// Path of the file the manifest last fetched is cached in for incremental fetches.
+	// Defaults to fync.ManifestCachePath().
+	ManifestCache string
+}
+
+// Server is a fync.Server that lists mods from a manifest.
+type Server struct {
+	manifest     *url.URL
+	events       *url.URL
+	client       *http.Client
+	delta        bool
+	skipOptional bool
+	key          ed25519.PublicKey
+	signature    *url.URL
+
+	incremental bool
+	cachePath   string
+
+	mu       sync.Mutex
+	mods     []mod
+	protocol fync.Protocol
+
+	// the manifest last fetched and its sync token, which incremental fetches request the changes to
+	last      *fync.Manifest
+	lastToken string
+	loaded    bool
+}
+
+// manifestCache is how the manifest last fetched is cached, along with where it was fetched from.
+type manifestCache struct {
+	URL      string         `json:"url"`
+	Token    string         `json:"token"`
+	Manifest *fync.Manifest `json:"manifest"`
+}
+
+// mod is a single mod listed by the manifest, whose URL has been resolved.
+type mod struct {
+	fync.ManifestMod
+
+	modTime time.Time
+}
+
+// New returns a Server for the manifest relative to the given base URL.
+func New(baseURL string, o *Options) (*Server, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported URL scheme %q", base.Scheme)
+	}
+
+	// the manifest is relative to the directory
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path += "/"
+	}
+
+	s := &Server{client: http.DefaultClient}
+	ref, events, signature := DefaultManifest, DefaultEvents, ""
+	if o != nil {
+		if o.Client != nil {
+			s.client = o.Client
+		}
+		if o.Manifest != "" {
+			ref = o.Manifest
+		}
+		if o.Events != "" {
+			events = o.Events
+		}
+		s.delta = o.Delta
+		s.skipOptional = o.SkipOptional
+		if o.PublicKey != nil {
+			if len(o.PublicKey) != ed25519.PublicKeySize {
+				return nil, errors.New("invalid Ed25519 public key")
+			}
+			s.key = o.PublicKey
+		}
+		if o.Signature != "" {
+			signature = o.Signature
+		}
+		if o.Incremental {
+			s.incremental = true
+			s.cachePath = o.ManifestCache
+			if s.cachePath == "" {
+				if s.cachePath, err = fync.ManifestCachePath(); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if o.TLSConfig != nil || o.CertFile != "" || o.KeyFile != "" {
+			if err := s.configureTLS(o); err != nil {
+				return nil, err
+			}
+		}
+		if o.Pins != nil {
+			t, ok := s.client.Transport.(*http.Transport)
+			if !ok && s.client.Transport != nil {
+				return nil, fmt.Errorf("pinning requires an *http.Transport, not %T", s.client.Transport)
+			}
+
+			client := *s.client
+			client.Transport = o.Pins.Transport(t)
+			s.client = &client
+		}
+	}
+
+	manifest, err := url.Parse(ref)
+	if err != nil {
+		return nil, err
+	}
+	s.manifest = base.ResolveReference(manifest)
+
+	if o != nil {
+		if err := s.authorize(o); err != nil {
+			return nil, err
+		}
+	}
+
+	if signature == "" {
+		signature = s.manifest.String() + fync.SignatureSuffix
+	}
+	sig, err := url.Parse(signature)
+	if err != nil {
+		return nil, err
+	}
+	s.signature = base.ResolveReference(sig)
+
+	u, err := url.Parse(events)
+	if err != nil {
+		return nil, err
+	}
+	s.events = base.ResolveReference(u)
+	return s, nil
+}
+
+// configureTLS replaces the client's transport with one using the chosen TLS config and client certificate.
+func (s *Server) configureTLS(o *Options) error {
+	t, ok := s.client.Transport.(*http.Transport)
+	if !ok && s.client.Transport != nil {
+		return fmt.Errorf("a TLS config requires an *http.Transport, not %T", s.client.Transport)
+	}
+	if t == nil {
+		t = http.DefaultTransport.(*http.Transport)
+	}
+	t = t.Clone()
+
+	if o.TLSConfig != nil {
+		t.TLSClientConfig = o.TLSConfig.Clone()
+	} else if t.TLSClientConfig == nil {
+		t.TLSClientConfig = &tls.Config{}
+	}
+
+	if o.CertFile != "" || o.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return err
+		}
+		t.TLSClientConfig.Certificates = append(t.TLSClientConfig.Certificates, cert)
+	}
+
+	client := *s.client
+	client.Transport = t
+	s.client = &client
+	return nil
+}
+
+// authorize replaces the client's transport with one sending the chosen credentials to the host of the manifest.
+func (s *Server) authorize(o *Options) error {
+	var auth func(*http.Request)
+	set := 0
+	if o.BearerToken != "" {
+		auth = func(req *http.Request) { req.Header.Set("Authorization", "Bearer "+o.BearerToken) }
+		set++
+	}
+	if o.Username != "" || o.Password != "" {
+		auth = func(req *http.Request) { req.SetBasicAuth(o.Username, o.Password) }
+		set++
+	}
+	if len(o.HMACSecret) > 0 {
+		secret := o.HMACSecret
+		auth = func(req *http.Request) { fync.SignRequest(req, secret) }
+		set++
+	}
+
+	if set > 1 {
+		return errors.New("only one of BearerToken, Username and Password, or HMACSecret may be set")
+	}
+	if auth == nil {
+		return nil
+	}
+
+	t := s.client.Transport
+	if t == nil {
+		t = http.DefaultTransport
+	}
+	client := *s.client
+	client.Transport = &authTransport{base: t, host: s.manifest.Host, scheme: s.manifest.Scheme, auth: auth}
+	s.client = &client
+	return nil
+}
+
+// authTransport is an http.RoundTripper authorizing requests to a single host.
+type authTransport struct {
+	base         http.RoundTripper
+	host, scheme string
+	auth         func(*http.Request)
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != t.host || req.URL.Scheme != t.scheme {
+		return t.base.RoundTrip(req)
+	}
+
+	// a RoundTripper must not modify the request it is given
+	req = req.Clone(req.Context())
+	t.auth(req)
+	return t.base.RoundTrip(req)
+}
+
+// String returns the URL of the manifest.
+func (s *Server) String() string {
+	return s.manifest.String()
+}
+
+// Mods returns a slice of mod ServerFiles for each mod in the manifest.
+// Mods are not downloaded until they are written.
+func (s *Server) Mods() ([]fync.ServerFile, error) {
+	mods, err := s.list()
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]fync.ServerFile, len(mods))
+	for i := range mods {
+		files[i] = s.newFile(mods[i])
+	}
+	return files, nil
+}
+
+// List returns a reference to each mod in the manifest, keyed by its URL.
+func (s *Server) List() ([]fync.ModRef, error) {
+	mods, err := s.list()
+	if err != nil {
+		return nil, err
+	}
+
+	refs := make([]fync.ModRef, len(mods))
+	for i, m := range mods {
+		refs[i] = fync.ModRef{Info: fileInfo{m}, SHA256: m.SHA256, Key: m.URL, Delta: s.useDelta()}
+	}
+	return refs, nil
+}
+
+// Open returns a ServerFile for the listed mod, which is downloaded once written.
+func (s *Server) Open(ref fync.ModRef) (fync.ServerFile, error) {
+	mods, err := s.list()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range mods {
+		if m.URL == ref.Key {
+			return s.newFile(m), nil
+		}
+	}
+	return nil, fmt.Errorf("%s: not in manifest", ref.Key)
+}
+
+// Protocol returns the protocol negotiated with the host when its manifest was fetched,
+// fetching the manifest first if it has not been. Hosts that are not fync-aware speak version zero.
+func (s *Server) Protocol() (fync.Protocol, error) {
+	if _, err := s.list(); err != nil {
+		return fync.Protocol{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.protocol, nil
+}
+
+// has reports whether the host supports the capability, as of when the manifest was last fetched.
+func (s *Server) has(capability string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.protocol.Has(capability)
+}
+
+// useDelta reports whether mods are transferred as deltas.
+func (s *Server) useDelta() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.protocol.Version == 0 {
+		return s.delta
+	}
+	return s.protocol.Has(fync.CapabilityDeltas)
+}
+
+// list fetches and parses the manifest once, negotiating the protocol, and caches the result.
+func (s *Server) list() ([]mod, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.mods != nil {
+		return s.mods, nil
+	}
+
+	base, since := s.base()
+	manifest, token, protocol, err := s.fetch(since)
+	if err != nil {
+		return nil, err
+	}
+
+	// changes that do not leave the manifest the host has are discarded for the whole manifest
+	if manifest.Since != "" {
+		if patched, ok := patch(base, manifest, token); ok {
+			manifest = patched
+		} else {
+			if manifest, token, protocol, err = s.fetch(""); err != nil {
+				return nil, err
+			}
+			if manifest.Since != "" {
+				return nil, fmt.Errorf("%s: changes sent in place of the whole manifest", s.manifest)
+			}
+		}
+	}
+
+	if s.incremental && token != "" {
+		s.remember(manifest, token)
+	}
+
+	mods := make([]mod, 0, len(manifest.Mods))
+	for _, listed := range manifest.Mods {
+		if listed.Optional && s.skipOptional {
+			continue
+		}
+
+		m := mod{ManifestMod: listed}
+		ref := m.URL
+		if ref == "" {
+			ref = url.PathEscape(m.Name)
+		}
+
+		u, err := url.Parse(ref)
+		if err != nil {
+			return nil, err
+		}
+		m.URL = s.manifest.ResolveReference(u).String()
+		if m.SHA256 == "" && (protocol.Has(fync.CapabilityHashes) || s.key != nil) {
+			return nil, fmt.Errorf("%s: missing SHA-256 checksum of %s", s.manifest, m.Name)
+		}
+
+		// learn the size of mods the manifest does not give one for
+		if m.Size == nil {
+			if err := s.head(&m); err != nil {
+				return nil, err
+			}
+		}
+
+		mods = append(mods, m)
+	}
+
+	s.mods = mods
+	s.protocol = protocol
+	return mods, nil
+}
+
+// fetch fetches and parses the manifest, negotiating the protocol, or only the changes to it
+// since the manifest with the sync token when there is one. The sync token of the host's manifest
+// is returned along with it when the host sends one.
+func (s *Server) fetch(since string) (*fync.Manifest, string, fync.Protocol, error) {
+	var protocol fync.Protocol
+
+	req, err := http.NewRequest(http.MethodGet, s.manifest.String(), nil)
+	if err != nil {
+		return nil, "", protocol, err
+	}
+	clientProtocol.SetHeader(req.Header)
+	if since != "" {
+		req.Header.Set(fync.SyncTokenHeader, since)
+	}
+
+	res, err := s.do(req)
+	if err != nil {
+		return nil, "", protocol, err
+	}
+	defer res.Body.Close()
+
+	if protocol, err = fync.ParseProtocol(res.Header); err != nil {
+		return nil, "", protocol, fmt.Errorf("%s: %w", s.manifest, err)
+	}
+	if protocol.Version > fync.ProtocolVersion {
+		return nil, "", protocol, fmt.Errorf("%s: unsupported protocol version %d", s.manifest, protocol.Version)
+	}
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, "", protocol, fmt.Errorf("%s: %w", s.manifest, err)
+	}
+	if s.key != nil {
+		if err := s.verify(data, res.Header.Get(fync.SignatureHeader)); err != nil {
+			return nil, "", protocol, fmt.Errorf("%s: %w", s.manifest, err)
+		}
+	}
+
+	manifest, err := fync.ParseManifest(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", protocol, fmt.Errorf("%s: %w", s.manifest, err)
+	}
+
+	var token string
+	if protocol.Has(fync.CapabilityIncremental) {
+		token = res.Header.Get(fync.SyncTokenHeader)
+	}
+	return manifest, token, protocol, nil
+}
+
+// patch applies the changes to the base manifest, reporting whether they left the manifest with the sync token.
+func patch(base, changes *fync.Manifest, token string) (*fync.Manifest, bool) {
+	if base == nil {
+		return nil, false
+	}
+
+	m, err := fync.PatchManifest(base, changes)
+	if err != nil || fync.ManifestToken(m) != token {
+		return nil, false
+	}
+	return m, true
+}
+
+// base returns the manifest last fetched and its sync token for incremental fetches,
+// reading them from the cache the first time. It returns nil when there is none.
+func (s *Server) base() (*fync.Manifest, string) {
+	if !s.incremental {
+		return nil, ""
+	}
+
+	if !s.loaded {
+		s.loaded = true
+
+		// a cache that cannot be read only costs fetching the whole manifest
+		var c manifestCache
+		if data, err := ioutil.ReadFile(s.cachePath); err == nil && json.Unmarshal(data, &c) == nil &&
+			c.URL == s.manifest.String() && c.Manifest != nil && c.Manifest.Since == "" && fync.ValidateManifest(c.Manifest) == nil {
+			s.last, s.lastToken = c.Manifest, c.Token
+		}
+	}
+	return s.last, s.lastToken
+}
+
+// remember records the whole manifest and its sync token as the one last fetched, caching them.
+func (s *Server) remember(m *fync.Manifest, token string) {
+	s.last, s.lastToken = m, token
+
+	// failing to cache the manifest only costs fetching it whole next time
+	if data, err := json.MarshalIndent(manifestCache{URL: s.manifest.String(), Token: token, Manifest: m}, "", "\t"); err == nil {
+		ioutil.WriteFile(s.cachePath, data, 0644)
+	}
+}
+
+// verify checks the signature of the manifest's data,
+// fetching it from beside the manifest unless it was sent along with it.
+func (s *Server) verify(data []byte, signature string) error {
+	if signature == "" {
+		res, err := s.request(http.MethodGet, s.signature.String())
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+
+		sig, err := ioutil.ReadAll(io.LimitReader(res.Body, 1<<10))
+		if err != nil {
+			return err
+		}
+		signature = string(sig)
+	}
+	return fync.VerifyManifest(data, signature, s.key)
+}
+
+// head fills in the mod's size and modification time from the headers of a HEAD request.
+func (s *Server) head(m *mod) error {
+	res, err := s.request(http.MethodHead, m.URL)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	// a negative length leaves the size unknown
+	size := res.ContentLength
+	m.Size = &size
+	m.modTime, _ = http.ParseTime(res.Header.Get("Last-Modified"))
+	return nil
+}
+
+func (s *Server) request(method, u string) (*http.Response, error) {
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	return s.do(req)
+}
+
+// conditional sends a request for u unless the resource matches the checksum as its ETag,
+// returning a nil response when it does.
+func (s *Server) conditional(method, u, sum string) (*http.Response, error) {
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("If-None-Match", `"`+sum+`"`)
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == http.StatusNotModified {
+		res.Body.Close()
+		return nil, nil
+	}
+
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("%s: unexpected status %q", req.URL, res.Status)
+	}
+	return res, nil
+}
+
+// do sends the request, failing unless it succeeds with 200 OK.
+func (s *Server) do(req *http.Request) (*http.Response, error) {
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+
+		// hosts refusing clients too old for the pack say which version it requires
+		if res.StatusCode == http.StatusUpgradeRequired {
+			if v, err := strconv.Atoi(res.Header.Get(fync.MinVersionHeader)); err == nil {
+				return nil, fmt.Errorf("%s: %w", req.URL, &fync.UpdateError{Of: "protocol", Required: v, Supported: fync.ProtocolVersion})
+			}
+		}
+		return nil, fmt.Errorf("%s: unexpected status %q", req.URL, res.Status)
+	}
+	return res, nil
+}
+
+// Watch subscribes to the host's change notifications, returning a channel receiving a ChangeEvent
+// each time the host notifies that the pack has changed, which also discards the cached manifest.
+// Changes made while an event is waiting to be received are sent together in it.
+// Streams cut off are resubscribed to, sending an event when a change was missed meanwhile,
+// while the watch fails if the host does not serve notifications.
+// The client of Options.Client must not time out requests, which would cut off every stream.
+func (s *Server) Watch(ctx context.Context) <-chan fync.ChangeEvent {
+	events := make(chan fync.ChangeEvent, 1)
+	go func() {
+		defer close(events)
+
+		w := watch{server: s, events: events, retry: defaultRetry}
+		for {
+			err := w.subscribe(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+
+			// only failures of the connection are retried
+			var eventsErr *eventsError
+			if errors.As(err, &eventsErr) {
+				select {
+				case events <- fync.ChangeEvent{Time: time.Now(), Err: err}:
+				case <-ctx.Done():
+				}
+				return
+			}
+
+			select {
+			case <-time.After(w.retry):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events
+}
+
+// eventsError is returned when the host does not serve change notifications.
+type eventsError struct {
+	err error
+}
+
+func (e *eventsError) Error() string { return e.err.Error() }
+func (e *eventsError) Unwrap() error { return e.err }
+
+// watch is the state of a Watch kept between subscriptions.
+type watch struct {
+	server *Server
+	events chan fync.ChangeEvent
+	retry  time.Duration
+
+	// ID of the last event received, identifying the host's last notification
+	lastID string
+}
+
+// subscribe streams the host's change notifications until the stream is cut off.
+func (w *watch) subscribe(ctx context.Context) error {
+	u := w.server.events.String()
+
+	// fync-aware hosts say whether they notify of changes when the manifest is fetched
+	w.server.mu.Lock()
+	protocol := w.server.protocol
+	w.server.mu.Unlock()
+	if protocol.Version > 0 && !protocol.Has(fync.CapabilityNotifications) {
+		return &eventsError{fmt.Errorf("%s: host does not notify of changes", w.server.manifest)}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return &eventsError{err}
+	}
+	req.Header.Set("Accept", fync.EventStreamType)
+	req.Header.Set("Cache-Control", "no-cache")
+	if w.lastID != "" {
+		req.Header.Set("Last-Event-ID", w.lastID)
+	}
+
+	res, err := w.server.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return &eventsError{fmt.Errorf("%s: unexpected status %q", u, res.Status)}
+	}
+	if !strings.HasPrefix(res.Header.Get("Content-Type"), fync.EventStreamType) {
+		return &eventsError{fmt.Errorf("%s: not an event stream", u)}
+	}
+
+	var event, id, data string
+	r := bufio.NewReader(res.Body)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+
+		// a blank line dispatches the event
+		if line == "" {
+			// a host that was notified while disconnected sends a new ID when resubscribed to
+			if event == "changed" || event == "ready" && w.lastID != "" && id != w.lastID {
+				w.changed(data)
+			}
+			if id != "" {
+				w.lastID = id
+			}
+			event, id, data = "", "", ""
+			continue
+		}
+
+		field, value := line, ""
+		if i := strings.Index(line, ":"); i >= 0 {
+			field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+		}
+		switch field {
+		case "event":
+			event = value
+		case "id":
+			id = value
+		case "data":
+			data = value
+		case "retry":
+			if ms, err := strconv.Atoi(value); err == nil && ms >= 0 {
+				w.retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+}
+
+// changed discards the cached manifest and sends an event for a change made at the time given by data,
+// unless an event is already waiting to be received.
+func (w *watch) changed(data string) {
+	t, err := time.Parse(time.RFC3339Nano, data)
+	if err != nil {
+		t = time.Now()
+	}
+
+	w.server.mu.Lock()
+	w.server.mods = nil
+	w.server.mu.Unlock()
+
+	select {
+	case w.events <- fync.ChangeEvent{Time: t}:
+	default:
+	}
+}
+
+func (s *Server) newFile(m mod) fync.ServerFile {
+	f := &file{mod: m, server: s}
+	if s.useDelta() {
+		return &deltaFile{f}
+	}
+	return f
+}
+
+// file is a fync.ServerFile that streams a mod listed by the manifest.
+type file struct {
+	mod
+	server *Server
+	res    *http.Response
+}
+
+// String returns the URL the mod is downloaded from.
+func (f *file) String() string {
+	return f.URL
+}
+
+func (f *file) Stat() (os.FileInfo, error) {
+	return fileInfo{f.mod}, nil
+}
+
+// SHA256 returns the checksum given by the manifest, if any.
+func (f *file) SHA256() (string, error) {
+	return f.mod.SHA256, nil
+}
+
+func (f *file) WriteTo(w io.Writer) (int64, error) {
+	if f.res == nil {
+		res, err := f.server.request(http.MethodGet, f.URL)
+		if err != nil {
+			return 0, err
+		}
+		f.res = res
+	}
+
+	defer f.Close()
+
+	var n int64
+	for resumes := 0; ; resumes++ {
+		body := &bodyReader{r: f.res.Body}
+		m, err := io.Copy(w, body)
+		n += m
+
+		// only downloads cut off while being read are resumed, and only by hosts that support ranges
+		if err == nil || body.err == nil || resumes >= maxResumes || !f.server.has(fync.CapabilityRanges) {
+			return n, err
+		}
+
+		res, resumeErr := f.resume(n)
+		if resumeErr != nil {
+			return n, err
+		}
+		f.res.Body.Close()
+		f.res = res
+	}
+}
+
+// Unchanged sends a conditional request for the mod, reporting whether the host found it unchanged
+// from the local copy by its checksum, which hosts such as fync.Handler use as the ETag of each mod.
+// The response to a request that is not found unchanged is kept to be written by WriteTo.
+func (f *file) Unchanged(sum string) (bool, error) {
+	f.Close()
+
+	res, err := f.server.conditional(http.MethodGet, f.URL, sum)
+	if err != nil {
+		return false, err
+	}
+	if res == nil {
+		return true, nil
+	}
+	f.res = res
+	return false, nil
+}
+
+// resume requests the rest of the mod from the offset, as long as it has not changed since it was requested.
+func (f *file) resume(offset int64) (*http.Response, error) {
+	// a transparently decompressed body cannot be resumed at an offset within it
+	if f.res.Uncompressed {
+		return nil, errors.New("httpserver: cannot resume a compressed download")
+	}
+
+	validator := f.res.Header.Get("ETag")
+	if validator == "" || strings.HasPrefix(validator, "W/") {
+		validator = f.res.Header.Get("Last-Modified")
+	}
+	if validator == "" {
+		return nil, errors.New("httpserver: cannot resume a download without an ETag or Last-Modified")
+	}
+
+	req, err := http.NewRequest(http.MethodGet, f.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	req.Header.Set("If-Range", validator)
+
+	res, err := f.server.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusPartialContent ||
+		!strings.HasPrefix(res.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)) {
+		res.Body.Close()
+		return nil, fmt.Errorf("%s: unexpected status %q", f.URL, res.Status)
+	}
+	return res, nil
+}
+
+// bodyReader records the error of reading a response body, telling it apart from errors writing it.
+type bodyReader struct {
+	r   io.Reader
+	err error
+}
+
+func (r *bodyReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+// Seek only supports rewinding to the start of the file so that a failed transfer can be retried.
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekStart {
+		return 0, errors.New("httpserver: can only seek to the start of a file")
+	}
+	return 0, f.Close()
+}
+
+func (f *file) Close() error {
+	if f.res == nil {
+		return nil
+	}
+
+	err := f.res.Body.Close()
+	f.res = nil
+	return err
+}
+
+// deltaFile is a file that can be transferred as a delta from an older version of it.
+type deltaFile struct {
+	*file
+}
+
+// Unchanged sends a conditional HEAD request for the mod, so that a changed mod can still be transferred
+// as a delta while an unchanged one is not transferred at all.
+func (f *deltaFile) Unchanged(sum string) (bool, error) {
+	res, err := f.server.conditional(http.MethodHead, f.URL, sum)
+	if err != nil {
+		return false, err
+	}
+	if res == nil {
+		return true, nil
+	}
+	res.Body.Close()
+	return false, nil
+}
+
+func (f *deltaFile) WriteDelta(w io.Writer, basis io.ReaderAt, size int64) (int64, error) {
+	return delta.Get(f.server.client, f.URL, w, basis, size)
+}
+
+type fileInfo struct {
+	mod
+}
+
+func (i fileInfo) Name() string       { return i.mod.Name }
+func (i fileInfo) Size() int64        { return *i.mod.Size }
+func (i fileInfo) Mode() os.FileMode  { return 0644 }
+func (i fileInfo) ModTime() time.Time { return i.modTime }
+func (i fileInfo) IsDir() bool        { return false }
+func (i fileInfo) Sys() interface{}   { return nil }
+
+var (
+	_ fync.ListServer      = (*Server)(nil)
+	_ fync.WatchServer     = (*Server)(nil)
+	_ fync.HashedFile      = (*file)(nil)
+	_ fync.DeltaFile       = (*deltaFile)(nil)
+	_ fync.ConditionalFile = (*file)(nil)
+)
@@ -0,0 +1,40 @@
+package httpserver_test
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/han-tyumi/fync"
+	"github.com/han-tyumi/fync/fynctest"
+	"github.com/han-tyumi/fync/httpserver"
+	"github.com/han-tyumi/fync/sourcetest"
+)
+
+func TestConformance(t *testing.T) {
+	sourcetest.Conformance(t, fynctest.HTTP)
+}
+
+func TestHandlerConformance(t *testing.T) {
+	sourcetest.Conformance(t, newHandlerServer)
+}
+
+// newHandlerServer returns a Server for the mods as served by fync.Handler.
+func newHandlerServer(t testing.TB, mods map[string]string) fync.Server {
+	dir := t.TempDir()
+	for name, data := range mods {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	host := httptest.NewServer(fync.Handler(dir, nil))
+	t.Cleanup(host.Close)
+
+	s, err := httpserver.New(host.URL, &httpserver.Options{Client: host.Client()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
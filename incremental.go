@@ -0,0 +1,119 @@
+package fync
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"sort"
+)
+
+// SyncTokenHeader is the header with which a client requests only the changes to the manifest
+// since the one it last fetched, by that manifest's sync token, and with which a host
+// supporting CapabilityIncremental sends the sync token of its current manifest.
+const SyncTokenHeader = "Fync-Sync-Token"
+
+// manifestCacheName is the name of the file within the mods directory caching the manifest last fetched,
+// so that later syncs request only the changes to it.
+const manifestCacheName = ".fync-manifest.json"
+
+// ManifestCachePath returns the default location of the cached manifest, within the mods directory.
+func ManifestCachePath() (string, error) {
+	return filepath.Join(modsDir, manifestCacheName), dirErr
+}
+
+// ManifestToken returns the sync token of a whole manifest, which only changes when its mods do,
+// regardless of the order they are listed in. Hosts and clients computing the token of the same
+// manifest agree on it, so that a client can check that changes applied by PatchManifest
+// left it with the host's manifest.
+func ManifestToken(m *Manifest) string {
+	whole := *m
+	whole.Since, whole.Removed = "", nil
+	whole.Mods = append([]ManifestMod(nil), m.Mods...)
+	sort.Slice(whole.Mods, func(i, j int) bool {
+		return whole.Mods[i].Name < whole.Mods[j].Name
+	})
+
+	data, _ := json.Marshal(&whole)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:16])
+}
+
+// DiffManifests returns the changes from the old manifest to the new one, which lists the mods
+// that were added or changed and names those that were removed.
+func DiffManifests(old, new *Manifest) *Manifest {
+	d := &Manifest{
+		SchemaVersion:      new.SchemaVersion,
+		MinProtocolVersion: new.MinProtocolVersion,
+		Mods:               []ManifestMod{},
+		Since:              ManifestToken(old),
+	}
+
+	before := make(map[string]ManifestMod, len(old.Mods))
+	for _, mod := range old.Mods {
+		before[mod.Name] = mod
+	}
+
+	for _, mod := range new.Mods {
+		if prev, ok := before[mod.Name]; !ok || !sameMod(prev, mod) {
+			d.Mods = append(d.Mods, mod)
+		}
+		delete(before, mod.Name)
+	}
+
+	for _, mod := range old.Mods {
+		if _, ok := before[mod.Name]; ok {
+			d.Removed = append(d.Removed, mod.Name)
+		}
+	}
+	return d
+}
+
+// PatchManifest returns the manifest resulting from applying the changes to the base manifest,
+// which must be the manifest the changes are since. Changed mods keep their place,
+// while added ones are listed after the rest.
+func PatchManifest(base, changes *Manifest) (*Manifest, error) {
+	if changes.Since == "" {
+		return nil, errors.New("manifest does not list changes")
+	}
+	if changes.Since != ManifestToken(base) {
+		return nil, errors.New("manifest changes are not to the base manifest")
+	}
+
+	changed := make(map[string]ManifestMod, len(changes.Mods))
+	for _, mod := range changes.Mods {
+		changed[mod.Name] = mod
+	}
+	removed := make(map[string]bool, len(changes.Removed))
+	for _, name := range changes.Removed {
+		removed[name] = true
+	}
+
+	m := &Manifest{SchemaVersion: changes.SchemaVersion, MinProtocolVersion: changes.MinProtocolVersion}
+	for _, mod := range base.Mods {
+		if removed[mod.Name] {
+			continue
+		}
+		if c, ok := changed[mod.Name]; ok {
+			mod = c
+			delete(changed, mod.Name)
+		}
+		m.Mods = append(m.Mods, mod)
+	}
+	for _, mod := range changes.Mods {
+		if _, ok := changed[mod.Name]; ok {
+			m.Mods = append(m.Mods, mod)
+		}
+	}
+	return m, nil
+}
+
+// sameMod reports whether two manifest entries describe a mod the same way.
+func sameMod(a, b ManifestMod) bool {
+	if (a.Size == nil) != (b.Size == nil) || a.Size != nil && *a.Size != *b.Size {
+		return false
+	}
+	a.Size, b.Size = nil, nil
+	return a == b
+}
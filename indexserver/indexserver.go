@@ -0,0 +1,593 @@
+// Package indexserver implements a fync.Server for mods exposed through a plain
+// HTTP directory listing, such as Apache or nginx autoindex pages and Caddy's file_server.
+package indexserver
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/han-tyumi/fync"
+)
+
+// Options contains options for the New function.
+type Options struct {
+	// The HTTP client used for all requests. Defaults to a client
+	// keeping enough idle connections open for the chosen Concurrency.
+	Client *http.Client
+
+	// Maximum number of concurrent HEAD requests used to gather the sizes
+	// of mods that the listing does not provide exactly. Defaults to 8.
+	Concurrency int
+}
+
+// Server is a fync.Server that lists mods from a directory index page.
+type Server struct {
+	base        *url.URL
+	client      *http.Client
+	concurrency int
+
+	statsMu sync.Mutex
+	stats   Stats
+
+	mu      sync.Mutex
+	entries []entry
+}
+
+type entry struct {
+	name    string
+	url     string
+	size    int64
+	modTime time.Time
+	etag    string
+}
+
+// New returns a Server for the directory listing at the given URL.
+func New(indexURL string, o *Options) (*Server, error) {
+	base, err := url.Parse(indexURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported URL scheme %q", base.Scheme)
+	}
+
+	// links in the listing are relative to the directory
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path += "/"
+	}
+
+	s := &Server{base: base, concurrency: 8}
+	if o != nil {
+		s.client = o.Client
+		if o.Concurrency > 0 {
+			s.concurrency = o.Concurrency
+		}
+	}
+
+	if s.client == nil {
+		// reuse connections across concurrent requests instead of churning through new ones
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.MaxIdleConnsPerHost = s.concurrency
+		s.client = &http.Client{Transport: transport}
+	}
+	return s, nil
+}
+
+// Mods returns a slice of mod ServerFiles for each jar in the directory listing.
+func (s *Server) Mods() ([]fync.ServerFile, error) {
+	entries, err := s.list()
+	if err != nil {
+		return nil, err
+	}
+
+	var mods []fync.ServerFile
+	for _, e := range entries {
+		if strings.HasSuffix(e.name, ".jar") {
+			mods = append(mods, &file{entry: e, server: s})
+		}
+	}
+	return mods, nil
+}
+
+// List returns a reference to each jar in the directory listing, keyed by its URL.
+// Mods whose size the listing did not report are probed so that it is exact.
+func (s *Server) List() ([]fync.ModRef, error) {
+	mods, err := s.Mods()
+	if err != nil {
+		return nil, err
+	}
+
+	refs := make([]fync.ModRef, len(mods))
+	for i, mod := range mods {
+		info, err := mod.Stat()
+		if err != nil {
+			return nil, err
+		}
+		refs[i] = fync.ModRef{Info: info, Key: mod.(*file).url}
+	}
+	return refs, nil
+}
+
+// Open returns a ServerFile for the listed mod, which is downloaded once written.
+func (s *Server) Open(ref fync.ModRef) (fync.ServerFile, error) {
+	entries, err := s.list()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range entries {
+		if e.url == ref.Key {
+			return &file{entry: e, server: s}, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: not in directory listing", ref.Key)
+}
+
+// String returns the URL of the directory listing.
+func (s *Server) String() string {
+	return s.base.String()
+}
+
+// Checksums returns the checksums from a sha256sums.txt file or .sha256 sidecar files
+// found in the directory listing. An empty map is returned when neither exist.
+func (s *Server) Checksums() (map[string]string, error) {
+	entries, err := s.list()
+	if err != nil {
+		return nil, err
+	}
+
+	sidecars := make(map[string]string)
+	for _, e := range entries {
+		if strings.EqualFold(e.name, "sha256sums.txt") {
+			return s.fetchChecksums(e.url, "")
+		}
+		if strings.HasSuffix(e.name, ".jar.sha256") {
+			sidecars[strings.TrimSuffix(e.name, ".sha256")] = e.url
+		}
+	}
+
+	sums := make(map[string]string)
+	for name, u := range sidecars {
+		sidecar, err := s.fetchChecksums(u, name)
+		if err != nil {
+			return nil, err
+		}
+
+		if sum, ok := sidecar[name]; ok {
+			sums[name] = sum
+		}
+	}
+	return sums, nil
+}
+
+// fetchChecksums fetches and parses a checksums file, using name for
+// sidecar files that contain only a bare checksum.
+func (s *Server) fetchChecksums(u, name string) (map[string]string, error) {
+	res, err := s.get(u, "")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	text := strings.TrimSpace(string(data))
+	if name != "" && !strings.ContainsAny(text, " \t\n") {
+		text += "  " + name
+	}
+	return fync.ParseChecksums(strings.NewReader(text))
+}
+
+// list fetches and parses the directory listing once, caching the result.
+func (s *Server) list() ([]entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.entries != nil {
+		return s.entries, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, s.base.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Caddy's file_server responds with a JSON listing when asked
+	req.Header.Set("Accept", "application/json, text/html;q=0.9")
+
+	res, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: unexpected status %q", s.base, res.Status)
+	}
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []entry
+	mediaType, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if mediaType == "application/json" {
+		entries, err = s.parseJSON(data)
+	} else {
+		entries, err = s.parseHTML(string(data))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if entries == nil {
+		entries = []entry{}
+	}
+	s.prefetch(entries)
+	s.entries = entries
+	return entries, nil
+}
+
+func (s *Server) parseJSON(data []byte) ([]entry, error) {
+	var listing []struct {
+		Name    string    `json:"name"`
+		Size    int64     `json:"size"`
+		URL     string    `json:"url"`
+		ModTime time.Time `json:"mod_time"`
+		IsDir   bool      `json:"is_dir"`
+	}
+
+	if err := json.Unmarshal(data, &listing); err != nil {
+		return nil, err
+	}
+
+	var entries []entry
+	for _, l := range listing {
+		if l.IsDir {
+			continue
+		}
+
+		ref := l.URL
+		if ref == "" {
+			ref = url.PathEscape(l.Name)
+		}
+
+		u, ok := s.resolve(ref)
+		if !ok {
+			continue
+		}
+
+		entries = append(entries, entry{name: l.Name, url: u, size: l.Size, modTime: l.ModTime})
+	}
+	return entries, nil
+}
+
+var (
+	linkPattern = regexp.MustCompile(`(?is)<a\s[^>]*?href\s*=\s*["']([^"']+)["'][^>]*>.*?</a>`)
+	tagPattern  = regexp.MustCompile(`(?s)<[^>]*>`)
+
+	// nginx: "01-May-2024 12:30    123456"
+	nginxPattern = regexp.MustCompile(`^(\d{2}-[A-Za-z]{3}-\d{4} \d{2}:\d{2})\s+(\d+|-)`)
+
+	// Apache: "2024-05-01 12:30  1.2M"
+	apachePattern = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2} \d{2}:\d{2})(?::\d{2})?\s+(\S+)`)
+)
+
+func (s *Server) parseHTML(page string) ([]entry, error) {
+	var entries []entry
+
+	links := linkPattern.FindAllStringSubmatchIndex(page, -1)
+	for i, link := range links {
+		u, ok := s.resolve(page[link[2]:link[3]])
+		if !ok {
+			continue
+		}
+
+		parsed, err := url.Parse(u)
+		if err != nil {
+			continue
+		}
+		name := path.Base(parsed.Path)
+
+		// the details of an entry are between its link and the next
+		end := len(page)
+		if i+1 < len(links) {
+			end = links[i+1][0]
+		}
+		details := tagPattern.ReplaceAllString(page[link[1]:end], " ")
+		details = strings.Join(strings.Fields(details), " ")
+
+		e := entry{name: name, url: u, size: -1}
+		if m := nginxPattern.FindStringSubmatch(details); m != nil {
+			e.modTime, _ = time.Parse("02-Jan-2006 15:04", m[1])
+			e.size = parseSize(m[2])
+		} else if m := apachePattern.FindStringSubmatch(details); m != nil {
+			e.modTime, _ = time.Parse("2006-01-02 15:04", m[1])
+			e.size = parseSize(m[2])
+		}
+
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// prefetch issues concurrent HEAD requests for jar entries without an exact size
+// so that they can be compared without downloading them. Entries whose request fails
+// are left for Stat to determine by beginning the download instead.
+func (s *Server) prefetch(entries []entry) {
+	sem := make(chan struct{}, s.concurrency)
+	var wg sync.WaitGroup
+
+	for i := range entries {
+		e := &entries[i]
+		if e.size >= 0 || !strings.HasSuffix(e.name, ".jar") {
+			continue
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			req, err := http.NewRequest(http.MethodHead, e.url, nil)
+			if err != nil {
+				return
+			}
+
+			res, err := s.do(req)
+			if err != nil {
+				return
+			}
+			res.Body.Close()
+
+			if res.StatusCode != http.StatusOK || res.ContentLength < 0 {
+				return
+			}
+
+			e.size = res.ContentLength
+			e.etag = res.Header.Get("ETag")
+			if modTime, err := http.ParseTime(res.Header.Get("Last-Modified")); err == nil {
+				e.modTime = modTime
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+// parseSize returns the exact byte size listed, or -1 when the
+// listing only provides an approximate size such as "1.2M".
+func parseSize(s string) int64 {
+	size, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return size
+}
+
+// resolve returns the absolute URL of a listing link
+// and whether it refers to a file directly within the listed directory.
+func (s *Server) resolve(ref string) (string, bool) {
+	u, err := s.base.Parse(ref)
+	if err != nil {
+		return "", false
+	}
+
+	dir := path.Dir(u.Path)
+	if !strings.HasSuffix(dir, "/") {
+		dir += "/"
+	}
+
+	// skip sorting links, parent directories, subdirectories, and other hosts
+	if u.RawQuery != "" || u.Host != s.base.Host || dir != s.base.Path || strings.HasSuffix(u.Path, "/") {
+		return "", false
+	}
+
+	u.Fragment = ""
+	return u.String(), true
+}
+
+// file is a fync.ServerFile that downloads a mod from the directory listing.
+type file struct {
+	entry
+	server *Server
+	res    *http.Response
+}
+
+// String returns the URL the mod is downloaded from.
+func (f *file) String() string {
+	return f.url
+}
+
+func (f *file) Stat() (os.FileInfo, error) {
+	if f.size < 0 {
+		// the listing only had an approximate size, so begin the download to find out,
+		// closing it straight away so the connection isn't held until the mod is written
+		if err := f.open(); err != nil {
+			return nil, err
+		}
+
+		size := f.res.ContentLength
+		f.Close()
+
+		if size < 0 {
+			return nil, fmt.Errorf("%s: unknown size", f.url)
+		}
+		f.size = size
+	}
+
+	return fileInfo{f.entry}, nil
+}
+
+func (f *file) WriteTo(w io.Writer) (int64, error) {
+	if err := f.open(); err != nil {
+		return 0, err
+	}
+
+	defer func() {
+		f.res.Body.Close()
+		f.res = nil
+	}()
+	return io.Copy(w, f.res.Body)
+}
+
+// Seek only supports rewinding to the start of the file so that a failed transfer can be retried.
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekStart {
+		return 0, errors.New("indexserver: can only seek to the start of a file")
+	}
+	return 0, f.Close()
+}
+
+func (f *file) Close() error {
+	if f.res == nil {
+		return nil
+	}
+
+	err := f.res.Body.Close()
+	f.res = nil
+	return err
+}
+
+func (f *file) open() error {
+	if f.res != nil {
+		return nil
+	}
+
+	res, err := f.server.get(f.url, f.etag)
+	if err != nil {
+		return err
+	}
+
+	f.res = res
+	return nil
+}
+
+// get requests the resource at u. When etag is not empty the request
+// fails if the resource has changed since its metadata was prefetched.
+func (s *Server) get(u, etag string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if etag != "" {
+		req.Header.Set("If-Match", etag)
+	}
+
+	res, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode == http.StatusPreconditionFailed {
+		res.Body.Close()
+		return nil, fmt.Errorf("%s: changed since it was listed", u)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("%s: unexpected status %q", u, res.Status)
+	}
+	return res, nil
+}
+
+// Stats contains connection statistics for the requests made by a Server.
+type Stats struct {
+	// Number of requests made.
+	Requests int
+
+	// Number of requests that opened a new connection or reused an idle one.
+	NewConns, ReusedConns int
+
+	// Total time spent resolving host names, connecting, and performing TLS handshakes.
+	DNSTime, ConnectTime, TLSTime time.Duration
+}
+
+func (st Stats) String() string {
+	return fmt.Sprintf("%d requests, %d new connections, %d reused connections, dns %s, connect %s, tls %s",
+		st.Requests, st.NewConns, st.ReusedConns, st.DNSTime, st.ConnectTime, st.TLSTime)
+}
+
+// Stats returns the connection statistics of the requests made so far.
+func (s *Server) Stats() Stats {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	return s.stats
+}
+
+// do sends req while recording its connection statistics.
+func (s *Server) do(req *http.Request) (*http.Response, error) {
+	var dnsStart, connectStart, tlsStart time.Time
+	record := func(f func(st *Stats)) {
+		s.statsMu.Lock()
+		f(&s.stats)
+		s.statsMu.Unlock()
+	}
+
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			record(func(st *Stats) {
+				st.Requests++
+				if info.Reused {
+					st.ReusedConns++
+				} else {
+					st.NewConns++
+				}
+			})
+		},
+		DNSStart: func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			record(func(st *Stats) { st.DNSTime += time.Since(dnsStart) })
+		},
+		ConnectStart: func(_, _ string) { connectStart = time.Now() },
+		ConnectDone: func(_, _ string, _ error) {
+			record(func(st *Stats) { st.ConnectTime += time.Since(connectStart) })
+		},
+		TLSHandshakeStart: func() { tlsStart = time.Now() },
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			record(func(st *Stats) { st.TLSTime += time.Since(tlsStart) })
+		},
+	}
+
+	return s.client.Do(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+}
+
+type fileInfo struct {
+	entry
+}
+
+func (i fileInfo) Name() string       { return i.name }
+func (i fileInfo) Size() int64        { return i.size }
+func (i fileInfo) Mode() os.FileMode  { return 0644 }
+func (i fileInfo) ModTime() time.Time { return i.modTime }
+func (i fileInfo) IsDir() bool        { return false }
+func (i fileInfo) Sys() interface{}   { return nil }
+
+var (
+	_ fync.ChecksumServer = (*Server)(nil)
+	_ fync.ListServer     = (*Server)(nil)
+)
@@ -0,0 +1,164 @@
+package ipfsserver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/han-tyumi/fync"
+)
+
+// Codecs and hash functions of the CIDs that can be verified.
+const (
+	codecRaw     = 0x55
+	codecDagPB   = 0x70
+	hashSHA256   = 0x12
+	hashIdentity = 0x00
+)
+
+// base58Alphabet is the alphabet of the base58btc encoding of CIDs.
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// base32Lower is the multibase base32 encoding CIDv1 are written in by default.
+var base32Lower = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// cid is the binary form of a content identifier.
+type cid []byte
+
+// parseCID parses a CIDv0 or a CIDv1 encoded as base32, base58btc, or base16.
+func parseCID(s string) (cid, error) {
+	var data []byte
+	var err error
+	switch {
+	case len(s) == 46 && strings.HasPrefix(s, "Qm"):
+		data, err = base58Decode(s)
+	case strings.HasPrefix(s, "b"):
+		data, err = base32Lower.DecodeString(s[1:])
+	case strings.HasPrefix(s, "B"):
+		data, err = base32Lower.DecodeString(strings.ToLower(s[1:]))
+	case strings.HasPrefix(s, "z"):
+		data, err = base58Decode(s[1:])
+	case strings.HasPrefix(s, "f"):
+		data, err = hex.DecodeString(s[1:])
+	default:
+		err = errors.New("unsupported encoding")
+	}
+	if err == nil {
+		_, _, _, err = cid(data).parts()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ipfsserver: invalid CID %q: %v", s, err)
+	}
+	return data, nil
+}
+
+// String returns the CID as it is written in IPFS paths: base58btc for CIDv0 and base32 for CIDv1.
+func (c cid) String() string {
+	if c.v0() {
+		return base58Encode(c)
+	}
+	return "b" + base32Lower.EncodeToString(c)
+}
+
+// v0 returns whether the CID is a CIDv0, which is a bare SHA-256 multihash of a dag-pb block.
+func (c cid) v0() bool {
+	return len(c) == 34 && c[0] == hashSHA256 && c[1] == 32
+}
+
+// parts returns the codec of the CID and the function and digest of its multihash.
+func (c cid) parts() (codec, hash uint64, digest []byte, err error) {
+	data := []byte(c)
+	if c.v0() {
+		return codecDagPB, hashSHA256, data[2:], nil
+	}
+
+	var fields [4]uint64
+	for i := range fields {
+		v, n := binary.Uvarint(data)
+		if n <= 0 {
+			return 0, 0, nil, errors.New("truncated")
+		}
+		fields[i], data = v, data[n:]
+	}
+	if fields[0] != 1 {
+		return 0, 0, nil, fmt.Errorf("unsupported version %d", fields[0])
+	}
+	if uint64(len(data)) != fields[3] {
+		return 0, 0, nil, errors.New("invalid digest length")
+	}
+	return fields[1], fields[2], data, nil
+}
+
+// verify returns a *fync.VerificationError unless the block is the one the CID identifies.
+// Its path is left for the caller to fill in.
+func (c cid) verify(block []byte) error {
+	_, hash, digest, err := c.parts()
+	if err != nil {
+		return err
+	}
+
+	var actual []byte
+	switch hash {
+	case hashSHA256:
+		sum := sha256.Sum256(block)
+		actual = sum[:]
+	case hashIdentity:
+		actual = block
+	default:
+		return fmt.Errorf("ipfsserver: %s: unsupported hash function 0x%x", c, hash)
+	}
+
+	if !bytes.Equal(actual, digest) {
+		got := append(append(cid(nil), c[:len(c)-len(digest)]...), actual...)
+		return &fync.VerificationError{Field: "block", Expected: c.String(), Actual: got.String()}
+	}
+	return nil
+}
+
+// base58Decode decodes a base58btc string.
+func base58Decode(s string) ([]byte, error) {
+	n := new(big.Int)
+	radix := big.NewInt(58)
+	for _, r := range s {
+		i := strings.IndexRune(base58Alphabet, r)
+		if i < 0 {
+			return nil, errors.New("invalid base58 character")
+		}
+		n.Mul(n, radix)
+		n.Add(n, big.NewInt(int64(i)))
+	}
+
+	// leading zeros are encoded as leading ones
+	zeros := len(s) - len(strings.TrimLeft(s, "1"))
+	return append(make([]byte, zeros), n.Bytes()...), nil
+}
+
+// base58Encode encodes data as base58btc.
+func base58Encode(data []byte) string {
+	n := new(big.Int).SetBytes(data)
+	radix := big.NewInt(58)
+	mod := new(big.Int)
+
+	var b []byte
+	for n.Sign() > 0 {
+		n.DivMod(n, radix, mod)
+		b = append(b, base58Alphabet[mod.Int64()])
+	}
+	for _, c := range data {
+		if c != 0 {
+			break
+		}
+		b = append(b, '1')
+	}
+
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return string(b)
+}
@@ -0,0 +1,410 @@
+// Package ipfsserver implements a fync.Server for mods within an IPFS directory, identified by its CID,
+// so that a pack can be mirrored by anyone pinning it while remaining verifiable by its CID alone.
+//
+// Directories are read either from a local IPFS node through its RPC API, which verifies what it fetches
+// itself, or from a gateway. Gateways are not trusted: only their verifiable responses are used,
+// and every block read from them is verified against its CID before it is used,
+// so a mod that has been tampered with fails to verify however it was obtained.
+package ipfsserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/han-tyumi/fync"
+)
+
+// DefaultGateway is the base URL of the gateway used when neither a gateway nor a node is chosen.
+const DefaultGateway = "https://trustless-gateway.link"
+
+// fetchConcurrency is the number of blocks fetched at once when listing a directory from a gateway.
+const fetchConcurrency = 8
+
+// Media types of the verifiable responses of gateways.
+const (
+	rawType = "application/vnd.ipld.raw"
+	carType = "application/vnd.ipld.car"
+)
+
+// Options contains options for the New function.
+type Options struct {
+	// Base URL of the RPC API of an IPFS node, such as "http://127.0.0.1:5001", used instead of a gateway.
+	API string
+
+	// Base URL of a gateway supporting verifiable responses, used when no node is chosen.
+	// Defaults to DefaultGateway.
+	Gateway string
+
+	// The HTTP client used for all requests. Defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// Server is a fync.Server that lists mods from an IPFS directory.
+type Server struct {
+	root    cid
+	path    string
+	api     string
+	gateway string
+	client  *http.Client
+}
+
+// New returns a Server for the directory with the given CID, optionally followed by a path within it,
+// such as "bafy.../mods", "/ipfs/bafy.../mods", or "ipfs://bafy.../mods".
+func New(location string, o *Options) (*Server, error) {
+	if o == nil {
+		o = &Options{}
+	}
+
+	p := strings.TrimPrefix(location, "ipfs://")
+	p = strings.TrimPrefix(strings.TrimPrefix(p, "/"), "ipfs/")
+	elems := strings.SplitN(p, "/", 2)
+
+	root, err := parseCID(elems[0])
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		root:    root,
+		api:     strings.TrimSuffix(o.API, "/"),
+		gateway: strings.TrimSuffix(o.Gateway, "/"),
+		client:  o.Client,
+	}
+	if len(elems) > 1 {
+		s.path = strings.Trim(path.Clean("/"+elems[1]), "/")
+	}
+	if s.gateway == "" {
+		s.gateway = DefaultGateway
+	}
+	if s.client == nil {
+		s.client = http.DefaultClient
+	}
+	return s, nil
+}
+
+// String returns the IPFS path of the directory.
+func (s *Server) String() string {
+	if s.path == "" {
+		return "/ipfs/" + s.root.String()
+	}
+	return "/ipfs/" + s.root.String() + "/" + s.path
+}
+
+// Mods returns a slice of mod ServerFiles for each jar within the directory.
+// Mods are not downloaded until they are written.
+func (s *Server) Mods() ([]fync.ServerFile, error) {
+	if s.api != "" {
+		return s.nodeMods()
+	}
+	return s.gatewayMods()
+}
+
+// nodeMods lists the directory using the node.
+func (s *Server) nodeMods() ([]fync.ServerFile, error) {
+	res, err := s.call("ls", s.String())
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var result struct {
+		Objects []struct {
+			Links []struct {
+				Name string `json:"Name"`
+				Hash string `json:"Hash"`
+				Size int64  `json:"Size"`
+				Type int    `json:"Type"`
+			} `json:"Links"`
+		} `json:"Objects"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("%s: %w", s, err)
+	}
+
+	var mods []fync.ServerFile
+	for _, object := range result.Objects {
+		for _, l := range object.Links {
+			if l.Type != unixfsFile || !strings.HasSuffix(l.Name, ".jar") {
+				continue
+			}
+
+			c, err := parseCID(l.Hash)
+			if err != nil {
+				return nil, err
+			}
+			mods = append(mods, &file{server: s, cid: c, name: l.Name, size: l.Size})
+		}
+	}
+	return mods, nil
+}
+
+// call calls a command of the node's RPC API with the given argument.
+func (s *Server) call(command, arg string) (*http.Response, error) {
+	u := s.api + "/api/v0/" + command + "?" + url.Values{"arg": {arg}}.Encode()
+	res, err := s.client.Post(u, "", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		defer res.Body.Close()
+
+		var e struct {
+			Message string `json:"Message"`
+		}
+		if json.NewDecoder(io.LimitReader(res.Body, 1<<16)).Decode(&e) == nil && e.Message != "" {
+			return nil, fmt.Errorf("%s: %s", s, e.Message)
+		}
+		return nil, fmt.Errorf("%s: unexpected status %q", u, res.Status)
+	}
+	return res, nil
+}
+
+// gatewayMods lists the directory from verified blocks fetched from the gateway,
+// fetching the root block of each mod to learn its size.
+func (s *Server) gatewayMods() ([]fync.ServerFile, error) {
+	dir, err := s.block(s.root)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.path != "" {
+		for _, elem := range strings.Split(s.path, "/") {
+			if dir, err = s.child(dir, elem); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := s.checkDir(dir); err != nil {
+		return nil, err
+	}
+
+	var links []link
+	for _, l := range dir.links {
+		if strings.HasSuffix(l.name, ".jar") {
+			links = append(links, l)
+		}
+	}
+
+	mods := make([]*file, len(links))
+	errs := make([]error, len(links))
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < fetchConcurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				mods[i], errs[i] = s.mod(links[i])
+			}
+		}()
+	}
+	for i := range links {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	var files []fync.ServerFile
+	for i := range mods {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		if mods[i] != nil {
+			files = append(files, mods[i])
+		}
+	}
+	return files, nil
+}
+
+// child returns the directory linked to from dir by the given name.
+func (s *Server) child(dir *node, name string) (*node, error) {
+	if err := s.checkDir(dir); err != nil {
+		return nil, err
+	}
+
+	for _, l := range dir.links {
+		if l.name == name {
+			return s.block(l.cid)
+		}
+	}
+	return nil, fmt.Errorf("%s: no %s directory", s, name)
+}
+
+// checkDir returns an error unless the node is a directory that can be listed.
+func (s *Server) checkDir(n *node) error {
+	switch n.typ {
+	case unixfsDirectory:
+		return nil
+	case unixfsHAMTShard:
+		return fmt.Errorf("%s: sharded directories can only be read using a node", s)
+	}
+	return fmt.Errorf("%s: not a directory", s)
+}
+
+// mod returns the mod linked to from the directory, or nil if it is not a file.
+func (s *Server) mod(l link) (*file, error) {
+	n, err := s.block(l.cid)
+	if err != nil {
+		return nil, err
+	}
+	if n.typ != unixfsFile && n.typ != unixfsRaw {
+		return nil, nil
+	}
+	return &file{server: s, cid: l.cid, name: l.name, size: int64(n.filesize), modTime: n.mtime}, nil
+}
+
+// block fetches and decodes the block with the given CID from the gateway.
+func (s *Server) block(c cid) (*node, error) {
+	// identity CIDs contain their block
+	if _, hash, digest, err := c.parts(); err != nil {
+		return nil, err
+	} else if hash == hashIdentity {
+		return decodeBlock(c, digest)
+	}
+
+	res, err := s.get(c, rawType)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	block, err := ioutil.ReadAll(io.LimitReader(res.Body, maxBlockSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(block) > maxBlockSize {
+		return nil, fmt.Errorf("%s: block %s is too large", s, c)
+	}
+
+	n, err := decodeBlock(c, block)
+	if e, ok := err.(*fync.VerificationError); ok {
+		e.Path = s.gateway + "/ipfs/" + c.String()
+	}
+	return n, err
+}
+
+// get requests a verifiable response of the given media type for the CID from the gateway.
+func (s *Server) get(c cid, mediaType string) (*http.Response, error) {
+	u := s.gateway + "/ipfs/" + c.String()
+	accept := mediaType
+	if mediaType == carType {
+		// blocks must be in the order they are written, repeating any that are used more than once
+		u += "?dag-scope=entity"
+		accept += "; version=1; order=dfs; dups=y"
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("%s: unexpected status %q", u, res.Status)
+	}
+	if t := res.Header.Get("Content-Type"); !strings.HasPrefix(t, mediaType) {
+		res.Body.Close()
+		return nil, fmt.Errorf("%s: gateway does not support verifiable responses", s.gateway)
+	}
+	return res, nil
+}
+
+// file is a fync.ServerFile that downloads a mod from the directory.
+type file struct {
+	server  *Server
+	cid     cid
+	name    string
+	size    int64
+	modTime time.Time
+	res     *http.Response
+}
+
+// String returns the IPFS path of the mod.
+func (f *file) String() string {
+	return f.server.String() + "/" + f.name
+}
+
+func (f *file) Stat() (os.FileInfo, error) {
+	return fileInfo{f.name, f.size, f.modTime}, nil
+}
+
+// WriteTo downloads the mod. Mods downloaded from a gateway fail as soon as a block of them
+// does not match its CID.
+func (f *file) WriteTo(w io.Writer) (int64, error) {
+	if f.res == nil {
+		var res *http.Response
+		var err error
+		if f.server.api != "" {
+			res, err = f.server.call("cat", "/ipfs/"+f.cid.String())
+		} else {
+			res, err = f.server.get(f.cid, carType)
+		}
+		if err != nil {
+			return 0, err
+		}
+		f.res = res
+	}
+
+	defer f.Close()
+
+	if f.server.api != "" {
+		return io.Copy(w, f.res.Body)
+	}
+
+	n, err := writeCAR(w, f.res.Body, f.cid)
+	if e, ok := err.(*fync.VerificationError); ok {
+		e.Path = f.String()
+	}
+	return n, err
+}
+
+// Seek only supports rewinding to the start of the file so that a failed transfer can be retried.
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekStart {
+		return 0, errors.New("ipfsserver: can only seek to the start of a file")
+	}
+	return 0, f.Close()
+}
+
+func (f *file) Close() error {
+	if f.res == nil {
+		return nil
+	}
+
+	err := f.res.Body.Close()
+	f.res = nil
+	return err
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (i fileInfo) Name() string       { return i.name }
+func (i fileInfo) Size() int64        { return i.size }
+func (i fileInfo) Mode() os.FileMode  { return 0644 }
+func (i fileInfo) ModTime() time.Time { return i.modTime }
+func (i fileInfo) IsDir() bool        { return false }
+func (i fileInfo) Sys() interface{}   { return nil }
+
+var _ fync.Server = (*Server)(nil)
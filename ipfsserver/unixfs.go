@@ -0,0 +1,232 @@
+package ipfsserver
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// Types of UnixFS nodes.
+const (
+	unixfsRaw       = 0
+	unixfsDirectory = 1
+	unixfsFile      = 2
+	unixfsHAMTShard = 5
+)
+
+// maxBlockSize is the size of the largest block that is accepted, which is larger than IPFS itself allows.
+const maxBlockSize = 4 << 20
+
+// errProtobuf is returned when a dag-pb block is not validly encoded.
+var errProtobuf = errors.New("ipfsserver: invalid dag-pb block")
+
+// link is a named link from a dag-pb node to another block.
+type link struct {
+	cid  cid
+	name string
+}
+
+// node is a decoded block: either raw data or a dag-pb node holding UnixFS data.
+type node struct {
+	links    []link
+	typ      uint64
+	data     []byte
+	filesize uint64
+	mtime    time.Time
+}
+
+// decodeBlock verifies the block against its CID and decodes it.
+func decodeBlock(c cid, block []byte) (*node, error) {
+	if err := c.verify(block); err != nil {
+		return nil, err
+	}
+
+	codec, _, _, err := c.parts()
+	if err != nil {
+		return nil, err
+	}
+	switch codec {
+	case codecRaw:
+		return &node{typ: unixfsRaw, data: block, filesize: uint64(len(block))}, nil
+	case codecDagPB:
+	default:
+		return nil, fmt.Errorf("ipfsserver: %s: unsupported codec 0x%x", c, codec)
+	}
+
+	n := &node{}
+	var unixfs []byte
+	err = fields(block, func(num int, v uint64, b []byte) error {
+		switch num {
+		case 1:
+			unixfs = b
+		case 2:
+			var l link
+			err := fields(b, func(num int, v uint64, b []byte) error {
+				switch num {
+				case 1:
+					l.cid = append(cid(nil), b...)
+				case 2:
+					l.name = string(b)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			n.links = append(n.links, l)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = fields(unixfs, func(num int, v uint64, b []byte) error {
+		switch num {
+		case 1:
+			n.typ = v
+		case 2:
+			n.data = b
+		case 3:
+			n.filesize = v
+		case 8:
+			return fields(b, func(num int, v uint64, b []byte) error {
+				if num == 1 {
+					n.mtime = time.Unix(int64(v), 0)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	return n, err
+}
+
+// fields calls f with the number and value of each field of a protobuf message,
+// with the value as v for varints and as b for length delimited fields.
+func fields(data []byte, f func(num int, v uint64, b []byte) error) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errProtobuf
+		}
+		data = data[n:]
+
+		var v uint64
+		var b []byte
+		switch key & 7 {
+		case 0:
+			if v, n = binary.Uvarint(data); n <= 0 {
+				return errProtobuf
+			}
+			data = data[n:]
+		case 1:
+			if len(data) < 8 {
+				return errProtobuf
+			}
+			v, data = binary.LittleEndian.Uint64(data), data[8:]
+		case 2:
+			l, n := binary.Uvarint(data)
+			if n <= 0 || l > uint64(len(data)-n) {
+				return errProtobuf
+			}
+			b, data = data[n:n+int(l)], data[n+int(l):]
+		case 5:
+			if len(data) < 4 {
+				return errProtobuf
+			}
+			v, data = uint64(binary.LittleEndian.Uint32(data)), data[4:]
+		default:
+			return errProtobuf
+		}
+
+		if err := f(int(key>>3), v, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeCAR writes the contents of the file with the given root from a CAR of its blocks
+// in depth-first order, verifying each block as it is read.
+func writeCAR(w io.Writer, r io.Reader, root cid) (int64, error) {
+	br := bufio.NewReader(r)
+
+	// the header lists the roots of the CAR, which are identified by the blocks themselves
+	l, err := binary.ReadUvarint(br)
+	if err != nil {
+		return 0, err
+	}
+	if l > maxBlockSize {
+		return 0, errors.New("ipfsserver: invalid CAR header")
+	}
+	if _, err := io.CopyN(ioutil.Discard, br, int64(l)); err != nil {
+		return 0, err
+	}
+
+	var written int64
+	stack := []cid{root}
+	for len(stack) > 0 {
+		c := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		// identity CIDs contain their block rather than being followed by it
+		_, hash, digest, err := c.parts()
+		if err != nil {
+			return written, err
+		}
+		block := digest
+		if hash != hashIdentity {
+			if block, err = readSection(br, c); err != nil {
+				return written, err
+			}
+		}
+
+		n, err := decodeBlock(c, block)
+		if err != nil {
+			return written, err
+		}
+		if n.typ != unixfsRaw && n.typ != unixfsFile {
+			return written, fmt.Errorf("ipfsserver: %s: not a file", c)
+		}
+
+		m, err := w.Write(n.data)
+		written += int64(m)
+		if err != nil {
+			return written, err
+		}
+
+		for i := len(n.links) - 1; i >= 0; i-- {
+			stack = append(stack, n.links[i].cid)
+		}
+	}
+	return written, nil
+}
+
+// readSection reads the next section of a CAR, which must contain the block with the given CID.
+func readSection(r *bufio.Reader, c cid) ([]byte, error) {
+	l, err := binary.ReadUvarint(r)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	if l > maxBlockSize || l < uint64(len(c)) {
+		return nil, errors.New("ipfsserver: invalid CAR section")
+	}
+
+	section := make([]byte, l)
+	if _, err := io.ReadFull(r, section); err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(section, c) {
+		return nil, fmt.Errorf("ipfsserver: expected block %s in CAR", c)
+	}
+	return section[len(c):], nil
+}
@@ -0,0 +1,399 @@
+package fync
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math/big"
+	"path"
+	"sort"
+	"strings"
+)
+
+var (
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+)
+
+// jarDigest is a digest algorithm accepted within jar signatures.
+type jarDigest struct {
+	// The name given to the algorithm within jar manifests and signature files, such as "SHA-256".
+	name string
+
+	oid  asn1.ObjectIdentifier
+	hash crypto.Hash
+	new  func() hash.Hash
+}
+
+// jarDigests are the digest algorithms accepted within jar signatures, strongest first.
+// SHA-1, which older jarsigners used, is not accepted since signatures using it can be forged.
+var jarDigests = []jarDigest{
+	{"SHA-512", asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}, crypto.SHA512, sha512.New},
+	{"SHA-384", asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}, crypto.SHA384, sha512.New384},
+	{"SHA-256", asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}, crypto.SHA256, sha256.New},
+}
+
+// errUnsigned is returned by verifyJar for jars without a signature.
+var errUnsigned = errors.New("not signed")
+
+// verifyJar checks that the jar at the given path is signed, as by jarsigner, with the key of one of the signers,
+// and that the signature covers every file within it.
+func verifyJar(jar string, signers []*x509.Certificate) error {
+	r, err := zip.OpenReader(jar)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	// names within META-INF are case-insensitive
+	meta := make(map[string]*zip.File)
+	for _, f := range r.File {
+		if name := strings.ToUpper(f.Name); isSignatureFile(name) || name == "META-INF/MANIFEST.MF" {
+			meta[name] = f
+		}
+	}
+
+	mf, ok := meta["META-INF/MANIFEST.MF"]
+	if !ok {
+		return errUnsigned
+	}
+	manifest, err := readArchived(mf)
+	if err != nil {
+		return err
+	}
+
+	// try the signatures in order, so that which one's error is returned does not vary
+	names := make([]string, 0, len(meta))
+	for name := range meta {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	err = errUnsigned
+	for _, name := range names {
+		f := meta[name]
+		ext := path.Ext(name)
+		if ext != ".RSA" && ext != ".EC" && ext != ".DSA" {
+			continue
+		}
+
+		sf, ok := meta[strings.TrimSuffix(name, ext)+".SF"]
+		if !ok {
+			continue
+		}
+
+		if err = verifySigner(f, sf, manifest, signers); err == nil {
+			return verifyEntries(r.File, manifest)
+		}
+	}
+	return err
+}
+
+// isSignatureFile reports whether the upper cased name is that of a file making up a jar's signature,
+// which is not itself covered by the signature.
+func isSignatureFile(name string) bool {
+	if !strings.HasPrefix(name, "META-INF/") || strings.Contains(name[len("META-INF/"):], "/") {
+		return false
+	}
+
+	switch path.Ext(name) {
+	case ".SF", ".RSA", ".EC", ".DSA":
+		return true
+	}
+	return strings.HasPrefix(name, "META-INF/SIG-")
+}
+
+// verifySigner checks that the signature block signs the signature file with the key of one of the signers,
+// and that the signature file vouches for the whole manifest.
+func verifySigner(block, sf *zip.File, manifest []byte, signers []*x509.Certificate) error {
+	blockData, err := readArchived(block)
+	if err != nil {
+		return err
+	}
+	sfData, err := readArchived(sf)
+	if err != nil {
+		return err
+	}
+
+	cert, err := verifyPKCS7(blockData, sfData)
+	if err != nil {
+		return err
+	}
+
+	trusted := false
+	for _, signer := range signers {
+		if bytes.Equal(signer.RawSubjectPublicKeyInfo, cert.RawSubjectPublicKeyInfo) {
+			trusted = true
+			break
+		}
+	}
+	if !trusted {
+		return fmt.Errorf("signed by untrusted %q", cert.Subject.CommonName)
+	}
+
+	// the manifest itself is vouched for by its digest, which jarsigner always records
+	sections := parseJarManifest(sfData)
+	for _, d := range jarDigests {
+		if want, ok := sections[0][strings.ToLower(d.name+"-Digest-Manifest")]; ok {
+			if !digestMatches(d, bytes.NewReader(manifest), want) {
+				return errors.New("manifest does not match its signature")
+			}
+			return nil
+		}
+	}
+	return errors.New("signature does not cover the manifest with a supported digest")
+}
+
+// verifyEntries checks that every file within the jar besides its signature is listed by the manifest
+// with a matching digest.
+func verifyEntries(files []*zip.File, manifest []byte) error {
+	entries := make(map[string]map[string]string)
+	for _, section := range parseJarManifest(manifest)[1:] {
+		if name, ok := section["name"]; ok {
+			entries[name] = section
+		}
+	}
+
+	for _, f := range files {
+		name := strings.ToUpper(f.Name)
+		if strings.HasSuffix(f.Name, "/") || isSignatureFile(name) || name == "META-INF/MANIFEST.MF" {
+			continue
+		}
+
+		entry, ok := entries[f.Name]
+		if !ok {
+			return fmt.Errorf("%s is not covered by the signature", f.Name)
+		}
+
+		verified := false
+		for _, d := range jarDigests {
+			want, ok := entry[strings.ToLower(d.name+"-Digest")]
+			if !ok {
+				continue
+			}
+
+			r, err := f.Open()
+			if err != nil {
+				return err
+			}
+			verified = digestMatches(d, r, want)
+			r.Close()
+
+			if !verified {
+				return fmt.Errorf("%s does not match its signature", f.Name)
+			}
+			break
+		}
+		if !verified {
+			return fmt.Errorf("%s is not covered by the signature with a supported digest", f.Name)
+		}
+	}
+	return nil
+}
+
+// digestMatches reports whether the digest of r is the base64 encoded digest want.
+func digestMatches(d jarDigest, r io.Reader, want string) bool {
+	h := d.new()
+	if _, err := io.Copy(h, r); err != nil {
+		return false
+	}
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)) == want
+}
+
+// parseJarManifest returns the attributes of each section of a jar manifest or signature file,
+// keyed by their lower cased names, beginning with the main section.
+func parseJarManifest(data []byte) []map[string]string {
+	text := strings.ReplaceAll(strings.ReplaceAll(string(data), "\r\n", "\n"), "\r", "\n")
+
+	sections := []map[string]string{{}}
+	var last string
+	for _, line := range strings.Split(text, "\n") {
+		section := sections[len(sections)-1]
+
+		switch {
+		case line == "":
+			// sections are separated by blank lines, of which there may be several
+			if len(section) > 0 {
+				sections = append(sections, map[string]string{})
+			}
+			last = ""
+		case line[0] == ' ':
+			// long values are continued on the following lines
+			if last != "" {
+				section[last] += line[1:]
+			}
+		default:
+			i := strings.Index(line, ": ")
+			if i < 0 {
+				continue
+			}
+			last = strings.ToLower(line[:i])
+			section[last] = line[i+2:]
+		}
+	}
+	return sections
+}
+
+// pkcs7ContentInfo is the outer structure of a PKCS #7 signature block.
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"tag:0"`
+}
+
+// pkcs7SignedData is the SignedData of a detached PKCS #7 signature.
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	ContentInfo      asn1.RawValue
+	Certificates     pkcs7Raw          `asn1:"optional,tag:0"`
+	CRLs             pkcs7Raw          `asn1:"optional,tag:1"`
+	SignerInfos      []pkcs7SignerInfo `asn1:"set"`
+}
+
+type pkcs7SignerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     pkcs7IssuerAndSerial
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   pkcs7Raw `asn1:"optional,tag:0"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+	UnauthenticatedAttributes pkcs7Raw `asn1:"optional,tag:1"`
+}
+
+type pkcs7IssuerAndSerial struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type pkcs7Attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue `asn1:"set"`
+}
+
+// pkcs7Raw is an implicitly tagged field kept as it was encoded, since signatures are over its exact encoding.
+type pkcs7Raw struct {
+	Raw asn1.RawContent
+}
+
+// verifyPKCS7 checks the detached PKCS #7 signature of content, returning the certificate of the signer.
+func verifyPKCS7(block, content []byte) (*x509.Certificate, error) {
+	var ci pkcs7ContentInfo
+	if _, err := asn1.Unmarshal(block, &ci); err != nil {
+		return nil, fmt.Errorf("invalid signature block: %w", err)
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		return nil, errors.New("invalid signature block: not signed data")
+	}
+
+	var sd pkcs7SignedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return nil, fmt.Errorf("invalid signature block: %w", err)
+	}
+
+	var certs []*x509.Certificate
+	if len(sd.Certificates.Raw) > 0 {
+		var set asn1.RawValue
+		if _, err := asn1.Unmarshal(sd.Certificates.Raw, &set); err != nil {
+			return nil, fmt.Errorf("invalid signature block: %w", err)
+		}
+
+		var err error
+		if certs, err = x509.ParseCertificates(set.Bytes); err != nil {
+			return nil, fmt.Errorf("invalid signature block: %w", err)
+		}
+	}
+
+	err := errors.New("invalid signature block: no signers")
+	for _, si := range sd.SignerInfos {
+		var cert *x509.Certificate
+		for _, c := range certs {
+			if c.SerialNumber.Cmp(si.IssuerAndSerialNumber.SerialNumber) == 0 && bytes.Equal(c.RawIssuer, si.IssuerAndSerialNumber.Issuer.FullBytes) {
+				cert = c
+				break
+			}
+		}
+		if cert == nil {
+			err = errors.New("invalid signature block: missing signer certificate")
+			continue
+		}
+
+		if err = verifySignerInfo(si, cert, content); err == nil {
+			return cert, nil
+		}
+	}
+	return nil, err
+}
+
+// verifySignerInfo checks that the signer's certificate made the signature of the signer info over content.
+func verifySignerInfo(si pkcs7SignerInfo, cert *x509.Certificate, content []byte) error {
+	var d *jarDigest
+	for i := range jarDigests {
+		if jarDigests[i].oid.Equal(si.DigestAlgorithm.Algorithm) {
+			d = &jarDigests[i]
+		}
+	}
+	if d == nil {
+		return fmt.Errorf("unsupported signature digest algorithm %v", si.DigestAlgorithm.Algorithm)
+	}
+
+	h := d.new()
+	h.Write(content)
+	sum := h.Sum(nil)
+
+	// authenticated attributes are signed in place of the content, which they hold the digest of,
+	// encoded as a set rather than with their implicit tag
+	signed := content
+	if raw := si.AuthenticatedAttributes.Raw; len(raw) > 0 {
+		signed = append([]byte{0x31}, raw[1:]...)
+
+		var attrs []pkcs7Attribute
+		if _, err := asn1.UnmarshalWithParams(signed, &attrs, "set"); err != nil {
+			return fmt.Errorf("invalid signature block: %w", err)
+		}
+
+		var digest []byte
+		for _, attr := range attrs {
+			if attr.Type.Equal(oidMessageDigest) {
+				if _, err := asn1.Unmarshal(attr.Values.Bytes, &digest); err != nil {
+					return fmt.Errorf("invalid signature block: %w", err)
+				}
+			}
+		}
+		if !bytes.Equal(digest, sum) {
+			return errors.New("signature file does not match its signature")
+		}
+
+		h = d.new()
+		h.Write(signed)
+		sum = h.Sum(nil)
+	}
+
+	var ok bool
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		ok = rsa.VerifyPKCS1v15(key, d.hash, sum, si.EncryptedDigest) == nil
+	case *ecdsa.PublicKey:
+		ok = ecdsa.VerifyASN1(key, sum, si.EncryptedDigest)
+	case ed25519.PublicKey:
+		ok = ed25519.Verify(key, signed, si.EncryptedDigest)
+	default:
+		return fmt.Errorf("unsupported signature key %T", key)
+	}
+	if !ok {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package fync
+
+import (
+	"archive/zip"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyJar(t *testing.T) {
+	key, cert := newJarSigner(t, "signer")
+	_, other := newJarSigner(t, "other")
+
+	files := map[string]string{
+		"mod.class":           "class",
+		"assets/lang/en.json": "{}",
+	}
+	modified := map[string]string{
+		"mod.class":           "changed",
+		"assets/lang/en.json": "{}",
+	}
+	added := map[string]string{
+		"mod.class":           "class",
+		"assets/lang/en.json": "{}",
+		"extra.class":         "extra",
+	}
+
+	tests := []struct {
+		name    string
+		jar     string
+		signers []*x509.Certificate
+		ok      bool
+	}{
+		{"signed", signJar(t, files, files, sha256Jar, sha256Jar, key, cert), []*x509.Certificate{cert}, true},
+		{"signed by another signer", signJar(t, files, files, sha256Jar, sha256Jar, key, cert), []*x509.Certificate{other, cert}, true},
+		{"untrusted", signJar(t, files, files, sha256Jar, sha256Jar, key, cert), []*x509.Certificate{other}, false},
+		{"modified entry", signJar(t, files, modified, sha256Jar, sha256Jar, key, cert), []*x509.Certificate{cert}, false},
+		{"unlisted entry", signJar(t, files, added, sha256Jar, sha256Jar, key, cert), []*x509.Certificate{cert}, false},
+		{"SHA-1 digests", signJar(t, files, files, sha1Jar, sha256Jar, key, cert), []*x509.Certificate{cert}, false},
+		{"SHA-1 signature", signJar(t, files, files, sha256Jar, sha1Jar, key, cert), []*x509.Certificate{cert}, false},
+	}
+
+	for _, tt := range tests {
+		err := verifyJar(tt.jar, tt.signers)
+		if tt.ok && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		} else if !tt.ok && err == nil {
+			t.Errorf("%s: verified", tt.name)
+		}
+	}
+}
+
+func TestVerifyJarUnsigned(t *testing.T) {
+	jar := writeJar(t, map[string]string{"mod.class": "class"})
+	if err := verifyJar(jar, nil); err != errUnsigned {
+		t.Errorf("got %v, want %v", err, errUnsigned)
+	}
+}
+
+// jarSigningDigest is a digest a test jar is signed with.
+type jarSigningDigest struct {
+	name string
+	hash crypto.Hash
+	oid  asn1.ObjectIdentifier
+}
+
+var (
+	sha256Jar = jarSigningDigest{"SHA-256", crypto.SHA256, asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}}
+	sha1Jar   = jarSigningDigest{"SHA1", crypto.SHA1, asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}}
+)
+
+func (d jarSigningDigest) sum(data []byte) []byte {
+	if d.hash == crypto.SHA1 {
+		sum := sha1.Sum(data)
+		return sum[:]
+	}
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+func (d jarSigningDigest) encoded(data string) string {
+	return base64.StdEncoding.EncodeToString(d.sum([]byte(data)))
+}
+
+// newJarSigner returns a key and a self-signed certificate for it.
+func newJarSigner(t *testing.T, name string) (*rsa.PrivateKey, *x509.Certificate) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, cert
+}
+
+// signJar writes a jar holding the contents of files, with a signature listing the contents of listed,
+// as jarsigner would, and returns its path. The manifest and signature file record digests made with d,
+// and the signature block signs with sd.
+func signJar(t *testing.T, listed, files map[string]string, d, sd jarSigningDigest, key *rsa.PrivateKey, cert *x509.Certificate) string {
+	t.Helper()
+
+	var manifest strings.Builder
+	manifest.WriteString("Manifest-Version: 1.0\r\n\r\n")
+	for name, data := range listed {
+		manifest.WriteString("Name: " + name + "\r\n" + d.name + "-Digest: " + d.encoded(data) + "\r\n\r\n")
+	}
+
+	sf := "Signature-Version: 1.0\r\n" + d.name + "-Digest-Manifest: " + d.encoded(manifest.String()) + "\r\n\r\n"
+
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, sd.hash, sd.sum([]byte(sf)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	all := map[string]string{
+		"META-INF/MANIFEST.MF": manifest.String(),
+		"META-INF/SIGNER.SF":   sf,
+		"META-INF/SIGNER.RSA":  string(signatureBlock(t, sd, cert, sig)),
+	}
+	for name, data := range files {
+		all[name] = data
+	}
+	return writeJar(t, all)
+}
+
+// testSignedData and testSignerInfo are how a test signature block is encoded, as jarsigner encodes them.
+type testSignedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      struct{ ContentType asn1.ObjectIdentifier }
+	Certificates     asn1.RawValue
+	SignerInfos      []testSignerInfo `asn1:"set"`
+}
+
+type testSignerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     testIssuerAndSerial
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+}
+
+type testIssuerAndSerial struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+// signatureBlock returns a detached PKCS #7 signature block holding the signature by the certificate's key.
+func signatureBlock(t *testing.T, d jarSigningDigest, cert *x509.Certificate, sig []byte) []byte {
+	t.Helper()
+
+	algorithm := pkix.AlgorithmIdentifier{Algorithm: d.oid, Parameters: asn1.NullRawValue}
+	sd := testSignedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{algorithm},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: cert.Raw},
+		SignerInfos: []testSignerInfo{{
+			Version:                   1,
+			IssuerAndSerialNumber:     testIssuerAndSerial{asn1.RawValue{FullBytes: cert.RawIssuer}, cert.SerialNumber},
+			DigestAlgorithm:           algorithm,
+			DigestEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}, Parameters: asn1.NullRawValue},
+			EncryptedDigest:           sig,
+		}},
+	}
+	sd.ContentInfo.ContentType = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+
+	signedData, err := asn1.Marshal(sd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := asn1.Marshal(struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue
+	}{oidSignedData, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signedData}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+// writeJar writes a jar holding the files and returns its path.
+func writeJar(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	jar := filepath.Join(t.TempDir(), "mod.jar")
+	f, err := os.Create(jar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	w := zip.NewWriter(f)
+	for name, data := range files {
+		fw, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write([]byte(data))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return jar
+}
@@ -0,0 +1,308 @@
+package fync
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// journalName is the name of the file within the mods directory recording the changes of a sync in progress.
+const journalName = ".fync-journal"
+
+// journal is an append-only record of the changes a sync makes to the mods directory,
+// so that a sync interrupted by a crash or power loss can be rolled back or completed.
+// Each change is recorded before it is made and synced to disk.
+// A nil journal records nothing.
+type journal struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// journalRecord is a single entry of the journal. Records are written one per line,
+// prefixed by the hex encoded CRC-32 checksum of the record so that a record cut short
+// or damaged by a crash is detected.
+type journalRecord struct {
+	// The kind of record: "begin", "backup", "write", "done", or "commit".
+	Op string `json:"op"`
+
+	// When the sync began, for begin records.
+	Time *time.Time `json:"time,omitempty"`
+
+	// Name of the mod being changed.
+	Name string `json:"name,omitempty"`
+
+	// Where a backed up mod is being moved, which is empty when it is deleted or moved to the trash.
+	To string `json:"to,omitempty"`
+
+	// Whether a backed up mod is being stored in the shared object store.
+	Shared bool `json:"shared,omitempty"`
+
+	// Whether a mod is being written over an existing mod that was not backed up.
+	Overwrite bool `json:"overwrite,omitempty"`
+}
+
+// beginJournal starts the journal of a sync begun at t, replacing the journal of any earlier sync.
+func beginJournal(t time.Time) (*journal, error) {
+	file, err := os.OpenFile(filepath.Join(modsDir, journalName), os.O_CREATE|os.O_TRUNC|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	j := &journal{file: file}
+	if err := j.append(journalRecord{Op: "begin", Time: &t}); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return j, nil
+}
+
+// append writes the record and syncs it to disk.
+func (j *journal) append(r journalRecord) error {
+	if j == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if _, err := fmt.Fprintf(j.file, "%08x %s\n", crc32.ChecksumIEEE(data), data); err != nil {
+		return err
+	}
+	return j.file.Sync()
+}
+
+// backup records that the named mod is about to be moved to the path to.
+func (j *journal) backup(name, to string, shared bool) error {
+	return j.append(journalRecord{Op: "backup", Name: name, To: to, Shared: shared})
+}
+
+// write records that the named mod is about to be written, and whether it overwrites a mod that was not backed up.
+func (j *journal) write(name string, overwrite bool) error {
+	return j.append(journalRecord{Op: "write", Name: name, Overwrite: overwrite})
+}
+
+// done records that the change to the named mod is complete.
+func (j *journal) done(name string) error {
+	return j.append(journalRecord{Op: "done", Name: name})
+}
+
+// commit records that the sync finished and removes the journal.
+func (j *journal) commit() error {
+	if j == nil {
+		return nil
+	}
+
+	if err := j.append(journalRecord{Op: "commit"}); err != nil {
+		j.file.Close()
+		return err
+	}
+	if err := j.file.Close(); err != nil {
+		return err
+	}
+	return os.Remove(j.file.Name())
+}
+
+// abandon closes the journal without committing it, leaving it to recover the sync from.
+func (j *journal) abandon() {
+	if j != nil {
+		j.file.Close()
+	}
+}
+
+// readJournal returns the records of the journal, or nil if there is none.
+// Reading stops at the first damaged record, since nothing after it can be trusted.
+func readJournal() (records []journalRecord, damaged bool, err error) {
+	data, err := ioutil.ReadFile(filepath.Join(modsDir, journalName))
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+
+		if len(line) < 10 || line[8] != ' ' {
+			return records, true, nil
+		}
+		sum, err := strconv.ParseUint(string(line[:8]), 16, 32)
+		if err != nil || crc32.ChecksumIEEE(line[9:]) != uint32(sum) {
+			return records, true, nil
+		}
+
+		var r journalRecord
+		if err := json.Unmarshal(line[9:], &r); err != nil {
+			return records, true, nil
+		}
+		records = append(records, r)
+	}
+
+	// a final record without its newline was cut short
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		damaged = true
+	}
+	return records, damaged, scanner.Err()
+}
+
+// ErrNotInterrupted is returned by RollBack when the last sync was not interrupted.
+var ErrNotInterrupted = errors.New("no interrupted sync to roll back")
+
+// InterruptedSync describes a sync that was interrupted before it finished, such as by a crash,
+// as recorded by its journal. Syncing again completes the changes it began,
+// while RollBack undoes them.
+type InterruptedSync struct {
+	// When the interrupted sync began.
+	Started time.Time
+
+	// Names of the mods the sync finished changing.
+	Completed []string
+
+	// Names of the mods the sync began changing but did not finish.
+	Incomplete []string
+
+	// Names of the mods the sync deleted or moved to the trash, which cannot be rolled back.
+	Unrecoverable []string
+
+	// Whether the journal ends with a damaged record, such as one cut short by the interruption.
+	// Changes recorded after it are unknown.
+	Damaged bool
+}
+
+// Interrupted returns the sync that was interrupted before it finished,
+// or nil if the last sync finished or none has been recorded.
+func Interrupted() (*InterruptedSync, error) {
+	if dirErr != nil {
+		return nil, dirErr
+	}
+
+	records, damaged, err := readJournal()
+	if err != nil || records == nil {
+		return nil, err
+	}
+
+	return interrupted(records, damaged), nil
+}
+
+// interrupted summarizes the journal records of an interrupted sync, or returns nil if it was committed.
+func interrupted(records []journalRecord, damaged bool) *InterruptedSync {
+	i := &InterruptedSync{Damaged: damaged}
+
+	begun := make(map[string]bool)
+	var order []string
+	for _, r := range records {
+		switch r.Op {
+		case "begin":
+			if r.Time != nil {
+				i.Started = *r.Time
+			}
+		case "backup", "write":
+			if !begun[r.Name] {
+				begun[r.Name] = true
+				order = append(order, r.Name)
+			}
+			if r.Op == "backup" && r.To == "" {
+				i.Unrecoverable = append(i.Unrecoverable, r.Name)
+			}
+		case "done":
+			i.Completed = append(i.Completed, r.Name)
+			delete(begun, r.Name)
+		case "commit":
+			return nil
+		}
+	}
+
+	for _, name := range order {
+		if begun[name] {
+			i.Incomplete = append(i.Incomplete, name)
+		}
+	}
+	return i
+}
+
+// RollBack undoes the changes of an interrupted sync, newest first, by removing the mods it wrote
+// and moving the mods it backed up back into the mods directory, and then discards its journal.
+// Mods it deleted or moved to the trash, or wrote over without backing up, are left as they are.
+// The number of mods restored is returned as well as any errors encountered.
+// Errors caused by insufficient privileges are returned as a *PermissionError.
+func RollBack() (n int, err error) {
+	defer func() {
+		err = permissionError(err)
+	}()
+
+	if dirErr != nil {
+		return n, dirErr
+	}
+
+	records, damaged, err := readJournal()
+	if err != nil {
+		return n, err
+	}
+	if records == nil || interrupted(records, damaged) == nil {
+		return n, ErrNotInterrupted
+	}
+
+	sets := make(map[string]bool)
+	for i := len(records) - 1; i >= 0; i-- {
+		r := records[i]
+		path := filepath.Join(modsDir, filepath.Base(r.Name))
+
+		switch r.Op {
+		case "write":
+			if r.Overwrite {
+				continue
+			}
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return n, err
+			}
+
+		case "backup":
+			if r.To == "" {
+				continue
+			}
+
+			// the mod may not have been moved before the interruption
+			if _, err := os.Stat(r.To); os.IsNotExist(err) {
+				continue
+			} else if err != nil {
+				return n, err
+			}
+			if _, err := os.Stat(path); err == nil {
+				continue
+			}
+
+			if r.Shared {
+				err = copyFile(r.To, path)
+			} else {
+				err = move(r.To, path)
+				sets[filepath.Dir(r.To)] = true
+			}
+			if err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+
+	// remove backup sets emptied by rolling back, which were never saved
+	for dir := range sets {
+		os.Remove(dir)
+	}
+
+	return n, os.Remove(filepath.Join(modsDir, journalName))
+}
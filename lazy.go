@@ -0,0 +1,159 @@
+package fync
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+// ModRef describes a server mod without holding it open.
+type ModRef struct {
+	// The mod's FileInfo, which must report the mod's exact size or a negative size if it is unknown.
+	Info os.FileInfo
+
+	// Hex encoded SHA-256 checksum of the mod, or empty if unknown.
+	// Checksums provided by a ChecksumServer take precedence.
+	SHA256 string
+
+	// Identifies the mod to the server when it is opened. It is not interpreted by fync.
+	Key string
+
+	// Whether the mod is opened as a DeltaFile, so that it can be transferred as a delta.
+	Delta bool
+}
+
+// ListServer represents a Server that is able to list its mods as cheap metadata,
+// only opening a mod's file or connection once its transfer actually starts.
+// Sync prefers List and Open over Mods when a Server implements them.
+type ListServer interface {
+	Server
+
+	// List returns a reference to each mod the server is using.
+	List() ([]ModRef, error)
+
+	// Open opens the referenced mod for transfer. It may be called again
+	// for the same mod after the previous ServerFile is closed, such as to retry a transfer.
+	Open(ref ModRef) (ServerFile, error)
+}
+
+// serverMods returns the server's mods, deferring opening them when the server is a ListServer.
+func serverMods(s Server) ([]ServerFile, error) {
+	ls, ok := s.(ListServer)
+	if !ok {
+		return s.Mods()
+	}
+
+	refs, err := ls.List()
+	if err != nil {
+		return nil, err
+	}
+
+	mods := make([]ServerFile, len(refs))
+	for i, ref := range refs {
+		if ref.Info == nil {
+			return nil, errors.New("server listed a mod without its FileInfo")
+		}
+		f := &lazyFile{server: ls, ref: ref}
+		if ref.Delta {
+			mods[i] = &lazyDeltaFile{f}
+		} else {
+			mods[i] = f
+		}
+	}
+	return mods, nil
+}
+
+// lazyFile is a ServerFile that opens the referenced mod when it is first written.
+type lazyFile struct {
+	server ListServer
+	ref    ModRef
+
+	mu   sync.Mutex
+	file ServerFile
+}
+
+func (f *lazyFile) Stat() (os.FileInfo, error) {
+	return f.ref.Info, nil
+}
+
+func (f *lazyFile) SHA256() (string, error) {
+	return f.ref.SHA256, nil
+}
+
+func (f *lazyFile) WriteTo(w io.Writer) (int64, error) {
+	file, err := f.open()
+	if err != nil {
+		return 0, err
+	}
+	return file.WriteTo(w)
+}
+
+// Unchanged opens the referenced mod, reporting whether it is unchanged from the local copy
+// when it is opened as a ConditionalFile, and that it has changed otherwise.
+func (f *lazyFile) Unchanged(sum string) (bool, error) {
+	file, err := f.open()
+	if err != nil {
+		return false, err
+	}
+
+	c, ok := file.(ConditionalFile)
+	if !ok {
+		return false, nil
+	}
+	return c.Unchanged(sum)
+}
+
+// open opens the referenced mod if it is not already open.
+func (f *lazyFile) open() (ServerFile, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		file, err := f.server.Open(f.ref)
+		if err != nil {
+			return nil, err
+		}
+		f.file = file
+	}
+	return f.file, nil
+}
+
+// Seek only supports rewinding to the start of the mod, which reopens it when next written.
+func (f *lazyFile) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekStart {
+		return 0, errors.New("can only seek to the start of a listed mod")
+	}
+	return 0, f.Close()
+}
+
+func (f *lazyFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// lazyDeltaFile is a lazyFile for a mod that is opened as a DeltaFile.
+type lazyDeltaFile struct {
+	*lazyFile
+}
+
+func (f *lazyDeltaFile) WriteDelta(w io.Writer, basis io.ReaderAt, size int64) (int64, error) {
+	file, err := f.open()
+	if err != nil {
+		return 0, err
+	}
+
+	d, ok := file.(DeltaFile)
+	if !ok {
+		return 0, errors.New("listed mod was not opened as a DeltaFile")
+	}
+	return d.WriteDelta(w, basis, size)
+}
@@ -0,0 +1,247 @@
+package fync
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// lockName is the name of the file within the mods directory recording the mods resulting from the last sync.
+const lockName = "fync.lock"
+
+// Lock records the exact mods resulting from a sync, much like go.sum does for modules.
+// A server's admin may distribute the lockfile from their own sync so that clients
+// can verify they end up with byte-identical mods by setting SyncOptions.Lock.
+type Lock struct {
+	Mods []LockedMod `json:"mods"`
+}
+
+// LockedMod is a single mod recorded by a Lock.
+type LockedMod struct {
+	// Name of the mod file.
+	Name string `json:"name"`
+
+	// Version of the mod as declared within its jar, when it could be determined.
+	Version string `json:"version,omitempty"`
+
+	// Where the mod was obtained from, when known. This is the result of calling String
+	// on the ServerFile or else the Server, if either implements fmt.Stringer.
+	Source string `json:"source,omitempty"`
+
+	// Size of the mod in bytes.
+	Size int64 `json:"size"`
+
+	// Hex encoded SHA-256 checksum of the mod.
+	SHA256 string `json:"sha256"`
+}
+
+// LockPath returns the location of the lockfile written after each sync.
+func LockPath() (string, error) {
+	return filepath.Join(modsDir, lockName), dirErr
+}
+
+// ReadLock reads the lockfile at the given path.
+func ReadLock(path string) (*Lock, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ParseLock(file)
+}
+
+// ParseLock parses a lockfile, ensuring each mod has a name and a valid checksum, which may be in either case.
+func ParseLock(r io.Reader) (*Lock, error) {
+	var l Lock
+	if err := json.NewDecoder(r).Decode(&l); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	for i, m := range l.Mods {
+		if m.Name == "" || filepath.Base(m.Name) != m.Name {
+			return nil, fmt.Errorf("lock mod %d: invalid name %q", i, m.Name)
+		}
+		if seen[m.Name] {
+			return nil, fmt.Errorf("%s: locked more than once", m.Name)
+		}
+		seen[m.Name] = true
+
+		if !validChecksum(m.SHA256) {
+			return nil, fmt.Errorf("%s: invalid locked SHA-256 checksum %q", m.Name, m.SHA256)
+		}
+		// checksums are compared as fync writes them
+		l.Mods[i].SHA256 = strings.ToLower(m.SHA256)
+	}
+	return &l, nil
+}
+
+// mod returns the locked mod with the given name.
+func (l *Lock) mod(name string) (LockedMod, bool) {
+	for _, m := range l.Mods {
+		if m.Name == name {
+			return m, true
+		}
+	}
+	return LockedMod{}, false
+}
+
+// LockError is returned when the server's mods do not match the Lock a sync is verified against.
+type LockError struct {
+	// Name of the mod.
+	Name string
+
+	// Why the mod does not match, such as "not locked" or "not on server".
+	Reason string
+}
+
+func (e *LockError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Name, e.Reason)
+}
+
+// sourceOf returns the result of calling String on v if it implements fmt.Stringer.
+func sourceOf(v interface{}) string {
+	if s, ok := v.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return ""
+}
+
+// writeLock records the server mods now within the mods directory to the lockfile.
+// Checksums recorded by the state are reused for mods whose size has not changed.
+func writeLock(mods []LockedMod, st *state) error {
+	l := Lock{Mods: make([]LockedMod, 0, len(mods))}
+
+	for _, m := range mods {
+		path := filepath.Join(modsDir, m.Name)
+
+		info, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			// the server mod was skipped
+			continue
+		} else if err != nil {
+			return err
+		}
+		m.Size = info.Size()
+
+		st.mu.Lock()
+		recorded, ok := st.Mods[m.Name]
+		st.mu.Unlock()
+
+		if ok && recorded.Size == m.Size && recorded.SHA256 != "" {
+			m.SHA256 = recorded.SHA256
+		} else if m.SHA256, err = hashLocal(path); err != nil {
+			return err
+		}
+
+		// the version is only informational, so mods without readable metadata are still locked
+		if info, err := readModInfo(path); err == nil {
+			m.Version = info.Version
+		}
+
+		l.Mods = append(l.Mods, m)
+	}
+
+	sort.Slice(l.Mods, func(i, j int) bool {
+		return l.Mods[i].Name < l.Mods[j].Name
+	})
+	data, err := json.MarshalIndent(l, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(modsDir, lockName), data, 0644)
+}
+
+// LockReport describes how the mods directory differs from a lockfile.
+type LockReport struct {
+	// Names of the locked mods that are not within the mods directory.
+	Missing []string
+
+	// Names of the mods within the mods directory that are not locked.
+	Extra []string
+
+	// Names of the locked mods whose size or checksum differs.
+	Modified []string
+}
+
+// Conforms returns whether the mods directory exactly matches the lockfile.
+func (r *LockReport) Conforms() bool {
+	return len(r.Missing) == 0 && len(r.Extra) == 0 && len(r.Modified) == 0
+}
+
+// VerifyLock checks the mods directory against the lockfile at lockPath,
+// or the one written by the last sync when it is empty, without contacting any server.
+func VerifyLock(lockPath string) (*LockReport, error) {
+	if dirErr != nil {
+		return nil, dirErr
+	}
+
+	if lockPath == "" {
+		lockPath = filepath.Join(modsDir, lockName)
+	}
+
+	l, err := ReadLock(lockPath)
+	if err != nil {
+		return nil, err
+	}
+	defer flushHashes()
+
+	files, err := ioutil.ReadDir(modsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	localMods := make(map[string]int64)
+	for i := range files {
+		if !files[i].IsDir() && strings.HasSuffix(files[i].Name(), ".jar") {
+			localMods[files[i].Name()] = files[i].Size()
+		}
+	}
+
+	r := &LockReport{}
+	var mu sync.Mutex
+	err = run(len(l.Mods), defaultConcurrency, nil, nil, func(i int) error {
+		m := l.Mods[i]
+
+		size, ok := localMods[m.Name]
+		modified := ok && size != m.Size
+		if ok && !modified {
+			sum, err := hashLocal(filepath.Join(modsDir, m.Name))
+			if err != nil {
+				return err
+			}
+			modified = sum != m.SHA256
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if !ok {
+			r.Missing = append(r.Missing, m.Name)
+		} else if modified {
+			r.Modified = append(r.Modified, m.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for name := range localMods {
+		if _, ok := l.mod(name); !ok {
+			r.Extra = append(r.Extra, name)
+		}
+	}
+
+	sort.Strings(r.Missing)
+	sort.Strings(r.Extra)
+	sort.Strings(r.Modified)
+	return r, nil
+}
@@ -0,0 +1,291 @@
+package fync
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ManifestSchemaVersion is the version of the manifest format described by Manifest.
+// Versions only change when a manifest could not be understood by older parsers,
+// while fields added to a version are ignored by parsers that predate them.
+const ManifestSchemaVersion = 1
+
+// Manifest lists the mods of a pack in the fync manifest format, the JSON encoding of a Manifest:
+//
+//	{
+//		"schemaVersion": 1,
+//		"mods": [
+//			{
+//				"name": "jei-1.16.5-7.7.1.jar",
+//				"url": "mods/jei-1.16.5-7.7.1.jar",
+//				"size": 742314,
+//				"sha256": "2be429ec087642b06b2a2c1c0f541ea57db33aa9c1537cce6655d3d706b8b8f3",
+//				"id": "jei",
+//				"version": "7.7.1",
+//				"optional": true
+//			}
+//		]
+//	}
+//
+// Every field of a mod but its name may be left out, and manifests without a schema version
+// are read as the first version. Packs requiring a newer fync than some players may have
+// declare the lowest protocol version they are synced with by "minProtocolVersion".
+// Manifests are served by Handler, generated by GenerateManifest for static hosting,
+// and read by httpserver, so that any tool producing or consuming them interoperates.
+type Manifest struct {
+	// The version of the format, which is ManifestSchemaVersion for manifests written by this module.
+	SchemaVersion int `json:"schemaVersion"`
+
+	// The lowest version of the fync protocol a client must speak to sync the pack, such as when it relies
+	// on behavior that clients speaking older versions lack. Those clients fail with an *UpdateError
+	// rather than syncing the pack. Zero when there is no minimum.
+	MinProtocolVersion int `json:"minProtocolVersion,omitempty"`
+
+	// The mods, each with a unique name.
+	Mods []ManifestMod `json:"mods"`
+
+	// The sync token of the manifest these are the changes to, as served to clients that request
+	// only the changes since the manifest they last fetched. The mods of such changes are those added
+	// or changed since, while those removed are named by Removed. Empty for whole manifests.
+	Since string `json:"since,omitempty"`
+
+	// Names of the mods removed since the manifest the changes are to.
+	Removed []string `json:"removed,omitempty"`
+}
+
+// ManifestMod is a single mod listed by a Manifest.
+type ManifestMod struct {
+	// The file name of the mod.
+	Name string `json:"name"`
+
+	// Where the mod is downloaded from, relative to the manifest. Defaults to its escaped name.
+	URL string `json:"url,omitempty"`
+
+	// The size of the mod in bytes, or nil when it is unknown.
+	Size *int64 `json:"size,omitempty"`
+
+	// The hex encoded SHA-256 checksum of the mod, in lowercase, or empty when it is unknown.
+	SHA256 string `json:"sha256,omitempty"`
+
+	// The ID and version of the mod as declared within its jar, which are empty when they cannot be determined.
+	ID      string `json:"id,omitempty"`
+	Version string `json:"version,omitempty"`
+
+	// Whether players may leave the mod out, such as for mods that only change how the game looks.
+	Optional bool `json:"optional,omitempty"`
+}
+
+// ManifestOptions contains options for the GenerateManifest function.
+type ManifestOptions struct {
+	// URL of the directory the mods are uploaded to, relative to the manifest,
+	// such as "mods/" or the absolute URL of a CDN. Defaults to the directory holding the manifest.
+	ModsURL string
+
+	// The lowest version of the fync protocol clients must speak, as by Manifest.MinProtocolVersion.
+	MinProtocolVersion int
+}
+
+// GenerateManifest returns the manifest of the mods within a directory, hashing each of them
+// and reading their IDs and versions from their jars. Files that are not validly named mods are skipped.
+// No mods are marked optional, which can be done before the manifest is written.
+//
+// Hosts that only serve files, such as S3 or GitHub Pages, can serve a pack by uploading
+// the mods along with the manifest written by its WriteTo method as manifest.json.
+func GenerateManifest(dir string, o *ManifestOptions) (*Manifest, error) {
+	if o == nil {
+		o = &ManifestOptions{}
+	}
+
+	files, err := modFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := generateManifest(files, o.ModsURL, func(info os.FileInfo) (ManifestMod, error) {
+		return describeMod(filepath.Join(dir, info.Name()), info)
+	})
+	if err != nil {
+		return nil, err
+	}
+	m.MinProtocolVersion = o.MinProtocolVersion
+	return m, nil
+}
+
+// generateManifest returns the manifest of the mods, described by describe,
+// whose URLs are relative to modsURL.
+func generateManifest(files []os.FileInfo, modsURL string, describe func(os.FileInfo) (ManifestMod, error)) (*Manifest, error) {
+	if modsURL != "" && !strings.HasSuffix(modsURL, "/") {
+		modsURL += "/"
+	}
+
+	m := &Manifest{SchemaVersion: ManifestSchemaVersion, Mods: make([]ManifestMod, len(files))}
+	for i, info := range files {
+		mod, err := describe(info)
+		if err != nil {
+			return nil, err
+		}
+		mod.URL = modsURL + url.PathEscape(mod.Name)
+		m.Mods[i] = mod
+	}
+	return m, nil
+}
+
+// ParseManifest reads a manifest and validates it with ValidateManifest,
+// lowercasing the checksums of its mods. Manifests requiring a newer version of fync
+// are rejected with an *UpdateError before the rest of them is decoded.
+func ParseManifest(r io.Reader) (*Manifest, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// the versions are checked first, since newer manifests may not decode as this version expects
+	var versions struct {
+		SchemaVersion      int `json:"schemaVersion"`
+		MinProtocolVersion int `json:"minProtocolVersion"`
+	}
+	if err := json.Unmarshal(data, &versions); err != nil {
+		return nil, err
+	}
+	if err := checkVersions(versions.SchemaVersion, versions.MinProtocolVersion); err != nil {
+		return nil, err
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+
+	for i := range m.Mods {
+		m.Mods[i].SHA256 = strings.ToLower(m.Mods[i].SHA256)
+	}
+	if err := ValidateManifest(&m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// ValidateManifest checks that a manifest is of a supported schema version and protocol version
+// and that each of its mods has a valid and unique name, a size that is not negative, a valid checksum,
+// and a valid URL. The mods removed by changes must also have valid names that are not listed otherwise.
+// Invalid names are reported as a *NameError, and versions newer than this module supports as an *UpdateError.
+func ValidateManifest(m *Manifest) error {
+	if err := checkVersions(m.SchemaVersion, m.MinProtocolVersion); err != nil {
+		return err
+	}
+
+	names := make(map[string]bool, len(m.Mods))
+	for _, mod := range m.Mods {
+		if err := checkName(mod.Name); err != nil {
+			return err
+		}
+		if names[mod.Name] {
+			return fmt.Errorf("%q: listed more than once", mod.Name)
+		}
+		names[mod.Name] = true
+
+		if mod.Size != nil && *mod.Size < 0 {
+			return fmt.Errorf("%q: invalid size %d", mod.Name, *mod.Size)
+		}
+		if mod.SHA256 != "" {
+			if sum, err := hex.DecodeString(mod.SHA256); err != nil || len(sum) != sha256.Size {
+				return fmt.Errorf("%q: invalid SHA-256 checksum %q", mod.Name, mod.SHA256)
+			}
+		}
+		if _, err := url.Parse(mod.URL); err != nil {
+			return fmt.Errorf("%q: %w", mod.Name, err)
+		}
+	}
+
+	if len(m.Removed) > 0 && m.Since == "" {
+		return errors.New("removed mods listed by a whole manifest")
+	}
+	for _, name := range m.Removed {
+		if err := checkName(name); err != nil {
+			return err
+		}
+		if names[name] {
+			return fmt.Errorf("%q: listed more than once", name)
+		}
+		names[name] = true
+	}
+	return nil
+}
+
+// checkVersions checks that a manifest's schema version and minimum protocol version are supported.
+func checkVersions(schema, protocol int) error {
+	if schema < 0 {
+		return fmt.Errorf("invalid manifest schema version %d", schema)
+	}
+	if protocol < 0 {
+		return fmt.Errorf("invalid minimum protocol version %d", protocol)
+	}
+
+	if schema > ManifestSchemaVersion {
+		return &UpdateError{Of: "manifest schema", Required: schema, Supported: ManifestSchemaVersion}
+	}
+	if protocol > ProtocolVersion {
+		return &UpdateError{Of: "protocol", Required: protocol, Supported: ProtocolVersion}
+	}
+	return nil
+}
+
+// WriteTo writes the manifest as indented JSON.
+func (m *Manifest) WriteTo(w io.Writer) (int64, error) {
+	data, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := w.Write(append(data, '\n'))
+	return int64(n), err
+}
+
+// modFiles returns the mods within a directory, skipping files that are not validly named mods.
+func modFiles(dir string) ([]os.FileInfo, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var mods []os.FileInfo
+	for _, info := range files {
+		if info.Mode().IsRegular() && checkName(info.Name()) == nil {
+			mods = append(mods, info)
+		}
+	}
+	return mods, nil
+}
+
+// describeMod returns the manifest entry of the mod at path, without its URL.
+func describeMod(path string, info os.FileInfo) (ManifestMod, error) {
+	size := info.Size()
+	mod := ManifestMod{Name: info.Name(), Size: &size}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return mod, err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return mod, err
+	}
+	mod.SHA256 = hex.EncodeToString(hash.Sum(nil))
+
+	// jars without readable metadata are still listed
+	if meta, err := readModInfo(path); err == nil {
+		mod.ID, mod.Version = meta.ID, meta.Version
+	}
+	return mod, nil
+}
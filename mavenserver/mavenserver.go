@@ -0,0 +1,431 @@
+// Package mavenserver implements a fync.Server for mods published to a Maven repository,
+// such as Nexus or Artifactory, given by their coordinates:
+//
+//	com.example:coolmod:1.2.0
+//	com.example:coolmod:1.3.0-SNAPSHOT
+//	com.example:coolmod:release:fabric
+//
+// Each coordinate is made up of a group, artifact, version, and optional classifier.
+// Snapshot versions resolve to the newest snapshot published, and the versions "latest" and "release"
+// to the newest version and newest release, using the repository's maven-metadata.xml files.
+// Each mod is verified against the checksum the repository publishes alongside it.
+package mavenserver
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/han-tyumi/fync"
+)
+
+// fetchConcurrency is the number of mods resolved at once.
+const fetchConcurrency = 8
+
+// metadataName is the name of the files describing the versions of an artifact.
+const metadataName = "maven-metadata.xml"
+
+// checksums lists the checksum files a repository may publish alongside a mod, in order of preference.
+var checksums = []struct {
+	ext     string
+	newHash func() hash.Hash
+}{
+	{".sha256", sha256.New},
+	{".sha512", sha512.New},
+	{".sha1", sha1.New},
+	{".md5", md5.New},
+}
+
+// Options contains options for the New function.
+type Options struct {
+	// Credentials used for repositories requiring basic authentication.
+	User, Password string
+
+	// The HTTP client used for all requests. Defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// Coordinate identifies an artifact within a Maven repository.
+type Coordinate struct {
+	Group, Artifact, Version, Classifier string
+}
+
+// ParseCoordinate parses a coordinate of the form group:artifact:version[:classifier].
+func ParseCoordinate(s string) (Coordinate, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) < 3 || len(parts) > 4 {
+		return Coordinate{}, fmt.Errorf("%q: expected group:artifact:version[:classifier]", s)
+	}
+	for _, p := range parts {
+		if p == "" || strings.ContainsAny(p, "/\\") {
+			return Coordinate{}, fmt.Errorf("%q: invalid coordinate", s)
+		}
+	}
+
+	c := Coordinate{Group: parts[0], Artifact: parts[1], Version: parts[2]}
+	if len(parts) == 4 {
+		c.Classifier = parts[3]
+	}
+	return c, nil
+}
+
+func (c Coordinate) String() string {
+	s := c.Group + ":" + c.Artifact + ":" + c.Version
+	if c.Classifier != "" {
+		s += ":" + c.Classifier
+	}
+	return s
+}
+
+// dir returns the path of the artifact's directory within a repository.
+func (c Coordinate) dir() string {
+	return strings.Replace(c.Group, ".", "/", -1) + "/" + c.Artifact
+}
+
+// Server is a fync.Server that lists mods published to a Maven repository.
+type Server struct {
+	repository  *url.URL
+	coordinates []Coordinate
+	o           Options
+}
+
+// New returns a Server for the mods with the given coordinates within the repository at the URL.
+func New(repository string, coordinates []string, o *Options) (*Server, error) {
+	u, err := url.Parse(repository)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported URL scheme %q", u.Scheme)
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+
+	s := &Server{repository: u}
+	if o != nil {
+		s.o = *o
+	}
+	if s.o.Client == nil {
+		s.o.Client = http.DefaultClient
+	}
+
+	for _, coordinate := range coordinates {
+		c, err := ParseCoordinate(coordinate)
+		if err != nil {
+			return nil, err
+		}
+		s.coordinates = append(s.coordinates, c)
+	}
+	return s, nil
+}
+
+// String returns the URL of the repository.
+func (s *Server) String() string {
+	return s.repository.String()
+}
+
+// Mods resolves each coordinate and returns a slice of mod ServerFiles for each.
+// Mods are not downloaded until they are written.
+func (s *Server) Mods() ([]fync.ServerFile, error) {
+	mods := make([]fync.ServerFile, len(s.coordinates))
+	errs := make([]error, len(s.coordinates))
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < fetchConcurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				mods[i], errs[i] = s.resolve(s.coordinates[i])
+			}
+		}()
+	}
+	for i := range s.coordinates {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return mods, nil
+}
+
+// metadata is the part of a maven-metadata.xml file that is used.
+type metadata struct {
+	Versioning struct {
+		Latest   string `xml:"latest"`
+		Release  string `xml:"release"`
+		Snapshot struct {
+			Timestamp   string `xml:"timestamp"`
+			BuildNumber int    `xml:"buildNumber"`
+			LocalCopy   bool   `xml:"localCopy"`
+		} `xml:"snapshot"`
+		SnapshotVersions []struct {
+			Classifier string `xml:"classifier"`
+			Extension  string `xml:"extension"`
+			Value      string `xml:"value"`
+		} `xml:"snapshotVersions>snapshotVersion"`
+	} `xml:"versioning"`
+}
+
+// resolve returns the mod with the given coordinate, along with its checksum and size.
+func (s *Server) resolve(c Coordinate) (*file, error) {
+	version := c.Version
+	switch strings.ToLower(version) {
+	case "latest", "release":
+		var m metadata
+		if err := s.metadata(c.dir()+"/"+metadataName, &m); err != nil {
+			return nil, err
+		}
+
+		if strings.ToLower(version) == "latest" {
+			version = m.Versioning.Latest
+		} else {
+			version = m.Versioning.Release
+		}
+		if version == "" {
+			return nil, fmt.Errorf("%s: no %s version", c, strings.ToLower(c.Version))
+		}
+	}
+
+	// snapshots are published with the time and number of their build in place of SNAPSHOT
+	fileVersion := version
+	if strings.HasSuffix(version, "-SNAPSHOT") {
+		var m metadata
+		if err := s.metadata(c.dir()+"/"+version+"/"+metadataName, &m); err != nil {
+			return nil, err
+		}
+		fileVersion = snapshotVersion(m, version, c.Classifier)
+	}
+
+	name := c.Artifact + "-" + fileVersion
+	if c.Classifier != "" {
+		name += "-" + c.Classifier
+	}
+	name += ".jar"
+
+	u, err := s.repository.Parse(c.dir() + "/" + version + "/" + name)
+	if err != nil {
+		return nil, err
+	}
+	f := &file{name: name, url: u.String(), server: s}
+
+	if err := f.head(); err != nil {
+		return nil, err
+	}
+	if err := f.fetchChecksum(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// snapshotVersion returns the version of the newest build of a snapshot.
+func snapshotVersion(m metadata, version, classifier string) string {
+	for _, v := range m.Versioning.SnapshotVersions {
+		if v.Extension == "jar" && v.Classifier == classifier && v.Value != "" {
+			return v.Value
+		}
+	}
+
+	// older repositories only record the newest build
+	snapshot := m.Versioning.Snapshot
+	if snapshot.Timestamp == "" || snapshot.LocalCopy {
+		return version
+	}
+	return fmt.Sprintf("%s-%s-%d", strings.TrimSuffix(version, "-SNAPSHOT"), snapshot.Timestamp, snapshot.BuildNumber)
+}
+
+func (s *Server) metadata(ref string, m *metadata) error {
+	u, err := s.repository.Parse(ref)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.request(http.MethodGet, u.String())
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if err := xml.NewDecoder(res.Body).Decode(m); err != nil {
+		return fmt.Errorf("%s: %w", u, err)
+	}
+	return nil
+}
+
+func (s *Server) request(method, u string) (*http.Response, error) {
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if s.o.User != "" || s.o.Password != "" {
+		req.SetBasicAuth(s.o.User, s.o.Password)
+	}
+
+	res, err := s.o.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("%s: unexpected status %q", u, res.Status)
+	}
+	return res, nil
+}
+
+// file is a fync.ServerFile that downloads a mod from the repository.
+type file struct {
+	name    string
+	url     string
+	size    int64
+	modTime time.Time
+	server  *Server
+	res     *http.Response
+
+	// the checksum published for the mod and the hash of its algorithm
+	sum     string
+	newHash func() hash.Hash
+}
+
+// head fills in the mod's size and modification time from the headers of a HEAD request.
+func (f *file) head() error {
+	res, err := f.server.request(http.MethodHead, f.url)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	// a negative length leaves the size unknown
+	f.size = res.ContentLength
+	f.modTime, _ = http.ParseTime(res.Header.Get("Last-Modified"))
+	return nil
+}
+
+// fetchChecksum fetches the strongest checksum the repository publishes for the mod.
+func (f *file) fetchChecksum() error {
+	for _, c := range checksums {
+		res, err := f.server.request(http.MethodGet, f.url+c.ext)
+		if err != nil {
+			continue
+		}
+
+		data, err := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		res.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		// checksum files may also give the name of the file after the checksum
+		fields := strings.Fields(string(data))
+		if len(fields) == 0 {
+			continue
+		}
+		sum := strings.ToLower(fields[0])
+		if b, err := hex.DecodeString(sum); err != nil || len(b) != c.newHash().Size() {
+			return fmt.Errorf("%s%s: invalid checksum %q", f.url, c.ext, fields[0])
+		}
+
+		f.sum, f.newHash = sum, c.newHash
+		return nil
+	}
+	return fmt.Errorf("%s: no checksum published", f.url)
+}
+
+// String returns the URL the mod is downloaded from.
+func (f *file) String() string {
+	return f.url
+}
+
+func (f *file) Stat() (os.FileInfo, error) {
+	return fileInfo{f.name, f.size, f.modTime}, nil
+}
+
+// SHA256 returns the mod's checksum when the repository publishes a SHA-256 checksum for it.
+func (f *file) SHA256() (string, error) {
+	if len(f.sum) == hex.EncodedLen(sha256.Size) {
+		return f.sum, nil
+	}
+	return "", nil
+}
+
+// WriteTo downloads the mod, failing if it does not match its published checksum once written.
+func (f *file) WriteTo(w io.Writer) (int64, error) {
+	if f.res == nil {
+		res, err := f.server.request(http.MethodGet, f.url)
+		if err != nil {
+			return 0, err
+		}
+		f.res = res
+	}
+
+	defer f.Close()
+
+	h := f.newHash()
+	n, err := io.Copy(io.MultiWriter(w, h), f.res.Body)
+	if err != nil {
+		return n, err
+	}
+
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != f.sum {
+		return n, &fync.VerificationError{Path: f.url, Field: "checksum", Expected: f.sum, Actual: sum}
+	}
+	return n, nil
+}
+
+// Seek only supports rewinding to the start of the file so that a failed transfer can be retried.
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekStart {
+		return 0, errors.New("mavenserver: can only seek to the start of a file")
+	}
+	return 0, f.Close()
+}
+
+func (f *file) Close() error {
+	if f.res == nil {
+		return nil
+	}
+
+	err := f.res.Body.Close()
+	f.res = nil
+	return err
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (i fileInfo) Name() string       { return i.name }
+func (i fileInfo) Size() int64        { return i.size }
+func (i fileInfo) Mode() os.FileMode  { return 0644 }
+func (i fileInfo) ModTime() time.Time { return i.modTime }
+func (i fileInfo) IsDir() bool        { return false }
+func (i fileInfo) Sys() interface{}   { return nil }
+
+var (
+	_ fync.Server     = (*Server)(nil)
+	_ fync.HashedFile = (*file)(nil)
+)
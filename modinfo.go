@@ -0,0 +1,196 @@
+package fync
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/json"
+	"strings"
+)
+
+// modInfo is the identity of a mod as declared within its jar.
+type modInfo struct {
+	ID      string
+	Version string
+}
+
+// readModInfo reads a mod's ID and version from the metadata within its jar,
+// supporting Forge's mods.toml and mcmod.info and Fabric's fabric.mod.json.
+// Values that cannot be determined are left empty.
+func readModInfo(path string) (modInfo, error) {
+	var info modInfo
+
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return info, err
+	}
+	defer r.Close()
+
+	files := make(map[string]*zip.File)
+	for _, f := range r.File {
+		files[f.Name] = f
+	}
+
+	if f, ok := files["META-INF/mods.toml"]; ok {
+		data, err := readArchived(f)
+		if err != nil {
+			return info, err
+		}
+		info = parseModsTOML(string(data))
+	} else if f, ok := files["fabric.mod.json"]; ok {
+		data, err := readArchived(f)
+		if err != nil {
+			return info, err
+		}
+
+		var meta struct {
+			ID      string `json:"id"`
+			Version string `json:"version"`
+		}
+		if json.Unmarshal(data, &meta) == nil {
+			info = modInfo{meta.ID, meta.Version}
+		}
+	} else if f, ok := files["mcmod.info"]; ok {
+		data, err := readArchived(f)
+		if err != nil {
+			return info, err
+		}
+
+		// mcmod.info is either a list of mods or an object containing one
+		var list []struct {
+			ModID   string `json:"modid"`
+			Version string `json:"version"`
+		}
+		var wrapped struct {
+			ModList []struct {
+				ModID   string `json:"modid"`
+				Version string `json:"version"`
+			} `json:"modList"`
+		}
+		if json.Unmarshal(data, &list) == nil && len(list) > 0 {
+			info = modInfo{list[0].ModID, list[0].Version}
+		} else if json.Unmarshal(data, &wrapped) == nil && len(wrapped.ModList) > 0 {
+			info = modInfo{wrapped.ModList[0].ModID, wrapped.ModList[0].Version}
+		}
+	}
+
+	// placeholders are filled in from the jar's manifest when built
+	if info.Version == "" || strings.Contains(info.Version, "${") {
+		info.Version = ""
+		if f, ok := files["META-INF/MANIFEST.MF"]; ok {
+			data, err := readArchived(f)
+			if err != nil {
+				return info, err
+			}
+			info.Version = manifestAttribute(string(data), "Implementation-Version")
+		}
+	}
+
+	return info, nil
+}
+
+// parseModsTOML returns the ID and version of the first mod declared in a mods.toml file.
+func parseModsTOML(data string) modInfo {
+	var info modInfo
+	inMods := false
+
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if strings.HasPrefix(line, "[") {
+			// only the first mod is of interest
+			if inMods && line == "[[mods]]" {
+				break
+			}
+			inMods = line == "[[mods]]"
+			continue
+		}
+
+		if !inMods {
+			continue
+		}
+
+		key, value, ok := tomlString(line)
+		if !ok {
+			continue
+		}
+
+		switch key {
+		case "modId":
+			info.ID = value
+		case "version":
+			info.Version = value
+		}
+	}
+
+	return info
+}
+
+// tomlString parses a TOML line assigning a basic or literal string to a key.
+func tomlString(line string) (string, string, bool) {
+	i := strings.Index(line, "=")
+	if i < 0 {
+		return "", "", false
+	}
+
+	key := strings.TrimSpace(line[:i])
+	value := strings.TrimSpace(line[i+1:])
+	if len(value) < 2 {
+		return "", "", false
+	}
+
+	quote := value[0]
+	if quote != '"' && quote != '\'' {
+		return "", "", false
+	}
+
+	end := strings.IndexByte(value[1:], quote)
+	if end < 0 {
+		return "", "", false
+	}
+	return key, value[1 : end+1], true
+}
+
+// manifestAttribute returns the value of the named attribute in a jar manifest's main section.
+func manifestAttribute(manifest, name string) string {
+	scanner := bufio.NewScanner(strings.NewReader(manifest))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			break
+		}
+
+		if strings.HasPrefix(line, name+":") {
+			return strings.TrimSpace(strings.TrimPrefix(line, name+":"))
+		}
+	}
+	return ""
+}
+
+// parseModFileName splits a mod's file name into the name of the mod and its version,
+// such as "jei" and "1.16.5-7.7.1" for "jei-1.16.5-7.7.1.jar". The version begins at the
+// first separator followed by a number, optionally prefixed by "v" or "mc",
+// and is empty when there is none.
+func parseModFileName(name string) (string, string) {
+	name = strings.TrimSuffix(name, ".jar")
+
+	for i := 0; i < len(name)-1; i++ {
+		if name[i] != '-' && name[i] != '_' && name[i] != '+' {
+			continue
+		}
+
+		rest := name[i+1:]
+		version := rest
+		for _, prefix := range []string{"v", "mc"} {
+			if strings.HasPrefix(strings.ToLower(rest), prefix) {
+				rest = rest[len(prefix):]
+				break
+			}
+		}
+
+		if rest != "" && rest[0] >= '0' && rest[0] <= '9' {
+			return name[:i], version
+		}
+	}
+	return name, ""
+}
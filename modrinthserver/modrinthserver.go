@@ -0,0 +1,373 @@
+// Package modrinthserver implements a fync.Server for a list of Modrinth projects,
+// or the projects of a Modrinth collection. The newest version of each project matching
+// the chosen game version and loader is looked up through the Modrinth API each time the mods
+// are listed, so a pack can be defined without hosting any files and follow its mods' releases.
+// Each mod is verified against the SHA-512 hash Modrinth records for it.
+package modrinthserver
+
+import (
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/han-tyumi/fync"
+)
+
+// DefaultAPI is the base URL of the Modrinth API used when none is chosen.
+const DefaultAPI = "https://api.modrinth.com"
+
+// DefaultUserAgent is the User-Agent sent to the Modrinth API when none is chosen.
+// Modrinth asks that it identify the application making the requests.
+const DefaultUserAgent = "han-tyumi/fync"
+
+// fetchConcurrency is the number of projects looked up at once.
+const fetchConcurrency = 8
+
+// Options contains options for the New function.
+type Options struct {
+	// IDs or slugs of the projects whose mods are listed.
+	Projects []string
+
+	// ID of a collection whose projects are listed along with Projects.
+	Collection string
+
+	// The Minecraft version mods must support, such as "1.20.1". Any version if empty.
+	GameVersion string
+
+	// The loader mods must support, such as "fabric" or "forge". Any loader if empty.
+	Loader string
+
+	// Whether beta and alpha versions may be chosen when they are newer than any release.
+	Unstable bool
+
+	// Base URL of the Modrinth API. Defaults to DefaultAPI.
+	API string
+
+	// User-Agent sent to the Modrinth API. Defaults to DefaultUserAgent.
+	UserAgent string
+
+	// The HTTP client used for all requests. Defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// Server is a fync.Server that lists the newest mod of each of a list of Modrinth projects.
+type Server struct {
+	o Options
+}
+
+// New returns a Server for the projects chosen by the options.
+func New(o *Options) (*Server, error) {
+	s := &Server{}
+	if o != nil {
+		s.o = *o
+	}
+	if s.o.API == "" {
+		s.o.API = DefaultAPI
+	}
+	s.o.API = strings.TrimSuffix(s.o.API, "/")
+	if s.o.UserAgent == "" {
+		s.o.UserAgent = DefaultUserAgent
+	}
+	if s.o.Client == nil {
+		s.o.Client = http.DefaultClient
+	}
+
+	if len(s.o.Projects) == 0 && s.o.Collection == "" {
+		return nil, errors.New("no Modrinth projects or collection")
+	}
+	return s, nil
+}
+
+// String returns the projects or collection mods are listed for.
+func (s *Server) String() string {
+	var sources []string
+	if s.o.Collection != "" {
+		sources = append(sources, "collection/"+s.o.Collection)
+	}
+	sources = append(sources, s.o.Projects...)
+
+	str := "modrinth:" + strings.Join(sources, ",")
+	if s.o.GameVersion != "" || s.o.Loader != "" {
+		str += "@" + strings.Trim(s.o.Loader+"-"+s.o.GameVersion, "-")
+	}
+	return str
+}
+
+// Mods looks up the newest matching version of each project and returns a slice of mod ServerFiles
+// for the primary file of each. Mods are not downloaded until they are written.
+func (s *Server) Mods() ([]fync.ServerFile, error) {
+	projects := append([]string(nil), s.o.Projects...)
+	if s.o.Collection != "" {
+		var collection struct {
+			Projects []string `json:"projects"`
+		}
+		if err := s.get("/v3/collection/"+url.PathEscape(s.o.Collection), nil, &collection); err != nil {
+			return nil, err
+		}
+		for _, p := range collection.Projects {
+			if !contains(projects, p) {
+				projects = append(projects, p)
+			}
+		}
+	}
+
+	mods := make([]*download, len(projects))
+	errs := make([]error, len(projects))
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < fetchConcurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				mods[i], errs[i] = s.mod(projects[i])
+			}
+		}()
+	}
+	for i := range projects {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	var files []fync.ServerFile
+	seen := make(map[string]bool)
+	for i := range mods {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+
+		// a project may be listed both by its slug and by its ID
+		if seen[mods[i].projectID] {
+			continue
+		}
+		seen[mods[i].projectID] = true
+		files = append(files, mods[i])
+	}
+	return files, nil
+}
+
+func contains(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+// version is the part of a project version returned by the Modrinth API that is used.
+type version struct {
+	ProjectID     string    `json:"project_id"`
+	VersionNumber string    `json:"version_number"`
+	VersionType   string    `json:"version_type"`
+	DatePublished time.Time `json:"date_published"`
+	Files         []struct {
+		Hashes   map[string]string `json:"hashes"`
+		URL      string            `json:"url"`
+		Filename string            `json:"filename"`
+		Primary  bool              `json:"primary"`
+		Size     int64             `json:"size"`
+	} `json:"files"`
+}
+
+// mod returns the primary file of the newest matching version of the project.
+func (s *Server) mod(project string) (*download, error) {
+	query := url.Values{}
+	if s.o.GameVersion != "" {
+		query.Set("game_versions", `["`+s.o.GameVersion+`"]`)
+	}
+	if s.o.Loader != "" {
+		query.Set("loaders", `["`+s.o.Loader+`"]`)
+	}
+
+	var versions []version
+	if err := s.get("/v2/project/"+url.PathEscape(project)+"/version", query, &versions); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].DatePublished.After(versions[j].DatePublished)
+	})
+
+	for _, v := range versions {
+		if v.VersionType != "release" && !s.o.Unstable {
+			continue
+		}
+		if len(v.Files) == 0 {
+			continue
+		}
+
+		f := v.Files[0]
+		for _, file := range v.Files {
+			if file.Primary {
+				f = file
+				break
+			}
+		}
+
+		if path.Base(f.Filename) != f.Filename || !strings.HasSuffix(f.Filename, ".jar") {
+			return nil, fmt.Errorf("%s %s: %q is not a mod", project, v.VersionNumber, f.Filename)
+		}
+		sum := strings.ToLower(f.Hashes["sha512"])
+		if _, err := hex.DecodeString(sum); err != nil || sum == "" {
+			return nil, fmt.Errorf("%s %s: invalid sha512 hash %q", project, v.VersionNumber, sum)
+		}
+
+		return &download{
+			projectID: v.ProjectID,
+			name:      f.Filename,
+			url:       f.URL,
+			size:      f.Size,
+			modTime:   v.DatePublished,
+			sum:       sum,
+			server:    s,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("%s: %s", project, s.notFound())
+}
+
+// notFound describes the versions that were looked for but not found.
+func (s *Server) notFound() string {
+	msg := "release"
+	if s.o.Unstable {
+		msg = "version"
+	}
+	if s.o.Loader != "" {
+		msg = s.o.Loader + " " + msg
+	}
+	msg = "no " + msg
+	if s.o.GameVersion != "" {
+		msg += " for Minecraft " + s.o.GameVersion
+	}
+	return msg
+}
+
+func (s *Server) get(endpoint string, query url.Values, v interface{}) error {
+	u := s.o.API + endpoint
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	res, err := s.do(u)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return fmt.Errorf("%s: %w", u, err)
+	}
+	return nil
+}
+
+// do sends a GET request for u with the chosen User-Agent.
+func (s *Server) do(u string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", s.o.UserAgent)
+
+	res, err := s.o.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("%s: unexpected status %q", u, res.Status)
+	}
+	return res, nil
+}
+
+// download is a fync.ServerFile that downloads the chosen file of a project.
+type download struct {
+	projectID string
+	name      string
+	url       string
+	size      int64
+	modTime   time.Time
+	sum       string
+	server    *Server
+	res       *http.Response
+}
+
+// String returns the URL the mod is downloaded from.
+func (d *download) String() string {
+	return d.url
+}
+
+func (d *download) Stat() (os.FileInfo, error) {
+	return fileInfo{d.name, d.size, d.modTime}, nil
+}
+
+// WriteTo downloads the mod, failing if it does not match its SHA-512 hash once written.
+func (d *download) WriteTo(w io.Writer) (int64, error) {
+	if d.res == nil {
+		res, err := d.server.do(d.url)
+		if err != nil {
+			return 0, err
+		}
+		d.res = res
+	}
+
+	defer d.Close()
+
+	h := sha512.New()
+	n, err := io.Copy(io.MultiWriter(w, h), d.res.Body)
+	if err != nil {
+		return n, err
+	}
+
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != d.sum {
+		return n, &fync.VerificationError{Path: d.url, Field: "checksum", Expected: d.sum, Actual: sum}
+	}
+	return n, nil
+}
+
+// Seek only supports rewinding to the start of the file so that a failed transfer can be retried.
+func (d *download) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekStart {
+		return 0, errors.New("modrinthserver: can only seek to the start of a file")
+	}
+	return 0, d.Close()
+}
+
+func (d *download) Close() error {
+	if d.res == nil {
+		return nil
+	}
+
+	err := d.res.Body.Close()
+	d.res = nil
+	return err
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (i fileInfo) Name() string       { return i.name }
+func (i fileInfo) Size() int64        { return i.size }
+func (i fileInfo) Mode() os.FileMode  { return 0644 }
+func (i fileInfo) ModTime() time.Time { return i.modTime }
+func (i fileInfo) IsDir() bool        { return false }
+func (i fileInfo) Sys() interface{}   { return nil }
+
+var _ fync.Server = (*Server)(nil)
@@ -0,0 +1,83 @@
+package fync
+
+import (
+	"io"
+	"os"
+)
+
+// move renames the file at from to the path to, falling back to copying and
+// then removing it when the paths are on different filesystems.
+func move(from, to string) error {
+	err := os.Rename(from, to)
+	if err == nil {
+		return nil
+	}
+
+	if linkErr, ok := err.(*os.LinkError); !ok || !crossDevice(linkErr.Err) {
+		return err
+	}
+
+	if err := copyFile(from, to); err != nil {
+		os.Remove(to)
+		return err
+	}
+	return os.Remove(from)
+}
+
+// place renames the file at from to the path to, replacing any existing file. When the paths are
+// on different filesystems it is first copied alongside the path to, so that it only appears once complete.
+func place(from, to string) error {
+	err := os.Rename(from, to)
+	if err == nil {
+		return nil
+	}
+
+	if linkErr, ok := err.(*os.LinkError); !ok || !crossDevice(linkErr.Err) {
+		return err
+	}
+
+	tmp := to + tempExt
+	if err := copyFile(from, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, to); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(from)
+}
+
+// copyFile copies the file at from to the path to,
+// preserving its modification time and syncing it to disk.
+func copyFile(from, to string) error {
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	dest, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+
+	if _, err := io.Copy(dest, src); err != nil {
+		return err
+	}
+
+	if err := dest.Sync(); err != nil {
+		return err
+	}
+
+	if err := dest.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(to, info.ModTime(), info.ModTime())
+}
@@ -0,0 +1,10 @@
+//go:build !windows
+// +build !windows
+
+package fync
+
+import "syscall"
+
+func crossDevice(err error) bool {
+	return err == syscall.EXDEV
+}
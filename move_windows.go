@@ -0,0 +1,10 @@
+package fync
+
+import "syscall"
+
+// errorNotSameDevice is the Windows ERROR_NOT_SAME_DEVICE error code.
+const errorNotSameDevice syscall.Errno = 17
+
+func crossDevice(err error) bool {
+	return err == errorNotSameDevice
+}
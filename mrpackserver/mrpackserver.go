@@ -0,0 +1,383 @@
+// Package mrpackserver implements a fync.Server for the mods of a Modrinth modpack (.mrpack),
+// downloading each mod listed by its modrinth.index.json from its URLs and verifying it against
+// the hashes the index gives for it. Mods the pack includes within its overrides are served
+// from the pack itself, with client overrides taking precedence.
+package mrpackserver
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/han-tyumi/fync"
+)
+
+// indexName is the name of the index within a pack.
+const indexName = "modrinth.index.json"
+
+// Options contains options for the New function.
+type Options struct {
+	// Whether to leave out mods the pack marks as optional for clients.
+	SkipOptional bool
+
+	// The HTTP client used to download the pack and its mods. Defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// Server is a fync.Server that lists the mods of a Modrinth modpack.
+// It should be closed once it is no longer needed.
+type Server struct {
+	location string
+	pack     *zip.Reader
+	closer   io.Closer
+	index    index
+	o        Options
+}
+
+// index is the part of a pack's modrinth.index.json that is used.
+type index struct {
+	FormatVersion int         `json:"formatVersion"`
+	Game          string      `json:"game"`
+	VersionID     string      `json:"versionId"`
+	Name          string      `json:"name"`
+	Files         []indexFile `json:"files"`
+}
+
+// indexFile is a single file the pack's index lists for download.
+type indexFile struct {
+	Path      string            `json:"path"`
+	Hashes    map[string]string `json:"hashes"`
+	Downloads []string          `json:"downloads"`
+	FileSize  int64             `json:"fileSize"`
+	Env       *struct {
+		Client string `json:"client"`
+	} `json:"env"`
+}
+
+// New returns a Server for the pack at the given path or http(s) URL.
+// Packs given by URL are downloaded into memory.
+func New(location string, o *Options) (*Server, error) {
+	s := &Server{location: location}
+	if o != nil {
+		s.o = *o
+	}
+	if s.o.Client == nil {
+		s.o.Client = http.DefaultClient
+	}
+
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		res, err := get(s.o.Client, location)
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		if s.pack, err = zip.NewReader(bytes.NewReader(data), int64(len(data))); err != nil {
+			return nil, fmt.Errorf("%s: %w", location, err)
+		}
+	} else {
+		rc, err := zip.OpenReader(location)
+		if err != nil {
+			return nil, err
+		}
+		s.pack = &rc.Reader
+		s.closer = rc
+	}
+
+	if err := s.readIndex(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// readIndex reads and validates the pack's index.
+func (s *Server) readIndex() error {
+	for _, f := range s.pack.File {
+		if f.Name != indexName {
+			continue
+		}
+
+		r, err := f.Open()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		if err := json.NewDecoder(r).Decode(&s.index); err != nil {
+			return fmt.Errorf("%s: %s: %w", s.location, indexName, err)
+		}
+
+		if s.index.FormatVersion != 1 {
+			return fmt.Errorf("%s: unsupported format version %d", s.location, s.index.FormatVersion)
+		}
+		if s.index.Game != "minecraft" {
+			return fmt.Errorf("%s: unsupported game %q", s.location, s.index.Game)
+		}
+		return nil
+	}
+	return fmt.Errorf("%s: no %s", s.location, indexName)
+}
+
+// String returns the pack's name and version.
+func (s *Server) String() string {
+	return s.index.Name + " " + s.index.VersionID
+}
+
+// Close closes a local pack.
+func (s *Server) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
+
+// Mods returns a slice of mod ServerFiles for each mod the pack lists or overrides.
+// Mods are not downloaded until they are written.
+func (s *Server) Mods() ([]fync.ServerFile, error) {
+	mods := make(map[string]fync.ServerFile)
+	var names []string
+	add := func(name string, f fync.ServerFile) {
+		if _, ok := mods[name]; !ok {
+			names = append(names, name)
+		}
+		mods[name] = f
+	}
+
+	for _, f := range s.index.Files {
+		name, ok := modName(f.Path)
+		if !ok {
+			continue
+		}
+
+		if f.Env != nil && (f.Env.Client == "unsupported" || f.Env.Client == "optional" && s.o.SkipOptional) {
+			continue
+		}
+
+		if len(f.Downloads) == 0 {
+			return nil, fmt.Errorf("%s: %s has no downloads", s, f.Path)
+		}
+
+		d := &download{indexFile: f, name: name, client: s.o.Client}
+		if err := d.checkHashes(); err != nil {
+			return nil, err
+		}
+		add(name, d)
+	}
+
+	// overrides replace the listed files, and client overrides replace both
+	for _, dir := range []string{"overrides/", "client-overrides/"} {
+		for _, f := range s.pack.File {
+			if !strings.HasPrefix(f.Name, dir) || f.FileInfo().IsDir() {
+				continue
+			}
+
+			if name, ok := modName(strings.TrimPrefix(f.Name, dir)); ok {
+				add(name, &override{server: s, f: f})
+			}
+		}
+	}
+
+	files := make([]fync.ServerFile, len(names))
+	for i, name := range names {
+		files[i] = mods[name]
+	}
+	return files, nil
+}
+
+// modName returns the name of the mod at the path within an instance,
+// or false if the path is not a mod.
+func modName(p string) (string, bool) {
+	if path.Dir(p) != "mods" || !strings.HasSuffix(p, ".jar") {
+		return "", false
+	}
+	return path.Base(p), true
+}
+
+func get(client *http.Client, u string) (*http.Response, error) {
+	res, err := client.Get(u)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("%s: unexpected status %q", u, res.Status)
+	}
+	return res, nil
+}
+
+// download is a fync.ServerFile that downloads a mod listed by the pack's index.
+type download struct {
+	indexFile
+	name   string
+	client *http.Client
+	res    *http.Response
+
+	// index of the download URL to try first
+	next int
+}
+
+// checkHashes ensures the index lists a hash the mod can be verified against.
+func (d *download) checkHashes() error {
+	for _, algorithm := range []string{"sha512", "sha1"} {
+		if sum, ok := d.Hashes[algorithm]; ok {
+			if _, err := hex.DecodeString(sum); err != nil {
+				return fmt.Errorf("%s: invalid %s hash %q", d.Path, algorithm, sum)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: no sha512 or sha1 hash", d.Path)
+}
+
+// String returns the first URL the mod is downloaded from.
+func (d *download) String() string {
+	return d.Downloads[0]
+}
+
+func (d *download) Stat() (os.FileInfo, error) {
+	return fileInfo{d.name, d.FileSize, time.Time{}}, nil
+}
+
+// WriteTo downloads the mod from the first of its URLs that responds,
+// failing if it does not match its hash once written.
+func (d *download) WriteTo(w io.Writer) (int64, error) {
+	if d.res == nil {
+		var err error
+		for ; d.next < len(d.Downloads); d.next++ {
+			if d.res, err = get(d.client, d.Downloads[d.next]); err == nil {
+				break
+			}
+		}
+		if d.res == nil {
+			d.next = 0
+			return 0, err
+		}
+	}
+
+	defer d.Close()
+
+	var h hash.Hash = sha512.New()
+	algorithm := "sha512"
+	if _, ok := d.Hashes[algorithm]; !ok {
+		algorithm, h = "sha1", sha1.New()
+	}
+
+	n, err := io.Copy(io.MultiWriter(w, h), d.res.Body)
+	if err != nil {
+		return n, err
+	}
+
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != strings.ToLower(d.Hashes[algorithm]) {
+		// a later attempt tries the next URL
+		d.next++
+		return n, &fync.VerificationError{
+			Path:     d.Downloads[d.next-1],
+			Field:    "checksum",
+			Expected: d.Hashes[algorithm],
+			Actual:   sum,
+		}
+	}
+	return n, nil
+}
+
+// Seek only supports rewinding to the start of the file so that a failed transfer can be retried.
+func (d *download) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekStart {
+		return 0, errors.New("mrpackserver: can only seek to the start of a file")
+	}
+	if d.next >= len(d.Downloads) {
+		d.next = 0
+	}
+	return 0, d.Close()
+}
+
+func (d *download) Close() error {
+	if d.res == nil {
+		return nil
+	}
+
+	err := d.res.Body.Close()
+	d.res = nil
+	return err
+}
+
+// override is a fync.ServerFile that reads a mod from the pack's overrides.
+type override struct {
+	server *Server
+	f      *zip.File
+	r      io.ReadCloser
+}
+
+// String returns the location of the mod within the pack.
+func (o *override) String() string {
+	return o.server.location + "!/" + o.f.Name
+}
+
+func (o *override) Stat() (os.FileInfo, error) {
+	return fileInfo{path.Base(o.f.Name), int64(o.f.UncompressedSize64), o.f.Modified}, nil
+}
+
+func (o *override) WriteTo(w io.Writer) (int64, error) {
+	if o.r == nil {
+		r, err := o.f.Open()
+		if err != nil {
+			return 0, err
+		}
+		o.r = r
+	}
+
+	defer o.Close()
+	return io.Copy(w, o.r)
+}
+
+// Seek only supports rewinding to the start of the file so that a failed transfer can be retried.
+func (o *override) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekStart {
+		return 0, errors.New("mrpackserver: can only seek to the start of a file")
+	}
+	return 0, o.Close()
+}
+
+func (o *override) Close() error {
+	if o.r == nil {
+		return nil
+	}
+
+	err := o.r.Close()
+	o.r = nil
+	return err
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (i fileInfo) Name() string       { return i.name }
+func (i fileInfo) Size() int64        { return i.size }
+func (i fileInfo) Mode() os.FileMode  { return 0644 }
+func (i fileInfo) ModTime() time.Time { return i.modTime }
+func (i fileInfo) IsDir() bool        { return false }
+func (i fileInfo) Sys() interface{}   { return nil }
+
+var _ fync.Server = (*Server)(nil)
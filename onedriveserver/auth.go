@@ -0,0 +1,227 @@
+package onedriveserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// scope is the access requested when signing in, which includes a refresh token.
+const scope = "Files.Read.All offline_access"
+
+// deviceGrant is the grant type of tokens requested with a device code.
+const deviceGrant = "urn:ietf:params:oauth:grant-type:device_code"
+
+// authorizer obtains access tokens for a signed in account, signing in with a device code
+// when there is no refresh token to obtain them with.
+type authorizer struct {
+	endpoint     string
+	clientID     string
+	tokenFile    string
+	onDeviceCode func(verificationURI, userCode string)
+	client       *http.Client
+
+	mu           sync.Mutex
+	token        string
+	refreshToken string
+	expires      time.Time
+}
+
+// tokenResult is the response of the token endpoint.
+type tokenResult struct {
+	AccessToken      string `json:"access_token"`
+	RefreshToken     string `json:"refresh_token"`
+	ExpiresIn        int    `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// tokenError is an error returned by the token endpoint.
+type tokenError struct {
+	code string
+	msg  string
+}
+
+func (e *tokenError) Error() string {
+	return "onedriveserver: signing in: " + e.msg
+}
+
+// accessToken returns a cached access token, refreshing it when it is about to expire.
+func (a *authorizer) accessToken() (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.token != "" && time.Now().Add(time.Minute).Before(a.expires) {
+		return a.token, nil
+	}
+
+	if a.refreshToken == "" && a.tokenFile != "" {
+		var saved struct {
+			RefreshToken string `json:"refresh_token"`
+		}
+		data, err := ioutil.ReadFile(a.tokenFile)
+		if err == nil {
+			err = json.Unmarshal(data, &saved)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return "", fmt.Errorf("onedriveserver: reading token file: %w", err)
+		}
+		a.refreshToken = saved.RefreshToken
+	}
+
+	var result *tokenResult
+	var err error
+	if a.refreshToken != "" {
+		result, err = a.request("/token", url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {a.refreshToken},
+			"client_id":     {a.clientID},
+			"scope":         {scope},
+		})
+
+		// a refresh token that has expired or been revoked requires signing in again
+		if e, ok := err.(*tokenError); ok && e.code == "invalid_grant" {
+			result, err = nil, nil
+		}
+	}
+	if result == nil && err == nil {
+		result, err = a.signIn()
+	}
+	if err != nil {
+		return "", err
+	}
+
+	a.token = result.AccessToken
+	a.expires = time.Now().Add(time.Duration(result.ExpiresIn) * time.Second)
+
+	// refresh tokens are replaced each time they are used
+	if result.RefreshToken != "" && result.RefreshToken != a.refreshToken {
+		a.refreshToken = result.RefreshToken
+		if a.tokenFile != "" {
+			if err := a.save(); err != nil {
+				return "", fmt.Errorf("onedriveserver: saving token file: %w", err)
+			}
+		}
+	}
+	return a.token, nil
+}
+
+// signIn signs in with a device code, waiting for the user to enter the code
+// at the verification URI on another device.
+func (a *authorizer) signIn() (*tokenResult, error) {
+	if a.onDeviceCode == nil {
+		return nil, errors.New("onedriveserver: signing in: OnDeviceCode is required to sign in with a device code")
+	}
+
+	res, err := a.client.PostForm(a.endpoint+"/devicecode", url.Values{"client_id": {a.clientID}, "scope": {scope}})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var code struct {
+		DeviceCode      string `json:"device_code"`
+		UserCode        string `json:"user_code"`
+		VerificationURI string `json:"verification_uri"`
+		ExpiresIn       int    `json:"expires_in"`
+		Interval        int    `json:"interval"`
+		tokenResult
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<16)).Decode(&code); err != nil && res.StatusCode == http.StatusOK {
+		return nil, fmt.Errorf("onedriveserver: signing in: %w", err)
+	}
+	if res.StatusCode != http.StatusOK || code.DeviceCode == "" {
+		return nil, code.tokenResult.err(res)
+	}
+
+	a.onDeviceCode(code.VerificationURI, code.UserCode)
+
+	interval := time.Duration(code.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	deadline := time.Now().Add(time.Duration(code.ExpiresIn) * time.Second)
+
+	for {
+		time.Sleep(interval)
+
+		result, err := a.request("/token", url.Values{
+			"grant_type":  {deviceGrant},
+			"device_code": {code.DeviceCode},
+			"client_id":   {a.clientID},
+		})
+		if e, ok := err.(*tokenError); ok && time.Now().Before(deadline) {
+			switch e.code {
+			case "authorization_pending":
+				continue
+			case "slow_down":
+				interval += 5 * time.Second
+				continue
+			}
+		}
+		return result, err
+	}
+}
+
+// request sends a form to an endpoint of the token service.
+func (a *authorizer) request(endpoint string, form url.Values) (*tokenResult, error) {
+	res, err := a.client.PostForm(a.endpoint+endpoint, form)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var result tokenResult
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<16)).Decode(&result); err != nil && res.StatusCode == http.StatusOK {
+		return nil, fmt.Errorf("onedriveserver: signing in: %w", err)
+	}
+	if res.StatusCode != http.StatusOK || result.AccessToken == "" {
+		return nil, result.err(res)
+	}
+	return &result, nil
+}
+
+// err returns the error described by the result of a failed request.
+func (r *tokenResult) err(res *http.Response) error {
+	msg := r.ErrorDescription
+	if msg == "" {
+		msg = r.Error
+	}
+	if msg == "" {
+		msg = fmt.Sprintf("unexpected status %q", res.Status)
+	}
+
+	// descriptions are followed by trace and correlation IDs on separate lines
+	if i := strings.IndexAny(msg, "\r\n"); i >= 0 {
+		msg = msg[:i]
+	}
+	return &tokenError{code: r.Error, msg: msg}
+}
+
+// save writes the refresh token to the token file, readable only by its owner.
+func (a *authorizer) save() error {
+	data, err := json.Marshal(struct {
+		RefreshToken string `json:"refresh_token"`
+	}{a.refreshToken})
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(a.tokenFile), 0700); err != nil {
+		return err
+	}
+	tmp := a.tokenFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, a.tokenFile)
+}
@@ -0,0 +1,332 @@
+// Package onedriveserver implements a fync.Server for mods within a OneDrive or SharePoint folder,
+// either a folder of the signed in account or a folder shared by a link, read through the Microsoft Graph API.
+//
+// Access tokens are either given directly or obtained by signing in with a device code,
+// entered in a browser on any device, which suits players whose only storage is a school or work account.
+// The refresh token obtained by signing in may be kept in a file so that signing in is only needed once.
+// Each mod is verified against the SHA-1 hash OneDrive reports for personal accounts,
+// or the QuickXorHash it reports for business accounts and SharePoint.
+package onedriveserver
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/han-tyumi/fync"
+)
+
+// Default base URLs of the Graph API and of the Microsoft identity platform used when none are chosen.
+const (
+	DefaultAPI   = "https://graph.microsoft.com/v1.0"
+	DefaultLogin = "https://login.microsoftonline.com"
+)
+
+// DefaultTenant is the tenant signed in to when none is chosen, which allows both personal and
+// work or school accounts.
+const DefaultTenant = "common"
+
+// Options contains options for the New function.
+type Options struct {
+	// An access token used to authorize requests instead of signing in.
+	Token string
+
+	// The application (client) ID of an app registered with Microsoft Entra ID allowing public client flows,
+	// used to sign in with a device code.
+	ClientID string
+
+	// The tenant signed in to, such as "consumers" for personal accounts only,
+	// or an organization's domain or ID. Defaults to DefaultTenant.
+	Tenant string
+
+	// Path of a file keeping the refresh token obtained by signing in, so that signing in is only needed
+	// once rather than each time the Server is created. It is created readable only by its owner.
+	TokenFile string
+
+	// Called with the URI to visit and the code to enter there when signing in with a device code.
+	// Signing in waits until the code is entered or expires.
+	OnDeviceCode func(verificationURI, userCode string)
+
+	// Base URLs of the Graph API and of the Microsoft identity platform. Default to DefaultAPI and DefaultLogin.
+	API, Login string
+
+	// The HTTP client used for all requests. Defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// Server is a fync.Server that lists mods from a OneDrive or SharePoint folder.
+type Server struct {
+	location string
+	item     string
+	api      string
+	client   *http.Client
+	token    string
+	auth     *authorizer
+}
+
+// New returns a Server for the folder at the given path within the signed in account's OneDrive,
+// such as "/mods", or for the folder shared by the given sharing link.
+func New(location string, o *Options) (*Server, error) {
+	if o == nil {
+		o = &Options{}
+	}
+
+	s := &Server{
+		location: location,
+		api:      strings.TrimSuffix(o.API, "/"),
+		client:   o.Client,
+		token:    o.Token,
+	}
+	if s.api == "" {
+		s.api = DefaultAPI
+	}
+	if s.client == nil {
+		s.client = http.DefaultClient
+	}
+
+	if strings.HasPrefix(location, "https://") {
+		// sharing links are addressed by their unpadded base64url encoding
+		s.item = "/shares/u!" + strings.TrimRight(base64.URLEncoding.EncodeToString([]byte(location)), "=") + "/driveItem"
+	} else if p := strings.Trim(path.Clean("/"+location), "/"); p == "" {
+		s.item = "/me/drive/root"
+	} else {
+		s.item = "/me/drive/root:/" + escapePath(p) + ":"
+	}
+
+	if s.token == "" {
+		if o.ClientID == "" {
+			return nil, errors.New("onedriveserver: an access token or client ID is required")
+		}
+
+		login, tenant := strings.TrimSuffix(o.Login, "/"), o.Tenant
+		if login == "" {
+			login = DefaultLogin
+		}
+		if tenant == "" {
+			tenant = DefaultTenant
+		}
+		s.auth = &authorizer{
+			endpoint:     login + "/" + url.PathEscape(tenant) + "/oauth2/v2.0",
+			clientID:     o.ClientID,
+			tokenFile:    o.TokenFile,
+			onDeviceCode: o.OnDeviceCode,
+			client:       s.client,
+		}
+	}
+	return s, nil
+}
+
+// escapePath escapes each element of a path.
+func escapePath(p string) string {
+	elems := strings.Split(p, "/")
+	for i, elem := range elems {
+		elems[i] = url.PathEscape(elem)
+	}
+	return strings.Join(elems, "/")
+}
+
+// String returns the path of the folder, or its sharing link.
+func (s *Server) String() string {
+	if strings.HasPrefix(s.location, "https://") {
+		return s.location
+	}
+	return "onedrive:" + path.Clean("/"+s.location)
+}
+
+// driveItem is the part of a file or folder listed by the Graph API that is used.
+type driveItem struct {
+	ID                   string    `json:"id"`
+	Name                 string    `json:"name"`
+	Size                 int64     `json:"size"`
+	LastModifiedDateTime time.Time `json:"lastModifiedDateTime"`
+	ParentReference      struct {
+		DriveID string `json:"driveId"`
+	} `json:"parentReference"`
+	File *struct {
+		Hashes struct {
+			SHA1Hash     string `json:"sha1Hash"`
+			SHA256Hash   string `json:"sha256Hash"`
+			QuickXorHash string `json:"quickXorHash"`
+		} `json:"hashes"`
+	} `json:"file"`
+	RemoteItem *driveItem `json:"remoteItem"`
+}
+
+// Mods returns a slice of mod ServerFiles for each jar within the folder.
+// Mods are not downloaded until they are written.
+func (s *Server) Mods() ([]fync.ServerFile, error) {
+	var folder driveItem
+	if err := s.get(s.api+s.item, &folder); err != nil {
+		return nil, err
+	}
+
+	// folders shared with the account and added to its OneDrive are kept in the sharer's drive
+	if folder.RemoteItem != nil {
+		folder = *folder.RemoteItem
+	}
+
+	var mods []fync.ServerFile
+	u := s.api + "/drives/" + url.PathEscape(folder.ParentReference.DriveID) + "/items/" + url.PathEscape(folder.ID) + "/children"
+	for u != "" {
+		var result struct {
+			Value    []driveItem `json:"value"`
+			NextLink string      `json:"@odata.nextLink"`
+		}
+		if err := s.get(u, &result); err != nil {
+			return nil, err
+		}
+
+		for _, item := range result.Value {
+			if item.File != nil && strings.HasSuffix(item.Name, ".jar") {
+				mods = append(mods, &file{server: s, driveItem: item})
+			}
+		}
+		u = result.NextLink
+	}
+	return mods, nil
+}
+
+// get requests a URL of the API, decoding its JSON response into v.
+func (s *Server) get(u string, v interface{}) error {
+	res, err := s.request(u)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return fmt.Errorf("%s: %w", s, err)
+	}
+	return nil
+}
+
+// request sends an authorized GET request.
+func (s *Server) request(u string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	token := s.token
+	if s.auth != nil {
+		if token, err = s.auth.accessToken(); err != nil {
+			return nil, err
+		}
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		defer res.Body.Close()
+
+		var e struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if json.NewDecoder(io.LimitReader(res.Body, 1<<16)).Decode(&e) == nil && e.Error.Message != "" {
+			return nil, fmt.Errorf("%s: %s", s, e.Error.Message)
+		}
+		return nil, fmt.Errorf("%s: unexpected status %q", s, res.Status)
+	}
+	return res, nil
+}
+
+// file is a fync.ServerFile that downloads a mod from the folder.
+type file struct {
+	server *Server
+	driveItem
+	res *http.Response
+}
+
+// String returns the path of the mod, or its path within the sharing link.
+func (f *file) String() string {
+	return strings.TrimSuffix(f.server.String(), "/") + "/" + f.Name
+}
+
+func (f *file) Stat() (os.FileInfo, error) {
+	return fileInfo{f.driveItem}, nil
+}
+
+// SHA256 returns the SHA-256 hash OneDrive reports for the mod, which only some personal accounts have.
+func (f *file) SHA256() (string, error) {
+	return strings.ToLower(f.File.Hashes.SHA256Hash), nil
+}
+
+// WriteTo downloads the mod, failing if it does not match the hash OneDrive reports for it once written.
+func (f *file) WriteTo(w io.Writer) (int64, error) {
+	if f.res == nil {
+		// the content is redirected to a preauthenticated URL, which the authorization is not sent to
+		res, err := f.server.request(f.server.api + "/drives/" + url.PathEscape(f.ParentReference.DriveID) + "/items/" + url.PathEscape(f.ID) + "/content")
+		if err != nil {
+			return 0, err
+		}
+		f.res = res
+	}
+
+	defer f.Close()
+
+	sha := sha1.New()
+	xor := &quickXorHash{}
+	n, err := io.Copy(io.MultiWriter(w, sha, xor), f.res.Body)
+	if err != nil {
+		return n, err
+	}
+
+	hashes := f.File.Hashes
+	if hashes.SHA1Hash != "" {
+		if sum := hex.EncodeToString(sha.Sum(nil)); !strings.EqualFold(sum, hashes.SHA1Hash) {
+			return n, &fync.VerificationError{Path: f.String(), Field: "checksum", Expected: strings.ToLower(hashes.SHA1Hash), Actual: sum}
+		}
+	} else if hashes.QuickXorHash != "" {
+		if sum := xor.sum(); sum != hashes.QuickXorHash {
+			return n, &fync.VerificationError{Path: f.String(), Field: "checksum", Expected: hashes.QuickXorHash, Actual: sum}
+		}
+	}
+	return n, nil
+}
+
+// Seek only supports rewinding to the start of the file so that a failed transfer can be retried.
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekStart {
+		return 0, errors.New("onedriveserver: can only seek to the start of a file")
+	}
+	return 0, f.Close()
+}
+
+func (f *file) Close() error {
+	if f.res == nil {
+		return nil
+	}
+
+	err := f.res.Body.Close()
+	f.res = nil
+	return err
+}
+
+type fileInfo struct {
+	driveItem
+}
+
+func (i fileInfo) Name() string       { return i.driveItem.Name }
+func (i fileInfo) Size() int64        { return i.driveItem.Size }
+func (i fileInfo) Mode() os.FileMode  { return 0644 }
+func (i fileInfo) ModTime() time.Time { return i.LastModifiedDateTime }
+func (i fileInfo) IsDir() bool        { return false }
+func (i fileInfo) Sys() interface{}   { return nil }
+
+var _ fync.HashedFile = (*file)(nil)
@@ -0,0 +1,87 @@
+package onedriveserver
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+)
+
+// Parameters of the QuickXorHash, whose width is split across three 64-bit cells, the last holding 32 bits.
+const (
+	quickXorWidth = 160
+	quickXorShift = 11
+)
+
+// quickXorHash computes the QuickXorHash OneDrive reports for files in business accounts and SharePoint,
+// which XORs each byte of the file into a 160-bit value at an offset advancing 11 bits per byte,
+// and then XORs in the length of the file.
+type quickXorHash struct {
+	data   [3]uint64
+	shift  int
+	length uint64
+}
+
+func (h *quickXorHash) Write(p []byte) (int, error) {
+	cell := h.shift / 64
+	offset := h.shift % 64
+
+	iterations := len(p)
+	if iterations > quickXorWidth {
+		iterations = quickXorWidth
+	}
+
+	// bytes a multiple of the width apart are XORed at the same offset
+	for i := 0; i < iterations; i++ {
+		last := cell == len(h.data)-1
+		bits := 64
+		if last {
+			bits = quickXorWidth % 64
+		}
+
+		if offset <= bits-8 {
+			for j := i; j < len(p); j += quickXorWidth {
+				h.data[cell] ^= uint64(p[j]) << uint(offset)
+			}
+		} else {
+			next := cell + 1
+			if last {
+				next = 0
+			}
+
+			var b byte
+			for j := i; j < len(p); j += quickXorWidth {
+				b ^= p[j]
+			}
+			h.data[cell] ^= uint64(b) << uint(offset)
+			h.data[next] ^= uint64(b) >> uint(bits-offset)
+		}
+
+		offset += quickXorShift
+		for offset >= bits {
+			if last {
+				cell = 0
+			} else {
+				cell++
+			}
+			offset -= bits
+		}
+	}
+
+	h.shift = (h.shift + quickXorShift*(len(p)%quickXorWidth)) % quickXorWidth
+	h.length += uint64(len(p))
+	return len(p), nil
+}
+
+// sum returns the base64 encoded hash of everything written.
+func (h *quickXorHash) sum() string {
+	var b [quickXorWidth / 8]byte
+	binary.LittleEndian.PutUint64(b[0:], h.data[0])
+	binary.LittleEndian.PutUint64(b[8:], h.data[1])
+	binary.LittleEndian.PutUint32(b[16:], uint32(h.data[2]))
+
+	var length [8]byte
+	binary.LittleEndian.PutUint64(length[:], h.length)
+	for i, l := range length {
+		b[len(b)-len(length)+i] ^= l
+	}
+	return base64.StdEncoding.EncodeToString(b[:])
+}
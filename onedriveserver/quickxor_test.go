@@ -0,0 +1,88 @@
+package onedriveserver
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"math/rand"
+	"testing"
+)
+
+func TestQuickXorHash(t *testing.T) {
+	// hashes computed by Microsoft's reference implementation, by the base64 encoded content
+	tests := []struct {
+		content, sum string
+	}{
+		{"", "AAAAAAAAAAAAAAAAAAAAAAAAAAA="},
+		{"Sg==", "SgAAAAAAAAAAAAAAAQAAAAAAAAA="},
+		{"tbQ=", "taAFAAAAAAAAAAAAAgAAAAAAAAA="},
+	}
+
+	for _, tt := range tests {
+		p, err := base64.StdEncoding.DecodeString(tt.content)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		h := &quickXorHash{}
+		h.Write(p)
+		if got := h.sum(); got != tt.sum {
+			t.Errorf("QuickXorHash(%s) = %s, want %s", tt.content, got, tt.sum)
+		}
+	}
+}
+
+func TestQuickXorHashChunks(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, n := range []int{1, 19, 159, 160, 161, 1000, 64 << 10} {
+		p := make([]byte, n)
+		rng.Read(p)
+		want := referenceQuickXor(p)
+
+		// chunks not a multiple of the width, so that each starts at a different shift
+		h := &quickXorHash{}
+		for rest := p; len(rest) > 0; {
+			k := rng.Intn(400) + 1
+			if k > len(rest) {
+				k = len(rest)
+			}
+			h.Write(rest[:k])
+			rest = rest[k:]
+		}
+		if got := h.sum(); got != want {
+			t.Errorf("QuickXorHash of %d bytes written in chunks = %s, want %s", n, got, want)
+		}
+
+		whole := &quickXorHash{}
+		whole.Write(p)
+		if got := whole.sum(); got != want {
+			t.Errorf("QuickXorHash of %d bytes = %s, want %s", n, got, want)
+		}
+	}
+}
+
+// referenceQuickXor computes the QuickXorHash one bit at a time, as it is specified.
+func referenceQuickXor(p []byte) string {
+	var bits [quickXorWidth]bool
+	for i, c := range p {
+		for k := 0; k < 8; k++ {
+			if c>>uint(k)&1 == 1 {
+				j := (i*quickXorShift + k) % quickXorWidth
+				bits[j] = !bits[j]
+			}
+		}
+	}
+
+	var b [quickXorWidth / 8]byte
+	for i, set := range bits {
+		if set {
+			b[i/8] |= 1 << uint(i%8)
+		}
+	}
+
+	var length [8]byte
+	binary.LittleEndian.PutUint64(length[:], uint64(len(p)))
+	for i, l := range length {
+		b[len(b)-len(length)+i] ^= l
+	}
+	return base64.StdEncoding.EncodeToString(b[:])
+}
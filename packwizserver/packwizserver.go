@@ -0,0 +1,398 @@
+// Package packwizserver implements a fync.Server for the mods of a packwiz pack,
+// given as the URL or path of its pack.toml. The pack's index and the metadata of each mod
+// are fetched and verified against the hashes that refer to them, and each mod is verified
+// against its own hash as it is downloaded.
+//
+// packwiz does not record the sizes of mods, so the mods have an unknown size
+// and are compared with local mods by their checksum when it is a SHA-256 hash.
+// Mods whose metadata only identifies them on CurseForge, without a download URL, are unsupported.
+package packwizserver
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/han-tyumi/fync"
+)
+
+// fetchConcurrency is the number of mod metadata files fetched at once.
+const fetchConcurrency = 8
+
+// metadataExt is the extension of the files describing each mod packwiz does not store itself.
+const metadataExt = ".pw.toml"
+
+// Options contains options for the New function.
+type Options struct {
+	// The HTTP client used for all requests. Defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// Server is a fync.Server that lists the mods of a packwiz pack.
+type Server struct {
+	pack   location
+	client *http.Client
+
+	mu   sync.Mutex
+	name string
+}
+
+// New returns a Server for the pack.toml at the given http(s) URL or path.
+func New(pack string, o *Options) (*Server, error) {
+	var loc location
+	if u, err := url.Parse(pack); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		loc.url = u
+	} else {
+		abs, err := filepath.Abs(pack)
+		if err != nil {
+			return nil, err
+		}
+		loc.path = abs
+	}
+
+	s := &Server{pack: loc, client: http.DefaultClient}
+	if o != nil && o.Client != nil {
+		s.client = o.Client
+	}
+	return s, nil
+}
+
+// String returns the pack's name once it is known, or the location of its pack.toml.
+func (s *Server) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.name != "" {
+		return s.name
+	}
+	return s.pack.String()
+}
+
+// Mods fetches the pack's index and returns a slice of mod ServerFiles for each client mod it lists.
+// Mods are not downloaded until they are written.
+func (s *Server) Mods() ([]fync.ServerFile, error) {
+	pack, err := s.document(s.pack, "", "")
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.name = pack.get("", "name")
+	if version := pack.get("", "version"); version != "" {
+		s.name += " " + version
+	}
+	s.mu.Unlock()
+
+	indexRef := pack.get("index", "file")
+	if indexRef == "" {
+		return nil, fmt.Errorf("%s: no index", s.pack)
+	}
+	indexLoc := s.pack.resolve(indexRef)
+	index, err := s.document(indexLoc, pack.get("index", "hash-format"), pack.get("index", "hash"))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []map[string]string
+	for _, f := range index.arrays["files"] {
+		if path.Dir(path.Clean(f["file"])) == "mods" {
+			entries = append(entries, f)
+		}
+	}
+
+	mods := make([]*file, len(entries))
+	errs := make([]error, len(entries))
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < fetchConcurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				mods[i], errs[i] = s.mod(indexLoc, index.get("", "hash-format"), entries[i])
+			}
+		}()
+	}
+	for i := range entries {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	var files []fync.ServerFile
+	for i := range mods {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		if mods[i] != nil {
+			files = append(files, mods[i])
+		}
+	}
+	return files, nil
+}
+
+// mod returns the mod for an entry of the index, or nil if it is not a client mod.
+func (s *Server) mod(index location, defaultFormat string, entry map[string]string) (*file, error) {
+	ref := entry["file"]
+	u := index.resolve(ref)
+
+	format := entry["hash-format"]
+	if format == "" {
+		format = defaultFormat
+	}
+
+	// jars stored within the pack itself
+	if entry["metafile"] != "true" {
+		if !strings.HasSuffix(ref, ".jar") {
+			return nil, nil
+		}
+		return s.newFile(path.Base(ref), u, format, entry["hash"])
+	}
+
+	meta, err := s.document(u, format, entry["hash"])
+	if err != nil {
+		return nil, err
+	}
+
+	if meta.get("", "side") == "server" {
+		return nil, nil
+	}
+
+	name := meta.get("", "filename")
+	if name == "" || path.Base(name) != name {
+		return nil, fmt.Errorf("%s: invalid filename %q", u, name)
+	}
+	if !strings.HasSuffix(name, ".jar") {
+		return nil, nil
+	}
+
+	download := meta.get("download", "url")
+	if download == "" {
+		return nil, fmt.Errorf("%s: %s has no download URL, as when it is only available through CurseForge", u, name)
+	}
+	downloadLoc, err := s.download(download)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", u, err)
+	}
+
+	return s.newFile(name, downloadLoc, meta.get("download", "hash-format"), meta.get("download", "hash"))
+}
+
+// download returns the location of a mod's download URL, which may only be a local file when the pack is.
+func (s *Server) download(download string) (location, error) {
+	u, err := url.Parse(download)
+	if err != nil {
+		return location{}, err
+	}
+
+	switch {
+	case u.Scheme == "http" || u.Scheme == "https":
+		return location{url: u}, nil
+	case u.Scheme == "file" && s.pack.url == nil:
+		// file:///C:/mod.jar names C:/mod.jar on Windows
+		p := filepath.FromSlash(u.Path)
+		if trimmed := filepath.FromSlash(strings.TrimPrefix(u.Path, "/")); filepath.VolumeName(trimmed) != "" {
+			p = trimmed
+		}
+		return location{path: p}, nil
+	default:
+		return location{}, fmt.Errorf("unsupported download URL %q", download)
+	}
+}
+
+func (s *Server) newFile(name string, u location, format, sum string) (*file, error) {
+	if _, err := newHash(format); err != nil {
+		return nil, fmt.Errorf("%s: %w", u, err)
+	}
+	if _, err := hex.DecodeString(sum); err != nil || sum == "" {
+		return nil, fmt.Errorf("%s: invalid %s hash %q", u, format, sum)
+	}
+	return &file{server: s, name: name, loc: u, format: format, sum: strings.ToLower(sum)}, nil
+}
+
+// document fetches and parses the TOML file at u, verifying it against sum when it is not empty.
+func (s *Server) document(u location, format, sum string) (*document, error) {
+	r, err := s.open(u)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if sum != "" {
+		h, err := newHash(format)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", u, err)
+		}
+		h.Write(data)
+		if got := hex.EncodeToString(h.Sum(nil)); got != strings.ToLower(sum) {
+			return nil, fmt.Errorf("%s: hash mismatch: expected %s, got %s", u, sum, got)
+		}
+	}
+
+	d, err := parseTOML(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", u, err)
+	}
+	return d, nil
+}
+
+// open opens the file at u, which is either a local file or downloaded over HTTP.
+func (s *Server) open(u location) (io.ReadCloser, error) {
+	if u.url == nil {
+		return os.Open(u.path)
+	}
+
+	res, err := s.client.Get(u.url.String())
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("%s: unexpected status %q", u, res.Status)
+	}
+	return res.Body, nil
+}
+
+// location is where a file of the pack is, by its URL, or by its path when the pack is read locally.
+type location struct {
+	url  *url.URL
+	path string
+}
+
+func (l location) String() string {
+	if l.url != nil {
+		return l.url.String()
+	}
+	return l.path
+}
+
+// resolve returns the location of a file named by the slash-separated path ref, relative to this file,
+// as the pack refers to its index and the index to each file.
+func (l location) resolve(ref string) location {
+	if l.url != nil {
+		return location{url: l.url.ResolveReference(&url.URL{Path: ref})}
+	}
+	dir := path.Dir(filepath.ToSlash(l.path))
+	return location{path: filepath.FromSlash(path.Join(dir, ref))}
+}
+
+// newHash returns a hash of the named packwiz hash format.
+func newHash(format string) (hash.Hash, error) {
+	switch format {
+	case "sha256":
+		return sha256.New(), nil
+	case "sha512":
+		return sha512.New(), nil
+	case "sha1":
+		return sha1.New(), nil
+	case "md5":
+		return md5.New(), nil
+	default:
+		return nil, fmt.Errorf("unsupported hash format %q", format)
+	}
+}
+
+// file is a fync.ServerFile that downloads a mod of the pack.
+type file struct {
+	server *Server
+	name   string
+	loc    location
+	format string
+	sum    string
+	r      io.ReadCloser
+}
+
+// String returns the URL the mod is downloaded from, or its path within a local pack.
+func (f *file) String() string {
+	return f.loc.String()
+}
+
+func (f *file) Stat() (os.FileInfo, error) {
+	return fileInfo{f.name}, nil
+}
+
+// SHA256 returns the mod's checksum when the pack records a SHA-256 hash for it.
+func (f *file) SHA256() (string, error) {
+	if f.format == "sha256" {
+		return f.sum, nil
+	}
+	return "", nil
+}
+
+// WriteTo downloads the mod, failing if it does not match its hash once written.
+func (f *file) WriteTo(w io.Writer) (int64, error) {
+	if f.r == nil {
+		r, err := f.server.open(f.loc)
+		if err != nil {
+			return 0, err
+		}
+		f.r = r
+	}
+
+	defer f.Close()
+
+	h, _ := newHash(f.format)
+	n, err := io.Copy(io.MultiWriter(w, h), f.r)
+	if err != nil {
+		return n, err
+	}
+
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != f.sum {
+		return n, &fync.VerificationError{Path: f.loc.String(), Field: "checksum", Expected: f.sum, Actual: sum}
+	}
+	return n, nil
+}
+
+// Seek only supports rewinding to the start of the file so that a failed transfer can be retried.
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekStart {
+		return 0, errors.New("packwizserver: can only seek to the start of a file")
+	}
+	return 0, f.Close()
+}
+
+func (f *file) Close() error {
+	if f.r == nil {
+		return nil
+	}
+
+	err := f.r.Close()
+	f.r = nil
+	return err
+}
+
+type fileInfo struct {
+	name string
+}
+
+func (i fileInfo) Name() string       { return i.name }
+func (i fileInfo) Size() int64        { return -1 }
+func (i fileInfo) Mode() os.FileMode  { return 0644 }
+func (i fileInfo) ModTime() time.Time { return time.Time{} }
+func (i fileInfo) IsDir() bool        { return false }
+func (i fileInfo) Sys() interface{}   { return nil }
+
+var _ fync.HashedFile = (*file)(nil)
+var _ fync.Server = (*Server)(nil)
@@ -0,0 +1,122 @@
+package packwizserver
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// document is a TOML document parsed into the scalar values of each of its tables,
+// which is all packwiz's files use. Values are keyed by the table's name and then their key,
+// with the tables of an array of tables kept in order. The root table is named "".
+type document struct {
+	tables map[string]map[string]string
+	arrays map[string][]map[string]string
+}
+
+// get returns the value of the key within the named table.
+func (d *document) get(table, key string) string {
+	return d.tables[table][key]
+}
+
+// parseTOML parses the subset of TOML used by packwiz.
+func parseTOML(data []byte) (*document, error) {
+	d := &document{
+		tables: map[string]map[string]string{"": {}},
+		arrays: make(map[string][]map[string]string),
+	}
+	current := d.tables[""]
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[[") {
+			end := strings.Index(line, "]]")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated table header", n)
+			}
+			name := unquoteKey(line[2:end])
+			current = make(map[string]string)
+			d.arrays[name] = append(d.arrays[name], current)
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			end := strings.Index(line, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated table header", n)
+			}
+			name := unquoteKey(line[1:end])
+			if d.tables[name] == nil {
+				d.tables[name] = make(map[string]string)
+			}
+			current = d.tables[name]
+			continue
+		}
+
+		i := strings.Index(line, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("line %d: expected a key and value", n)
+		}
+
+		value, err := parseValue(strings.TrimSpace(line[i+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		current[unquoteKey(line[:i])] = value
+	}
+	return d, scanner.Err()
+}
+
+// unquoteKey returns a bare or quoted key, or table name, with any quotes removed.
+func unquoteKey(key string) string {
+	key = strings.TrimSpace(key)
+	if len(key) >= 2 && (key[0] == '"' || key[0] == '\'') && key[len(key)-1] == key[0] {
+		return key[1 : len(key)-1]
+	}
+	return key
+}
+
+// parseValue parses a string, or returns any other scalar value as it is written.
+func parseValue(value string) (string, error) {
+	if value == "" {
+		return "", fmt.Errorf("missing value")
+	}
+
+	switch value[0] {
+	case '"':
+		if strings.HasPrefix(value, `"""`) {
+			return "", fmt.Errorf("multi-line strings are unsupported")
+		}
+
+		// find the closing quote, skipping escaped quotes
+		for i := 1; i < len(value); i++ {
+			switch value[i] {
+			case '\\':
+				i++
+			case '"':
+				return strconv.Unquote(value[:i+1])
+			}
+		}
+		return "", fmt.Errorf("unterminated string")
+
+	case '\'':
+		end := strings.IndexByte(value[1:], '\'')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated string")
+		}
+		return value[1 : end+1], nil
+
+	default:
+		if i := strings.Index(value, "#"); i >= 0 {
+			value = strings.TrimSpace(value[:i])
+		}
+		return value, nil
+	}
+}
@@ -0,0 +1,48 @@
+package fync
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// PermissionError is returned when an operation fails due to insufficient privileges,
+// such as when the Minecraft installation is within a protected folder.
+type PermissionError struct {
+	// Path the operation failed on.
+	Path string
+
+	// The underlying error.
+	Err error
+}
+
+func (e *PermissionError) Error() string {
+	return fmt.Sprintf("%s: insufficient privileges: %v", e.Path, e.Err)
+}
+
+func (e *PermissionError) Unwrap() error {
+	return e.Err
+}
+
+// permissionError returns err as a PermissionError if it was caused by insufficient privileges.
+func permissionError(err error) error {
+	if err == nil || !insufficientPrivileges(err) {
+		return err
+	}
+
+	var permErr *PermissionError
+	if errors.As(err, &permErr) {
+		return err
+	}
+
+	var path string
+	var pathErr *os.PathError
+	var linkErr *os.LinkError
+	if errors.As(err, &pathErr) {
+		path = pathErr.Path
+	} else if errors.As(err, &linkErr) {
+		path = linkErr.New
+	}
+
+	return &PermissionError{Path: path, Err: err}
+}
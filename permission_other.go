@@ -0,0 +1,13 @@
+//go:build !windows
+// +build !windows
+
+package fync
+
+import (
+	"errors"
+	"os"
+)
+
+func insufficientPrivileges(err error) bool {
+	return errors.Is(err, os.ErrPermission)
+}
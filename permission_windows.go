@@ -0,0 +1,21 @@
+package fync
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+const (
+	// errorPrivilegeNotHeld is the Windows ERROR_PRIVILEGE_NOT_HELD error code.
+	errorPrivilegeNotHeld syscall.Errno = 1314
+
+	// errorElevationRequired is the Windows ERROR_ELEVATION_REQUIRED error code.
+	errorElevationRequired syscall.Errno = 740
+)
+
+func insufficientPrivileges(err error) bool {
+	return errors.Is(err, os.ErrPermission) ||
+		errors.Is(err, errorPrivilegeNotHeld) ||
+		errors.Is(err, errorElevationRequired)
+}
@@ -0,0 +1,208 @@
+package fync
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// pinsName is the name of the file within the mods directory recording the keys of hosts trusted on first use.
+const pinsName = ".fync-pins.json"
+
+// PinError is returned when a host presents a key other than the one pinned for it,
+// such as when its connection is being intercepted, unless Pins.OnChange trusts the new key.
+type PinError struct {
+	// Address of the host.
+	Host string
+
+	// The fingerprints of the pinned key and the key the host presented.
+	Pinned, Presented string
+}
+
+func (e *PinError) Error() string {
+	return fmt.Sprintf("%s: host key changed from %s to %s", e.Host, e.Pinned, e.Presented)
+}
+
+// Pins records the fingerprint of each host's public key the first time it is connected to,
+// and refuses connections to hosts presenting a different key afterwards, trusting hosts on first use
+// much like ssh does. It suits self-signed HTTPS and FTPS hosts, whose certificates cannot be verified
+// by certificate authorities. Hosts are identified by their address, including the port.
+// SSH hosts, as used by scpserver, are already trusted on first use by ssh's own known hosts.
+type Pins struct {
+	// Called when a host presents a key other than the one pinned for it, such as after the host
+	// was reinstalled, with the fingerprints of both keys. Returning true pins the presented key
+	// in place of the old one, while the connection is refused with a *PinError otherwise or when nil.
+	OnChange func(host, pinned, presented string) bool
+
+	path  string
+	mu    sync.Mutex
+	hosts map[string]string
+}
+
+// pinsFile is how pins are saved, with the fingerprint of each host's key keyed by its address.
+type pinsFile struct {
+	Hosts map[string]string `json:"hosts"`
+}
+
+// PinsPath returns the default location of the pins, within the mods directory.
+func PinsPath() (string, error) {
+	return filepath.Join(modsDir, pinsName), dirErr
+}
+
+// OpenPins reads the pins recorded at the given path, which are empty if none have been recorded there.
+// Keys pinned afterwards are saved to it as they are pinned.
+func OpenPins(path string) (*Pins, error) {
+	p := &Pins{path: path, hosts: make(map[string]string)}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return p, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var saved pinsFile
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for host, fingerprint := range saved.Hosts {
+		p.hosts[host] = fingerprint
+	}
+	return p, nil
+}
+
+// KeyFingerprint returns the fingerprint of an encoded public key, such as a certificate's
+// RawSubjectPublicKeyInfo, as the base64 encoded SHA-256 digest prefixed by "SHA256:", like ssh-keygen.
+func KeyFingerprint(key []byte) string {
+	sum := sha256.Sum256(key)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// Pinned returns the fingerprint of the key pinned for the host, or an empty string if there is none.
+func (p *Pins) Pinned(host string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.hosts[host]
+}
+
+// Check verifies that the key the host presented has the fingerprint pinned for it,
+// pinning it if the host has not been connected to before.
+func (p *Pins) Check(host, fingerprint string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pinned, ok := p.hosts[host]
+	if ok && pinned == fingerprint {
+		return nil
+	}
+	if ok && (p.OnChange == nil || !p.OnChange(host, pinned, fingerprint)) {
+		return &PinError{Host: host, Pinned: pinned, Presented: fingerprint}
+	}
+
+	p.hosts[host] = fingerprint
+	return p.save()
+}
+
+// Forget removes the key pinned for the host, so that the next key it presents is trusted.
+func (p *Pins) Forget(host string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.hosts[host]; !ok {
+		return nil
+	}
+	delete(p.hosts, host)
+	return p.save()
+}
+
+func (p *Pins) save() error {
+	data, err := json.MarshalIndent(pinsFile{p.hosts}, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(p.path, data, 0644)
+}
+
+// TLSConfig returns a copy of the config, or a new config when nil, that trusts the certificate
+// of the host at addr by the key pinned for it rather than by certificate authorities.
+func (p *Pins) TLSConfig(addr string, config *tls.Config) *tls.Config {
+	if config == nil {
+		config = &tls.Config{}
+	} else {
+		config = config.Clone()
+	}
+
+	config.InsecureSkipVerify = true
+	config.VerifyConnection = func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return errors.New("tls: server presented no certificate")
+		}
+		return p.Check(addr, KeyFingerprint(cs.PeerCertificates[0].RawSubjectPublicKeyInfo))
+	}
+	return config
+}
+
+// Transport returns a copy of the transport, or of http.DefaultTransport when nil,
+// whose TLS connections are trusted by the keys pinned for their hosts as by TLSConfig.
+// Connections are made directly to each host, ignoring any proxy, and speak HTTP/1.1.
+func (p *Pins) Transport(t *http.Transport) *http.Transport {
+	if t == nil {
+		t = http.DefaultTransport.(*http.Transport)
+	}
+	t = t.Clone()
+
+	config, dial := t.TLSClientConfig, t.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext
+	}
+
+	t.Proxy = nil
+	t.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+
+		c := p.TLSConfig(addr, config)
+		if c.ServerName == "" {
+			c.ServerName = host
+		}
+		c.NextProtos = []string{"http/1.1"}
+
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		// the handshake is abandoned once the request is canceled
+		done := make(chan struct{})
+		go func() {
+			select {
+			case <-ctx.Done():
+				conn.Close()
+			case <-done:
+			}
+		}()
+
+		tc := tls.Client(conn, c)
+		err = tc.Handshake()
+		close(done)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return tc, nil
+	}
+	return t
+}
@@ -0,0 +1,319 @@
+package fync
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ActionType is the kind of change an Action makes to the mods directory.
+type ActionType int
+
+const (
+	// ActionInstall writes a server mod, overwriting any local mod of the same name.
+	ActionInstall ActionType = iota
+
+	// ActionReplace backs up a local mod that differs from the server mod of the same name
+	// before writing the server mod.
+	ActionReplace
+
+	// ActionRemove backs up a local mod that is not on the server.
+	ActionRemove
+)
+
+func (t ActionType) String() string {
+	switch t {
+	case ActionInstall:
+		return "install"
+	case ActionReplace:
+		return "replace"
+	case ActionRemove:
+		return "remove"
+	default:
+		return fmt.Sprintf("ActionType(%d)", int(t))
+	}
+}
+
+// Action is a single change to the mods directory planned by Sync.
+type Action struct {
+	// The kind of change.
+	Type ActionType
+
+	// Name of the mod.
+	Name string
+
+	// The server mod being written, or nil when removing a local mod.
+	Server os.FileInfo
+
+	// Whether the action should be skipped.
+	Skip bool
+
+	file ServerFile
+	sum  string
+}
+
+// close closes the action's server file if it has not already been closed.
+func (a *Action) close() {
+	if a.file != nil {
+		a.file.Close()
+		a.file = nil
+	}
+}
+
+// Plan is the set of changes a sync will make to the mods directory.
+type Plan struct {
+	Actions []*Action
+
+	// the server mods to record in the lockfile
+	mods []LockedMod
+}
+
+// close closes the server files of any actions that were not performed.
+func (p *Plan) close() {
+	for _, a := range p.Actions {
+		a.close()
+	}
+}
+
+// validate ensures the actions are still able to be performed after being modified.
+func (p *Plan) validate() error {
+	for _, a := range p.Actions {
+		if a.Skip {
+			continue
+		}
+
+		switch a.Type {
+		case ActionInstall, ActionReplace:
+			if a.file == nil {
+				return fmt.Errorf("%s: cannot %s a mod that is not on the server", a.Name, a.Type)
+			}
+		case ActionRemove:
+		default:
+			return fmt.Errorf("%s: invalid action %s", a.Name, a.Type)
+		}
+	}
+	return nil
+}
+
+// plan compares the server mods with the local mods to determine the changes to make.
+// Server files without a planned action are closed.
+func plan(serverMods []ServerFile, sums map[string]string, st *state, o *SyncOptions) (*Plan, error) {
+	// determine local mods
+	var localMods map[string]int64
+	if !(o.KeepExisting && o.Force) {
+		scanned := progress("scan", o)
+		if scanned != nil {
+			scanned(0, 1)
+		}
+
+		files, err := ioutil.ReadDir(modsDir)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		localMods = make(map[string]int64)
+		for i := range files {
+			if !files[i].IsDir() && strings.HasSuffix(files[i].Name(), ".jar") {
+				localMods[files[i].Name()] = files[i].Size()
+			}
+		}
+
+		// forget managed mods that have since been removed by hand
+		st.prune(localMods)
+
+		if scanned != nil {
+			scanned(1, 1)
+		}
+	}
+
+	p := &Plan{}
+	var mu sync.Mutex
+	onServer := make(map[string]bool)
+
+	err := run(len(serverMods), concurrency(o), o.Cancel, progress("compare", o), func(i int) error {
+		mod := serverMods[i]
+
+		a, err := planMod(mod, localMods, sums, st, o)
+		if err != nil {
+			mod.Close()
+			return err
+		}
+
+		// up to date mods will not be written
+		if a.Action == nil {
+			mod.Close()
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if onServer[a.name()] {
+			if a.Action != nil {
+				a.close()
+			}
+			return &NameError{Name: a.name(), Reason: "listed more than once"}
+		}
+		onServer[a.name()] = true
+		p.mods = append(p.mods, LockedMod{Name: a.name(), Source: sourceOf(mod)})
+		if a.Action != nil {
+			p.Actions = append(p.Actions, a.Action)
+		}
+		return nil
+	})
+	if err != nil {
+		p.close()
+		return nil, err
+	}
+
+	if o.Lock != nil {
+		for _, m := range o.Lock.Mods {
+			if !onServer[m.Name] {
+				p.close()
+				return nil, &LockError{Name: m.Name, Reason: "locked but not on server"}
+			}
+		}
+	}
+
+	if !o.KeepExisting {
+		for name := range localMods {
+			if !onServer[name] && (!o.ManagedOnly || st.managed(name)) {
+				p.Actions = append(p.Actions, &Action{Type: ActionRemove, Name: name})
+			}
+		}
+	}
+
+	sort.Slice(p.Actions, func(i, j int) bool {
+		if p.Actions[i].Type != p.Actions[j].Type {
+			return p.Actions[i].Type < p.Actions[j].Type
+		}
+		return p.Actions[i].Name < p.Actions[j].Name
+	})
+	return p, nil
+}
+
+// NameError is returned when a server mod's name is not a valid mod file name,
+// so that a misconfigured server cannot write other files into the mods directory.
+type NameError struct {
+	// Name of the server mod.
+	Name string
+
+	// Why the name is invalid, such as "not a jar" or "hidden file".
+	Reason string
+}
+
+func (e *NameError) Error() string {
+	return fmt.Sprintf("%q: invalid mod name: %s", e.Name, e.Reason)
+}
+
+// checkName returns a *NameError if name is not a valid mod file name.
+func checkName(name string) error {
+	var reason string
+	switch {
+	case name == "":
+		reason = "empty"
+	case strings.ContainsAny(name, `/\`):
+		reason = "contains a path separator"
+	case strings.IndexFunc(name, invalidNameRune) >= 0:
+		reason = "contains characters not allowed in file names"
+	case strings.HasPrefix(name, "."):
+		reason = "hidden file"
+	case !strings.HasSuffix(name, ".jar"):
+		reason = "not a jar"
+	default:
+		return nil
+	}
+	return &NameError{Name: name, Reason: reason}
+}
+
+// invalidNameRune reports whether r is not allowed within file names on every platform.
+func invalidNameRune(r rune) bool {
+	return r < ' ' || r == 0x7f || strings.ContainsRune(`<>:"|?*`, r)
+}
+
+// plannedMod is the result of planning a single server mod,
+// with a nil Action when the local mod is already up to date.
+type plannedMod struct {
+	*Action
+	info os.FileInfo
+}
+
+func (m *plannedMod) name() string {
+	return m.info.Name()
+}
+
+func planMod(mod ServerFile, localMods map[string]int64, sums map[string]string, st *state, o *SyncOptions) (*plannedMod, error) {
+	info, err := mod.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	name := info.Name()
+	if err := checkName(name); err != nil {
+		return nil, err
+	}
+
+	sum := sums[name]
+	if hashed, ok := mod.(HashedFile); ok && sum == "" {
+		if sum, err = hashed.SHA256(); err != nil {
+			return nil, err
+		}
+	}
+	sum = strings.ToLower(sum)
+
+	// mods must match the lock exactly, and are verified against it when the server has no checksum
+	if o.Lock != nil {
+		locked, ok := o.Lock.mod(name)
+		if !ok {
+			return nil, &LockError{Name: name, Reason: "not locked"}
+		}
+		if info.Size() >= 0 && locked.Size != info.Size() {
+			return nil, &LockError{Name: name, Reason: "size differs from lock"}
+		}
+		if sum != "" && sum != locked.SHA256 {
+			return nil, &LockError{Name: name, Reason: "checksum differs from lock"}
+		}
+		sum = locked.SHA256
+	}
+
+	m := &plannedMod{info: info}
+	action := &Action{Name: name, Server: info, file: mod, sum: sum}
+
+	// overwrite existing local mods when forced
+	if o.Force {
+		action.Type = ActionInstall
+		m.Action = action
+		return m, nil
+	}
+
+	size, exists := localMods[name]
+	if !exists {
+		action.Type = ActionInstall
+		m.Action = action
+		return m, nil
+	}
+
+	// leave mods installed by hand as they are
+	if o.ManagedOnly && !st.managed(name) {
+		return m, nil
+	}
+
+	// mods of unknown size can only be compared by checksum, and are assumed current without one
+	changed := info.Size() >= 0 && size != info.Size()
+	if !changed && sum != "" {
+		localSum, err := hashLocal(filepath.Join(modsDir, name))
+		if err != nil {
+			return nil, err
+		}
+		changed = localSum != sum
+	}
+
+	if changed {
+		action.Type = ActionReplace
+		m.Action = action
+	}
+	return m, nil
+}
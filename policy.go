@@ -0,0 +1,147 @@
+package fync
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// JarPolicy restricts the mods a sync installs to trusted jars, so that a compromised host
+// cannot push malicious ones. A jar is allowed when its checksum is on the allow list
+// or when it carries a valid signature by one of the trusted signers; a policy allowing neither
+// rejects every jar. Server mods are always hashed when a policy is set.
+type JarPolicy struct {
+	// The checksums of the allowed jars, such as from an allow list signed by a trusted curator.
+	AllowList *AllowList
+
+	// Certificates whose keys are trusted to sign jars, as with jarsigner.
+	// Jars are only trusted when every file within them is covered by the signature
+	// and it uses SHA-256 or stronger digests.
+	Signers []*x509.Certificate
+
+	// Directory rejected jars are moved to, leaving them out of the sync rather than failing it.
+	// A mod rejected while replacing an older version leaves the older version in its backup set.
+	// When empty, the first rejected jar fails the sync.
+	QuarantineDir string
+}
+
+// PolicyError is returned when a mod is rejected by the JarPolicy of a sync.
+type PolicyError struct {
+	// Name of the mod.
+	Name string
+
+	// Why the mod was rejected.
+	Reason string
+
+	// Where the rejected jar was moved, when the policy quarantines them.
+	Quarantined string
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("%s: rejected by policy: %s", e.Name, e.Reason)
+}
+
+// check returns a *PolicyError if the jar written to path for the named mod, with the given checksum,
+// is not allowed, moving it to the quarantine directory when there is one.
+// A nil policy allows every jar.
+func (p *JarPolicy) check(path, name, sum string) error {
+	if p == nil || p.AllowList.allows(sum) {
+		return nil
+	}
+
+	reason := "not on the allow list"
+	if len(p.Signers) > 0 {
+		err := verifyJar(path, p.Signers)
+		if err == nil {
+			return nil
+		}
+		reason += " and " + err.Error()
+	}
+
+	e := &PolicyError{Name: name, Reason: reason}
+	if p.QuarantineDir != "" {
+		if err := os.MkdirAll(p.QuarantineDir, os.ModeDir|0755); err != nil {
+			return err
+		}
+
+		to := filepath.Join(p.QuarantineDir, name)
+		if err := place(path, to); err != nil {
+			return err
+		}
+		e.Quarantined = to
+	}
+	return e
+}
+
+// AllowList lists the checksums of jars that are allowed by a JarPolicy.
+// It is signed like a manifest, with SignManifest, so that it can be distributed
+// separately from the packs it vouches for.
+type AllowList struct {
+	Mods []AllowedMod `json:"mods"`
+}
+
+// AllowedMod is a single jar allowed by an AllowList.
+type AllowedMod struct {
+	// Name of the mod, for reference only, since jars are allowed by their checksum alone.
+	Name string `json:"name,omitempty"`
+
+	// Hex encoded SHA-256 checksum of the jar.
+	SHA256 string `json:"sha256"`
+}
+
+// ReadAllowList reads the allow list at the given path, verifying it against the signature
+// beside it, named with the SignatureSuffix.
+func ReadAllowList(path string, key ed25519.PublicKey) (*AllowList, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := ioutil.ReadFile(path + SignatureSuffix)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseAllowList(data, string(signature), key)
+}
+
+// ParseAllowList parses an allow list once its signature is verified as by VerifyManifest,
+// ensuring each mod has a valid checksum.
+func ParseAllowList(data []byte, signature string, key ed25519.PublicKey) (*AllowList, error) {
+	if err := VerifyManifest(data, signature, key); err != nil {
+		return nil, err
+	}
+
+	var l AllowList
+	if err := json.NewDecoder(bytes.NewReader(data)).Decode(&l); err != nil {
+		return nil, err
+	}
+
+	for i, m := range l.Mods {
+		l.Mods[i].SHA256 = strings.ToLower(m.SHA256)
+		if !validChecksum(l.Mods[i].SHA256) {
+			return nil, fmt.Errorf("allowed mod %d: invalid SHA-256 checksum %q", i, m.SHA256)
+		}
+	}
+	return &l, nil
+}
+
+// allows reports whether the checksum is on the allow list. A nil allow list allows nothing.
+func (l *AllowList) allows(sum string) bool {
+	if l == nil || sum == "" {
+		return false
+	}
+
+	for _, m := range l.Mods {
+		if m.SHA256 == sum {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,144 @@
+package fync
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ProtocolVersion is the version of the fync protocol implemented by this module.
+// Each version only adds to the last, so clients and hosts speak the lower of their versions.
+const ProtocolVersion = 1
+
+// Headers with which clients and fync-aware hosts negotiate the protocol over HTTP.
+// A client sends the version and capabilities it supports with its request for the manifest,
+// and the host responds with the version they both speak and the requested capabilities it supports.
+// Responses without a version are from hosts that are not fync-aware and only serve files,
+// and requests without one are from clients that predate the protocol.
+const (
+	VersionHeader      = "Fync-Version"
+	CapabilitiesHeader = "Fync-Capabilities"
+)
+
+// MinVersionHeader is the header with which a host refusing a client that speaks too old a version
+// of the protocol, with the status 426 Upgrade Required, sends the lowest version it serves.
+const MinVersionHeader = "Fync-Min-Version"
+
+// Capabilities of fync-aware hosts. Capabilities unknown to a client or host are ignored,
+// so that new ones can be added without a new version.
+const (
+	// The manifest lists the SHA-256 checksum of every mod.
+	CapabilityHashes = "hashes"
+
+	// Mods can be requested in byte ranges, so that interrupted downloads resume where they stopped.
+	CapabilityRanges = "ranges"
+
+	// Mods can be transferred as deltas from older versions of them, as served by delta.FileServer.
+	CapabilityDeltas = "deltas"
+
+	// Changes to the mods are pushed to subscribers, as served by Notifier.
+	CapabilityNotifications = "notifications"
+
+	// Clients sending the sync token of the manifest they last fetched, in the SyncTokenHeader,
+	// are sent only the changes to it, as by DiffManifests, when the host still knows of it.
+	CapabilityIncremental = "incremental"
+)
+
+// UpdateError is returned when a pack requires a newer version of fync than this one,
+// such as for a manifest using a newer schema, so that the pack fails to sync
+// rather than having fields added since being misread.
+type UpdateError struct {
+	// What is required, either "protocol" or "manifest schema".
+	Of string
+
+	// The version required, and the newest version this module supports.
+	Required, Supported int
+}
+
+func (e *UpdateError) Error() string {
+	return fmt.Sprintf("requires fync %s version %d, but only version %d is supported; please update fync", e.Of, e.Required, e.Supported)
+}
+
+// Protocol is a version of the fync protocol along with the capabilities supported with it.
+type Protocol struct {
+	// The version, which is zero for peers that are not fync-aware.
+	Version int
+
+	// The capabilities, as a sorted set.
+	Capabilities []string
+}
+
+// Has reports whether the capability is supported.
+func (p Protocol) Has(capability string) bool {
+	for _, c := range p.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// SetHeader sets the headers describing the protocol.
+func (p Protocol) SetHeader(h http.Header) {
+	h.Set(VersionHeader, strconv.Itoa(p.Version))
+	h.Set(CapabilitiesHeader, strings.Join(p.Capabilities, ", "))
+}
+
+// ParseProtocol parses the protocol described by the headers of a request or response,
+// whose version is zero when there are none.
+func ParseProtocol(h http.Header) (Protocol, error) {
+	var p Protocol
+
+	if v := strings.TrimSpace(h.Get(VersionHeader)); v != "" {
+		version, err := strconv.Atoi(v)
+		if err != nil || version < 0 {
+			return p, fmt.Errorf("invalid %s %q", VersionHeader, v)
+		}
+		p.Version = version
+	}
+
+	// capabilities may be listed by any number of headers
+	set := make(map[string]bool)
+	for _, v := range h[http.CanonicalHeaderKey(CapabilitiesHeader)] {
+		for _, c := range strings.Split(v, ",") {
+			if c = strings.ToLower(strings.TrimSpace(c)); c != "" {
+				set[c] = true
+			}
+		}
+	}
+	p.Capabilities = sortedSet(set)
+	return p, nil
+}
+
+// Negotiate returns the protocol a host supporting the host protocol speaks with a client
+// requesting the client protocol: the lower of their versions, with the capabilities both support.
+// Clients that are not fync-aware are not given any capabilities.
+func Negotiate(host, client Protocol) Protocol {
+	p := Protocol{Version: host.Version}
+	if client.Version < p.Version {
+		p.Version = client.Version
+	}
+	if p.Version == 0 {
+		return p
+	}
+
+	set := make(map[string]bool)
+	for _, c := range client.Capabilities {
+		if host.Has(c) {
+			set[c] = true
+		}
+	}
+	p.Capabilities = sortedSet(set)
+	return p
+}
+
+func sortedSet(set map[string]bool) []string {
+	s := make([]string, 0, len(set))
+	for c := range set {
+		s = append(s, c)
+	}
+	sort.Strings(s)
+	return s
+}
@@ -0,0 +1,300 @@
+package fync
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// ErrNoBackups is returned by Restore when there are no backup sets to restore.
+var ErrNoBackups = errors.New("no backups to restore")
+
+// RestoreOptions contains options for the Restore function.
+type RestoreOptions struct {
+	// Called when a mod installed by the sync being undone is being removed.
+	OnRemove func(name, path string)
+
+	// Called when a backed up mod is being restored.
+	OnRestore func(name, from, to string)
+}
+
+// Backup describes a backup set created by a sync.
+type Backup struct {
+	// Identifies the backup set for Restore.
+	ID string
+
+	// When the sync that created the backup set began.
+	Created time.Time
+
+	// Names of the mods the sync backed up.
+	Mods []string
+
+	// Details of the mods the sync backed up, when recorded.
+	Files []BackupFile
+
+	// Names of the mods the sync installed.
+	Installed []string
+}
+
+// ListBackups returns the available backup sets, newest first.
+func ListBackups() ([]Backup, error) {
+	if dirErr != nil {
+		return nil, dirErr
+	}
+
+	sets, err := backupSets()
+	if err != nil {
+		return nil, err
+	}
+
+	backups := make([]Backup, 0, len(sets))
+	for _, set := range sets {
+		index, mods, err := set.contents()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, f := range index.Files {
+			if f.Deduplicated {
+				mods = append(mods, f.Name)
+			}
+		}
+		sort.Strings(mods)
+
+		backups = append(backups, Backup{
+			ID:        set.name,
+			Created:   set.created,
+			Mods:      mods,
+			Files:     index.Files,
+			Installed: index.Installed,
+		})
+	}
+
+	return backups, nil
+}
+
+// Restore returns the mods directory to its state before the sync that created the
+// backup set with the given ID, or the most recent backup set when id is empty.
+// Each newer sync is undone first, newest to oldest, by removing the mods it installed
+// and moving the mods it backed up back into the mods directory.
+// Backup sets are removed once restored.
+// The number of mods restored is returned as well as any errors encountered.
+// Errors caused by insufficient privileges are returned as a *PermissionError.
+func Restore(id string, o *RestoreOptions) (n int, err error) {
+	defer func() {
+		err = permissionError(err)
+	}()
+
+	if dirErr != nil {
+		return n, dirErr
+	}
+
+	sets, err := backupSets()
+	if err != nil {
+		return n, err
+	}
+
+	if len(sets) == 0 {
+		return n, ErrNoBackups
+	}
+
+	last := 0
+	if id != "" {
+		last = -1
+		for i := range sets {
+			if sets[i].name == id {
+				last = i
+				break
+			}
+		}
+
+		if last < 0 {
+			return n, fmt.Errorf("backup %q does not exist", id)
+		}
+	}
+
+	for _, set := range sets[:last+1] {
+		restored, err := set.restore(o)
+		n += restored
+		if err != nil {
+			return n, err
+		}
+	}
+
+	return n, collectObjects()
+}
+
+// restore undoes the sync that created the backup set.
+func (e backupSetEntry) restore(o *RestoreOptions) (int, error) {
+	var n int
+
+	index, mods, err := e.contents()
+	if err != nil {
+		return n, err
+	}
+
+	// remove the mods the sync installed
+	for _, name := range index.Installed {
+		path := filepath.Join(modsDir, filepath.Base(name))
+
+		if o.OnRemove != nil {
+			o.OnRemove(name, path)
+		}
+
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return n, err
+		}
+	}
+
+	// move the backed up mods back
+	var archive *zip.ReadCloser
+	if e.compressed {
+		archive, err = openArchive(e.path())
+		if err != nil {
+			return n, err
+		}
+		defer archive.Close()
+	}
+
+	for _, name := range mods {
+		from := filepath.Join(e.path(), name)
+		to := filepath.Join(modsDir, name)
+
+		if o.OnRestore != nil {
+			o.OnRestore(name, from, to)
+		}
+
+		if archive != nil {
+			err = extract(archive, name, to)
+		} else {
+			err = move(from, to)
+		}
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+
+	// copy deduplicated mods back since other backup sets may share them
+	for _, f := range index.Files {
+		if !f.Deduplicated {
+			continue
+		}
+
+		from := objectPath(f.SHA256)
+		to := filepath.Join(modsDir, filepath.Base(f.Name))
+
+		if o.OnRestore != nil {
+			o.OnRestore(f.Name, from, to)
+		}
+
+		if err := copyFile(from, to); err != nil {
+			return n, err
+		}
+		n++
+	}
+
+	if archive != nil {
+		archive.Close()
+	}
+	return n, os.RemoveAll(e.path())
+}
+
+// contents returns the index of the backup set and the names of the mods it contains.
+// Backup sets without an index have an empty one.
+func (e backupSetEntry) contents() (backupIndex, []string, error) {
+	var index backupIndex
+	var mods []string
+	var data []byte
+
+	if e.compressed {
+		archive, err := openArchive(e.path())
+		if err != nil {
+			return index, nil, err
+		}
+		defer archive.Close()
+
+		for _, f := range archive.File {
+			if f.Name == backupIndexName {
+				if data, err = readArchived(f); err != nil {
+					return index, nil, err
+				}
+			} else if !f.FileInfo().IsDir() && path.Base(f.Name) == f.Name {
+				mods = append(mods, f.Name)
+			}
+		}
+	} else {
+		files, err := ioutil.ReadDir(e.path())
+		if err != nil {
+			return index, nil, err
+		}
+
+		for i := range files {
+			if !files[i].IsDir() && files[i].Name() != backupIndexName {
+				mods = append(mods, files[i].Name())
+			}
+		}
+
+		data, err = ioutil.ReadFile(filepath.Join(e.path(), backupIndexName))
+		if err != nil && !os.IsNotExist(err) {
+			return index, nil, err
+		}
+	}
+
+	if data != nil {
+		if err := json.Unmarshal(data, &index); err != nil {
+			return index, nil, err
+		}
+	}
+	return index, mods, nil
+}
+
+func readArchived(f *zip.File) ([]byte, error) {
+	r, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return ioutil.ReadAll(r)
+}
+
+// extract writes the named file within archive to the path to.
+func extract(archive *zip.ReadCloser, name, to string) error {
+	for _, f := range archive.File {
+		if f.Name != name {
+			continue
+		}
+
+		r, err := f.Open()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		file, err := os.Create(to)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		if _, err := io.Copy(file, r); err != nil {
+			return err
+		}
+
+		if err := file.Close(); err != nil {
+			return err
+		}
+		return os.Chtimes(to, f.Modified, f.Modified)
+	}
+
+	return fmt.Errorf("%s: not found in backup archive", name)
+}
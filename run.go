@@ -0,0 +1,119 @@
+package fync
+
+import (
+	"errors"
+	"sync"
+)
+
+// defaultConcurrency is the number of tasks run at once when SyncOptions.Concurrency is not set.
+const defaultConcurrency = 8
+
+// ErrCanceled is returned when an operation is canceled by closing SyncOptions.Cancel.
+var ErrCanceled = errors.New("canceled")
+
+// concurrency returns the number of tasks to run at once.
+func concurrency(o *SyncOptions) int {
+	if o.Concurrency > 0 {
+		return o.Concurrency
+	}
+	return defaultConcurrency
+}
+
+// run calls f with each index up to total using at most limit concurrent calls, calling
+// progress as each call completes successfully when it is not nil. No further calls are made
+// once one fails or cancel is closed, and every call in progress returns before the first
+// error encountered, or ErrCanceled, is.
+func run(total, limit int, cancel <-chan struct{}, progress func(curr, total int), f func(i int) error) error {
+	if progress != nil {
+		progress(0, total)
+	}
+
+	if total == 0 {
+		return nil
+	}
+
+	if limit <= 0 || limit > total {
+		limit = total
+	}
+
+	next := make(chan int)
+	results := make(chan error)
+	done := make(chan struct{})
+
+	// done is closed by the first call to fail, as soon as it does
+	var failed sync.Once
+
+	var wg sync.WaitGroup
+	for w := 0; w < limit; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				select {
+				case <-done:
+					continue
+				default:
+				}
+
+				err := f(i)
+				if err != nil {
+					failed.Do(func() { close(done) })
+				}
+				results <- err
+			}
+		}()
+	}
+
+	var canceled bool
+	go func() {
+		defer close(next)
+		for i := 0; i < total; i++ {
+			// stop before handing out another call once one has failed or the run is canceled,
+			// which the select below could otherwise pick the send over, while workers skip
+			// any handed out as the first failed
+			select {
+			case <-done:
+				return
+			case <-cancel:
+				canceled = true
+				return
+			default:
+			}
+
+			select {
+			case next <- i:
+			case <-done:
+				return
+			case <-cancel:
+				canceled = true
+				return
+			}
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var first error
+	curr := 0
+	for err := range results {
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			continue
+		}
+
+		if progress != nil {
+			curr++
+			progress(curr, total)
+		}
+	}
+
+	if first == nil && canceled {
+		return ErrCanceled
+	}
+	return first
+}
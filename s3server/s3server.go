@@ -0,0 +1,322 @@
+// Package s3server implements a fync.Server for mods stored in an S3 compatible bucket,
+// such as Amazon S3, MinIO, or Backblaze B2.
+//
+// Objects are listed directly beneath a configurable prefix. Each object's SHA-256 checksum
+// is read from its S3 additional checksum or, failing that, from a "sha256" metadata entry
+// (x-amz-meta-sha256) containing the hex encoded checksum. Objects without either are compared by size.
+package s3server
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/han-tyumi/fync"
+)
+
+// Options contains options for the New function.
+type Options struct {
+	// Only objects directly beneath this prefix, such as "pack/mods/", are listed.
+	Prefix string
+
+	// Region of the bucket. Defaults to $AWS_REGION or us-east-1.
+	Region string
+
+	// Base URL of the S3 compatible service, such as "https://s3.us-west-004.backblazeb2.com"
+	// or "http://localhost:9000" for MinIO. Defaults to Amazon S3 within the Region.
+	Endpoint string
+
+	// Whether to address the bucket within the request path rather than as a subdomain of the endpoint.
+	// This is required by many S3 compatible services and is the default when an Endpoint is given.
+	PathStyle *bool
+
+	// Credentials used to sign requests. They default to $AWS_ACCESS_KEY_ID, $AWS_SECRET_ACCESS_KEY,
+	// and $AWS_SESSION_TOKEN, and requests are sent anonymously to public buckets when there are none.
+	AccessKeyID, SecretAccessKey, SessionToken string
+
+	// The HTTP client used for all requests. Defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// Server is a fync.Server that lists mods from an S3 bucket.
+type Server struct {
+	bucket    string
+	prefix    string
+	region    string
+	endpoint  *url.URL
+	pathStyle bool
+	client    *http.Client
+
+	accessKeyID, secretAccessKey, sessionToken string
+}
+
+// New returns a Server for the named bucket.
+func New(bucket string, o *Options) (*Server, error) {
+	if bucket == "" {
+		return nil, errors.New("s3server: missing bucket name")
+	}
+
+	if o == nil {
+		o = &Options{}
+	}
+
+	s := &Server{
+		bucket:          bucket,
+		prefix:          o.Prefix,
+		region:          o.Region,
+		client:          o.Client,
+		accessKeyID:     o.AccessKeyID,
+		secretAccessKey: o.SecretAccessKey,
+		sessionToken:    o.SessionToken,
+	}
+
+	if s.region == "" {
+		s.region = os.Getenv("AWS_REGION")
+	}
+	if s.region == "" {
+		s.region = "us-east-1"
+	}
+
+	if s.accessKeyID == "" && s.secretAccessKey == "" {
+		s.accessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		s.secretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		s.sessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+
+	if s.client == nil {
+		s.client = http.DefaultClient
+	}
+
+	endpoint := o.Endpoint
+	s.pathStyle = endpoint != ""
+	if endpoint == "" {
+		endpoint = "https://s3." + s.region + ".amazonaws.com"
+	}
+	if o.PathStyle != nil {
+		s.pathStyle = *o.PathStyle
+	}
+
+	var err error
+	if s.endpoint, err = url.Parse(endpoint); err != nil {
+		return nil, err
+	}
+	if s.endpoint.Scheme != "http" && s.endpoint.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported URL scheme %q", s.endpoint.Scheme)
+	}
+	return s, nil
+}
+
+// String returns the s3:// URL of the bucket and prefix.
+func (s *Server) String() string {
+	return "s3://" + s.bucket + "/" + s.prefix
+}
+
+// Mods returns a slice of mod ServerFiles for each jar directly beneath the prefix.
+// Mods are not downloaded until they are written.
+func (s *Server) Mods() ([]fync.ServerFile, error) {
+	var mods []fync.ServerFile
+
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {s.prefix}, "delimiter": {"/"}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		res, err := s.request(http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			IsTruncated           bool
+			NextContinuationToken string
+			Contents              []struct {
+				Key          string
+				Size         int64
+				LastModified time.Time
+				ETag         string
+			}
+		}
+		err = xml.NewDecoder(res.Body).Decode(&result)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range result.Contents {
+			if strings.HasSuffix(c.Key, ".jar") {
+				mods = append(mods, &file{
+					server:  s,
+					key:     c.Key,
+					size:    c.Size,
+					modTime: c.LastModified,
+					etag:    c.ETag,
+				})
+			}
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return mods, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// objectURL returns the URL of the object with the given key, or of the bucket when it is empty.
+func (s *Server) objectURL(key string, query url.Values) *url.URL {
+	u := *s.endpoint
+
+	p := "/" + key
+	if s.pathStyle {
+		p = "/" + s.bucket + p
+	} else {
+		u.Host = s.bucket + "." + u.Host
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + p
+	u.RawPath = escape(u.Path, true)
+	u.RawQuery = query.Encode()
+	return &u
+}
+
+// request sends a signed request for the object with the given key, or the bucket when it is empty.
+func (s *Server) request(method, key string, query url.Values, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, s.objectURL(key, query).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	if s.accessKeyID != "" {
+		s.sign(req, time.Now())
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		defer res.Body.Close()
+		return nil, responseError(s.String()+strings.TrimPrefix(key, s.prefix), res)
+	}
+	return res, nil
+}
+
+// responseError returns an error describing an unsuccessful response, including the S3 error message if any.
+func responseError(name string, res *http.Response) error {
+	if res.StatusCode == http.StatusPreconditionFailed {
+		return fmt.Errorf("%s: changed since it was listed", name)
+	}
+
+	var e struct {
+		Code    string
+		Message string
+	}
+	data, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1<<16))
+	if xml.Unmarshal(data, &e) == nil && e.Code != "" {
+		return fmt.Errorf("%s: %s: %s", name, e.Code, e.Message)
+	}
+	return fmt.Errorf("%s: unexpected status %q", name, res.Status)
+}
+
+// file is a fync.ServerFile that downloads a mod from the bucket.
+type file struct {
+	server  *Server
+	key     string
+	size    int64
+	modTime time.Time
+	etag    string
+	res     *http.Response
+}
+
+// String returns the s3:// URL of the mod.
+func (f *file) String() string {
+	return "s3://" + f.server.bucket + "/" + f.key
+}
+
+func (f *file) Stat() (os.FileInfo, error) {
+	return fileInfo{f}, nil
+}
+
+// SHA256 returns the object's additional SHA-256 checksum or sha256 metadata entry, if any.
+func (f *file) SHA256() (string, error) {
+	res, err := f.server.request(http.MethodHead, f.key, nil, http.Header{"X-Amz-Checksum-Mode": {"ENABLED"}})
+	if err != nil {
+		return "", err
+	}
+	res.Body.Close()
+
+	// checksums of multipart uploads are of each part's checksum instead, and suffixed with the number of parts
+	if sum := res.Header.Get("X-Amz-Checksum-Sha256"); sum != "" && !strings.Contains(sum, "-") {
+		data, err := base64.StdEncoding.DecodeString(sum)
+		if err != nil {
+			return "", fmt.Errorf("%s: invalid checksum %q", f, sum)
+		}
+		return hex.EncodeToString(data), nil
+	}
+	return res.Header.Get("X-Amz-Meta-Sha256"), nil
+}
+
+func (f *file) WriteTo(w io.Writer) (int64, error) {
+	if f.res == nil {
+		// fail rather than mix up the object's listed size with a newer version
+		var header http.Header
+		if f.etag != "" {
+			header = http.Header{"If-Match": {f.etag}}
+		}
+
+		res, err := f.server.request(http.MethodGet, f.key, nil, header)
+		if err != nil {
+			return 0, err
+		}
+		f.res = res
+	}
+
+	defer f.Close()
+	return io.Copy(w, f.res.Body)
+}
+
+// Seek only supports rewinding to the start of the file so that a failed transfer can be retried.
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekStart {
+		return 0, errors.New("s3server: can only seek to the start of a file")
+	}
+	return 0, f.Close()
+}
+
+func (f *file) Close() error {
+	if f.res == nil {
+		return nil
+	}
+
+	err := f.res.Body.Close()
+	f.res = nil
+	return err
+}
+
+type fileInfo struct {
+	*file
+}
+
+func (i fileInfo) Name() string       { return path.Base(i.key) }
+func (i fileInfo) Size() int64        { return i.size }
+func (i fileInfo) Mode() os.FileMode  { return 0644 }
+func (i fileInfo) ModTime() time.Time { return i.modTime }
+func (i fileInfo) IsDir() bool        { return false }
+func (i fileInfo) Sys() interface{}   { return nil }
+
+var _ fync.HashedFile = (*file)(nil)
@@ -0,0 +1,319 @@
+// Package scpserver implements a fync.Server for mods within a directory of an SSH host,
+// for hosts that only allow commands to be run over SSH rather than SFTP.
+// It requires the ssh command, and the host a POSIX shell.
+//
+// The mods are listed and their sizes found by a shell script run on the host, and each mod
+// is streamed by running cat. Checksums are read from a sha256sums.txt file alongside the mods,
+// or computed on the host with sha256sum when Options.Hash is set.
+// Authentication is left to ssh, such as by an agent or Options.Identity, since ssh is run
+// without prompting for passwords.
+package scpserver
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/han-tyumi/fync"
+)
+
+// checksumsName is the name of an optional file alongside the mods listing their checksums.
+const checksumsName = "sha256sums.txt"
+
+// checksumsMarker separates the mods listed by the listing script from their checksums,
+// and cannot be mistaken for a mod since those are listed with a tab.
+const checksumsMarker = "--"
+
+// Options contains options for the New function.
+type Options struct {
+	// Port of the host's SSH server. Defaults to ssh's choice, usually 22.
+	Port int
+
+	// Path of the private key used to authenticate. Defaults to ssh's choice.
+	Identity string
+
+	// Whether to hash the mods on the host with sha256sum when there is no sha256sums.txt file
+	// alongside them, so that mods are compared by checksum. It reads each mod on the host each sync.
+	Hash bool
+
+	// Additional arguments given to ssh before the host, such as "-o", "StrictHostKeyChecking=yes".
+	Args []string
+
+	// Path of the ssh command. Defaults to ssh as found on $PATH.
+	SSH string
+}
+
+// Server is a fync.Server that lists mods within a directory of an SSH host.
+type Server struct {
+	host string
+	dir  string
+	args []string
+	ssh  string
+	hash bool
+
+	mu   sync.Mutex
+	sums map[string]string
+}
+
+// New returns a Server for the mods within the directory of the host given in the form
+// [user@]host:dir, as used by scp, or ssh://[user@]host[:port]/dir.
+// Relative directories, and those of URLs beginning with /~/, are within the user's home directory.
+func New(location string, o *Options) (*Server, error) {
+	if o == nil {
+		o = &Options{}
+	}
+
+	s := &Server{ssh: o.SSH, hash: o.Hash}
+	if s.ssh == "" {
+		s.ssh = "ssh"
+	}
+
+	port := o.Port
+	if strings.HasPrefix(location, "ssh://") {
+		u, err := url.Parse(location)
+		if err != nil {
+			return nil, err
+		}
+
+		s.host = u.Hostname()
+		if u.User != nil {
+			s.host = u.User.Username() + "@" + s.host
+		}
+		if u.Port() != "" && port == 0 {
+			if port, err = strconv.Atoi(u.Port()); err != nil {
+				return nil, fmt.Errorf("invalid port %q", u.Port())
+			}
+		}
+		s.dir = u.Path
+		if strings.HasPrefix(s.dir, "/~/") {
+			s.dir = s.dir[len("/~/"):]
+		}
+	} else {
+		i := strings.Index(location, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("%q: expected [user@]host:dir", location)
+		}
+		s.host, s.dir = location[:i], location[i+1:]
+	}
+
+	if s.host == "" || strings.HasPrefix(s.host, "-") {
+		return nil, fmt.Errorf("%q: invalid host", location)
+	}
+	if s.dir == "" {
+		s.dir = "."
+	}
+
+	// never prompt for a password or passphrase, which would wait forever
+	s.args = []string{"-o", "BatchMode=yes"}
+	if port != 0 {
+		s.args = append(s.args, "-p", strconv.Itoa(port))
+	}
+	if o.Identity != "" {
+		s.args = append(s.args, "-i", o.Identity)
+	}
+	s.args = append(s.args, o.Args...)
+
+	if _, err := exec.LookPath(s.ssh); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// String returns the host and directory of the mods.
+func (s *Server) String() string {
+	return s.host + ":" + s.dir
+}
+
+// Mods lists the mods within the directory on the host and returns a slice of mod ServerFiles for each.
+// Mods are not read until they are written.
+func (s *Server) Mods() ([]fync.ServerFile, error) {
+	// list each mod with its size, followed by the checksums when they are available
+	script := `cd -- ` + quote(s.dir) + ` || exit 1
+for f in *.jar; do
+	[ -f "$f" ] || continue
+	printf '%s\t%s\n' "$(wc -c < "$f")" "$f"
+done
+if [ -f ` + checksumsName + ` ]; then
+	echo ` + checksumsMarker + `
+	cat ` + checksumsName + `
+elif ` + strconv.FormatBool(s.hash) + `; then
+	echo ` + checksumsMarker + `
+	sha256sum -- *.jar 2>/dev/null
+fi
+`
+
+	var stdout bytes.Buffer
+	if err := s.run(sh(script), &stdout); err != nil {
+		return nil, err
+	}
+
+	listing, checksums := stdout.String(), ""
+	if i := strings.Index("\n"+listing, "\n"+checksumsMarker+"\n"); i >= 0 {
+		listing, checksums = listing[:i], listing[i+len(checksumsMarker)+1:]
+	}
+
+	var mods []fync.ServerFile
+	scanner := bufio.NewScanner(strings.NewReader(listing))
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.SplitN(line, "\t", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s: unexpected listing %q", s, line)
+		}
+		size, err := strconv.ParseInt(strings.TrimSpace(fields[0]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: unexpected listing %q", s, line)
+		}
+		mods = append(mods, &file{server: s, name: fields[1], size: size})
+	}
+
+	sums, err := fync.ParseChecksums(strings.NewReader(checksums))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s, err)
+	}
+
+	s.mu.Lock()
+	s.sums = sums
+	s.mu.Unlock()
+	return mods, nil
+}
+
+// Checksums returns the checksums listed alongside the mods when they were last listed by Mods,
+// which must be called first.
+func (s *Server) Checksums() (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.sums == nil {
+		return nil, errors.New("scpserver: Checksums called before Mods")
+	}
+	return s.sums, nil
+}
+
+// command returns the ssh command running the remote command on the host.
+func (s *Server) command(remote string) *exec.Cmd {
+	args := append(append([]string(nil), s.args...), s.host, remote)
+	return exec.Command(s.ssh, args...)
+}
+
+// run runs the remote command on the host, writing its output to stdout.
+func (s *Server) run(remote string, stdout io.Writer) error {
+	cmd := s.command(remote)
+	var stderr bytes.Buffer
+	cmd.Stdout = stdout
+	cmd.Stderr = &stderr
+	return sshError(s, cmd.Run(), &stderr)
+}
+
+// sshError describes an error running ssh by what it wrote to stderr, when it wrote anything.
+func sshError(s *Server, err error, stderr *bytes.Buffer) error {
+	if err == nil {
+		return nil
+	}
+	if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		return fmt.Errorf("%s: %s", s, msg)
+	}
+	return fmt.Errorf("%s: %w", s, err)
+}
+
+// sh returns a remote command running the script with sh, whatever the user's login shell is.
+func sh(script string) string {
+	return "sh -c " + quote(script)
+}
+
+// quote quotes s for a POSIX shell.
+func quote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// file is a fync.ServerFile that streams a mod from the host.
+type file struct {
+	server *Server
+	name   string
+	size   int64
+
+	cmd    *exec.Cmd
+	stdout io.ReadCloser
+	stderr bytes.Buffer
+}
+
+// String returns the location of the mod on the host.
+func (f *file) String() string {
+	return f.server.String() + "/" + f.name
+}
+
+func (f *file) Stat() (os.FileInfo, error) {
+	return fileInfo{f.name, f.size}, nil
+}
+
+func (f *file) WriteTo(w io.Writer) (int64, error) {
+	if f.cmd == nil {
+		cmd := f.server.command(sh(`cd -- ` + quote(f.server.dir) + ` && exec cat -- ` + quote(f.name)))
+		f.stderr.Reset()
+		cmd.Stderr = &f.stderr
+
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return 0, err
+		}
+		if err := cmd.Start(); err != nil {
+			return 0, err
+		}
+		f.cmd, f.stdout = cmd, stdout
+	}
+
+	defer f.Close()
+
+	n, err := io.Copy(w, f.stdout)
+	if err != nil {
+		return n, err
+	}
+
+	// a failed cat may have written nothing at all
+	cmd := f.cmd
+	f.cmd = nil
+	return n, sshError(f.server, cmd.Wait(), &f.stderr)
+}
+
+// Seek only supports rewinding to the start of the file so that a failed transfer can be retried.
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekStart {
+		return 0, errors.New("scpserver: can only seek to the start of a file")
+	}
+	return 0, f.Close()
+}
+
+// Close stops streaming the mod if it has not been completely written.
+func (f *file) Close() error {
+	if f.cmd == nil {
+		return nil
+	}
+
+	f.cmd.Process.Kill()
+	f.cmd.Wait()
+	f.cmd = nil
+	return nil
+}
+
+type fileInfo struct {
+	name string
+	size int64
+}
+
+func (i fileInfo) Name() string       { return i.name }
+func (i fileInfo) Size() int64        { return i.size }
+func (i fileInfo) Mode() os.FileMode  { return 0644 }
+func (i fileInfo) ModTime() time.Time { return time.Time{} }
+func (i fileInfo) IsDir() bool        { return false }
+func (i fileInfo) Sys() interface{}   { return nil }
+
+var _ fync.ChecksumServer = (*Server)(nil)
@@ -0,0 +1,45 @@
+package fync
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// SignatureHeader is the header with which the signature of a manifest is sent along with it,
+// as by Handler when given a private key.
+const SignatureHeader = "Fync-Signature"
+
+// SignatureSuffix is appended to the URL of a manifest for the URL of its signature,
+// for hosts that only serve files and so cannot send the signature in a header.
+const SignatureSuffix = ".sig"
+
+// ErrInvalidSignature is returned when the signature of a manifest or AllowList was not made
+// by the expected key, such as for one that was tampered with.
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// SignManifest returns the base64 encoded Ed25519 signature of a manifest's exact bytes,
+// as served in the SignatureHeader or written beside the manifest with the SignatureSuffix.
+// An AllowList is signed the same way.
+//
+// A statically hosted pack is signed by writing its manifest to a buffer and uploading the signature
+// of its contents along with it:
+//
+//	var buf bytes.Buffer
+//	m.WriteTo(&buf)
+//	ioutil.WriteFile("manifest.json", buf.Bytes(), 0644)
+//	ioutil.WriteFile("manifest.json"+fync.SignatureSuffix, []byte(fync.SignManifest(buf.Bytes(), key)), 0644)
+func SignManifest(data []byte, key ed25519.PrivateKey) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(key, data))
+}
+
+// VerifyManifest checks that the base64 encoded signature of a manifest's exact bytes was made
+// by the private key of the public key, returning ErrInvalidSignature if not.
+func VerifyManifest(data []byte, signature string, key ed25519.PublicKey) error {
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(signature))
+	if err != nil || len(sig) != ed25519.SignatureSize || len(key) != ed25519.PublicKeySize || !ed25519.Verify(key, data, sig) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
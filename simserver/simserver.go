@@ -0,0 +1,269 @@
+// Package simserver implements a fync.Server for fake mods with scripted sizes, speeds, and failures,
+// for building and demonstrating the progress of syncs without a real server or large downloads.
+// Each mod's contents are generated from its name and version, so they are the same every sync.
+package simserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/han-tyumi/fync"
+)
+
+// ErrSimulated is returned by transfers that fail as scripted.
+var ErrSimulated = errors.New("simserver: simulated failure")
+
+// chunkSize is the number of bytes generated at a time.
+const chunkSize = 32 << 10
+
+// Mod is the script of a single fake mod.
+type Mod struct {
+	// Name of the mod.
+	Name string
+
+	// Size of the mod in bytes.
+	Size int64
+
+	// Changing the version changes the mod's contents without changing its size.
+	Version int
+
+	// Bytes per second the mod is transferred at, overriding Options.Speed when not zero.
+	Speed int64
+
+	// How long the mod takes to begin transferring, overriding Options.Latency when not zero.
+	Latency time.Duration
+
+	// Number of attempts to transfer the mod that fail halfway through before one succeeds.
+	Failures int
+
+	// Whether the mod reports an unknown size.
+	UnknownSize bool
+}
+
+// Options contains options for the New function.
+type Options struct {
+	// The mods to serve. Defaults to mods generated from Count and Size.
+	Mods []Mod
+
+	// Number of mods to generate when Mods is empty. Defaults to 20.
+	Count int
+
+	// Average size of the generated mods. Defaults to 1 MiB.
+	Size int64
+
+	// Seeds the sizes of the generated mods.
+	Seed int64
+
+	// Bytes per second each mod is transferred at. Defaults to unlimited.
+	Speed int64
+
+	// How long each mod takes to begin transferring.
+	Latency time.Duration
+
+	// How long listing the mods takes.
+	ListLatency time.Duration
+
+	// Whether to provide checksums for the mods, which are computed by generating them.
+	Checksums bool
+}
+
+// Server is a fync.Server that lists fake mods.
+type Server struct {
+	o Options
+
+	mu       sync.Mutex
+	attempts map[string]int
+}
+
+// New returns a Server for the fake mods described by o.
+func New(o *Options) *Server {
+	s := &Server{attempts: make(map[string]int)}
+	if o != nil {
+		s.o = *o
+	}
+
+	if len(s.o.Mods) == 0 {
+		count, size := s.o.Count, s.o.Size
+		if count == 0 {
+			count = 20
+		}
+		if size == 0 {
+			size = 1 << 20
+		}
+
+		r := rand.New(rand.NewSource(s.o.Seed))
+		s.o.Mods = make([]Mod, count)
+		for i := range s.o.Mods {
+			s.o.Mods[i] = Mod{
+				Name: fmt.Sprintf("sim-mod-%02d-1.0.0.jar", i+1),
+				Size: size/2 + r.Int63n(size+1),
+			}
+		}
+	}
+	return s
+}
+
+// String identifies the simulation.
+func (s *Server) String() string {
+	return "simulation"
+}
+
+// Mods returns a slice of mod ServerFiles for each fake mod.
+func (s *Server) Mods() ([]fync.ServerFile, error) {
+	time.Sleep(s.o.ListLatency)
+
+	files := make([]fync.ServerFile, len(s.o.Mods))
+	for i := range s.o.Mods {
+		files[i] = &file{server: s, mod: s.o.Mods[i]}
+	}
+	return files, nil
+}
+
+// Checksums returns the checksum of each fake mod when checksums are provided,
+// or an empty map when they are not.
+func (s *Server) Checksums() (map[string]string, error) {
+	sums := make(map[string]string)
+	if !s.o.Checksums {
+		return sums, nil
+	}
+
+	for _, m := range s.o.Mods {
+		h := sha256.New()
+		if _, err := io.Copy(h, newContents(m)); err != nil {
+			return nil, err
+		}
+		sums[m.Name] = hex.EncodeToString(h.Sum(nil))
+	}
+	return sums, nil
+}
+
+// attempt records an attempt to transfer the named mod, returning how many came before it.
+func (s *Server) attempt(name string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := s.attempts[name]
+	s.attempts[name]++
+	return n
+}
+
+// file is a fync.ServerFile that generates a fake mod.
+type file struct {
+	server *Server
+	mod    Mod
+}
+
+// String identifies the fake mod.
+func (f *file) String() string {
+	return "simulation:" + f.mod.Name
+}
+
+func (f *file) Stat() (os.FileInfo, error) {
+	return fileInfo{f.mod}, nil
+}
+
+func (f *file) WriteTo(w io.Writer) (int64, error) {
+	latency, speed := f.mod.Latency, f.mod.Speed
+	if latency == 0 {
+		latency = f.server.o.Latency
+	}
+	if speed == 0 {
+		speed = f.server.o.Speed
+	}
+
+	time.Sleep(latency)
+
+	var r io.Reader = newContents(f.mod)
+	fails := f.server.attempt(f.mod.Name) < f.mod.Failures
+	if fails {
+		r = io.LimitReader(r, f.mod.Size/2)
+	}
+
+	n, err := throttle(w, r, speed)
+	if err != nil {
+		return n, err
+	}
+
+	if fails {
+		return n, ErrSimulated
+	}
+	return n, nil
+}
+
+// throttle copies r to w at no more than speed bytes per second, or as fast as possible when it is zero.
+func throttle(w io.Writer, r io.Reader, speed int64) (int64, error) {
+	if speed <= 0 {
+		return io.Copy(w, r)
+	}
+
+	start := time.Now()
+	buf := make([]byte, chunkSize)
+	var n int64
+	for {
+		read, err := r.Read(buf)
+		if read > 0 {
+			written, err := w.Write(buf[:read])
+			n += int64(written)
+			if err != nil {
+				return n, err
+			}
+
+			// wait until the bytes written so far would have taken long enough to arrive
+			due := start.Add(time.Duration(float64(n) / float64(speed) * float64(time.Second)))
+			time.Sleep(time.Until(due))
+		}
+
+		if err == io.EOF {
+			return n, nil
+		} else if err != nil {
+			return n, err
+		}
+	}
+}
+
+// Seek only supports rewinding to the start of the file so that a failed transfer can be retried.
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekStart {
+		return 0, errors.New("simserver: can only seek to the start of a file")
+	}
+	return 0, nil
+}
+
+func (f *file) Close() error {
+	return nil
+}
+
+// newContents returns a reader of the mod's generated contents.
+func newContents(m Mod) io.Reader {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s\x00%d", m.Name, m.Version)
+	return io.LimitReader(rand.New(rand.NewSource(int64(h.Sum64()))), m.Size)
+}
+
+type fileInfo struct {
+	mod Mod
+}
+
+func (i fileInfo) Name() string { return i.mod.Name }
+
+func (i fileInfo) Size() int64 {
+	if i.mod.UnknownSize {
+		return -1
+	}
+	return i.mod.Size
+}
+
+func (i fileInfo) Mode() os.FileMode  { return 0644 }
+func (i fileInfo) ModTime() time.Time { return time.Time{} }
+func (i fileInfo) IsDir() bool        { return false }
+func (i fileInfo) Sys() interface{}   { return nil }
+
+var _ fync.ChecksumServer = (*Server)(nil)
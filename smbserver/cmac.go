@@ -0,0 +1,85 @@
+package smbserver
+
+import (
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+// cmac returns the AES-CMAC of msg as specified by RFC 4493, which SMB 3 signs messages with.
+func cmac(key, msg []byte) []byte {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		panic(err)
+	}
+
+	// the subkeys are the encrypted zero block doubled once and twice in GF(2^128)
+	k1 := make([]byte, aes.BlockSize)
+	block.Encrypt(k1, k1)
+	k1 = double(k1)
+	k2 := double(k1)
+
+	n := (len(msg) + aes.BlockSize - 1) / aes.BlockSize
+	complete := n > 0 && len(msg)%aes.BlockSize == 0
+	if n == 0 {
+		n = 1
+	}
+
+	// the last block is padded when it is incomplete and masked by a subkey
+	last := make([]byte, aes.BlockSize)
+	copy(last, msg[(n-1)*aes.BlockSize:])
+	if complete {
+		xor(last, k1)
+	} else {
+		last[len(msg)-(n-1)*aes.BlockSize] = 0x80
+		xor(last, k2)
+	}
+
+	mac := make([]byte, aes.BlockSize)
+	for i := 0; i < n-1; i++ {
+		xor(mac, msg[i*aes.BlockSize:(i+1)*aes.BlockSize])
+		block.Encrypt(mac, mac)
+	}
+	xor(mac, last)
+	block.Encrypt(mac, mac)
+	return mac
+}
+
+// double multiplies a block by x in GF(2^128).
+func double(b []byte) []byte {
+	d := make([]byte, len(b))
+	for i := range b {
+		d[i] = b[i] << 1
+		if i+1 < len(b) {
+			d[i] |= b[i+1] >> 7
+		}
+	}
+	if b[0]&0x80 != 0 {
+		d[len(d)-1] ^= 0x87
+	}
+	return d
+}
+
+// xor sets each byte of dst to its XOR with the corresponding byte of src.
+func xor(dst, src []byte) {
+	for i := range dst {
+		dst[i] ^= src[i]
+	}
+}
+
+// kdf derives a 128-bit key from key using the SP800-108 counter mode KDF with HMAC-SHA256,
+// as SMB 3 derives its signing keys.
+func kdf(key, label, context []byte) []byte {
+	h := hmac.New(sha256.New, key)
+
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], 1)
+	h.Write(buf[:])
+	h.Write(label)
+	h.Write([]byte{0})
+	h.Write(context)
+	binary.BigEndian.PutUint32(buf[:], 128)
+	h.Write(buf[:])
+	return h.Sum(nil)[:16]
+}
@@ -0,0 +1,57 @@
+package smbserver
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// RFC 4493 section 4
+const cmacKey = "2b7e151628aed2a6abf7158809cf4f3c"
+
+func TestCMAC(t *testing.T) {
+	msg := "6bc1bee22e409f96e93d7e117393172a" +
+		"ae2d8a571e03ac9c9eb76fac45af8e51" +
+		"30c81c46a35ce411e5fbc1191a0a52ef" +
+		"f69f2445df4f9b17ad2b417be66c3710"
+
+	tests := []struct {
+		length int
+		mac    string
+	}{
+		{0, "bb1d6929e95937287fa37d129b756746"},
+		{16, "070a16b46b4d4144f79bdd9dd04a287c"},
+		{40, "dfa66747de9ae63030ca32611497c827"},
+		{64, "51f0bebf7e3b9d92fc49741779363cfe"},
+	}
+
+	key := unhex(t, cmacKey)
+	for _, tt := range tests {
+		if got := hex.EncodeToString(cmac(key, unhex(t, msg)[:tt.length])); got != tt.mac {
+			t.Errorf("AES-CMAC of %d bytes = %s, want %s", tt.length, got, tt.mac)
+		}
+	}
+}
+
+func TestCMACSubkeys(t *testing.T) {
+	// the encryption of the zero block with the key, then K1 and K2
+	l := unhex(t, "7df76b0c1ab899b33e42f047b91b546f")
+	k1 := double(l)
+	k2 := double(k1)
+
+	if got, want := hex.EncodeToString(k1), "fbeed618357133667c85e08f7236a8de"; got != want {
+		t.Errorf("K1 = %s, want %s", got, want)
+	}
+	if got, want := hex.EncodeToString(k2), "f7ddac306ae266ccf90bc11ee46d513b"; got != want {
+		t.Errorf("K2 = %s, want %s", got, want)
+	}
+}
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
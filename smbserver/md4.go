@@ -0,0 +1,67 @@
+package smbserver
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// The order in which each round of MD4 reads the words of a block, and the rotations of each step.
+var (
+	md4Order = [3][16]int{
+		{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+		{0, 4, 8, 12, 1, 5, 9, 13, 2, 6, 10, 14, 3, 7, 11, 15},
+		{0, 8, 4, 12, 2, 10, 6, 14, 1, 9, 5, 13, 3, 11, 7, 15},
+	}
+	md4Shift = [3][4]int{{3, 7, 11, 19}, {3, 5, 9, 13}, {3, 9, 11, 15}}
+)
+
+// md4 returns the MD4 digest of data as specified by RFC 1320, from which NTLM derives its keys.
+// It has long been broken as a hash function, but the protocol requires it.
+func md4(data []byte) []byte {
+	s := [4]uint32{0x67452301, 0xefcdab89, 0x98badcfe, 0x10325476}
+
+	// the message is padded to 56 bytes modulo 64, followed by its length in bits
+	msg := append(append([]byte(nil), data...), 0x80)
+	for len(msg)%64 != 56 {
+		msg = append(msg, 0)
+	}
+	var length [8]byte
+	binary.LittleEndian.PutUint64(length[:], uint64(len(data))*8)
+	msg = append(msg, length[:]...)
+
+	var x [16]uint32
+	for ; len(msg) > 0; msg = msg[64:] {
+		for i := range x {
+			x[i] = binary.LittleEndian.Uint32(msg[i*4:])
+		}
+
+		a, b, c, d := s[0], s[1], s[2], s[3]
+		for round := range md4Order {
+			for i, k := range md4Order[round] {
+				var f uint32
+				switch round {
+				case 0:
+					f = b&c | ^b&d
+				case 1:
+					f = (b&c | b&d | c&d) + 0x5a827999
+				case 2:
+					f = (b ^ c ^ d) + 0x6ed9eba1
+				}
+
+				// each step updates the next of a, d, c, and b in turn
+				a, b, c, d = d, bits.RotateLeft32(a+f+x[k], md4Shift[round][i%4]), b, c
+			}
+		}
+
+		s[0] += a
+		s[1] += b
+		s[2] += c
+		s[3] += d
+	}
+
+	sum := make([]byte, 16)
+	for i, v := range s {
+		binary.LittleEndian.PutUint32(sum[i*4:], v)
+	}
+	return sum
+}
@@ -0,0 +1,310 @@
+package smbserver
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rc4"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// Flags of NTLM messages that are used.
+const (
+	ntlmUnicode         = 0x00000001
+	ntlmRequestTarget   = 0x00000004
+	ntlmSign            = 0x00000010
+	ntlmNTLM            = 0x00000200
+	ntlmAnonymous       = 0x00000800
+	ntlmAlwaysSign      = 0x00008000
+	ntlmExtendedSession = 0x00080000
+	ntlmTargetInfo      = 0x00800000
+	ntlm128             = 0x20000000
+	ntlmKeyExchange     = 0x40000000
+	ntlm56              = 0x80000000
+)
+
+// IDs of the attribute-value pairs of target information that are used.
+const (
+	avEOL       = 0
+	avTimestamp = 7
+)
+
+// ntlmSignature begins every NTLM message.
+var ntlmSignature = []byte("NTLMSSP\x00")
+
+// errChallenge is returned when the server's NTLM challenge is not validly encoded.
+var errChallenge = errors.New("smbserver: invalid NTLM challenge")
+
+// ntlm authenticates a user with NTLMv2.
+type ntlm struct {
+	user, password, domain string
+
+	negotiate  []byte
+	sessionKey []byte
+}
+
+// negotiateMessage returns the NTLM NEGOTIATE message beginning authentication.
+func (n *ntlm) negotiateMessage() []byte {
+	flags := uint32(ntlmUnicode | ntlmRequestTarget | ntlmSign | ntlmNTLM | ntlmAlwaysSign |
+		ntlmExtendedSession | ntlmTargetInfo | ntlm128 | ntlmKeyExchange | ntlm56)
+	if n.user == "" {
+		flags |= ntlmAnonymous
+	}
+
+	msg := make([]byte, 32)
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], 1)
+	binary.LittleEndian.PutUint32(msg[12:], flags)
+	n.negotiate = msg
+	return msg
+}
+
+// authenticateMessage returns the NTLM AUTHENTICATE message answering the server's CHALLENGE message,
+// and derives the session key.
+func (n *ntlm) authenticateMessage(challenge []byte) ([]byte, error) {
+	if len(challenge) < 48 || !bytes.HasPrefix(challenge, ntlmSignature) || binary.LittleEndian.Uint32(challenge[8:]) != 2 {
+		return nil, errChallenge
+	}
+
+	flags := binary.LittleEndian.Uint32(challenge[20:])
+	serverChallenge := challenge[24:32]
+	targetInfo, err := field(challenge, 40)
+	if err != nil {
+		return nil, err
+	}
+
+	var lm, nt, encryptedKey []byte
+	if n.user == "" {
+		// anonymous authentication has no responses and no session key
+		lm = []byte{0}
+		n.sessionKey = nil
+	} else {
+		ntowf := ntowfv2(n.user, n.password, n.domain)
+
+		clientChallenge := make([]byte, 8)
+		if _, err := rand.Read(clientChallenge); err != nil {
+			return nil, err
+		}
+
+		timestamp, ok := avPair(targetInfo, avTimestamp)
+		if !ok {
+			timestamp = make([]byte, 8)
+			binary.LittleEndian.PutUint64(timestamp, filetime(time.Now()))
+		}
+
+		temp := []byte{1, 1, 0, 0, 0, 0, 0, 0}
+		temp = append(temp, timestamp...)
+		temp = append(temp, clientChallenge...)
+		temp = append(temp, 0, 0, 0, 0)
+		temp = append(temp, targetInfo...)
+		temp = append(temp, 0, 0, 0, 0)
+
+		proof := hmacMD5(ntowf, serverChallenge, temp)
+		nt = append(proof, temp...)
+
+		// the LMv2 response is omitted when the server provides a timestamp
+		if ok {
+			lm = make([]byte, 24)
+		} else {
+			lm = append(hmacMD5(ntowf, serverChallenge, clientChallenge), clientChallenge...)
+		}
+
+		n.sessionKey = hmacMD5(ntowf, proof)
+		if flags&ntlmKeyExchange != 0 {
+			exported := make([]byte, 16)
+			if _, err := rand.Read(exported); err != nil {
+				return nil, err
+			}
+			c, err := rc4.NewCipher(n.sessionKey)
+			if err != nil {
+				return nil, err
+			}
+			encryptedKey = make([]byte, 16)
+			c.XORKeyStream(encryptedKey, exported)
+			n.sessionKey = exported
+		}
+	}
+
+	authFlags := flags &^ ntlmAnonymous
+	if n.user == "" {
+		authFlags |= ntlmAnonymous
+	}
+
+	msg := make([]byte, 64)
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], 3)
+	binary.LittleEndian.PutUint32(msg[60:], authFlags)
+	for i, payload := range [][]byte{lm, nt, utf16le(n.domain), utf16le(n.user), nil, encryptedKey} {
+		binary.LittleEndian.PutUint16(msg[12+i*8:], uint16(len(payload)))
+		binary.LittleEndian.PutUint16(msg[14+i*8:], uint16(len(payload)))
+		binary.LittleEndian.PutUint32(msg[16+i*8:], uint32(len(msg)))
+		msg = append(msg, payload...)
+	}
+	return msg, nil
+}
+
+// field returns the payload described by the length and offset fields of an NTLM message at i.
+func field(msg []byte, i int) ([]byte, error) {
+	length := int(binary.LittleEndian.Uint16(msg[i:]))
+	offset := int(binary.LittleEndian.Uint32(msg[i+4:]))
+	if offset > len(msg) || length > len(msg)-offset {
+		return nil, errChallenge
+	}
+	return msg[offset : offset+length], nil
+}
+
+// avPair returns the value of an attribute-value pair of target information.
+func avPair(info []byte, id uint16) ([]byte, bool) {
+	for len(info) >= 4 {
+		avID := binary.LittleEndian.Uint16(info)
+		avLen := int(binary.LittleEndian.Uint16(info[2:]))
+		if avID == avEOL || avLen > len(info)-4 {
+			break
+		}
+		if avID == id {
+			return info[4 : 4+avLen], true
+		}
+		info = info[4+avLen:]
+	}
+	return nil, false
+}
+
+// ntowfv2 returns the key NTLMv2 derives from a user's password, their name, and their domain.
+func ntowfv2(user, password, domain string) []byte {
+	return hmacMD5(md4(utf16le(password)), utf16le(strings.ToUpper(user)+domain))
+}
+
+func hmacMD5(key []byte, data ...[]byte) []byte {
+	h := hmac.New(md5.New, key)
+	for _, d := range data {
+		h.Write(d)
+	}
+	return h.Sum(nil)
+}
+
+// utf16le encodes s as little-endian UTF-16, the encoding of strings in NTLM and SMB.
+func utf16le(s string) []byte {
+	u := utf16.Encode([]rune(s))
+	b := make([]byte, len(u)*2)
+	for i, c := range u {
+		binary.LittleEndian.PutUint16(b[i*2:], c)
+	}
+	return b
+}
+
+// decodeUTF16 decodes little-endian UTF-16.
+func decodeUTF16(b []byte) string {
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = binary.LittleEndian.Uint16(b[i*2:])
+	}
+	return string(utf16.Decode(u))
+}
+
+// filetime returns t as a Windows FILETIME, in 100 nanosecond intervals since 1601.
+func filetime(t time.Time) uint64 {
+	return uint64(t.UnixNano()/100) + 116444736000000000
+}
+
+// fromFiletime returns the time of a Windows FILETIME.
+func fromFiletime(ft uint64) time.Time {
+	if ft == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(ft-116444736000000000)*100)
+}
+
+// OIDs of SPNEGO and of NTLM as a mechanism of it, DER encoded.
+var (
+	spnegoOID = []byte{0x06, 0x06, 0x2b, 0x06, 0x01, 0x05, 0x05, 0x02}
+	ntlmOID   = []byte{0x06, 0x0a, 0x2b, 0x06, 0x01, 0x04, 0x01, 0x82, 0x37, 0x02, 0x02, 0x0a}
+)
+
+// spnegoInit wraps the NTLM NEGOTIATE message in the SPNEGO NegTokenInit that begins authentication.
+func spnegoInit(token []byte) []byte {
+	mechTypes := der(0xa0, der(0x30, ntlmOID))
+	mechToken := der(0xa2, der(0x04, token))
+	return der(0x60, append(spnegoOID, der(0xa0, der(0x30, append(mechTypes, mechToken...)))...))
+}
+
+// spnegoResponse wraps an NTLM message in a SPNEGO NegTokenResp.
+func spnegoResponse(token []byte) []byte {
+	return der(0xa1, der(0x30, der(0xa2, der(0x04, token))))
+}
+
+// spnegoToken returns the NTLM message within the server's SPNEGO NegTokenResp.
+func spnegoToken(b []byte) ([]byte, error) {
+	resp, err := derContents(b, 0xa1)
+	if err == nil {
+		resp, err = derContents(resp, 0x30)
+	}
+
+	// the fields of the response are each optional and tagged
+	for err == nil && len(resp) > 0 {
+		tag := resp[0]
+		var contents, rest []byte
+		if contents, rest, err = derNext(resp); err != nil {
+			break
+		}
+		if tag == 0xa2 {
+			return derContents(contents, 0x04)
+		}
+		resp = rest
+	}
+	if err == nil {
+		err = errors.New("smbserver: SPNEGO response has no token")
+	}
+	return nil, err
+}
+
+// der encodes a DER element with the given tag and contents.
+func der(tag byte, contents []byte) []byte {
+	b := []byte{tag}
+	switch n := len(contents); {
+	case n < 0x80:
+		b = append(b, byte(n))
+	case n < 0x100:
+		b = append(b, 0x81, byte(n))
+	default:
+		b = append(b, 0x82, byte(n>>8), byte(n))
+	}
+	return append(b, contents...)
+}
+
+// derContents returns the contents of a DER element, which must have the given tag.
+func derContents(b []byte, tag byte) ([]byte, error) {
+	if len(b) == 0 || b[0] != tag {
+		return nil, errors.New("smbserver: invalid SPNEGO token")
+	}
+	contents, _, err := derNext(b)
+	return contents, err
+}
+
+// derNext returns the contents of the first DER element of b and what follows it.
+func derNext(b []byte) (contents, rest []byte, err error) {
+	if len(b) < 2 {
+		return nil, nil, errors.New("smbserver: invalid SPNEGO token")
+	}
+
+	n, i := int(b[1]), 2
+	if n&0x80 != 0 {
+		size := n & 0x7f
+		if size == 0 || size > 3 || len(b) < 2+size {
+			return nil, nil, errors.New("smbserver: invalid SPNEGO token")
+		}
+		n = 0
+		for _, c := range b[2 : 2+size] {
+			n = n<<8 | int(c)
+		}
+		i += size
+	}
+	if n > len(b)-i {
+		return nil, nil, errors.New("smbserver: invalid SPNEGO token")
+	}
+	return b[i : i+n], b[i+n:], nil
+}
@@ -0,0 +1,42 @@
+package smbserver
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestMD4(t *testing.T) {
+	// RFC 1320 appendix A.5
+	tests := []struct {
+		data, digest string
+	}{
+		{"", "31d6cfe0d16ae931b73c59d7e0c089c0"},
+		{"a", "bde52cb31de33e46245e05fbdbd6fb24"},
+		{"abc", "a448017aaf21d8525fc10ae87aa6729d"},
+		{"message digest", "d9130a8164549fe818874806e1c7014b"},
+		{"abcdefghijklmnopqrstuvwxyz", "d79e1c308aa5bbcdeea8ed63df412da9"},
+		{"ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789", "043f8582f241db351ce627e153e7f0e4"},
+		{strings.Repeat("1234567890", 8), "e33b4ddc9c38f2199c3e7b164fcc0536"},
+	}
+
+	for _, tt := range tests {
+		if got := hex.EncodeToString(md4([]byte(tt.data))); got != tt.digest {
+			t.Errorf("MD4(%q) = %s, want %s", tt.data, got, tt.digest)
+		}
+	}
+}
+
+func TestNTOWFv2(t *testing.T) {
+	// MS-NLMP section 4.2.4.1.1
+	got := hex.EncodeToString(ntowfv2("User", "Password", "Domain"))
+	if want := "0c868a403bfd7a93a3001ef22ef02e3f"; got != want {
+		t.Errorf("NTOWFv2 = %s, want %s", got, want)
+	}
+
+	// MS-NLMP section 4.2.2.1.2, the MD4 of the password it is derived from
+	got = hex.EncodeToString(md4(utf16le("Password")))
+	if want := "a4f49c406510bdcab6824ee7c30fd852"; got != want {
+		t.Errorf("NTOWFv1 = %s, want %s", got, want)
+	}
+}
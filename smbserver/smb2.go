@@ -0,0 +1,593 @@
+package smbserver
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Commands of SMB2 that are used.
+const (
+	cmdNegotiate      = 0x0000
+	cmdSessionSetup   = 0x0001
+	cmdTreeConnect    = 0x0003
+	cmdCreate         = 0x0005
+	cmdClose          = 0x0006
+	cmdRead           = 0x0008
+	cmdQueryDirectory = 0x000e
+)
+
+// Dialects of SMB2 and SMB3 that are offered, oldest first.
+const (
+	dialect202 = 0x0202
+	dialect210 = 0x0210
+	dialect300 = 0x0300
+	dialect302 = 0x0302
+	dialect311 = 0x0311
+)
+
+// Flags of SMB2 headers, sessions, and shares that are used.
+const (
+	flagResponse      = 0x00000001
+	flagAsync         = 0x00000002
+	flagSigned        = 0x00000008
+	signingEnabled    = 0x0001
+	signingRequired   = 0x0002
+	sessionGuest      = 0x0001
+	sessionNull       = 0x0002
+	sessionEncrypt    = 0x0004
+	shareEncrypt      = 0x00008000
+	fileDirectoryFlag = 0x00000010
+)
+
+// NT status codes that are handled.
+const (
+	statusSuccess               = 0x00000000
+	statusPending               = 0x00000103
+	statusNoMoreFiles           = 0x80000006
+	statusEndOfFile             = 0xc0000011
+	statusMoreProcessing        = 0xc0000016
+	statusAccessDenied          = 0xc0000022
+	statusObjectNameNotFound    = 0xc0000034
+	statusObjectPathNotFound    = 0xc000003a
+	statusSharingViolation      = 0xc0000043
+	statusLogonFailure          = 0xc000006d
+	statusAccountRestriction    = 0xc000006e
+	statusPasswordExpired       = 0xc0000071
+	statusAccountDisabled       = 0xc0000072
+	statusNotSupported          = 0xc00000bb
+	statusBadNetworkName        = 0xc00000cc
+	statusFileIsADirectory      = 0xc00000ba
+	statusNotADirectory         = 0xc0000103
+	statusUserSessionDeleted    = 0xc0000203
+	statusNetworkSessionExpired = 0xc000035c
+)
+
+// statusNames describes the NT status codes most likely to be returned.
+var statusNames = map[uint32]string{
+	statusAccessDenied:          "access denied",
+	statusObjectNameNotFound:    "no such file or directory",
+	statusObjectPathNotFound:    "no such file or directory",
+	statusSharingViolation:      "file is in use",
+	statusLogonFailure:          "logon failure: unknown user name or bad password",
+	statusAccountRestriction:    "account restriction",
+	statusPasswordExpired:       "password expired",
+	statusAccountDisabled:       "account disabled",
+	statusNotSupported:          "not supported",
+	statusBadNetworkName:        "no such share",
+	statusFileIsADirectory:      "is a directory",
+	statusNotADirectory:         "not a directory",
+	statusUserSessionDeleted:    "session deleted",
+	statusNetworkSessionExpired: "session expired",
+}
+
+// headerSize is the size of SMB2 message headers.
+const headerSize = 64
+
+// maxChunk is the most data read or listed by a single request, the most that needs only a single credit.
+const maxChunk = 64 << 10
+
+// statusError is returned when a request fails with an NT status code.
+type statusError struct {
+	status uint32
+}
+
+func (e *statusError) Error() string {
+	if name, ok := statusNames[e.status]; ok {
+		return name
+	}
+	return fmt.Sprintf("NT status 0x%08x", e.status)
+}
+
+// conn is an SMB2 connection with an authenticated session. Requests are sent one at a time.
+type conn struct {
+	c       net.Conn
+	timeout time.Duration
+
+	mu         sync.Mutex
+	messageID  uint64
+	dialect    uint16
+	sessionID  uint64
+	signingKey []byte
+	preauth    []byte
+	maxRead    uint32
+}
+
+// dial connects to the host at addr and authenticates as the user of n.
+func dial(addr string, n *ntlm, timeout time.Duration) (*conn, error) {
+	nc, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &conn{c: nc, timeout: timeout}
+	if err := c.negotiate(); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	if err := c.sessionSetup(n); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *conn) Close() error {
+	return c.c.Close()
+}
+
+// negotiate negotiates the dialect of the connection.
+func (c *conn) negotiate() error {
+	dialects := []uint16{dialect202, dialect210, dialect300, dialect302, dialect311}
+
+	body := make([]byte, 36, 128)
+	binary.LittleEndian.PutUint16(body[0:], 36)
+	binary.LittleEndian.PutUint16(body[2:], uint16(len(dialects)))
+	binary.LittleEndian.PutUint16(body[4:], signingEnabled)
+	if _, err := rand.Read(body[12:28]); err != nil {
+		return err
+	}
+	for _, d := range dialects {
+		body = append(body, byte(d), byte(d>>8))
+	}
+
+	// SMB 3.1.1 requires the preauthentication integrity context, which chooses SHA-512
+	for (headerSize+len(body))%8 != 0 {
+		body = append(body, 0)
+	}
+	binary.LittleEndian.PutUint32(body[28:], uint32(headerSize+len(body)))
+	binary.LittleEndian.PutUint16(body[32:], 1)
+
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	context := []byte{1, 0, 38, 0, 0, 0, 0, 0, 1, 0, 32, 0, 1, 0}
+	body = append(append(body, context...), salt...)
+
+	req := c.message(cmdNegotiate, 0, body)
+	res, err := c.roundTrip(req)
+	if err != nil {
+		return err
+	}
+	if len(res) < headerSize+65 {
+		return errors.New("smbserver: invalid negotiate response")
+	}
+
+	r := res[headerSize:]
+	c.dialect = binary.LittleEndian.Uint16(r[4:])
+	c.maxRead = binary.LittleEndian.Uint32(r[32:])
+	if c.dialect < dialect202 || c.dialect > dialect311 {
+		return fmt.Errorf("smbserver: unsupported dialect 0x%04x", c.dialect)
+	}
+
+	if c.dialect == dialect311 {
+		c.preauth = make([]byte, sha512.Size)
+		c.preauth = preauthHash(c.preauth, req)
+		c.preauth = preauthHash(c.preauth, res)
+	}
+	return nil
+}
+
+// sessionSetup authenticates the session with NTLM wrapped in SPNEGO, deriving the key requests are signed with.
+func (c *conn) sessionSetup(n *ntlm) error {
+	token := spnegoInit(n.negotiateMessage())
+	preauth := c.preauth
+
+	for round := 0; ; round++ {
+		body := make([]byte, 24)
+		binary.LittleEndian.PutUint16(body[0:], 25)
+		body[3] = signingEnabled
+		binary.LittleEndian.PutUint16(body[12:], headerSize+24)
+		binary.LittleEndian.PutUint16(body[14:], uint16(len(token)))
+		body = append(body, token...)
+
+		req := c.message(cmdSessionSetup, 0, body)
+		if preauth != nil {
+			preauth = preauthHash(preauth, req)
+		}
+
+		res, err := c.roundTrip(req)
+		if err != nil {
+			return err
+		}
+		// NTLM takes a single challenge
+		status := binary.LittleEndian.Uint32(res[8:])
+		if status == statusMoreProcessing && round > 0 {
+			status = statusLogonFailure
+		}
+		if status != statusMoreProcessing && status != statusSuccess {
+			return &statusError{status}
+		}
+		if len(res) < headerSize+8 {
+			return errors.New("smbserver: invalid session setup response")
+		}
+
+		r := res[headerSize:]
+		flags := binary.LittleEndian.Uint16(r[2:])
+		offset := int(binary.LittleEndian.Uint16(r[4:]))
+		length := int(binary.LittleEndian.Uint16(r[6:]))
+		if offset > len(res) || length > len(res)-offset {
+			return errors.New("smbserver: invalid session setup response")
+		}
+		buffer := res[offset : offset+length]
+
+		if status == statusMoreProcessing {
+			c.sessionID = binary.LittleEndian.Uint64(res[40:])
+			if preauth != nil {
+				preauth = preauthHash(preauth, res)
+			}
+
+			challenge, err := spnegoToken(buffer)
+			if err != nil {
+				return err
+			}
+			auth, err := n.authenticateMessage(challenge)
+			if err != nil {
+				return err
+			}
+			token = spnegoResponse(auth)
+			continue
+		}
+
+		if flags&sessionEncrypt != 0 {
+			return errors.New("smbserver: the host requires encryption, which is unsupported")
+		}
+
+		// guest and anonymous sessions have no key to sign with
+		if flags&(sessionGuest|sessionNull) != 0 || n.sessionKey == nil {
+			return nil
+		}
+
+		key := n.sessionKey
+		switch {
+		case c.dialect == dialect311:
+			key = kdf(key, []byte("SMBSigningKey\x00"), preauth)
+		case c.dialect >= dialect300:
+			key = kdf(key, []byte("SMB2AESCMAC\x00"), []byte("SmbSign\x00"))
+		}
+		c.signingKey = key
+
+		if binary.LittleEndian.Uint32(res[16:])&flagSigned != 0 && !c.verify(res) {
+			return errors.New("smbserver: invalid signature of session setup response")
+		}
+		return nil
+	}
+}
+
+// preauthHash chains the hash of a message onto the preauthentication integrity hash of SMB 3.1.1.
+func preauthHash(h, msg []byte) []byte {
+	sum := sha512.Sum512(append(append([]byte(nil), h...), msg...))
+	return sum[:]
+}
+
+// message returns a request message with the next message ID, signed once the session has a key.
+func (c *conn) message(cmd uint16, treeID uint32, body []byte) []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	msg := make([]byte, headerSize, headerSize+len(body))
+	copy(msg, "\xfeSMB")
+	binary.LittleEndian.PutUint16(msg[4:], headerSize)
+	if c.dialect > dialect202 {
+		binary.LittleEndian.PutUint16(msg[6:], 1)
+	}
+	binary.LittleEndian.PutUint16(msg[12:], cmd)
+	binary.LittleEndian.PutUint16(msg[14:], 64)
+	binary.LittleEndian.PutUint64(msg[24:], c.messageID)
+	binary.LittleEndian.PutUint32(msg[36:], treeID)
+	binary.LittleEndian.PutUint64(msg[40:], c.sessionID)
+	msg = append(msg, body...)
+	c.messageID++
+
+	if c.signingKey != nil {
+		binary.LittleEndian.PutUint32(msg[16:], flagSigned)
+		copy(msg[48:], c.signature(msg))
+	}
+	return msg
+}
+
+// signature returns the signature of a message, computed as if its signature were zero.
+func (c *conn) signature(msg []byte) []byte {
+	m := append([]byte(nil), msg...)
+	copy(m[48:64], make([]byte, 16))
+
+	if c.dialect >= dialect300 {
+		return cmac(c.signingKey, m)
+	}
+	h := hmac.New(sha256.New, c.signingKey)
+	h.Write(m)
+	return h.Sum(nil)[:16]
+}
+
+// verify returns whether a message is signed by the session's key.
+func (c *conn) verify(msg []byte) bool {
+	return hmac.Equal(msg[48:64], c.signature(msg))
+}
+
+// roundTrip sends a request and returns its response, waiting through interim responses.
+func (c *conn) roundTrip(req []byte) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.c.SetDeadline(time.Now().Add(c.timeout))
+
+	frame := make([]byte, 4, 4+len(req))
+	binary.BigEndian.PutUint32(frame, uint32(len(req)))
+	if _, err := c.c.Write(append(frame, req...)); err != nil {
+		return nil, err
+	}
+
+	id := binary.LittleEndian.Uint64(req[24:])
+	for {
+		res, err := c.read()
+		if err != nil {
+			return nil, err
+		}
+
+		// skip notifications, such as oplock breaks, and responses the host will follow up
+		flags := binary.LittleEndian.Uint32(res[16:])
+		status := binary.LittleEndian.Uint32(res[8:])
+		if binary.LittleEndian.Uint64(res[24:]) != id || flags&flagAsync != 0 && status == statusPending {
+			continue
+		}
+
+		// a response left unsigned may have been tampered with
+		if c.signingKey != nil && (flags&flagSigned == 0 || !c.verify(res)) {
+			return nil, errors.New("smbserver: invalid signature of response")
+		}
+		return res, nil
+	}
+}
+
+// read reads a single message from the connection.
+func (c *conn) read() ([]byte, error) {
+	var frame [4]byte
+	if _, err := io.ReadFull(c.c, frame[:]); err != nil {
+		return nil, err
+	}
+
+	n := binary.BigEndian.Uint32(frame[:]) & 0xffffff
+	if n < headerSize {
+		return nil, errors.New("smbserver: invalid message")
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(c.c, msg); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(msg, []byte("\xfdSMB")):
+		return nil, errors.New("smbserver: the host requires encryption, which is unsupported")
+	case !bytes.HasPrefix(msg, []byte("\xfeSMB")) || binary.LittleEndian.Uint32(msg[16:])&flagResponse == 0:
+		return nil, errors.New("smbserver: invalid message")
+	}
+	return msg, nil
+}
+
+// call sends a request within a share and returns the body of its response, failing unless it succeeded
+// or has one of the given statuses.
+func (c *conn) call(cmd uint16, treeID uint32, body []byte, ok ...uint32) (status uint32, res []byte, err error) {
+	msg, err := c.roundTrip(c.message(cmd, treeID, body))
+	if err != nil {
+		return 0, nil, err
+	}
+
+	status = binary.LittleEndian.Uint32(msg[8:])
+	if status != statusSuccess {
+		for _, s := range ok {
+			if status == s {
+				return status, nil, nil
+			}
+		}
+		return status, nil, &statusError{status}
+	}
+	return status, msg, nil
+}
+
+// treeConnect connects to a share, returning its tree ID.
+func (c *conn) treeConnect(host, share string) (uint32, error) {
+	path := utf16le(`\\` + host + `\` + share)
+	body := make([]byte, 8)
+	binary.LittleEndian.PutUint16(body[0:], 9)
+	binary.LittleEndian.PutUint16(body[4:], headerSize+8)
+	binary.LittleEndian.PutUint16(body[6:], uint16(len(path)))
+
+	_, res, err := c.call(cmdTreeConnect, 0, append(body, path...))
+	if err != nil {
+		return 0, err
+	}
+	if len(res) < headerSize+16 {
+		return 0, errors.New("smbserver: invalid tree connect response")
+	}
+	if binary.LittleEndian.Uint32(res[headerSize+4:])&shareEncrypt != 0 {
+		return 0, errors.New("smbserver: the share requires encryption, which is unsupported")
+	}
+	return binary.LittleEndian.Uint32(res[36:]), nil
+}
+
+// Access, sharing, and options of opened files and directories.
+const (
+	accessReadData       = 0x00000001
+	accessReadAttributes = 0x00000080
+	accessSynchronize    = 0x00100000
+	shareAll             = 0x00000007
+	openExisting         = 0x00000001
+	optionDirectory      = 0x00000001
+	optionNonDirectory   = 0x00000040
+)
+
+// fileID identifies an open file or directory.
+type fileID [16]byte
+
+// create opens an existing file or directory within a share for reading.
+func (c *conn) create(treeID uint32, path string, dir bool) (fileID, error) {
+	var id fileID
+
+	name := utf16le(strings.ReplaceAll(path, "/", `\`))
+	options := uint32(optionNonDirectory)
+	if dir {
+		options = optionDirectory
+	}
+
+	body := make([]byte, 56)
+	binary.LittleEndian.PutUint16(body[0:], 57)
+	binary.LittleEndian.PutUint32(body[4:], 2)
+	binary.LittleEndian.PutUint32(body[24:], accessReadData|accessReadAttributes|accessSynchronize)
+	binary.LittleEndian.PutUint32(body[32:], shareAll)
+	binary.LittleEndian.PutUint32(body[36:], openExisting)
+	binary.LittleEndian.PutUint32(body[40:], options)
+	binary.LittleEndian.PutUint16(body[44:], headerSize+56)
+	binary.LittleEndian.PutUint16(body[46:], uint16(len(name)))
+
+	// the buffer must not be empty even when the name is
+	body = append(body, name...)
+	if len(name) == 0 {
+		body = append(body, 0)
+	}
+
+	_, res, err := c.call(cmdCreate, treeID, body)
+	if err != nil {
+		return id, err
+	}
+	if len(res) < headerSize+88 {
+		return id, errors.New("smbserver: invalid create response")
+	}
+	copy(id[:], res[headerSize+64:])
+	return id, nil
+}
+
+// close closes an open file or directory.
+func (c *conn) close(treeID uint32, id fileID) error {
+	body := make([]byte, 24)
+	binary.LittleEndian.PutUint16(body[0:], 24)
+	copy(body[8:], id[:])
+
+	_, _, err := c.call(cmdClose, treeID, body)
+	return err
+}
+
+// dirEntry is an entry of a directory listing.
+type dirEntry struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+// queryDirectory lists an open directory, returning nil once every entry has been listed.
+func (c *conn) queryDirectory(treeID uint32, id fileID, restart bool) ([]dirEntry, error) {
+	pattern := utf16le("*")
+	body := make([]byte, 32)
+	binary.LittleEndian.PutUint16(body[0:], 33)
+	body[2] = 0x01 // FileDirectoryInformation
+	if restart {
+		body[3] = 0x01
+	}
+	copy(body[8:], id[:])
+	binary.LittleEndian.PutUint16(body[24:], headerSize+32)
+	binary.LittleEndian.PutUint16(body[26:], uint16(len(pattern)))
+	binary.LittleEndian.PutUint32(body[28:], maxChunk)
+
+	status, res, err := c.call(cmdQueryDirectory, treeID, append(body, pattern...), statusNoMoreFiles)
+	if err != nil || status == statusNoMoreFiles {
+		return nil, err
+	}
+	if len(res) < headerSize+8 {
+		return nil, errors.New("smbserver: invalid query directory response")
+	}
+
+	offset := int(binary.LittleEndian.Uint16(res[headerSize+2:]))
+	length := int(binary.LittleEndian.Uint32(res[headerSize+4:]))
+	if offset > len(res) || length > len(res)-offset {
+		return nil, errors.New("smbserver: invalid query directory response")
+	}
+	info := res[offset : offset+length]
+
+	entries := []dirEntry{}
+	for len(info) >= 64 {
+		next := int(binary.LittleEndian.Uint32(info[0:]))
+		nameLength := int(binary.LittleEndian.Uint32(info[60:]))
+		if nameLength > len(info)-64 || next != 0 && (next < 64 || next > len(info)) {
+			return nil, errors.New("smbserver: invalid directory entry")
+		}
+
+		entries = append(entries, dirEntry{
+			name:    decodeUTF16(info[64 : 64+nameLength]),
+			size:    int64(binary.LittleEndian.Uint64(info[40:])),
+			modTime: fromFiletime(binary.LittleEndian.Uint64(info[24:])),
+			dir:     binary.LittleEndian.Uint32(info[56:])&fileDirectoryFlag != 0,
+		})
+
+		if next == 0 {
+			break
+		}
+		info = info[next:]
+	}
+	return entries, nil
+}
+
+// readAt reads up to len(p) bytes of an open file at an offset, returning io.EOF at its end.
+func (c *conn) readAt(treeID uint32, id fileID, p []byte, offset int64) (int, error) {
+	length := len(p)
+	if length > maxChunk {
+		length = maxChunk
+	}
+	if c.maxRead > 0 && uint32(length) > c.maxRead {
+		length = int(c.maxRead)
+	}
+
+	body := make([]byte, 49)
+	binary.LittleEndian.PutUint16(body[0:], 49)
+	binary.LittleEndian.PutUint32(body[4:], uint32(length))
+	binary.LittleEndian.PutUint64(body[8:], uint64(offset))
+	copy(body[16:], id[:])
+
+	status, res, err := c.call(cmdRead, treeID, body, statusEndOfFile)
+	if err != nil {
+		return 0, err
+	}
+	if status == statusEndOfFile {
+		return 0, io.EOF
+	}
+	if len(res) < headerSize+16 {
+		return 0, errors.New("smbserver: invalid read response")
+	}
+
+	dataOffset := int(res[headerSize+2])
+	dataLength := int(binary.LittleEndian.Uint32(res[headerSize+4:]))
+	if dataOffset > len(res) || dataLength > len(res)-dataOffset || dataLength > length {
+		return 0, errors.New("smbserver: invalid read response")
+	}
+	return copy(p, res[dataOffset:dataOffset+dataLength]), nil
+}
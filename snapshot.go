@@ -0,0 +1,71 @@
+package fync
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// snapshotsName is the name of the directory within the backup directory containing snapshots.
+const snapshotsName = "snapshots"
+
+// SnapshotDir returns the directory containing the snapshots of the mods directory
+// taken before syncing when the Snapshot option is used.
+func SnapshotDir() (string, error) {
+	return filepath.Join(backupDir, snapshotsName), dirErr
+}
+
+// snapshot hard links, or copies when linking is not possible, the entire mods directory
+// into a new timestamped snapshot directory whose path is returned.
+func snapshot(t time.Time) (string, error) {
+	parent := filepath.Join(backupDir, snapshotsName)
+	if err := os.MkdirAll(parent, os.ModeDir|0755); err != nil {
+		return "", err
+	}
+
+	base := filepath.Join(parent, t.Format(backupTimeFormat))
+	dir := base
+	for i := 1; ; i++ {
+		err := os.Mkdir(dir, os.ModeDir|0755)
+		if err == nil {
+			break
+		}
+		if !os.IsExist(err) {
+			return "", err
+		}
+		dir = base + "-" + strconv.Itoa(i)
+	}
+
+	err := filepath.Walk(modsDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// the backup directory may have been placed within the mods directory
+		if info.IsDir() && (path == backupDir || strings.HasPrefix(path, backupDir+string(filepath.Separator))) {
+			return filepath.SkipDir
+		}
+
+		rel, err := filepath.Rel(modsDir, path)
+		if err != nil {
+			return err
+		}
+		dest := filepath.Join(dir, rel)
+
+		if info.IsDir() {
+			return os.MkdirAll(dest, os.ModeDir|0755)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		if err := os.Link(path, dest); err == nil {
+			return nil
+		}
+		return copyFile(path, dest)
+	})
+
+	return dir, err
+}
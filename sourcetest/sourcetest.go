@@ -0,0 +1,405 @@
+// Package sourcetest provides a conformance suite for fync.Server implementations,
+// checking that they honor the contract Sync relies on for any optional interfaces they implement.
+package sourcetest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/han-tyumi/fync"
+	"github.com/han-tyumi/fync/fynctest"
+)
+
+// Mods returns the mods the suite serves, with names that need escaping by most protocols
+// and contents spanning multiple buffer sizes.
+func Mods() map[string]string {
+	large := make([]byte, 300<<10)
+	for i := range large {
+		large[i] = byte(i * 31 % 251)
+	}
+
+	return map[string]string{
+		"jei-1.16.5+7.7.1.jar": "jei",
+		"Mod With Spaces.jar":  strings.Repeat("spaces", 1000),
+		"large.jar":            string(large),
+		"a.jar":                "a",
+	}
+}
+
+// Conformance runs the suite against Servers made by newServer, which must serve
+// exactly the mods it is given. Each check runs as a subtest.
+func Conformance(t *testing.T, newServer fynctest.NewServerFunc) {
+	t.Run("Mods", func(t *testing.T) { testMods(t, newServer) })
+	t.Run("Repeatable", func(t *testing.T) { testRepeatable(t, newServer) })
+	t.Run("Checksums", func(t *testing.T) { testChecksums(t, newServer) })
+	t.Run("List", func(t *testing.T) { testList(t, newServer) })
+	t.Run("Seek", func(t *testing.T) { testSeek(t, newServer) })
+	t.Run("Close", func(t *testing.T) { testClose(t, newServer) })
+	t.Run("Cancel", func(t *testing.T) { testCancel(t, newServer) })
+	t.Run("Scenarios", func(t *testing.T) { fynctest.Run(t, newServer, fynctest.Scenarios) })
+}
+
+// testMods checks that each mod is listed once with accurate metadata and contents.
+func testMods(t *testing.T, newServer fynctest.NewServerFunc) {
+	want := Mods()
+	files := mods(t, newServer(t, want))
+
+	seen := make(map[string]bool)
+	for _, f := range files {
+		info := stat(t, f)
+		name := info.Name()
+
+		data, ok := want[name]
+		if !ok {
+			t.Errorf("%s: not served", name)
+			continue
+		}
+		if seen[name] {
+			t.Errorf("%s: listed more than once", name)
+		}
+		seen[name] = true
+
+		if info.IsDir() {
+			t.Errorf("%s: is a directory", name)
+		}
+		if size := info.Size(); size >= 0 && size != int64(len(data)) {
+			t.Errorf("%s: size is %d, want %d", name, size, len(data))
+		}
+
+		var buf bytes.Buffer
+		n, err := f.WriteTo(&buf)
+		if err != nil {
+			t.Errorf("%s: WriteTo: %v", name, err)
+		} else if n != int64(buf.Len()) {
+			t.Errorf("%s: WriteTo returned %d, wrote %d bytes", name, n, buf.Len())
+		} else if buf.String() != data {
+			t.Errorf("%s: wrote different contents", name)
+		}
+		f.Close()
+	}
+
+	for _, name := range names(want) {
+		if !seen[name] {
+			t.Errorf("%s: missing", name)
+		}
+	}
+}
+
+// testRepeatable checks that listing the mods again gives the same mods.
+func testRepeatable(t *testing.T, newServer fynctest.NewServerFunc) {
+	s := newServer(t, Mods())
+
+	first := listing(t, mods(t, s))
+	second := listing(t, mods(t, s))
+
+	if strings.Join(first, "\n") != strings.Join(second, "\n") {
+		t.Errorf("listed %v, then %v", first, second)
+	}
+}
+
+// testChecksums checks that any checksums the server provides are correct.
+func testChecksums(t *testing.T, newServer fynctest.NewServerFunc) {
+	want := Mods()
+	s := newServer(t, want)
+	files := mods(t, s)
+
+	if cs, ok := s.(fync.ChecksumServer); ok {
+		sums, err := cs.Checksums()
+		if err != nil {
+			t.Fatalf("Checksums: %v", err)
+		}
+
+		for name, sum := range sums {
+			data, ok := want[name]
+			if !ok {
+				t.Errorf("Checksums: %s: not served", name)
+			} else if strings.ToLower(sum) != checksum(data) {
+				t.Errorf("Checksums: %s: got %s, want %s", name, sum, checksum(data))
+			}
+		}
+	}
+
+	for _, f := range files {
+		name := stat(t, f).Name()
+
+		if hf, ok := f.(fync.HashedFile); ok {
+			sum, err := hf.SHA256()
+			if err != nil {
+				t.Errorf("%s: SHA256: %v", name, err)
+			} else if sum != "" && strings.ToLower(sum) != checksum(want[name]) {
+				t.Errorf("%s: SHA256 is %s, want %s", name, sum, checksum(want[name]))
+			}
+		}
+		f.Close()
+	}
+}
+
+// testList checks that a ListServer lists the same mods as Mods and that each can be opened.
+func testList(t *testing.T, newServer fynctest.NewServerFunc) {
+	want := Mods()
+	s, ok := newServer(t, want).(fync.ListServer)
+	if !ok {
+		t.Skip("not a fync.ListServer")
+	}
+
+	refs, err := s.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+
+	var listed []string
+	for _, ref := range refs {
+		name := ref.Info.Name()
+		listed = append(listed, name)
+
+		if size := ref.Info.Size(); size >= 0 && size != int64(len(want[name])) {
+			t.Errorf("%s: size is %d, want %d", name, size, len(want[name]))
+		}
+		if ref.SHA256 != "" && strings.ToLower(ref.SHA256) != checksum(want[name]) {
+			t.Errorf("%s: SHA256 is %s, want %s", name, ref.SHA256, checksum(want[name]))
+		}
+
+		f, err := s.Open(ref)
+		if err != nil {
+			t.Errorf("%s: Open: %v", name, err)
+			continue
+		}
+
+		var buf bytes.Buffer
+		if _, err := f.WriteTo(&buf); err != nil {
+			t.Errorf("%s: WriteTo: %v", name, err)
+		} else if buf.String() != want[name] {
+			t.Errorf("%s: wrote different contents", name)
+		}
+		f.Close()
+	}
+
+	sort.Strings(listed)
+	if got := listing(t, mods(t, s)); strings.Join(listed, "\n") != strings.Join(got, "\n") {
+		t.Errorf("List gave %v, Mods gave %v", listed, got)
+	}
+}
+
+// testSeek checks that files which can be rewound write their contents again once rewound.
+func testSeek(t *testing.T, newServer fynctest.NewServerFunc) {
+	want := Mods()
+	tested := false
+
+	for _, f := range mods(t, newServer(t, want)) {
+		name := stat(t, f).Name()
+
+		seeker, ok := f.(io.Seeker)
+		if !ok {
+			f.Close()
+			continue
+		}
+		tested = true
+
+		// abandon the first write partway through, as a failed transfer would
+		f.WriteTo(&limitedWriter{n: 1})
+
+		if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+			t.Errorf("%s: Seek: %v", name, err)
+			f.Close()
+			continue
+		}
+
+		var buf bytes.Buffer
+		if _, err := f.WriteTo(&buf); err != nil {
+			t.Errorf("%s: WriteTo after Seek: %v", name, err)
+		} else if buf.String() != want[name] {
+			t.Errorf("%s: wrote different contents after Seek", name)
+		}
+		f.Close()
+	}
+
+	if !tested {
+		t.Skip("no files implement io.Seeker")
+	}
+}
+
+// testClose checks that files may be closed without being written, and closed again.
+func testClose(t *testing.T, newServer fynctest.NewServerFunc) {
+	for _, f := range mods(t, newServer(t, Mods())) {
+		name := stat(t, f).Name()
+
+		if err := f.Close(); err != nil {
+			t.Errorf("%s: Close: %v", name, err)
+		}
+		if err := f.Close(); err != nil {
+			t.Errorf("%s: second Close: %v", name, err)
+		}
+	}
+}
+
+// testCancel checks that a sync canceled while a mod is being written stops without leaving
+// partial mods or temporary files behind. The mod being written may still be finished.
+func testCancel(t *testing.T, newServer fynctest.NewServerFunc) {
+	instance := fynctest.NewInstance(t)
+	want := Mods()
+
+	cancel := make(chan struct{})
+	s := &slowServer{Server: newServer(t, want), writing: make(chan struct{}), resume: cancel}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := fync.Sync(s, &fync.SyncOptions{Cancel: cancel, Concurrency: 1})
+		done <- err
+	}()
+
+	select {
+	case <-s.writing:
+	case err := <-done:
+		t.Fatalf("Sync returned %v before writing a mod", err)
+	}
+	close(cancel)
+
+	if err := <-done; err != fync.ErrCanceled {
+		t.Fatalf("Sync: got %v, want %v", err, fync.ErrCanceled)
+	}
+
+	files, err := ioutil.ReadDir(instance.ModsDir())
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+
+	written := 0
+	for _, f := range files {
+		name := f.Name()
+		switch {
+		case strings.HasSuffix(name, ".fync-tmp"):
+			t.Errorf("%s: left behind", name)
+		case filepath.Ext(name) == ".jar":
+			written++
+			data, err := ioutil.ReadFile(filepath.Join(instance.ModsDir(), name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != want[name] {
+				t.Errorf("%s: left behind partially written", name)
+			}
+		}
+	}
+	if written > 1 {
+		t.Errorf("%d mods written after canceling while writing the first", written)
+	}
+}
+
+func mods(t *testing.T, s fync.Server) []fync.ServerFile {
+	t.Helper()
+
+	files, err := s.Mods()
+	if err != nil {
+		t.Fatalf("Mods: %v", err)
+	}
+	return files
+}
+
+func stat(t *testing.T, f fync.ServerFile) os.FileInfo {
+	t.Helper()
+
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	return info
+}
+
+// listing returns the sorted names of the files, closing them.
+func listing(t *testing.T, files []fync.ServerFile) []string {
+	t.Helper()
+
+	var l []string
+	for _, f := range files {
+		info := stat(t, f)
+		l = append(l, info.Name())
+		f.Close()
+	}
+	sort.Strings(l)
+	return l
+}
+
+func names(mods map[string]string) []string {
+	var n []string
+	for name := range mods {
+		n = append(n, name)
+	}
+	sort.Strings(n)
+	return n
+}
+
+func checksum(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// slowServer is a server whose mods stall once they start being written, until resume is closed.
+type slowServer struct {
+	fync.Server
+	writing chan struct{}
+	resume  <-chan struct{}
+	once    sync.Once
+}
+
+func (s *slowServer) Mods() ([]fync.ServerFile, error) {
+	files, err := s.Server.Mods()
+	if err != nil {
+		return nil, err
+	}
+
+	slow := make([]fync.ServerFile, len(files))
+	for i, f := range files {
+		slow[i] = &slowFile{ServerFile: f, server: s}
+	}
+	return slow, nil
+}
+
+// slowFile is a mod of a slowServer.
+type slowFile struct {
+	fync.ServerFile
+	server *slowServer
+}
+
+func (f *slowFile) WriteTo(w io.Writer) (int64, error) {
+	return f.ServerFile.WriteTo(&slowWriter{w: w, server: f.server})
+}
+
+// slowWriter stalls on its first write until its server resumes.
+type slowWriter struct {
+	w       io.Writer
+	server  *slowServer
+	stalled bool
+}
+
+func (w *slowWriter) Write(p []byte) (int, error) {
+	if !w.stalled {
+		w.stalled = true
+		w.server.once.Do(func() { close(w.server.writing) })
+		<-w.server.resume
+	}
+	return w.w.Write(p)
+}
+
+// limitedWriter fails once n bytes have been written.
+type limitedWriter struct {
+	n int
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		n := w.n
+		w.n = 0
+		return n, io.ErrShortWrite
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package fync
+
+import "syscall"
+
+// availableSpace returns the number of bytes available to the user on the volume containing path.
+func availableSpace(path string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
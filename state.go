@@ -0,0 +1,90 @@
+package fync
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// stateName is the name of the file within the mods directory recording the mods fync installed.
+const stateName = ".fync-state.json"
+
+// state records the mods within the mods directory that were installed by fync.
+type state struct {
+	mu   sync.Mutex
+	Mods map[string]stateMod `json:"mods"`
+}
+
+type stateMod struct {
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// readState reads the state of the mods directory, which is empty if it has not been saved before.
+func readState() (*state, error) {
+	st := &state{Mods: make(map[string]stateMod)}
+
+	data, err := ioutil.ReadFile(filepath.Join(modsDir, stateName))
+	if os.IsNotExist(err) {
+		return st, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, st); err != nil {
+		return nil, err
+	}
+
+	if st.Mods == nil {
+		st.Mods = make(map[string]stateMod)
+	}
+	return st, nil
+}
+
+func (st *state) save() error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	data, err := json.MarshalIndent(st, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(modsDir, stateName), data, 0644)
+}
+
+// managed returns whether the named mod was installed by fync.
+func (st *state) managed(name string) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	_, ok := st.Mods[name]
+	return ok
+}
+
+// manage records that the named mod was installed by fync.
+func (st *state) manage(name string, size int64, sum string) {
+	st.mu.Lock()
+	st.Mods[name] = stateMod{Size: size, SHA256: sum}
+	st.mu.Unlock()
+}
+
+// release records that the named mod is no longer within the mods directory.
+func (st *state) release(name string) {
+	st.mu.Lock()
+	delete(st.Mods, name)
+	st.mu.Unlock()
+}
+
+// prune releases managed mods that are not among the local mods.
+func (st *state) prune(localMods map[string]int64) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	for name := range st.Mods {
+		if _, ok := localMods[name]; !ok {
+			delete(st.Mods, name)
+		}
+	}
+}
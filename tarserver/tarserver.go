@@ -0,0 +1,361 @@
+// Package tarserver implements a fync.Server for mods within a tar archive,
+// which may be compressed with gzip or bzip2, such as a server backup or export.
+// Mods are read straight from the archive as they are written, so it is never extracted.
+//
+// Mods within an uncompressed archive are read directly from where they are stored.
+// A compressed archive can only be read from its start, so its mods are hashed while it is first
+// listed, letting up to date mods be skipped without reading the archive again, and mods being
+// written share a single pass through the archive, reopening it only when a mod has already been passed.
+package tarserver
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/han-tyumi/fync"
+)
+
+// compression is the kind of compression applied to an archive.
+type compression int
+
+const (
+	compressNone compression = iota
+	compressGzip
+	compressBzip2
+)
+
+// Options contains options for the New function.
+type Options struct {
+	// Only mods within this directory of the archive, such as "mods", are listed.
+	// Defaults to listing jars anywhere within the archive.
+	Dir string
+}
+
+// Server is a fync.Server that lists mods from a tar archive.
+// It should be closed once it is no longer needed.
+type Server struct {
+	path        string
+	dir         string
+	compression compression
+
+	// the shared pass through a compressed archive
+	mu     sync.Mutex
+	stream *stream
+}
+
+// New returns a Server for the tar archive at the given path.
+func New(path string, o *Options) (*Server, error) {
+	if o == nil {
+		o = &Options{}
+	}
+
+	c, err := detect(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Server{path: path, dir: strings.Trim(o.Dir, "/"), compression: c}, nil
+}
+
+// detect determines how the archive at path is compressed from its first bytes.
+func detect(path string) (compression, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return compressNone, err
+	}
+	defer f.Close()
+
+	magic := make([]byte, 3)
+	if _, err := io.ReadFull(f, magic); err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return compressNone, err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return compressGzip, nil
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		return compressBzip2, nil
+	default:
+		return compressNone, nil
+	}
+}
+
+// String returns the path of the archive.
+func (s *Server) String() string {
+	return s.path
+}
+
+// Close closes the archive if it is being read.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stream == nil {
+		return nil
+	}
+
+	err := s.stream.Close()
+	s.stream = nil
+	return err
+}
+
+// Mods returns a slice of mod ServerFiles for each jar within the archive.
+func (s *Server) Mods() ([]fync.ServerFile, error) {
+	st, err := s.open()
+	if err != nil {
+		return nil, err
+	}
+	defer st.Close()
+
+	var mods []fync.ServerFile
+	seen := make(map[string]string)
+
+	for {
+		header, index, err := st.next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: %w", s.path, err)
+		}
+
+		if !s.listed(header) {
+			continue
+		}
+
+		name := path.Base(header.Name)
+		if other, ok := seen[name]; ok {
+			return nil, fmt.Errorf("%s: both %s and %s are named %s", s.path, other, header.Name, name)
+		}
+		seen[name] = header.Name
+
+		f := &file{server: s, header: header, index: index}
+
+		if s.compression == compressNone {
+			if f.offset, err = st.file.Seek(0, io.SeekCurrent); err != nil {
+				return nil, err
+			}
+		} else {
+			h := sha256.New()
+			if _, err := io.Copy(h, st.tar); err != nil {
+				return nil, fmt.Errorf("%s: %w", s.path, err)
+			}
+			f.sum = hex.EncodeToString(h.Sum(nil))
+		}
+
+		mods = append(mods, f)
+	}
+	return mods, nil
+}
+
+// listed returns whether the archive entry is a mod to list.
+func (s *Server) listed(header *tar.Header) bool {
+	if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+		return false
+	}
+
+	if !strings.HasSuffix(header.Name, ".jar") {
+		return false
+	}
+
+	return s.dir == "" || path.Dir(path.Clean(header.Name)) == s.dir
+}
+
+// open begins a new pass through the archive.
+func (s *Server) open() (*stream, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+
+	st := &stream{file: f}
+
+	var r io.Reader = f
+	switch s.compression {
+	case compressGzip:
+		gz, err := gzip.NewReader(bufio.NewReader(f))
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%s: %w", s.path, err)
+		}
+		st.decompressor = gz
+		r = gz
+	case compressBzip2:
+		r = bzip2.NewReader(bufio.NewReader(f))
+	}
+
+	st.tar = tar.NewReader(r)
+	return st, nil
+}
+
+// seek advances the shared pass through a compressed archive to the entry at index,
+// starting a new pass when the entry has already been passed.
+// It must be called with s.mu held.
+func (s *Server) seek(index int, name string) error {
+	if s.stream != nil && s.stream.passed > index {
+		s.stream.Close()
+		s.stream = nil
+	}
+
+	if s.stream == nil {
+		st, err := s.open()
+		if err != nil {
+			return err
+		}
+		s.stream = st
+	}
+
+	for {
+		header, i, err := s.stream.next()
+		if err == io.EOF {
+			return fmt.Errorf("%s: %s is no longer within the archive", s.path, name)
+		} else if err != nil {
+			return fmt.Errorf("%s: %w", s.path, err)
+		}
+
+		if i == index {
+			if header.Name != name {
+				return fmt.Errorf("%s: %s is no longer within the archive", s.path, name)
+			}
+			return nil
+		}
+	}
+}
+
+// stream is a single pass through an archive.
+type stream struct {
+	file         *os.File
+	decompressor io.Closer
+	tar          *tar.Reader
+
+	// the number of entries that have been passed
+	passed int
+}
+
+// next returns the next entry of the archive along with its index.
+func (st *stream) next() (*tar.Header, int, error) {
+	header, err := st.tar.Next()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	st.passed++
+	return header, st.passed - 1, nil
+}
+
+func (st *stream) Close() error {
+	if st.decompressor != nil {
+		st.decompressor.Close()
+	}
+	return st.file.Close()
+}
+
+// file is a fync.ServerFile that reads a mod from the archive.
+type file struct {
+	server *Server
+	header *tar.Header
+	index  int
+
+	// where the mod is stored within an uncompressed archive
+	offset int64
+
+	// the mod's checksum, when learned from listing a compressed archive
+	sum string
+
+	r *os.File
+}
+
+// String returns the location of the mod within the archive.
+func (f *file) String() string {
+	return f.server.path + "!/" + f.header.Name
+}
+
+func (f *file) Stat() (os.FileInfo, error) {
+	return fileInfo{f}, nil
+}
+
+// SHA256 returns the checksum of a mod within a compressed archive.
+// Mods within an uncompressed archive are not hashed ahead of time.
+func (f *file) SHA256() (string, error) {
+	return f.sum, nil
+}
+
+func (f *file) WriteTo(w io.Writer) (int64, error) {
+	if f.server.compression != compressNone {
+		return f.writeStreamed(w)
+	}
+
+	if f.r == nil {
+		r, err := os.Open(f.server.path)
+		if err != nil {
+			return 0, err
+		}
+		f.r = r
+	}
+
+	defer f.Close()
+	return io.Copy(w, io.NewSectionReader(f.r, f.offset, f.header.Size))
+}
+
+// writeStreamed writes the mod from the shared pass through a compressed archive.
+func (f *file) writeStreamed(w io.Writer) (int64, error) {
+	s := f.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.seek(f.index, f.header.Name); err != nil {
+		return 0, err
+	}
+
+	n, err := io.Copy(w, s.stream.tar)
+	if err != nil {
+		// the pass may be left somewhere unexpected, so the next mod starts a new one
+		s.stream.Close()
+		s.stream = nil
+	}
+	return n, err
+}
+
+// Seek only supports rewinding to the start of the file so that a failed transfer can be retried.
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekStart {
+		return 0, errors.New("tarserver: can only seek to the start of a file")
+	}
+	return 0, f.Close()
+}
+
+func (f *file) Close() error {
+	if f.r == nil {
+		return nil
+	}
+
+	err := f.r.Close()
+	f.r = nil
+	return err
+}
+
+type fileInfo struct {
+	f *file
+}
+
+func (i fileInfo) Name() string       { return path.Base(i.f.header.Name) }
+func (i fileInfo) Size() int64        { return i.f.header.Size }
+func (i fileInfo) Mode() os.FileMode  { return 0644 }
+func (i fileInfo) ModTime() time.Time { return i.f.header.ModTime }
+func (i fileInfo) IsDir() bool        { return false }
+func (i fileInfo) Sys() interface{}   { return nil }
+
+var _ fync.HashedFile = (*file)(nil)
+var _ fync.Server = (*Server)(nil)
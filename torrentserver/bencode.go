@@ -0,0 +1,186 @@
+package torrentserver
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"strconv"
+)
+
+// errBencode is returned when a torrent is not validly bencoded.
+var errBencode = errors.New("torrentserver: invalid torrent file")
+
+// torrentFile is a file within a torrent, with its path relative to the torrent's root.
+type torrentFile struct {
+	path   []string
+	length int64
+}
+
+// torrent is the part of a torrent's metainfo that is used.
+type torrent struct {
+	infoHash string
+	name     string
+	files    []torrentFile
+}
+
+// parseTorrent parses bencoded torrent metainfo.
+func parseTorrent(data []byte) (*torrent, error) {
+	d := &decoder{data: data}
+	v, err := d.value()
+	if err != nil {
+		return nil, err
+	}
+
+	meta, ok := v.(map[string]interface{})
+	if !ok || d.infoEnd == 0 {
+		return nil, errBencode
+	}
+	info, ok := meta["info"].(map[string]interface{})
+	if !ok {
+		return nil, errBencode
+	}
+
+	sum := sha1.Sum(data[d.infoStart:d.infoEnd])
+	t := &torrent{infoHash: hex.EncodeToString(sum[:])}
+	if t.name, ok = info["name"].(string); !ok {
+		return nil, errBencode
+	}
+
+	// single file torrents have a length rather than files
+	files, ok := info["files"].([]interface{})
+	if !ok {
+		return t, nil
+	}
+
+	for _, f := range files {
+		f, ok := f.(map[string]interface{})
+		if !ok {
+			return nil, errBencode
+		}
+		length, ok := f["length"].(int64)
+		if !ok {
+			return nil, errBencode
+		}
+		elems, ok := f["path"].([]interface{})
+		if !ok {
+			return nil, errBencode
+		}
+
+		tf := torrentFile{length: length}
+		for _, elem := range elems {
+			elem, ok := elem.(string)
+			if !ok {
+				return nil, errBencode
+			}
+			tf.path = append(tf.path, elem)
+		}
+		t.files = append(t.files, tf)
+	}
+	return t, nil
+}
+
+// decoder decodes bencoded values, recording where the info dictionary of the metainfo begins and ends
+// so that its hash can be computed.
+type decoder struct {
+	data  []byte
+	pos   int
+	depth int
+
+	infoStart, infoEnd int
+}
+
+// value decodes the next value: an int64, string, []interface{}, or map[string]interface{}.
+func (d *decoder) value() (interface{}, error) {
+	if d.pos >= len(d.data) || d.depth > 64 {
+		return nil, errBencode
+	}
+
+	switch c := d.data[d.pos]; {
+	case c == 'i':
+		end := d.find('e')
+		if end < 0 {
+			return nil, errBencode
+		}
+		n, err := strconv.ParseInt(string(d.data[d.pos+1:end]), 10, 64)
+		if err != nil {
+			return nil, errBencode
+		}
+		d.pos = end + 1
+		return n, nil
+
+	case c == 'l':
+		d.pos++
+		d.depth++
+		list := []interface{}{}
+		for d.pos < len(d.data) && d.data[d.pos] != 'e' {
+			v, err := d.value()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		if d.pos >= len(d.data) {
+			return nil, errBencode
+		}
+		d.pos++
+		d.depth--
+		return list, nil
+
+	case c == 'd':
+		d.pos++
+		d.depth++
+		dict := map[string]interface{}{}
+		for d.pos < len(d.data) && d.data[d.pos] != 'e' {
+			key, err := d.string()
+			if err != nil {
+				return nil, err
+			}
+
+			start := d.pos
+			v, err := d.value()
+			if err != nil {
+				return nil, err
+			}
+			if key == "info" && d.depth == 1 {
+				d.infoStart, d.infoEnd = start, d.pos
+			}
+			dict[key] = v
+		}
+		if d.pos >= len(d.data) {
+			return nil, errBencode
+		}
+		d.pos++
+		d.depth--
+		return dict, nil
+
+	case c >= '0' && c <= '9':
+		return d.string()
+	}
+	return nil, errBencode
+}
+
+// string decodes the next value as a length prefixed string.
+func (d *decoder) string() (string, error) {
+	colon := d.find(':')
+	if colon < 0 {
+		return "", errBencode
+	}
+	n, err := strconv.Atoi(string(d.data[d.pos:colon]))
+	if err != nil || n < 0 || n > len(d.data)-colon-1 {
+		return "", errBencode
+	}
+
+	s := string(d.data[colon+1 : colon+1+n])
+	d.pos = colon + 1 + n
+	return s, nil
+}
+
+// find returns the index of the next c at or after the current position, or -1 if there is none.
+func (d *decoder) find(c byte) int {
+	for i := d.pos; i < len(d.data); i++ {
+		if d.data[i] == c {
+			return i
+		}
+	}
+	return -1
+}
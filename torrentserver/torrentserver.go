@@ -0,0 +1,391 @@
+// Package torrentserver implements a fync.Server for mods within a torrent, downloaded from its peers
+// given a .torrent file or magnet link, so that the players syncing a pack share the work of uploading it.
+// It requires the aria2c command.
+//
+// Each piece of the torrent is verified against its hash while downloading, and the download is kept
+// in a directory that later syncs check and reuse, so that only pieces of the torrent that changed
+// are downloaded again. Once downloaded, the torrent may be seeded in the background for a while.
+package torrentserver
+
+import (
+	"bytes"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/han-tyumi/fync"
+)
+
+// DefaultPath is the directory within the torrent containing the mods when none is chosen.
+const DefaultPath = "mods"
+
+// Options contains options for the New function.
+type Options struct {
+	// Directory within the torrent containing the mods, or "." for its root. Defaults to DefaultPath.
+	Path string
+
+	// Directory the torrent is downloaded to, reused by later syncs when it is kept.
+	// Defaults to a temporary directory that is removed once the Server is closed.
+	Dir string
+
+	// How long to seed the torrent in the background once it has been downloaded.
+	// Seeding stops early when the Server is closed. Defaults to not seeding.
+	SeedTime time.Duration
+
+	// Additional arguments passed to aria2c, such as "--max-upload-limit=1M" or "--bt-tracker=...".
+	Args []string
+
+	// Path of the aria2c command. Defaults to aria2c as found on $PATH.
+	Aria2c string
+
+	// The HTTP client used to download .torrent files. Defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// Server is a fync.Server that lists mods from a torrent.
+// It should be closed once it is no longer needed.
+type Server struct {
+	source   string
+	infoHash string
+	path     string
+	dir      string
+	temp     bool
+	seedTime time.Duration
+	args     []string
+	aria2c   string
+	client   *http.Client
+
+	mu     sync.Mutex
+	seed   *exec.Cmd
+	seeded chan struct{}
+}
+
+// New returns a Server for the torrent with the given magnet link, or the .torrent file at the given path or URL.
+func New(source string, o *Options) (*Server, error) {
+	if o == nil {
+		o = &Options{}
+	}
+
+	s := &Server{
+		source:   source,
+		path:     strings.Trim(path.Clean("/"+o.Path), "/"),
+		dir:      o.Dir,
+		seedTime: o.SeedTime,
+		args:     o.Args,
+		aria2c:   o.Aria2c,
+		client:   o.Client,
+	}
+
+	if o.Path == "" {
+		s.path = DefaultPath
+	}
+	if s.aria2c == "" {
+		s.aria2c = "aria2c"
+	}
+	if s.client == nil {
+		s.client = http.DefaultClient
+	}
+
+	if strings.HasPrefix(source, "magnet:") {
+		hash, err := magnetHash(source)
+		if err != nil {
+			return nil, err
+		}
+		s.infoHash = hash
+	}
+
+	if _, err := exec.LookPath(s.aria2c); err != nil {
+		return nil, err
+	}
+
+	if s.dir == "" {
+		dir, err := ioutil.TempDir("", "fync-torrent")
+		if err != nil {
+			return nil, err
+		}
+		s.dir = dir
+		s.temp = true
+	} else if err := os.MkdirAll(s.dir, os.ModeDir|0755); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// magnetHash returns the hex encoded info hash of a magnet link.
+func magnetHash(link string) (string, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", err
+	}
+
+	for _, xt := range u.Query()["xt"] {
+		if !strings.HasPrefix(xt, "urn:btih:") {
+			continue
+		}
+
+		hash := strings.TrimPrefix(xt, "urn:btih:")
+		switch len(hash) {
+		case 40:
+			if _, err := hex.DecodeString(hash); err == nil {
+				return strings.ToLower(hash), nil
+			}
+		case 32:
+			if b, err := base32.StdEncoding.DecodeString(strings.ToUpper(hash)); err == nil {
+				return hex.EncodeToString(b), nil
+			}
+		}
+		return "", fmt.Errorf("torrentserver: invalid info hash %q", hash)
+	}
+	return "", errors.New("torrentserver: magnet link has no BitTorrent info hash")
+}
+
+// String returns the magnet link of the torrent, or the path or URL of its .torrent file.
+func (s *Server) String() string {
+	if s.infoHash != "" {
+		return "magnet:?xt=urn:btih:" + s.infoHash
+	}
+	return s.source
+}
+
+// Close stops seeding the torrent and removes the download when it is within a temporary directory.
+func (s *Server) Close() error {
+	s.stopSeeding()
+
+	if !s.temp {
+		return nil
+	}
+	return os.RemoveAll(s.dir)
+}
+
+// Mods downloads the torrent and returns a slice of mod ServerFiles for each jar within its mods directory.
+func (s *Server) Mods() ([]fync.ServerFile, error) {
+	metainfo, err := s.metainfo()
+	if err != nil {
+		return nil, err
+	}
+
+	// aria2c cannot download the torrent while seeding it
+	s.stopSeeding()
+
+	if s.infoHash != "" {
+		err = s.run(s.source, "--seed-time=0", "--bt-save-metadata=true", "--bt-load-saved-metadata=true")
+	} else {
+		err = s.run(metainfo, "--seed-time=0")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(metainfo)
+	if err != nil {
+		return nil, err
+	}
+	t, err := parseTorrent(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s, err)
+	}
+	if t.files == nil {
+		return nil, fmt.Errorf("%s: torrent is of a single file rather than a folder", s)
+	}
+
+	var mods []fync.ServerFile
+	for _, tf := range t.files {
+		dir, name := path.Split(strings.Join(tf.path, "/"))
+		if strings.TrimSuffix(dir, "/") != s.path || !strings.HasSuffix(name, ".jar") {
+			continue
+		}
+		if !validPath(tf.path) {
+			return nil, fmt.Errorf("%s: invalid path %q", s, strings.Join(tf.path, "/"))
+		}
+
+		p := filepath.Join(append([]string{s.dir, t.name}, tf.path...)...)
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if info.Size() != tf.length {
+			return nil, fmt.Errorf("%s: %s was not fully downloaded", s, name)
+		}
+		mods = append(mods, &file{server: s, path: p, name: path.Join(t.name, strings.Join(tf.path, "/")), info: info})
+	}
+
+	if s.seedTime > 0 {
+		if err := s.startSeeding(metainfo); err != nil {
+			return nil, err
+		}
+	}
+	return mods, nil
+}
+
+// validPath returns whether each element of a path within a torrent names a file within its directory.
+func validPath(elems []string) bool {
+	for _, elem := range elems {
+		if elem == "" || elem == "." || elem == ".." || strings.ContainsAny(elem, `/\`) {
+			return false
+		}
+	}
+	return true
+}
+
+// metainfo returns the path of the torrent's metainfo, downloading the .torrent file if it is at a URL.
+// The metainfo of a magnet link is saved there once aria2c has obtained it from its peers.
+func (s *Server) metainfo() (string, error) {
+	if s.infoHash != "" {
+		return filepath.Join(s.dir, s.infoHash+".torrent"), nil
+	}
+	if !strings.HasPrefix(s.source, "http://") && !strings.HasPrefix(s.source, "https://") {
+		return s.source, nil
+	}
+
+	res, err := s.client.Get(s.source)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: unexpected status %q", s.source, res.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(res.Body, 64<<20))
+	if err != nil {
+		return "", err
+	}
+
+	t, err := parseTorrent(data)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", s.source, err)
+	}
+	p := filepath.Join(s.dir, t.infoHash+".torrent")
+	return p, ioutil.WriteFile(p, data, 0644)
+}
+
+// command returns the aria2c command downloading or seeding the torrent given by arg within the directory,
+// checking existing files in it so that only the pieces that are missing or changed are downloaded.
+func (s *Server) command(arg string, args ...string) *exec.Cmd {
+	args = append([]string{
+		"--dir=" + s.dir,
+		"--check-integrity=true",
+		"--auto-file-renaming=false",
+		"--allow-overwrite=true",
+		"--file-allocation=none",
+		"--summary-interval=0",
+		"--console-log-level=error",
+		"--download-result=hide",
+	}, args...)
+	args = append(args, s.args...)
+	return exec.Command(s.aria2c, append(args, "--", arg)...)
+}
+
+// run downloads the torrent given by arg.
+func (s *Server) run(arg string, args ...string) error {
+	cmd := s.command(arg, args...)
+
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(out.String()); msg != "" {
+			return fmt.Errorf("aria2c: %s", msg)
+		}
+		return fmt.Errorf("aria2c: %w", err)
+	}
+	return nil
+}
+
+// startSeeding starts seeding the downloaded torrent with the given metainfo in the background.
+func (s *Server) startSeeding(metainfo string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	minutes := strconv.FormatFloat(s.seedTime.Minutes(), 'f', -1, 64)
+	cmd := s.command(metainfo, "--seed-time="+minutes, "--bt-seed-unverified=true")
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	s.seed, s.seeded = cmd, done
+
+	go func() {
+		cmd.Wait()
+		close(done)
+	}()
+	return nil
+}
+
+// stopSeeding stops seeding the torrent, waiting for aria2c to exit.
+func (s *Server) stopSeeding() {
+	s.mu.Lock()
+	cmd, done := s.seed, s.seeded
+	s.seed = nil
+	s.mu.Unlock()
+
+	if cmd != nil {
+		cmd.Process.Kill()
+		<-done
+	}
+}
+
+// file is a fync.ServerFile that copies a mod from the downloaded torrent.
+type file struct {
+	server *Server
+	path   string
+	name   string
+	info   os.FileInfo
+	r      *os.File
+}
+
+// String returns the location of the mod within the torrent.
+func (f *file) String() string {
+	return f.server.String() + ":" + f.name
+}
+
+func (f *file) Stat() (os.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *file) WriteTo(w io.Writer) (int64, error) {
+	if f.r == nil {
+		r, err := os.Open(f.path)
+		if err != nil {
+			return 0, err
+		}
+		f.r = r
+	}
+
+	defer f.Close()
+	return io.Copy(w, f.r)
+}
+
+// Seek only supports rewinding to the start of the file so that a failed transfer can be retried.
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekStart {
+		return 0, errors.New("torrentserver: can only seek to the start of a file")
+	}
+	return 0, f.Close()
+}
+
+func (f *file) Close() error {
+	if f.r == nil {
+		return nil
+	}
+
+	err := f.r.Close()
+	f.r = nil
+	return err
+}
+
+var _ fync.Server = (*Server)(nil)
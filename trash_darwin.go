@@ -0,0 +1,31 @@
+package fync
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// trash moves the file at path to the user's Trash through the Finder
+// so that it can be put back from there.
+func trash(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command("osascript",
+		"-e", "on run argv",
+		"-e", `tell application "Finder" to delete POSIX file (item 1 of argv)`,
+		"-e", "end run",
+		abs)
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: moving to trash: %v: %s", abs, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
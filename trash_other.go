@@ -0,0 +1,69 @@
+//go:build !windows && !darwin
+// +build !windows,!darwin
+
+package fync
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// trash moves the file at path to the user's home trash
+// as described by the freedesktop.org Trash specification.
+func trash(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	dataDir, err := userDataDir()
+	if err != nil {
+		return err
+	}
+
+	trashDir := filepath.Join(dataDir, "Trash")
+	filesDir := filepath.Join(trashDir, "files")
+	infoDir := filepath.Join(trashDir, "info")
+	for _, dir := range []string{filesDir, infoDir} {
+		if err := os.MkdirAll(dir, os.ModeDir|0700); err != nil {
+			return err
+		}
+	}
+
+	// reserve a unique name by exclusively creating its info file
+	base := filepath.Base(abs)
+	ext := filepath.Ext(base)
+	name := base
+	var info *os.File
+	for i := 1; ; i++ {
+		info, err = os.OpenFile(filepath.Join(infoDir, name+".trashinfo"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			break
+		}
+		if !os.IsExist(err) {
+			return err
+		}
+		name = strings.TrimSuffix(base, ext) + "." + strconv.Itoa(i) + ext
+	}
+
+	// escape the path as a URL but leave its separators intact
+	u := url.URL{Path: abs}
+	_, err = fmt.Fprintf(info, "[Trash Info]\nPath=%s\nDeletionDate=%s\n",
+		u.EscapedPath(), time.Now().Format("2006-01-02T15:04:05"))
+	if closeErr := info.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = move(abs, filepath.Join(filesDir, name))
+	}
+	if err != nil {
+		os.Remove(filepath.Join(infoDir, name+".trashinfo"))
+	}
+	return err
+}
@@ -0,0 +1,68 @@
+package fync
+
+import (
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	foDelete          = 0x0003
+	fofSilent         = 0x0004
+	fofNoConfirmation = 0x0010
+	fofAllowUndo      = 0x0040
+	fofNoErrorUI      = 0x0400
+)
+
+var procSHFileOperationW = syscall.NewLazyDLL("shell32.dll").NewProc("SHFileOperationW")
+
+// trash moves the file at path to the Recycle Bin.
+func trash(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	// the source is a list of paths terminated by an additional null character
+	from, err := syscall.UTF16FromString(abs)
+	if err != nil {
+		return err
+	}
+	from = append(from, 0)
+
+	// SHFILEOPSTRUCTW is naturally aligned on 64-bit Windows but byte packed on 32-bit Windows
+	var op [56]byte
+	ptrSize := unsafe.Sizeof(uintptr(0))
+	put := func(offset uintptr, v uintptr) {
+		if ptrSize == 8 {
+			binary.LittleEndian.PutUint64(op[offset:], uint64(v))
+		} else {
+			binary.LittleEndian.PutUint32(op[offset:], uint32(v))
+		}
+	}
+
+	var wFunc, pFrom, fFlags, fAnyOperationsAborted uintptr
+	if ptrSize == 8 {
+		wFunc, pFrom, fFlags, fAnyOperationsAborted = 8, 16, 32, 36
+	} else {
+		wFunc, pFrom, fFlags, fAnyOperationsAborted = 4, 8, 16, 18
+	}
+
+	binary.LittleEndian.PutUint32(op[wFunc:], foDelete)
+	put(pFrom, uintptr(unsafe.Pointer(&from[0])))
+	binary.LittleEndian.PutUint16(op[fFlags:], fofAllowUndo|fofNoConfirmation|fofSilent|fofNoErrorUI)
+
+	r, _, _ := procSHFileOperationW.Call(uintptr(unsafe.Pointer(&op[0])))
+	runtime.KeepAlive(from)
+
+	if r != 0 {
+		return fmt.Errorf("%s: moving to recycle bin: error code %#x", abs, r)
+	}
+	if binary.LittleEndian.Uint32(op[fAnyOperationsAborted:]) != 0 {
+		return fmt.Errorf("%s: moving to recycle bin: aborted", abs)
+	}
+	return nil
+}
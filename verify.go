@@ -0,0 +1,69 @@
+package fync
+
+// DriftReport describes how the local mods differ from the server's.
+type DriftReport struct {
+	// Names of the server mods that are not within the mods directory.
+	Missing []string
+
+	// Names of the local mods that differ from the server mod of the same name.
+	Modified []string
+
+	// Names of the local mods that are not on the server and would be backed up by a sync.
+	Extra []string
+}
+
+// InSync returns whether syncing would make no changes.
+func (r *DriftReport) InSync() bool {
+	return len(r.Missing) == 0 && len(r.Modified) == 0 && len(r.Extra) == 0
+}
+
+// Verify compares the local mods with the server's by name, size, and checksum when available,
+// reporting any drift without modifying anything. Options affecting which mods would be changed
+// by Sync, such as KeepExisting, ManagedOnly, and Lock, are respected while Force is ignored.
+// A nil o uses the default options.
+func Verify(s Server, o *SyncOptions) (*DriftReport, error) {
+	if dirErr != nil {
+		return nil, dirErr
+	}
+
+	opts := SyncOptions{}
+	if o != nil {
+		opts = *o
+	}
+	opts.Force = false
+	opts.OnPlan = nil
+
+	defer flushHashes()
+
+	mods, sums, err := fetchMods(s)
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := readState()
+	if err != nil {
+		for _, mod := range mods {
+			mod.Close()
+		}
+		return nil, err
+	}
+
+	p, err := plan(mods, sums, st, &opts)
+	if err != nil {
+		return nil, err
+	}
+	defer p.close()
+
+	r := &DriftReport{}
+	for _, a := range p.Actions {
+		switch a.Type {
+		case ActionInstall:
+			r.Missing = append(r.Missing, a.Name)
+		case ActionReplace:
+			r.Modified = append(r.Modified, a.Name)
+		case ActionRemove:
+			r.Extra = append(r.Extra, a.Name)
+		}
+	}
+	return r, nil
+}
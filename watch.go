@@ -0,0 +1,213 @@
+package fync
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ChangeEvent is sent by a WatchServer each time its mods change.
+type ChangeEvent struct {
+	// When the host noticed the change.
+	Time time.Time
+
+	// The error that ended the watch, sent as the last ChangeEvent before the channel is closed.
+	// It is nil when the watch ended because its context was canceled.
+	Err error
+}
+
+// WatchServer represents a Server whose host pushes a notification each time its mods change,
+// so that a daemon can sync as soon as the pack is updated rather than polling.
+type WatchServer interface {
+	Server
+
+	// Watch returns a channel receiving a ChangeEvent each time the server's mods change,
+	// which is closed once the context is canceled or the watch fails.
+	// Mods listed after an event is received include the change.
+	Watch(ctx context.Context) <-chan ChangeEvent
+}
+
+// EventStreamType is the media type of the server-sent events served by Notifier.
+const EventStreamType = "text/event-stream"
+
+// Timing of the events served by Notifier.
+const (
+	// How long clients wait before reconnecting to a stream that was cut off.
+	eventRetry = 3 * time.Second
+
+	// How often a comment is sent to keep idle streams from being closed by proxies.
+	eventKeepAlive = 30 * time.Second
+
+	// How often WatchDir checks for changes when no interval is given.
+	defaultWatchInterval = 2 * time.Second
+)
+
+// Notifier is an http.Handler that pushes a notification to its subscribers each time Notify is called,
+// as a stream of server-sent events. Hosts serve it alongside their manifest so that clients,
+// such as those of httpserver, can watch for changes to the pack.
+//
+// Each stream begins with a "ready" event, followed by a "changed" event for each notification.
+// Every event's ID identifies the notification it follows, and its data is the time of that
+// notification in RFC 3339 format, so a client that reconnects knows it missed a change when the ID
+// of the ready event differs from the last ID it saw. The zero value is ready to use.
+type Notifier struct {
+	mu    sync.Mutex
+	epoch string
+	n     uint64
+	time  time.Time
+	subs  map[chan struct{}]bool
+}
+
+// init initializes the notifier on first use. Its epoch distinguishes the IDs of each run of the host.
+func (n *Notifier) init() {
+	if n.subs == nil {
+		n.time = time.Now()
+		n.epoch = strconv.FormatInt(n.time.UnixNano(), 36)
+		n.subs = make(map[chan struct{}]bool)
+	}
+}
+
+// current returns the ID and time of the latest notification.
+func (n *Notifier) current() (string, time.Time) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.init()
+	return n.epoch + "-" + strconv.FormatUint(n.n, 10), n.time
+}
+
+// Notify notifies every subscriber that the mods have changed.
+// Notifications made while a subscriber is still being sent an earlier one are sent together.
+func (n *Notifier) Notify() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.init()
+	n.n++
+	n.time = time.Now()
+	for ch := range n.subs {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// ServeHTTP subscribes to notifications, streaming them until the request is canceled.
+func (n *Notifier) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	ch := make(chan struct{}, 1)
+	n.mu.Lock()
+	n.init()
+	n.subs[ch] = true
+	n.mu.Unlock()
+
+	defer func() {
+		n.mu.Lock()
+		delete(n.subs, ch)
+		n.mu.Unlock()
+	}()
+
+	h := w.Header()
+	h.Set("Content-Type", EventStreamType)
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no")
+
+	id, t := n.current()
+	fmt.Fprintf(w, "retry: %d\n\n", eventRetry/time.Millisecond)
+	if err := writeEvent(w, "ready", id, t); err != nil {
+		return
+	}
+	flusher.Flush()
+
+	ticker := time.NewTicker(eventKeepAlive)
+	defer ticker.Stop()
+
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ch:
+			id, t := n.current()
+			err = writeEvent(w, "changed", id, t)
+		case <-ticker.C:
+			_, err = io.WriteString(w, ": keep-alive\n\n")
+		}
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEvent writes a server-sent event.
+func writeEvent(w io.Writer, event, id string, t time.Time) error {
+	_, err := fmt.Fprintf(w, "event: %s\nid: %s\ndata: %s\n\n", event, id, t.UTC().Format(time.RFC3339Nano))
+	return err
+}
+
+// WatchDir calls Notify each time a file within the directory is added, removed, or modified,
+// checking every interval, or every 2 seconds when the interval is not positive.
+// It returns once the context is done, with the context's error, or when the directory cannot be read.
+func (n *Notifier) WatchDir(ctx context.Context, dir string, interval time.Duration) error {
+	if interval <= 0 {
+		interval = defaultWatchInterval
+	}
+
+	last, err := fingerprintDir(dir)
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			curr, err := fingerprintDir(dir)
+			if err != nil {
+				return err
+			}
+			if curr != last {
+				last = curr
+				n.Notify()
+			}
+		}
+	}
+}
+
+// fingerprintDir returns the paths, sizes, and modification times of the files within the directory,
+// which change whenever the files do.
+func fingerprintDir(dir string) (string, error) {
+	var b strings.Builder
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&b, "%q %d %d %v\n", path, info.Size(), info.ModTime().UnixNano(), info.IsDir())
+		return nil
+	})
+	return b.String(), err
+}
@@ -0,0 +1,140 @@
+package webdavserver
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// auth authorizes requests with the scheme the server challenged for, either basic or digest.
+type auth struct {
+	user, password string
+
+	mu        sync.Mutex
+	challenge map[string]string
+	digest    bool
+	count     int
+}
+
+// authorize sets the request's Authorization header, if the scheme is known.
+// Until the server responds with a challenge, basic authorization is used.
+func (a *auth) authorize(req *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.digest {
+		req.SetBasicAuth(a.user, a.password)
+		return
+	}
+
+	a.count++
+	nc := fmt.Sprintf("%08x", a.count)
+
+	var b [8]byte
+	rand.Read(b[:])
+	cnonce := hex.EncodeToString(b[:])
+
+	uri := req.URL.RequestURI()
+	ha1 := md5Hex(a.user + ":" + a.challenge["realm"] + ":" + a.password)
+	if strings.EqualFold(a.challenge["algorithm"], "MD5-sess") {
+		ha1 = md5Hex(ha1 + ":" + a.challenge["nonce"] + ":" + cnonce)
+	}
+	ha2 := md5Hex(req.Method + ":" + uri)
+
+	var response string
+	qop := ""
+	for _, q := range strings.Split(a.challenge["qop"], ",") {
+		if strings.TrimSpace(q) == "auth" {
+			qop = "auth"
+		}
+	}
+	if qop != "" {
+		response = md5Hex(ha1 + ":" + a.challenge["nonce"] + ":" + nc + ":" + cnonce + ":" + qop + ":" + ha2)
+	} else {
+		response = md5Hex(ha1 + ":" + a.challenge["nonce"] + ":" + ha2)
+	}
+
+	header := fmt.Sprintf(`Digest username=%q, realm=%q, nonce=%q, uri=%q, response=%q`,
+		a.user, a.challenge["realm"], a.challenge["nonce"], uri, response)
+	if algorithm := a.challenge["algorithm"]; algorithm != "" {
+		header += ", algorithm=" + algorithm
+	}
+	if opaque := a.challenge["opaque"]; opaque != "" {
+		header += fmt.Sprintf(", opaque=%q", opaque)
+	}
+	if qop != "" {
+		header += fmt.Sprintf(", qop=%s, nc=%s, cnonce=%q", qop, nc, cnonce)
+	}
+	req.Header.Set("Authorization", header)
+}
+
+// respond records the challenge of an unauthorized response,
+// returning whether the request should be retried with it.
+func (a *auth) respond(res *http.Response, wasDigest bool) bool {
+	header := res.Header.Get("WWW-Authenticate")
+	if !strings.HasPrefix(strings.ToLower(header), "digest ") {
+		return false
+	}
+
+	challenge := parseChallenge(header[len("digest "):])
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// retry once with a new digest challenge, or again when the old nonce went stale
+	if wasDigest && !strings.EqualFold(challenge["stale"], "true") {
+		return false
+	}
+
+	a.digest = true
+	a.challenge = challenge
+	a.count = 0
+	return true
+}
+
+// isDigest returns whether digest authorization is in use.
+func (a *auth) isDigest() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.digest
+}
+
+// parseChallenge parses the comma separated parameters of a digest challenge.
+func parseChallenge(s string) map[string]string {
+	params := make(map[string]string)
+	for s != "" {
+		s = strings.TrimLeft(s, " ,")
+
+		eq := strings.Index(s, "=")
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(s[:eq]))
+		s = s[eq+1:]
+
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			end := strings.Index(s[1:], `"`)
+			if end < 0 {
+				value, s = s[1:], ""
+			} else {
+				value, s = s[1:end+1], s[end+2:]
+			}
+		} else if comma := strings.Index(s, ","); comma >= 0 {
+			value, s = strings.TrimSpace(s[:comma]), s[comma:]
+		} else {
+			value, s = strings.TrimSpace(s), ""
+		}
+		params[key] = value
+	}
+	return params
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,270 @@
+// Package webdavserver implements a fync.Server for mods within a WebDAV folder,
+// such as one shared from Nextcloud or ownCloud, using basic or digest authorization.
+package webdavserver
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/han-tyumi/fync"
+)
+
+// Options contains options for the New function.
+type Options struct {
+	// Credentials used when the server requires authorization. The scheme, basic or digest,
+	// is chosen by the server. For Nextcloud public shares, the user is the share's token.
+	User, Password string
+
+	// The HTTP client used for all requests. Defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// Server is a fync.Server that lists mods from a WebDAV folder.
+type Server struct {
+	folder *url.URL
+	client *http.Client
+	auth   *auth
+}
+
+// New returns a Server for the WebDAV folder at the given URL, such as
+// https://cloud.example.com/remote.php/dav/files/user/pack/mods.
+func New(folderURL string, o *Options) (*Server, error) {
+	u, err := url.Parse(folderURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported URL scheme %q", u.Scheme)
+	}
+
+	// members of the folder are relative to it
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+		u.RawPath = ""
+	}
+
+	s := &Server{folder: u, client: http.DefaultClient}
+	if o != nil {
+		if o.Client != nil {
+			s.client = o.Client
+		}
+		if o.User != "" || o.Password != "" {
+			s.auth = &auth{user: o.User, password: o.Password}
+		}
+	}
+	return s, nil
+}
+
+// String returns the URL of the folder.
+func (s *Server) String() string {
+	return s.folder.String()
+}
+
+// propfindBody requests only the properties needed to list the folder's mods.
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:">
+	<d:prop>
+		<d:resourcetype/>
+		<d:getcontentlength/>
+		<d:getlastmodified/>
+		<d:getetag/>
+	</d:prop>
+</d:propfind>`
+
+// Mods returns a slice of mod ServerFiles for each jar within the folder.
+// Mods are not downloaded until they are written.
+func (s *Server) Mods() ([]fync.ServerFile, error) {
+	res, err := s.do("PROPFIND", s.folder.String(), http.Header{
+		"Depth":        {"1"},
+		"Content-Type": {"application/xml; charset=utf-8"},
+	}, propfindBody)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusMultiStatus {
+		return nil, fmt.Errorf("%s: unexpected status %q", s.folder, res.Status)
+	}
+
+	var result struct {
+		Responses []struct {
+			Href     string `xml:"href"`
+			Propstat []struct {
+				Status string `xml:"status"`
+				Prop   struct {
+					ResourceType struct {
+						Collection *struct{} `xml:"collection"`
+					} `xml:"resourcetype"`
+					ContentLength string `xml:"getcontentlength"`
+					LastModified  string `xml:"getlastmodified"`
+					ETag          string `xml:"getetag"`
+				} `xml:"prop"`
+			} `xml:"propstat"`
+		} `xml:"response"`
+	}
+	if err := xml.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	var mods []fync.ServerFile
+	for _, r := range result.Responses {
+		href, err := url.Parse(r.Href)
+		if err != nil {
+			return nil, err
+		}
+		u := s.folder.ResolveReference(href)
+
+		// only direct members of the folder are of interest
+		name := path.Base(u.Path)
+		if strings.HasSuffix(u.Path, "/") || path.Dir(u.Path)+"/" != s.folder.Path || !strings.HasSuffix(name, ".jar") {
+			continue
+		}
+
+		f := &file{server: s, name: name, url: u.String(), size: -1}
+		isDir := false
+		for _, ps := range r.Propstat {
+			if !strings.Contains(ps.Status, " 200 ") {
+				continue
+			}
+
+			if ps.Prop.ResourceType.Collection != nil {
+				isDir = true
+			}
+			if ps.Prop.ContentLength != "" {
+				if f.size, err = strconv.ParseInt(strings.TrimSpace(ps.Prop.ContentLength), 10, 64); err != nil {
+					return nil, fmt.Errorf("%s: invalid content length %q", u, ps.Prop.ContentLength)
+				}
+			}
+			f.modTime, _ = http.ParseTime(ps.Prop.LastModified)
+			f.etag = ps.Prop.ETag
+		}
+
+		if isDir {
+			continue
+		}
+		if f.size < 0 {
+			return nil, fmt.Errorf("%s: unknown size", u)
+		}
+		mods = append(mods, f)
+	}
+	return mods, nil
+}
+
+// do sends a request, authorizing it when credentials were given.
+func (s *Server) do(method, u string, header http.Header, body string) (*http.Response, error) {
+	for {
+		req, err := http.NewRequest(method, u, strings.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		for name, values := range header {
+			req.Header[name] = values
+		}
+
+		wasDigest := false
+		if s.auth != nil {
+			wasDigest = s.auth.isDigest()
+			s.auth.authorize(req)
+		}
+
+		res, err := s.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if res.StatusCode == http.StatusUnauthorized && s.auth != nil && s.auth.respond(res, wasDigest) {
+			res.Body.Close()
+			continue
+		}
+		return res, nil
+	}
+}
+
+// file is a fync.ServerFile that downloads a mod from the folder.
+type file struct {
+	server  *Server
+	name    string
+	url     string
+	size    int64
+	modTime time.Time
+	etag    string
+	res     *http.Response
+}
+
+// String returns the URL the mod is downloaded from.
+func (f *file) String() string {
+	return f.url
+}
+
+func (f *file) Stat() (os.FileInfo, error) {
+	return fileInfo{f}, nil
+}
+
+func (f *file) WriteTo(w io.Writer) (int64, error) {
+	if f.res == nil {
+		// fail rather than mix up the mod's listed size with a newer version
+		var header http.Header
+		if f.etag != "" {
+			header = http.Header{"If-Match": {f.etag}}
+		}
+
+		res, err := f.server.do(http.MethodGet, f.url, header, "")
+		if err != nil {
+			return 0, err
+		}
+
+		if res.StatusCode != http.StatusOK {
+			res.Body.Close()
+			if res.StatusCode == http.StatusPreconditionFailed {
+				return 0, fmt.Errorf("%s: changed since it was listed", f.url)
+			}
+			return 0, fmt.Errorf("%s: unexpected status %q", f.url, res.Status)
+		}
+		f.res = res
+	}
+
+	defer f.Close()
+	return io.Copy(w, f.res.Body)
+}
+
+// Seek only supports rewinding to the start of the file so that a failed transfer can be retried.
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekStart {
+		return 0, errors.New("webdavserver: can only seek to the start of a file")
+	}
+	return 0, f.Close()
+}
+
+func (f *file) Close() error {
+	if f.res == nil {
+		return nil
+	}
+
+	err := f.res.Body.Close()
+	f.res = nil
+	return err
+}
+
+type fileInfo struct {
+	f *file
+}
+
+func (i fileInfo) Name() string       { return i.f.name }
+func (i fileInfo) Size() int64        { return i.f.size }
+func (i fileInfo) Mode() os.FileMode  { return 0644 }
+func (i fileInfo) ModTime() time.Time { return i.f.modTime }
+func (i fileInfo) IsDir() bool        { return false }
+func (i fileInfo) Sys() interface{}   { return nil }
+
+var _ fync.Server = (*Server)(nil)
@@ -0,0 +1,117 @@
+package zipserver
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// blockSize is the size of the blocks in which a remote archive's metadata is read.
+const blockSize = 64 << 10
+
+// remote is an io.ReaderAt for an archive served over HTTP with support for range requests.
+// Reads are made in cached blocks since reading the archive's directory makes many small reads.
+type remote struct {
+	url    string
+	size   int64
+	client *http.Client
+
+	mu     sync.Mutex
+	blocks map[int64][]byte
+}
+
+// openRemote returns a remote for the archive at u, learning its size from a HEAD request.
+func openRemote(u string, client *http.Client) (*remote, error) {
+	req, err := http.NewRequest(http.MethodHead, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: unexpected status %q", u, res.Status)
+	}
+	if res.Header.Get("Accept-Ranges") != "bytes" {
+		return nil, fmt.Errorf("%s: server does not support range requests", u)
+	}
+	if res.ContentLength < 0 {
+		return nil, fmt.Errorf("%s: unknown size", u)
+	}
+
+	return &remote{url: u, size: res.ContentLength, client: client, blocks: make(map[int64][]byte)}, nil
+}
+
+func (r *remote) ReadAt(p []byte, off int64) (int, error) {
+	var n int
+	for n < len(p) {
+		pos := off + int64(n)
+		if pos >= r.size {
+			return n, io.EOF
+		}
+
+		block, err := r.block(pos / blockSize)
+		if err != nil {
+			return n, err
+		}
+		n += copy(p[n:], block[pos%blockSize:])
+	}
+	return n, nil
+}
+
+// block returns the block at the given index, fetching it if it is not cached.
+func (r *remote) block(i int64) ([]byte, error) {
+	r.mu.Lock()
+	block, ok := r.blocks[i]
+	r.mu.Unlock()
+	if ok {
+		return block, nil
+	}
+
+	start := i * blockSize
+	end := start + blockSize
+	if end > r.size {
+		end = r.size
+	}
+
+	body, err := r.open(start, end-start)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	block = make([]byte, end-start)
+	if _, err := io.ReadFull(body, block); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	r.blocks[i] = block
+	r.mu.Unlock()
+	return block, nil
+}
+
+// open requests length bytes of the archive beginning at off.
+func (r *remote) open(off, length int64) (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodGet, r.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+length-1))
+
+	res, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusPartialContent {
+		res.Body.Close()
+		return nil, fmt.Errorf("%s: unexpected status %q", r.url, res.Status)
+	}
+	return res.Body, nil
+}
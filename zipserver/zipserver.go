@@ -0,0 +1,239 @@
+// Package zipserver implements a fync.Server for mods within a single zip archive,
+// either a local file or one served over HTTP(S) with support for range requests.
+// Only the archive's directory is read up front, and each mod is read from the archive
+// only once it is written, so the archive is never extracted as a whole.
+package zipserver
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/han-tyumi/fync"
+)
+
+// Options contains options for the New function.
+type Options struct {
+	// Only mods within this directory of the archive, such as "mods", are listed.
+	// Defaults to listing jars anywhere within the archive.
+	Dir string
+
+	// The HTTP client used for archives given by URL. Defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// Server is a fync.Server that lists mods from a zip archive.
+// It should be closed once it is no longer needed.
+type Server struct {
+	location string
+	archive  *zip.Reader
+	closer   io.Closer
+	remote   *remote
+	dir      string
+}
+
+// New returns a Server for the zip archive at the given path or http(s) URL.
+func New(location string, o *Options) (*Server, error) {
+	if o == nil {
+		o = &Options{}
+	}
+
+	s := &Server{location: location, dir: strings.Trim(o.Dir, "/")}
+
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		client := o.Client
+		if client == nil {
+			client = http.DefaultClient
+		}
+
+		r, err := openRemote(location, client)
+		if err != nil {
+			return nil, err
+		}
+
+		if s.archive, err = zip.NewReader(r, r.size); err != nil {
+			return nil, fmt.Errorf("%s: %w", location, err)
+		}
+		s.remote = r
+		return s, nil
+	}
+
+	rc, err := zip.OpenReader(location)
+	if err != nil {
+		return nil, err
+	}
+	s.archive = &rc.Reader
+	s.closer = rc
+	return s, nil
+}
+
+// String returns the path or URL of the archive.
+func (s *Server) String() string {
+	return s.location
+}
+
+// Close closes a local archive.
+func (s *Server) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
+
+// Mods returns a slice of mod ServerFiles for each jar within the archive.
+func (s *Server) Mods() ([]fync.ServerFile, error) {
+	var mods []fync.ServerFile
+	seen := make(map[string]string)
+
+	for _, f := range s.archive.File {
+		if f.FileInfo().IsDir() || !strings.HasSuffix(f.Name, ".jar") {
+			continue
+		}
+
+		if s.dir != "" && path.Dir(f.Name) != s.dir {
+			continue
+		}
+
+		name := path.Base(f.Name)
+		if other, ok := seen[name]; ok {
+			return nil, fmt.Errorf("%s: both %s and %s are named %s", s.location, other, f.Name, name)
+		}
+		seen[name] = f.Name
+
+		mods = append(mods, &file{server: s, f: f})
+	}
+	return mods, nil
+}
+
+// file is a fync.ServerFile that reads a mod from the archive.
+type file struct {
+	server *Server
+	f      *zip.File
+	r      io.ReadCloser
+}
+
+// String returns the location of the mod within the archive.
+func (f *file) String() string {
+	return f.server.location + "!/" + f.f.Name
+}
+
+func (f *file) Stat() (os.FileInfo, error) {
+	return fileInfo{f.f}, nil
+}
+
+func (f *file) WriteTo(w io.Writer) (int64, error) {
+	if f.r == nil {
+		r, err := f.open()
+		if err != nil {
+			return 0, err
+		}
+		f.r = r
+	}
+
+	defer f.Close()
+	return io.Copy(w, f.r)
+}
+
+// open opens the mod for reading. Mods within remote archives are requested
+// with a single range request rather than through the archive's cached blocks.
+func (f *file) open() (io.ReadCloser, error) {
+	if f.server.remote == nil {
+		return f.f.Open()
+	}
+
+	offset, err := f.f.DataOffset()
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := f.server.remote.open(offset, int64(f.f.CompressedSize64))
+	if err != nil {
+		return nil, err
+	}
+
+	var r io.ReadCloser
+	switch f.f.Method {
+	case zip.Store:
+		r = body
+	case zip.Deflate:
+		r = flate.NewReader(body)
+	default:
+		body.Close()
+		return nil, fmt.Errorf("%s: unsupported compression method %d", f, f.f.Method)
+	}
+
+	return &checksumReader{r: r, body: body, f: f.f, hash: crc32.NewIEEE()}, nil
+}
+
+// Seek only supports rewinding to the start of the file so that a failed transfer can be retried.
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekStart {
+		return 0, errors.New("zipserver: can only seek to the start of a file")
+	}
+	return 0, f.Close()
+}
+
+func (f *file) Close() error {
+	if f.r == nil {
+		return nil
+	}
+
+	err := f.r.Close()
+	f.r = nil
+	return err
+}
+
+// checksumReader verifies the CRC-32 of a mod read directly from a remote archive,
+// as the archive/zip package does for mods it reads itself.
+type checksumReader struct {
+	r    io.ReadCloser
+	body io.Closer
+	f    *zip.File
+	hash interface {
+		io.Writer
+		Sum32() uint32
+	}
+	n int64
+}
+
+func (c *checksumReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.hash.Write(p[:n])
+	c.n += int64(n)
+
+	if err == io.EOF {
+		if uint64(c.n) != c.f.UncompressedSize64 || c.hash.Sum32() != c.f.CRC32 {
+			return n, zip.ErrChecksum
+		}
+	}
+	return n, err
+}
+
+func (c *checksumReader) Close() error {
+	err := c.r.Close()
+	if bodyErr := c.body.Close(); err == nil {
+		err = bodyErr
+	}
+	return err
+}
+
+type fileInfo struct {
+	f *zip.File
+}
+
+func (i fileInfo) Name() string       { return path.Base(i.f.Name) }
+func (i fileInfo) Size() int64        { return int64(i.f.UncompressedSize64) }
+func (i fileInfo) Mode() os.FileMode  { return 0644 }
+func (i fileInfo) ModTime() time.Time { return i.f.Modified }
+func (i fileInfo) IsDir() bool        { return false }
+func (i fileInfo) Sys() interface{}   { return nil }
+
+var _ fync.Server = (*Server)(nil)